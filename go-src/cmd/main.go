@@ -2,12 +2,13 @@ package main
 
 import (
 	"context"
-	"crypto/sha1"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -20,9 +21,50 @@ import (
 )
 
 const (
-	usersDataFilePath = "data/users.json"
+	// cliActor is the actor of the changes made by the command line (e.g. import)
+	cliActor = "cli"
+
+	// storage backends (see STORAGE_BACKEND)
+	jsonFileStorageBackend = "json-file"
+	memoryStorageBackend   = "memory"
+	sqliteStorageBackend   = "sqlite"
+	postgresStorageBackend = "postgres"
+)
+
+type (
+	// usersStorage holds the repos of the storage backend (see STORAGE_BACKEND)
+	usersStorage struct {
+		users     infra.UsersRepo
+		revisions revisionsRepo
+		audit     auditRepo
+		// reload reloads the users data file if it changed, returning if it was reloaded (nil if not supported by the backend)
+		reload func() (bool, error)
+		// close releases the storage (to be called on exit)
+		close func()
+	}
+
+	revisionsRepo interface {
+		AddRevisions(ctx context.Context, revisions []lib.UserRevision) error
+		GetRevisions(ctx context.Context, userID string) ([]lib.UserRevision, error)
+		ReplaceRevisions(ctx context.Context, userID string, revisions []lib.UserRevision) error
+	}
+
+	auditRepo interface {
+		AddAuditEntry(ctx context.Context, entry lib.AuditEntry) error
+		GetAuditEntries(ctx context.Context, userID string) ([]lib.AuditEntry, error)
+	}
 )
 
+// usersExporter is the users service used by the export command
+type usersExporter interface {
+	ExportUsers(ctx context.Context, filter lib.UsersFilter, sort []lib.SortField, fn func(user lib.User) error) error
+}
+
+// deletedUsersPurger is the users service used by the deleted users purge job
+type deletedUsersPurger interface {
+	PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int, error)
+}
+
 type serviceConfig struct {
 	ServerPort int `env:"PORT,required"`
 
@@ -38,18 +80,150 @@ type serviceConfig struct {
 	CORSAllowMethods []string `env:"CORS_ALLOW_METHODS,required"`
 	CORSAllowHeaders []string `env:"CORS_ALLOW_HEADERS,required"`
 
+	IdempotencyKeyTTL time.Duration `env:"IDEMPOTENCY_KEY_TTL" envDefault:"24h"`
+
+	AuthMaxFailedAttempts int           `env:"AUTH_MAX_FAILED_ATTEMPTS" envDefault:"5"`
+	AuthLockoutDuration   time.Duration `env:"AUTH_LOCKOUT_DURATION" envDefault:"15m"`
+	// AuthRateLimitMaxFrequency and AuthRateLimitBurstSize are the stricter rate limit of the authentication attempts
+	// (per IP address, on top of the general rate limit)
+	AuthRateLimitMaxFrequency int `env:"AUTH_RATE_LIMIT_MAX_FREQUENCY" envDefault:"1"`
+	AuthRateLimitBurstSize    int `env:"AUTH_RATE_LIMIT_BURST_SIZE" envDefault:"5"`
+
+	// AdminToken is the bearer token of the admin requests (e.g. including the deleted users), no admin requests if empty
+	AdminToken string `env:"ADMIN_TOKEN"`
+
+	// DeletedUsersRetention is how long the deleted users are kept (restorable) before being purged
+	DeletedUsersRetention     time.Duration `env:"DELETED_USERS_RETENTION" envDefault:"720h"`
+	DeletedUsersPurgeInterval time.Duration `env:"DELETED_USERS_PURGE_INTERVAL" envDefault:"1h"`
+
+	// storageConfig is the users storage of the service
+	*storageConfig
+
+	// UsersDataWatch is how the users data source changes are watched to reload it (json-file and memory backends):
+	// "auto" (inotify, or polling if not available), "poll" (every UsersDataPollInterval) or "off" (only reloaded on SIGHUP),
+	// the URLs are polled in both cases
+	UsersDataWatch        string        `env:"USERS_DATA_WATCH" envDefault:"auto"`
+	UsersDataPollInterval time.Duration `env:"USERS_DATA_POLL_INTERVAL" envDefault:"5s"`
+
 	LogLevel string `env:"LOG_LEVEL" envDefault:"error"`
 }
 
+// storageConfig is the users storage configuration, shared by the server and the commands
+type storageConfig struct {
+	// StorageBackend is where the users are stored: "json-file" (the users data file), "memory" (loaded from the users data file,
+	// the changes are lost on exit), "sqlite" or "postgres" (databases filled from the users data file when they're created)
+	StorageBackend     string `env:"STORAGE_BACKEND" envDefault:"json-file"`
+	SQLiteDatabasePath string `env:"SQLITE_DATABASE_PATH" envDefault:"data/users.db"`
+
+	// PostgresURL is the connection URL (or DSN) of the postgres backend, the pool settings are non-positive for no limit
+	PostgresURL             string        `env:"POSTGRES_URL"`
+	PostgresMaxOpenConns    int           `env:"POSTGRES_MAX_OPEN_CONNS" envDefault:"10"`
+	PostgresMaxIdleConns    int           `env:"POSTGRES_MAX_IDLE_CONNS" envDefault:"5"`
+	PostgresConnMaxLifetime time.Duration `env:"POSTGRES_CONN_MAX_LIFETIME" envDefault:"30m"`
+	PostgresConnMaxIdleTime time.Duration `env:"POSTGRES_CONN_MAX_IDLE_TIME" envDefault:"5m"`
+
+	// UsersDataValidation is "warn" (the invalid users of the data file are logged) or "strict" (refused at startup)
+	UsersDataValidation string `env:"USERS_DATA_VALIDATION" envDefault:"warn"`
+
+	// UsersDataSource is where the users data is read from: a file path, a file:// URL, an http(s):// URL or "-" (stdin),
+	// gzip-compressed or not (the json-file backend requires a file)
+	UsersDataSource string `env:"USERS_DATA_SOURCE" envDefault:"data/users.json"`
+	// UsersDataMaxSize is the maximum size (bytes) of the users data read from a URL or stdin (no maximum if not positive)
+	UsersDataMaxSize int64 `env:"USERS_DATA_MAX_SIZE" envDefault:"268435456"`
+	// usersDataSource is the parsed UsersDataSource
+	usersDataSource *infra.UsersDataSource
+
+	// UsersHistoryFilePath and UsersAuditFilePath are the users history and audit trail files of the json-file backend,
+	// next to the users data file by default (the database backends keep them in their databases)
+	UsersHistoryFilePath string `env:"USERS_HISTORY_FILE_PATH"`
+	UsersAuditFilePath   string `env:"USERS_AUDIT_FILE_PATH"`
+}
+
 func getServiceConfig() (*serviceConfig, error) {
 	config := &serviceConfig{}
 	err := env.Parse(config)
 	if err != nil {
 		return nil, err
 	}
+
+	config.storageConfig, err = getStorageConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.UsersDataWatch {
+	case "off":
+	case "auto", "poll":
+		if config.UsersDataPollInterval <= 0 {
+			return nil, fmt.Errorf("invalid USERS_DATA_POLL_INTERVAL '%s' (expected a positive duration)", config.UsersDataPollInterval)
+		}
+	default:
+		return nil, fmt.Errorf("invalid USERS_DATA_WATCH '%s' (expected auto, poll or off)", config.UsersDataWatch)
+	}
+
+	if config.DeletedUsersPurgeInterval <= 0 {
+		return nil, fmt.Errorf("invalid DELETED_USERS_PURGE_INTERVAL '%s' (expected a positive duration)", config.DeletedUsersPurgeInterval)
+	}
+
+	if config.IdempotencyKeyTTL <= 0 {
+		return nil, fmt.Errorf("invalid IDEMPOTENCY_KEY_TTL '%s' (expected a positive duration)", config.IdempotencyKeyTTL)
+	}
+
 	return config, nil
 }
 
+// getStorageConfig gets the users storage configuration (without the server configuration, for the commands)
+func getStorageConfig() (*storageConfig, error) {
+	config := &storageConfig{}
+	err := env.Parse(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.UsersDataValidation != "warn" && config.UsersDataValidation != "strict" {
+		return nil, fmt.Errorf("invalid USERS_DATA_VALIDATION '%s' (expected warn or strict)", config.UsersDataValidation)
+	}
+
+	config.usersDataSource, err = infra.NewUsersDataSource(config.UsersDataSource, config.UsersDataMaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.StorageBackend {
+	case memoryStorageBackend, sqliteStorageBackend:
+	case jsonFileStorageBackend:
+		if config.usersDataSource.FilePath() == "" {
+			return nil, fmt.Errorf("the json-file storage backend requires a file USERS_DATA_SOURCE (got '%s')", config.UsersDataSource)
+		}
+	case postgresStorageBackend:
+		if config.PostgresURL == "" {
+			return nil, fmt.Errorf("POSTGRES_URL is required by the postgres storage backend")
+		}
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_BACKEND '%s' (expected json-file, memory, sqlite or postgres)", config.StorageBackend)
+	}
+
+	setUsersFilesPaths(config)
+
+	return config, nil
+}
+
+// setUsersFilesPaths sets the default paths of the users history and audit trail files of the json-file backend (not set),
+// next to the users data file
+func setUsersFilesPaths(config *storageConfig) {
+	if config.StorageBackend != jsonFileStorageBackend {
+		return // the memory backend keeps them in memory, and the database backends in their databases
+	}
+
+	filesPath := config.usersDataSource.FilePath()
+	if config.UsersHistoryFilePath == "" {
+		config.UsersHistoryFilePath = infra.UsersHistoryFilePath(filesPath)
+	}
+	if config.UsersAuditFilePath == "" {
+		config.UsersAuditFilePath = infra.UsersAuditFilePath(filesPath)
+	}
+}
+
 // CLI commands
 var (
 	rootCmd = &cobra.Command{
@@ -61,10 +235,53 @@ var (
 		Short: "Start the server",
 		RunE:  runHTTP,
 	}
+	migratePasswordsCmd = &cobra.Command{
+		Use:   "migrate-passwords",
+		Short: "Hash the plaintext passwords of the users data file",
+		RunE:  runMigratePasswords,
+	}
+	migrateDatesCmd = &cobra.Command{
+		Use:   "migrate-dates",
+		Short: "Rewrite the legacy (dd/mm/yyyy) creation dates of the users data file in RFC 3339",
+		RunE:  runMigrateDates,
+	}
+	importCmd = &cobra.Command{
+		Use:   "import",
+		Short: "Import users (JSON, NDJSON or CSV) into the users data file, reporting the result of each row",
+		RunE:  runImport,
+		// rejected rows are not a usage error
+		SilenceUsage: true,
+	}
+	exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export the users (JSON, NDJSON or CSV, without passwords) from the users data file",
+		RunE:  runExport,
+	}
 )
 
 func init() {
 	rootCmd.AddCommand(httpCmd)
+
+	migratePasswordsCmd.Flags().String("data-file", "", "users data file path (default USERS_DATA_SOURCE)")
+	rootCmd.AddCommand(migratePasswordsCmd)
+
+	migrateDatesCmd.Flags().String("data-file", "", "users data file path (default USERS_DATA_SOURCE)")
+	rootCmd.AddCommand(migrateDatesCmd)
+
+	importCmd.Flags().String("data-file", "", "users data file path (default the configured STORAGE_BACKEND and USERS_DATA_SOURCE)")
+	importCmd.Flags().String("input", "", "imported users file path (\"-\" for stdin)")
+	importCmd.Flags().String("format", "", "imported users format: json, ndjson or csv (default from the input file extension)")
+	importCmd.Flags().Bool("dry-run", false, "only validate and report, without writing")
+	importCmd.Flags().Bool("all-or-nothing", false, "write only if no row is rejected")
+	importCmd.MarkFlagRequired("input")
+	rootCmd.AddCommand(importCmd)
+
+	exportCmd.Flags().String("data-file", "", "users data file path (default the configured STORAGE_BACKEND and USERS_DATA_SOURCE)")
+	exportCmd.Flags().String("output", "", "exported users file path (\"-\" for stdout)")
+	exportCmd.Flags().String("format", "", "exported users format: json, ndjson or csv (default from the output file extension)")
+	exportCmd.Flags().StringSlice("fields", nil, "exported user fields, in order (default all but the password)")
+	exportCmd.MarkFlagRequired("output")
+	rootCmd.AddCommand(exportCmd)
 }
 
 func main() {
@@ -74,6 +291,102 @@ func main() {
 	}
 }
 
+// newUsersStorage opens the repos of the configured storage backend, reading the users data from its source
+func newUsersStorage(ctx context.Context, config *storageConfig) (usersStorage, error) {
+	source := config.usersDataSource
+	strictValidation := config.UsersDataValidation == "strict"
+	storage := usersStorage{close: func() {}}
+
+	fetchUsersData := func() ([]lib.User, error) {
+		usersData, _, err := source.Fetch(ctx, strictValidation)
+		return usersData, err
+	}
+
+	switch config.StorageBackend {
+	case jsonFileStorageBackend:
+		// Reading users data file (all the writes are persisted back to it)
+		usersRepo, err := infra.NewUsersFileRepo(source.FilePath(), strictValidation)
+		if err != nil {
+			return usersStorage{}, err
+		}
+		storage.users = usersRepo
+		storage.reload = usersRepo.Reload
+
+		// Reading users history file (every change is appended to it)
+		revisionsRepo, err := infra.NewRevisionsFileRepo(config.UsersHistoryFilePath)
+		if err != nil {
+			return usersStorage{}, err
+		}
+		storage.revisions = revisionsRepo
+
+		// Reading users audit trail file (every audited operation is appended to it)
+		auditRepo, err := infra.NewAuditFileRepo(config.UsersAuditFilePath)
+		if err != nil {
+			return usersStorage{}, err
+		}
+		storage.audit = auditRepo
+
+	case memoryStorageBackend:
+		// Reading users data source (nothing is persisted, all the changes are lost on exit or reload)
+		usersData, err := fetchUsersData()
+		if err != nil {
+			return usersStorage{}, err
+		}
+		usersRepo := infra.NewUsersRepo(usersData)
+		storage.users = usersRepo
+		storage.revisions = infra.NewRevisionsRepo(nil)
+		storage.audit = infra.NewAuditRepo(nil)
+		storage.reload = func() (bool, error) {
+			usersData, changed, err := source.Fetch(ctx, strictValidation)
+			if err != nil || !changed {
+				return false, err
+			}
+			usersRepo.ReplaceUsersData(usersData)
+			return true, nil
+		}
+		return storage, nil
+
+	case sqliteStorageBackend:
+		// Opening users database (filled from the users data source when it's created)
+		usersRepo, err := infra.NewUsersSQLiteRepo(config.SQLiteDatabasePath, fetchUsersData)
+		if err != nil {
+			return usersStorage{}, err
+		}
+		storage.users = usersRepo
+		storage.revisions = usersRepo.RevisionsRepo()
+		storage.audit = usersRepo.AuditRepo()
+		storage.close = closeUsersDatabase(usersRepo)
+
+	case postgresStorageBackend:
+		// Connecting to users database (filled from the users data source when it's created)
+		usersRepo, err := infra.NewUsersPostgresRepo(config.PostgresURL, infra.PostgresPoolConfig{
+			MaxOpenConns:    config.PostgresMaxOpenConns,
+			MaxIdleConns:    config.PostgresMaxIdleConns,
+			ConnMaxLifetime: config.PostgresConnMaxLifetime,
+			ConnMaxIdleTime: config.PostgresConnMaxIdleTime,
+		}, fetchUsersData)
+		if err != nil {
+			return usersStorage{}, err
+		}
+		storage.users = usersRepo
+		storage.revisions = usersRepo.RevisionsRepo()
+		storage.audit = usersRepo.AuditRepo()
+		storage.close = closeUsersDatabase(usersRepo)
+	}
+
+	return storage, nil
+}
+
+// closeUsersDatabase gets the function closing the users database, logging the error
+func closeUsersDatabase(db io.Closer) func() {
+	return func() {
+		err := db.Close()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error()}).Error("closing users database")
+		}
+	}
+}
+
 func runHTTP(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
@@ -87,25 +400,41 @@ func runHTTP(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// Reading users data file
-	usersData, usersDataVersion, err := readUsersDataJSONFile()
+	storage, err := newUsersStorage(ctx, config.storageConfig)
 	if err != nil {
 		return err
 	}
+	defer storage.close()
+
+	usersSvc := lib.NewUsersService(
+		storage.users,
+		storage.revisions,
+		storage.audit,
+		config.AuthMaxFailedAttempts,
+		config.AuthLockoutDuration,
+	)
+
+	// Deleted users purge job (stopped on exit)
+	purgeCtx, stopPurge := context.WithCancel(ctx)
+	defer stopPurge()
+	go runDeletedUsersPurge(purgeCtx, usersSvc, config.DeletedUsersRetention, config.DeletedUsersPurgeInterval)
 
 	// Default HTTP Server
 	httpSrv := srv.NewHTTPServer(config.ServerPort,
-		srv.NewUsersHandler(
-			lib.NewUsersService(
-				infra.NewUsersRepo(usersData),
-			),
-		),
-		srv.ETagMiddleware(usersDataVersion),
+		srv.NewUsersHandler(usersSvc),
+		srv.ActorMiddleware,
+		srv.AdminMiddleware(config.AdminToken),
+		srv.IdempotencyMiddleware(config.IdempotencyKeyTTL),
 		srv.CORSMiddleware(
 			config.CORSAllowOrigin,
 			config.CORSAllowMethods,
 			config.CORSAllowHeaders,
 		),
+		srv.AuthRateLimiterMiddleware(
+			config.AuthRateLimitMaxFrequency,
+			config.AuthRateLimitBurstSize,
+			config.RateLimitMemoryDuration,
+		),
 		srv.RateLimiterMiddleware(
 			config.RateLimitMaxFrequency,
 			config.RateLimitBurstSize,
@@ -123,7 +452,16 @@ func runHTTP(cmd *cobra.Command, args []string) error {
 	defer healthSrv.Close(ctx)
 	healthSrv.ListenAndServe()
 
-	sig := waitSignal() // blocking until signal
+	// Users data source watch, reloading it on changes (stopped on exit)
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+	if storage.reload != nil && config.UsersDataWatch != "off" {
+		go watchUsersDataSource(watchCtx, config.usersDataSource, config.UsersDataWatch == "poll", config.UsersDataPollInterval, func() {
+			reloadUsersData(storage.reload)
+		})
+	}
+
+	sig := waitSignal(func() { reloadUsersData(storage.reload) }) // blocking until signal
 	log.WithFields(log.Fields{
 		"signal": sig.String(),
 	}).Debug("received signal, exiting...")
@@ -131,42 +469,329 @@ func runHTTP(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func configureLog(logLevel string) error {
-	lv, err := log.ParseLevel(logLevel)
+// watchUsersDataSource calls onChange when the users data source changes, until the context is done:
+// the files are watched (see infra.WatchFile), the URLs are polled (with conditional requests) and the stdin is not watched
+func watchUsersDataSource(ctx context.Context, source *infra.UsersDataSource, poll bool, pollInterval time.Duration, onChange func()) {
+	if source.FilePath() != "" {
+		infra.WatchFile(ctx, source.FilePath(), poll, pollInterval, onChange)
+		return
+	}
+	if !source.IsURL() {
+		return
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			onChange()
+		}
+	}
+}
+
+// reloadUsersData reloads the users data file (if supported by the storage backend), logging the result,
+// the current data is kept if the reload fails
+func reloadUsersData(reload func() (bool, error)) {
+	if reload == nil {
+		log.Warn("users data reload not supported by the storage backend")
+		return
+	}
+
+	reloaded, err := reload()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("users data reload failed")
+		return
+	}
+	if reloaded {
+		log.Info("users data reloaded")
+	}
+}
+
+// runDeletedUsersPurge purges the users deleted longer than the retention, at every interval, until the context is done
+func runDeletedUsersPurge(ctx context.Context, usersSvc deletedUsersPurger, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := usersSvc.PurgeDeletedUsers(ctx, retention)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err.Error(),
+				}).Error("deleted users purge failed")
+				continue
+			}
+			if purged > 0 {
+				log.WithFields(log.Fields{
+					"purged": purged,
+				}).Info("deleted users purged")
+			}
+		}
+	}
+}
+
+func runMigratePasswords(cmd *cobra.Command, args []string) error {
+	// plaintext passwords are hashed when the users data file is read
+	filePath, err := rewriteUsersDataFile(cmd)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"file": filePath,
+	}).Info("users passwords migrated")
+
+	return nil
+}
+
+func runMigrateDates(cmd *cobra.Command, args []string) error {
+	// legacy creation dates are parsed when the users data file is read
+	filePath, err := rewriteUsersDataFile(cmd)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"file": filePath,
+	}).Info("users creation dates migrated")
+
+	return nil
+}
+
+// rewriteUsersDataFile reads the users data file (from the "data-file" flag, or else the configured USERS_DATA_SOURCE)
+// and persists it back, in the current format, returns the file path
+func rewriteUsersDataFile(cmd *cobra.Command) (string, error) {
+	filePath, err := cmd.Flags().GetString("data-file")
+	if err != nil {
+		return "", err
+	}
+	if filePath == "" {
+		config, err := getStorageConfig()
+		if err != nil {
+			return "", err
+		}
+		filePath = config.usersDataSource.FilePath()
+		if filePath == "" {
+			return "", fmt.Errorf("%s requires a users data file, set --data-file or a file USERS_DATA_SOURCE (got '%s')",
+				cmd.Name(), config.UsersDataSource)
+		}
+	}
+
+	usersRepo, err := infra.NewUsersFileRepo(filePath, false)
+	if err != nil {
+		return "", err
+	}
+
+	err = usersRepo.Save()
+	if err != nil {
+		return "", err
+	}
+
+	return filePath, nil
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	inputPath, err := flags.GetString("input")
+	if err != nil {
+		return err
+	}
+	formatName, err := flags.GetString("format")
+	if err != nil {
+		return err
+	}
+	dryRun, err := flags.GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+	allOrNothing, err := flags.GetBool("all-or-nothing")
+	if err != nil {
+		return err
+	}
+
+	// the format defaults to the input file extension
+	if formatName == "" {
+		formatName = dataFormatFromExtension(inputPath)
+	}
+	format, err := lib.ParseDataFormat(formatName)
+	if err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if inputPath != "-" {
+		inputFile, err := os.Open(inputPath)
+		if err != nil {
+			return err
+		}
+		defer inputFile.Close()
+		input = inputFile
+	}
+
+	// the imported users revisions are recorded as made by the command line
+	ctx := lib.WithActor(context.Background(), cliActor)
+
+	storage, err := newCommandUsersStorage(ctx, cmd, !dryRun)
+	if err != nil {
+		return err
+	}
+	defer storage.close()
+
+	// the login lockout is not used by the import
+	usersSvc := lib.NewUsersService(storage.users, storage.revisions, storage.audit, 0, 0)
+
+	report, err := usersSvc.ImportUsers(ctx, input, format, lib.ImportOptions{
+		DryRun:       dryRun,
+		AllOrNothing: allOrNothing,
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(report)
 	if err != nil {
 		return err
 	}
-	log.SetLevel(lv)
-	log.SetFormatter(&log.JSONFormatter{})
 
+	if report.Rejected > 0 {
+		return fmt.Errorf("%d rows rejected", report.Rejected)
+	}
 	return nil
 }
 
-func readUsersDataJSONFile() ([]lib.User, string, error) {
-	jsonFile, err := os.Open(usersDataFilePath)
+func runExport(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	outputPath, err := flags.GetString("output")
+	if err != nil {
+		return err
+	}
+	formatName, err := flags.GetString("format")
+	if err != nil {
+		return err
+	}
+	fields, err := flags.GetStringSlice("fields")
+	if err != nil {
+		return err
+	}
+
+	// the format defaults to the output file extension
+	if formatName == "" {
+		formatName = dataFormatFromExtension(outputPath)
+	}
+	format, err := lib.ParseDataFormat(formatName)
 	if err != nil {
-		return nil, "", err
+		return err
 	}
-	defer jsonFile.Close()
 
-	jsonBytes, err := ioutil.ReadAll(jsonFile)
+	storage, err := newCommandUsersStorage(context.Background(), cmd, false)
 	if err != nil {
-		return nil, "", err
+		return err
 	}
+	defer storage.close()
 
-	dataVersion := fmt.Sprintf("%x", sha1.Sum(jsonBytes))
+	// the login lockout is not used by the export
+	usersSvc := lib.NewUsersService(storage.users, storage.revisions, storage.audit, 0, 0)
 
-	var usersData []lib.User
+	var output io.Writer = cmd.OutOrStdout()
+	if outputPath != "-" {
+		outputFile, err := os.Create(outputPath)
+		if err != nil {
+			return err
+		}
+		defer outputFile.Close()
+		output = outputFile
+	}
 
-	err = json.Unmarshal(jsonBytes, &usersData)
+	err = exportUsers(usersSvc, output, format, fields)
+	if err != nil && outputPath != "-" {
+		// not leaving a partial export behind
+		os.Remove(outputPath)
+	}
+	return err
+}
+
+// newCommandUsersStorage opens the storage of the import and export commands: the users data file of the "data-file" flag
+// (json-file backend, with the history and audit trail files next to it) if set, or else the configured storage backend
+// (see STORAGE_BACKEND and USERS_DATA_SOURCE), it must persist the changes if they are written
+func newCommandUsersStorage(ctx context.Context, cmd *cobra.Command, written bool) (usersStorage, error) {
+	dataFilePath, err := cmd.Flags().GetString("data-file")
 	if err != nil {
-		return nil, "", err
+		return usersStorage{}, err
+	}
+
+	var config *storageConfig
+	if dataFilePath != "" {
+		config = &storageConfig{StorageBackend: jsonFileStorageBackend, UsersDataSource: dataFilePath}
+		config.usersDataSource, err = infra.NewUsersDataSource(dataFilePath, 0)
+		if err != nil {
+			return usersStorage{}, err
+		}
+		setUsersFilesPaths(config)
+	} else {
+		config, err = getStorageConfig()
+		if err != nil {
+			return usersStorage{}, err
+		}
 	}
 
-	return usersData, dataVersion, nil
+	// the changes of the memory backend would be lost right away
+	if written && config.StorageBackend == memoryStorageBackend {
+		return usersStorage{}, fmt.Errorf("%s cannot write to the memory storage backend, set --data-file or another STORAGE_BACKEND", cmd.Name())
+	}
+
+	return newUsersStorage(ctx, config)
+}
+
+// exportUsers writes all the users in the format received as parameter (only the selected fields)
+func exportUsers(usersSvc usersExporter, output io.Writer, format lib.DataFormat, fields []string) error {
+	usersWriter, err := lib.NewUsersWriter(output, format, fields)
+	if err != nil {
+		return err
+	}
+
+	err = usersSvc.ExportUsers(context.Background(), lib.UsersFilter{}, nil, usersWriter.Write)
+	if err != nil {
+		return err
+	}
+
+	return usersWriter.Close()
 }
 
-func waitSignal() os.Signal {
+// dataFormatFromExtension gets the users data format name from the file extension (e.g. "users.csv" results in "csv")
+func dataFormatFromExtension(filePath string) string {
+	extension := strings.TrimPrefix(strings.ToLower(filepath.Ext(filePath)), ".")
+	if extension == "jsonl" {
+		return string(lib.FormatNDJSON)
+	}
+	return extension
+}
+
+func configureLog(logLevel string) error {
+	lv, err := log.ParseLevel(logLevel)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(lv)
+	log.SetFormatter(&log.JSONFormatter{})
+
+	return nil
+}
+
+// waitSignal blocks until an exit signal is received, calling onHangup on every SIGHUP
+func waitSignal(onHangup func()) os.Signal {
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig,
 		os.Interrupt,
@@ -174,6 +799,13 @@ func waitSignal() os.Signal {
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT)
+	defer signal.Stop(sig)
 
-	return <-sig
+	for {
+		s := <-sig
+		if s != syscall.SIGHUP {
+			return s
+		}
+		onHangup()
+	}
 }
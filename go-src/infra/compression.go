@@ -0,0 +1,48 @@
+package infra
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+)
+
+// gzipMagic is the header of the gzip-compressed data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isGzipped checks if the data is gzip-compressed
+func isGzipped(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// decompressData gets the data decompressed if it's gzip-compressed (as is otherwise)
+func decompressData(data []byte) ([]byte, error) {
+	if !isGzipped(data) {
+		return data, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+// compressData gets the data gzip-compressed
+func compressData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
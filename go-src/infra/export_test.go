@@ -0,0 +1,8 @@
+package infra
+
+// the test helpers exported to the external tests of the package (e.g. the users repo conformance tests)
+var (
+	NewTestUsersSQLiteRepo   = newTestUsersSQLiteRepo
+	NewTestUsersPostgresRepo = newTestUsersPostgresRepo
+	WriteTestUsersDataFile   = writeTestUsersDataFile
+)
@@ -0,0 +1,124 @@
+package infra
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// fileWatchDebounce groups the events of a single file change (e.g. written in several steps)
+	fileWatchDebounce = 100 * time.Millisecond
+)
+
+// WatchFile calls onChange every time the file changes (written, or replaced by a rename), until the context is done.
+// The file directory is watched with inotify (so the file replacements are seen too), or the file is polled
+// at the interval if inotify is not available or polling is requested (e.g. network file systems)
+func WatchFile(ctx context.Context, filePath string, poll bool, pollInterval time.Duration, onChange func()) {
+	if !poll {
+		watcher, err := watchFileDir(filePath)
+		if err == nil {
+			watchFileEvents(ctx, watcher, filePath, onChange)
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"file":  filePath,
+			"error": err.Error(),
+		}).Warn("watching file with inotify failed, polling it instead")
+	}
+
+	pollFile(ctx, filePath, pollInterval, onChange)
+}
+
+// watchFileDir creates an inotify watcher of the file directory
+func watchFileDir(filePath string) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	err = watcher.Add(filepath.Dir(filePath))
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	return watcher, nil
+}
+
+// watchFileEvents calls onChange after the events of the file (the other files of the directory are ignored),
+// once the events stop for the debounce time, until the context is done
+func watchFileEvents(ctx context.Context, watcher *fsnotify.Watcher, filePath string, onChange func()) {
+	defer watcher.Close()
+
+	filePath = filepath.Clean(filePath)
+
+	// nil (blocking) while there's no change pending
+	var debounce <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// the renames over the file are seen as creations
+			if filepath.Clean(event.Name) != filePath || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			debounce = time.After(fileWatchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.WithFields(log.Fields{
+				"file":  filePath,
+				"error": err.Error(),
+			}).Warn("watching file")
+		case <-debounce:
+			debounce = nil
+			onChange()
+		}
+	}
+}
+
+// pollFile calls onChange when the file info (identity, size or modification time) changes between the intervals,
+// until the context is done
+func pollFile(ctx context.Context, filePath string, interval time.Duration, onChange func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// nil while the file is missing
+	fileInfo, _ := os.Stat(filePath)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			newFileInfo, err := os.Stat(filePath)
+			if err != nil {
+				continue // missing (e.g. being replaced), checked again on the next interval
+			}
+
+			if fileInfo == nil || fileInfoChanged(fileInfo, newFileInfo) {
+				onChange()
+			}
+			fileInfo = newFileInfo
+		}
+	}
+}
+
+// fileInfoChanged checks if the file info shows a change of the file (or a different file)
+func fileInfoChanged(fileInfo, newFileInfo os.FileInfo) bool {
+	return !os.SameFile(fileInfo, newFileInfo) ||
+		fileInfo.Size() != newFileInfo.Size() ||
+		!fileInfo.ModTime().Equal(newFileInfo.ModTime())
+}
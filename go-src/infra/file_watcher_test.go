@@ -0,0 +1,79 @@
+package infra
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchFile(t *testing.T) {
+	testCases := []struct {
+		name string
+		poll bool
+	}{
+		{name: "inotify", poll: false},
+		{name: "polling", poll: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "users.json")
+			require.NoError(t, ioutil.WriteFile(filePath, []byte("[]"), 0644))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			changes := make(chan struct{}, 10)
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				WatchFile(ctx, filePath, tc.poll, 20*time.Millisecond, func() { changes <- struct{}{} })
+			}()
+
+			// waiting for the watch to start (the first poll is the reference)
+			time.Sleep(100 * time.Millisecond)
+
+			// the other files of the directory are ignored
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other.json"), []byte("[]"), 0644))
+			assertNoFileChange(t, changes)
+
+			// written in place
+			require.NoError(t, ioutil.WriteFile(filePath, []byte(`[{"id": "1"}]`), 0644))
+			assertFileChange(t, changes)
+
+			// replaced by a rename
+			require.NoError(t, writeFileAtomic(filePath, []byte(`[{"id": "2"}]`)))
+			assertFileChange(t, changes)
+			assertNoFileChange(t, changes)
+
+			cancel()
+			select {
+			case <-stopped:
+			case <-time.After(time.Second):
+				t.Fatal("watch not stopped with its context")
+			}
+		})
+	}
+}
+
+// assertFileChange asserts the watched file change is notified
+func assertFileChange(t *testing.T, changes <-chan struct{}) {
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "file change not notified")
+	}
+}
+
+// assertNoFileChange asserts no file change is notified (for a while)
+func assertNoFileChange(t *testing.T, changes <-chan struct{}) {
+	select {
+	case <-changes:
+		assert.Fail(t, "unexpected file change notified")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
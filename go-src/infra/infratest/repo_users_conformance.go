@@ -0,0 +1,511 @@
+// Package infratest holds the storage backends test helpers, linked into the test binaries only
+package infratest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hbernardo/users/go-src/infra"
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// conformancePasswordHash is the password of the conformance users (already hashed, as the repos store them)
+	conformancePasswordHash = "$2a$04$js1Fu.31sy/dmem1Xa9dQOZZVVOa0N71vQEiuKm9wTZBVqTOnaLTm"
+	// conformanceWriters is the number of concurrent writers (and readers) of the concurrent access tests
+	conformanceWriters = 8
+)
+
+// UsersRepoFactory creates a new users repo holding the users data (in order), for the test only
+// (e.g. released with t.Cleanup)
+type UsersRepoFactory func(t *testing.T, usersData []lib.User) infra.UsersRepo
+
+// RunUsersRepoConformance runs the users repo contract tests against the repos created by the factory,
+// so every storage backend behaves as the in-memory repo: pagination (offset and cursor) edge cases, not found users,
+// data order and sorting, filters, writes, conflicts and concurrent access
+func RunUsersRepoConformance(t *testing.T, newRepo UsersRepoFactory) {
+	t.Run("pagination", func(t *testing.T) { testConformancePagination(t, newRepo) })
+	t.Run("cursor pagination", func(t *testing.T) { testConformanceCursorPagination(t, newRepo) })
+	t.Run("not found", func(t *testing.T) { testConformanceNotFound(t, newRepo) })
+	t.Run("ordering", func(t *testing.T) { testConformanceOrdering(t, newRepo) })
+	t.Run("filters", func(t *testing.T) { testConformanceFilters(t, newRepo) })
+	t.Run("writes", func(t *testing.T) { testConformanceWrites(t, newRepo) })
+	t.Run("conflicts", func(t *testing.T) { testConformanceConflicts(t, newRepo) })
+	t.Run("concurrent access", func(t *testing.T) { testConformanceConcurrentAccess(t, newRepo) })
+}
+
+// conformanceUsersData gets the users of the conformance tests: with ties on the sort keys (to check the data order
+// is kept), a deleted user, IPv4, IPv6 and missing IP addresses, and non-ASCII names
+func conformanceUsersData() []lib.User {
+	deletedAt := time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC)
+
+	return []lib.User{
+		{ID: "144bf891-f161-4c9a-8d83-38a275e088a5", FirstName: "Nicky", LastName: "Blasio", Email: "nblasio0@jiathis.com",
+			IPAddress: "43.113.46.36", CreationDate: time.Date(2021, time.June, 6, 0, 0, 0, 0, time.UTC)},
+		{ID: "1311f914-1d4f-40b6-8886-80193265d5a4", FirstName: "Terrence", LastName: "Trillow", Email: "ttrillow1@feedburner.com",
+			IPAddress: "63.119.6.98", CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC), DeletedAt: &deletedAt},
+		{ID: "3e601207-0e80-4e7e-ae87-bb802b16a179", FirstName: "Niels", LastName: "MacPaik", Email: "nmacpaik2@phoca.cz",
+			IPAddress: "94.47.183.190", CreationDate: time.Date(2021, time.January, 19, 0, 0, 0, 0, time.UTC)},
+		{ID: "40d1b4ad-6b1b-4b37-8b7e-2c3c1c3c7f0e", FirstName: "nicky", LastName: "Zed", Email: "NZ@Jiathis.com",
+			IPAddress: "2001:db8::1", CreationDate: time.Date(2021, time.June, 6, 0, 0, 0, 0, time.UTC)},
+		{ID: "0a64ef1e-3b67-4a0b-9c4e-7a2a5e3c2a11", FirstName: "Ana", LastName: "blasio", Email: "ana@phoca.cz",
+			CreationDate: time.Date(2021, time.January, 19, 0, 0, 0, 0, time.UTC)},
+		{ID: "9b2f4c1e-8d7a-4e3b-a1c5-6f0e2d4b8a97", FirstName: "Óscar", LastName: "Núñez", Email: "oscar@example.com",
+			IPAddress: "10.0.0.1", CreationDate: time.Date(2020, time.December, 31, 23, 59, 59, 0, time.UTC)},
+	}
+}
+
+// conformanceUser gets a new user (not in the conformance users data), based on its number
+func conformanceUser(n int) lib.User {
+	return lib.User{
+		ID:           fmt.Sprintf("00000000-0000-4000-8000-%012d", n),
+		FirstName:    fmt.Sprintf("Writer%d", n),
+		LastName:     "Concurrent",
+		Email:        fmt.Sprintf("writer%d@example.com", n),
+		Password:     conformancePasswordHash,
+		IPAddress:    fmt.Sprintf("192.0.2.%d", n),
+		CreationDate: time.Date(2022, time.February, 1, 0, 0, n, 0, time.UTC),
+	}
+}
+
+// newConformanceRepo creates a repo holding the conformance users data
+func newConformanceRepo(t *testing.T, newRepo UsersRepoFactory) (infra.UsersRepo, []lib.User) {
+	usersData := conformanceUsersData()
+	for i := range usersData {
+		usersData[i].Password = conformancePasswordHash
+	}
+	return newRepo(t, usersData), usersData
+}
+
+// expectedUsers gets the users of the data matching the filter, sorted (the reference behavior of the queries)
+func expectedUsers(usersData []lib.User, filter lib.UsersFilter, sort []lib.SortField) []lib.User {
+	users := []lib.User{}
+	for _, user := range usersData {
+		if filter.Matches(user) {
+			users = append(users, user)
+		}
+	}
+	lib.SortUsers(users, sort)
+	return users
+}
+
+func testConformancePagination(t *testing.T, newRepo UsersRepoFactory) {
+	ctx := context.Background()
+	repo, usersData := newConformanceRepo(t, newRepo)
+	notDeleted := expectedUsers(usersData, lib.UsersFilter{}, nil)
+
+	testCases := []struct {
+		name          string
+		limit         int
+		offset        int
+		expectedUsers []lib.User
+	}{
+		{name: "first page", limit: 2, offset: 0, expectedUsers: notDeleted[:2]},
+		{name: "middle page", limit: 2, offset: 2, expectedUsers: notDeleted[2:4]},
+		{name: "last page partial", limit: 2, offset: 4, expectedUsers: notDeleted[4:]},
+		{name: "limit over total", limit: 100, offset: 0, expectedUsers: notDeleted},
+		{name: "zero limit", limit: 0, offset: 0, expectedUsers: []lib.User{}},
+		{name: "offset at total", limit: 2, offset: len(notDeleted), expectedUsers: []lib.User{}},
+		{name: "offset over total", limit: 2, offset: 100, expectedUsers: []lib.User{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: tc.limit, Offset: tc.offset})
+			assert.NoError(t, err)
+			assert.Equal(t, lib.UsersPage{Users: tc.expectedUsers, Total: len(notDeleted)}, page)
+
+			searchPage, err := repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "", Limit: tc.limit, Offset: tc.offset})
+			assert.NoError(t, err)
+			assert.Equal(t, 0, searchPage.Total)
+		})
+	}
+
+	for _, query := range []lib.UsersQuery{{Limit: -1}, {Limit: 1, Offset: -1}} {
+		_, err := repo.GetUsers(ctx, query)
+		assert.ErrorIs(t, err, lib.ErrPreconditionFailed)
+
+		_, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "nicky", Limit: query.Limit, Offset: query.Offset})
+		assert.ErrorIs(t, err, lib.ErrPreconditionFailed)
+	}
+}
+
+func testConformanceCursorPagination(t *testing.T, newRepo UsersRepoFactory) {
+	ctx := context.Background()
+	repo, usersData := newConformanceRepo(t, newRepo)
+
+	for _, sort := range [][]lib.SortField{
+		nil,
+		{{Field: "first_name"}},
+		{{Field: "last_name", Descending: true}},
+		{{Field: "ip_address"}},
+		{{Field: "creation_date", Descending: true}, {Field: "email"}},
+	} {
+		t.Run(fmt.Sprintf("sort %v", sort), func(t *testing.T) {
+			filter := lib.UsersFilter{IncludeDeleted: true}
+			expected := expectedUsers(usersData, filter, lib.KeysetSort(sort))
+
+			// walking all the pages, each one continuing right after the previous one
+			var users []lib.User
+			cursor := &lib.UsersCursor{Sort: sort}
+			for pages := 0; cursor != nil; pages++ {
+				require.Less(t, pages, len(expected), "too many pages")
+
+				page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 2, Cursor: cursor, Sort: sort, Filter: filter})
+				require.NoError(t, err)
+				assert.Equal(t, len(expected), page.Total)
+
+				users = append(users, page.Users...)
+				cursor = page.NextCursor
+			}
+			assert.Equal(t, expected, users)
+
+			// a zero limit has no next page
+			page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 0, Cursor: &lib.UsersCursor{Sort: sort}, Sort: sort, Filter: filter})
+			assert.NoError(t, err)
+			assert.Empty(t, page.Users)
+			assert.Nil(t, page.NextCursor)
+
+			// the cursor of the last user has nothing after it
+			last := lib.NewUsersCursor(expected[len(expected)-1], sort)
+			page, err = repo.GetUsers(ctx, lib.UsersQuery{Limit: 2, Cursor: &last, Sort: sort, Filter: filter})
+			assert.NoError(t, err)
+			assert.Empty(t, page.Users)
+			assert.Nil(t, page.NextCursor)
+		})
+	}
+
+	// the cursor stays valid when its user is deleted
+	sort := []lib.SortField{{Field: "first_name"}}
+	expected := expectedUsers(usersData, lib.UsersFilter{}, lib.KeysetSort(sort))
+	cursor := lib.NewUsersCursor(expected[1], sort)
+	require.NoError(t, repo.DeleteUser(ctx, expected[1].ID))
+
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 1, Cursor: &cursor, Sort: sort})
+	assert.NoError(t, err)
+	assert.Equal(t, expected[2:3], page.Users)
+}
+
+func testConformanceNotFound(t *testing.T, newRepo UsersRepoFactory) {
+	ctx := context.Background()
+	repo, usersData := newConformanceRepo(t, newRepo)
+	unknownUser := conformanceUser(0)
+
+	_, err := repo.GetUser(ctx, unknownUser.ID)
+	assert.ErrorIs(t, err, lib.ErrNotFound)
+
+	_, err = repo.GetUserByEmail(ctx, unknownUser.Email)
+	assert.ErrorIs(t, err, lib.ErrNotFound)
+
+	_, err = repo.UpdateUser(ctx, unknownUser, "")
+	assert.ErrorIs(t, err, lib.ErrNotFound)
+
+	err = repo.DeleteUser(ctx, unknownUser.ID)
+	assert.ErrorIs(t, err, lib.ErrNotFound)
+
+	// the failed writes don't change the data
+	_, err = repo.GetUser(ctx, unknownUser.ID)
+	assert.ErrorIs(t, err, lib.ErrNotFound)
+
+	batch, err := repo.GetUsersByIDs(ctx, []string{usersData[2].ID, unknownUser.ID, usersData[0].ID, usersData[2].ID})
+	assert.NoError(t, err)
+	assert.Equal(t, lib.UsersBatch{
+		Users:      []lib.User{usersData[2], usersData[0], usersData[2]},
+		MissingIDs: []string{unknownUser.ID},
+	}, batch)
+
+	batch, err = repo.GetUsersByIDs(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, lib.UsersBatch{Users: []lib.User{}, MissingIDs: []string{}}, batch)
+
+	// the deleted (soft deleted) users are still found by the direct reads
+	user, err := repo.GetUser(ctx, usersData[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, usersData[1], user)
+
+	// the emails are case-insensitive
+	user, err = repo.GetUserByEmail(ctx, "nz@JIATHIS.COM")
+	assert.NoError(t, err)
+	assert.Equal(t, usersData[3], user)
+}
+
+func testConformanceOrdering(t *testing.T, newRepo UsersRepoFactory) {
+	ctx := context.Background()
+	repo, usersData := newConformanceRepo(t, newRepo)
+
+	sorts := [][]lib.SortField{nil}
+	for _, field := range []string{"id", "first_name", "last_name", "email", "ip_address", "creation_date"} {
+		sorts = append(sorts, []lib.SortField{{Field: field}}, []lib.SortField{{Field: field, Descending: true}})
+	}
+	sorts = append(sorts,
+		[]lib.SortField{{Field: "first_name"}, {Field: "creation_date", Descending: true}},
+		[]lib.SortField{{Field: "creation_date"}, {Field: "last_name"}, {Field: "ip_address", Descending: true}},
+	)
+
+	for _, sort := range sorts {
+		t.Run(fmt.Sprintf("sort %v", sort), func(t *testing.T) {
+			// the ties keep the data order (stable sorting)
+			filter := lib.UsersFilter{IncludeDeleted: true}
+			page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 100, Filter: filter, Sort: sort})
+			assert.NoError(t, err)
+			assert.Equal(t, expectedUsers(usersData, filter, sort), page.Users)
+
+			page, err = repo.GetUsers(ctx, lib.UsersQuery{Limit: 2, Offset: 1, Filter: filter, Sort: sort})
+			assert.NoError(t, err)
+			assert.Equal(t, expectedUsers(usersData, filter, sort)[1:3], page.Users)
+		})
+	}
+
+	// the search results are ordered by relevance, and then by data order
+	page, err := repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "nicky", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{usersData[0], usersData[3]}, page.Users)
+	assert.Equal(t, 2, page.Total)
+
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "nicky", Limit: 1, Offset: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{usersData[3]}, page.Users)
+	assert.Equal(t, 2, page.Total)
+}
+
+func testConformanceFilters(t *testing.T, newRepo UsersRepoFactory) {
+	ctx := context.Background()
+	repo, usersData := newConformanceRepo(t, newRepo)
+
+	_, ipv4Net, _ := net.ParseCIDR("43.113.46.0/24")
+	_, ipv6Net, _ := net.ParseCIDR("2001:db8::/32")
+	_, emptyNet, _ := net.ParseCIDR("198.51.100.0/24")
+
+	filters := map[string]lib.UsersFilter{
+		"none":                    {},
+		"deleted included":        {IncludeDeleted: true},
+		"only deleted":            {OnlyDeleted: true},
+		"only deleted - filtered": {OnlyDeleted: true, EmailDomain: "phoca.cz"},
+		"first name":              {FirstName: &lib.StringFilter{Value: "NICKY"}},
+		"first name prefix":       {FirstName: &lib.StringFilter{Value: "ni", Prefix: true}},
+		"first name non-ASCII":    {FirstName: &lib.StringFilter{Value: "ós", Prefix: true}},
+		"last name":               {LastName: &lib.StringFilter{Value: "blasio"}},
+		"last name deleted":       {LastName: &lib.StringFilter{Value: "trillow"}},
+		"last name - deleted":     {LastName: &lib.StringFilter{Value: "trillow"}, IncludeDeleted: true},
+		"email domain":            {EmailDomain: "JIATHIS.com"},
+		"ipv4 network":            {IPNet: ipv4Net},
+		"ipv6 network":            {IPNet: ipv6Net},
+		"empty network":           {IPNet: emptyNet},
+		"created after":           {CreatedAfter: time.Date(2021, time.January, 19, 0, 0, 0, 0, time.UTC)},
+		"created before":          {CreatedBefore: time.Date(2021, time.June, 6, 0, 0, 0, 0, time.UTC)},
+		"created between":         {CreatedAfter: time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC), CreatedBefore: time.Date(2021, time.June, 7, 0, 0, 0, 0, time.UTC)},
+		"combined":                {FirstName: &lib.StringFilter{Value: "n", Prefix: true}, EmailDomain: "jiathis.com", CreatedAfter: time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)},
+		"no match":                {FirstName: &lib.StringFilter{Value: "nobody"}},
+		"combined - no match":     {LastName: &lib.StringFilter{Value: "zed"}, IPNet: ipv4Net},
+		"email domain - deleted":  {EmailDomain: "phoca.cz", IncludeDeleted: true},
+		"first name - partial":    {FirstName: &lib.StringFilter{Value: "nick"}},
+	}
+
+	for name, filter := range filters {
+		t.Run(name, func(t *testing.T) {
+			sort := []lib.SortField{{Field: "last_name"}}
+			expected := expectedUsers(usersData, filter, sort)
+
+			page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 100, Filter: filter, Sort: sort})
+			assert.NoError(t, err)
+			assert.Equal(t, lib.UsersPage{Users: expected, Total: len(expected)}, page)
+		})
+	}
+}
+
+func testConformanceWrites(t *testing.T, newRepo UsersRepoFactory) {
+	ctx := context.Background()
+	repo, usersData := newConformanceRepo(t, newRepo)
+
+	// the created users are added to the end of the data
+	newUser := conformanceUser(1)
+	createdUser, err := repo.CreateUser(ctx, newUser)
+	assert.NoError(t, err)
+	assert.Equal(t, newUser, createdUser)
+	usersData = append(usersData, newUser)
+
+	// the updated users keep their positions
+	updatedUser := usersData[0]
+	updatedUser.FirstName = "Nicholas"
+	updatedUser.Email = "nicholas@jiathis.com"
+	returnedUser, err := repo.UpdateUser(ctx, updatedUser, lib.UserVersion(usersData[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, updatedUser, returnedUser)
+
+	// the updates expecting a previous version are rejected
+	staleUser := usersData[0]
+	staleUser.LastName = "Stale"
+	_, err = repo.UpdateUser(ctx, staleUser, lib.UserVersion(usersData[0]))
+	assert.ErrorIs(t, err, lib.ErrPreconditionFailed)
+	usersData[0] = updatedUser
+
+	// the soft deleted users are updates too
+	deletedAt := time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC)
+	deletedUser := usersData[2]
+	deletedUser.DeletedAt = &deletedAt
+	_, err = repo.UpdateUser(ctx, deletedUser, "")
+	assert.NoError(t, err)
+	usersData[2] = deletedUser
+
+	// the removed users keep the order of the others
+	err = repo.DeleteUser(ctx, usersData[3].ID)
+	assert.NoError(t, err)
+	removedUser := usersData[3]
+	usersData = append(usersData[:3:3], usersData[4:]...)
+
+	// the upserted users replace the existing ones in place and the new ones are added to the end (the last write wins)
+	replacedUser := usersData[4]
+	replacedUser.LastName = "Nuñez"
+	otherUser := conformanceUser(2)
+	err = repo.UpsertUsers(ctx, []lib.User{otherUser, usersData[4], replacedUser, removedUser})
+	assert.NoError(t, err)
+	usersData[4] = replacedUser
+	usersData = append(usersData, otherUser, removedUser)
+
+	err = repo.UpsertUsers(ctx, nil)
+	assert.NoError(t, err)
+
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 100, Filter: lib.UsersFilter{IncludeDeleted: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, lib.UsersPage{Users: usersData, Total: len(usersData)}, page)
+
+	// the writes are seen by all the reads
+	user, err := repo.GetUser(ctx, updatedUser.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, updatedUser, user)
+
+	user, err = repo.GetUserByEmail(ctx, "NICHOLAS@jiathis.com")
+	assert.NoError(t, err)
+	assert.Equal(t, updatedUser, user)
+
+	_, err = repo.GetUserByEmail(ctx, "nblasio0@jiathis.com")
+	assert.ErrorIs(t, err, lib.ErrNotFound)
+
+	page, err = repo.GetUsers(ctx, lib.UsersQuery{Limit: 100, Filter: lib.UsersFilter{FirstName: &lib.StringFilter{Value: "nicholas"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{updatedUser}, page.Users)
+
+	// the search index follows the writes (the deleted users are not searchable)
+	for text, expected := range map[string][]lib.User{
+		"nicholas":   {updatedUser},
+		"concurrent": {newUser, otherUser},
+		"niels":      {},
+		"nuñez":      {replacedUser},
+		"zed":        {removedUser},
+	} {
+		page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: text, Limit: 10})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, page.Users, text)
+	}
+
+	// the returned users are copies, the caller changes don't affect the repo
+	page, err = repo.GetUsers(ctx, lib.UsersQuery{Limit: 1, Offset: len(usersData) - 1, Filter: lib.UsersFilter{IncludeDeleted: true}})
+	require.NoError(t, err)
+	page.Users[0].FirstName = "Changed"
+	user, err = repo.GetUser(ctx, removedUser.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, removedUser, user)
+}
+
+func testConformanceConflicts(t *testing.T, newRepo UsersRepoFactory) {
+	ctx := context.Background()
+	repo, usersData := newConformanceRepo(t, newRepo)
+
+	// creating a user with an existing ID (deleted users included) is a conflict
+	for _, existingUser := range []lib.User{usersData[0], usersData[1]} {
+		conflictingUser := conformanceUser(1)
+		conflictingUser.ID = existingUser.ID
+
+		_, err := repo.CreateUser(ctx, conflictingUser)
+		assert.ErrorIs(t, err, lib.ErrConflict)
+
+		// the existing user is kept
+		user, err := repo.GetUser(ctx, existingUser.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, existingUser, user)
+	}
+
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 100, Filter: lib.UsersFilter{IncludeDeleted: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, lib.UsersPage{Users: usersData, Total: len(usersData)}, page)
+
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "writer1", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, page.Total)
+}
+
+func testConformanceConcurrentAccess(t *testing.T, newRepo UsersRepoFactory) {
+	ctx := context.Background()
+	repo, usersData := newConformanceRepo(t, newRepo)
+	updatedID := usersData[0].ID
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*conformanceWriters)
+	for i := 1; i <= conformanceWriters; i++ {
+		wg.Add(4)
+
+		// creating different users
+		go func(i int) {
+			defer wg.Done()
+			_, err := repo.CreateUser(ctx, conformanceUser(i))
+			errs <- err
+		}(i)
+
+		// updating the same user
+		go func(i int) {
+			defer wg.Done()
+			user := usersData[0]
+			user.FirstName = fmt.Sprintf("Nicky%d", i)
+			_, err := repo.UpdateUser(ctx, user, "")
+			errs <- err
+		}(i)
+
+		// reading consistent pages: the total and the page are from the same data
+		go func() {
+			defer wg.Done()
+			page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 100})
+			if err == nil && page.Total != len(page.Users) {
+				err = fmt.Errorf("inconsistent page: total %d, %d users", page.Total, len(page.Users))
+			}
+			errs <- err
+		}()
+
+		go func() {
+			defer wg.Done()
+			_, err := repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "writer", Limit: 100})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// none of the writes was lost
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 100, Filter: lib.UsersFilter{IncludeDeleted: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, len(usersData)+conformanceWriters, page.Total)
+
+	for i := 1; i <= conformanceWriters; i++ {
+		user, err := repo.GetUser(ctx, conformanceUser(i).ID)
+		assert.NoError(t, err)
+		assert.Equal(t, conformanceUser(i), user)
+	}
+
+	// one of the updates won, and it's the one indexed
+	updatedUser, err := repo.GetUser(ctx, updatedID)
+	require.NoError(t, err)
+	assert.Regexp(t, `^Nicky[1-9]$`, updatedUser.FirstName)
+
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: updatedUser.FirstName, Limit: 100})
+	assert.NoError(t, err)
+	assert.Contains(t, page.Users, updatedUser)
+}
@@ -0,0 +1,109 @@
+package infra
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// encodeNDJSON encodes the values as NDJSON (one JSON value per line)
+func encodeNDJSON(values []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, value := range values {
+		err := encoder.Encode(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// appendNDJSONFile appends the values to the NDJSON file (created if it doesn't exist), flushing them to disk,
+// the file name describes the file in the errors (e.g. "users history file")
+func appendNDJSONFile(filePath string, fileName string, values []interface{}) error {
+	data, err := encodeNDJSON(values)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", fileName, err)
+	}
+
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("writing %s: %w", fileName, err)
+	}
+
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("writing %s: %w", fileName, err)
+	}
+
+	return file.Close()
+}
+
+// rewriteNDJSONFile replaces the NDJSON file content by the values (atomically, see writeFileAtomic)
+func rewriteNDJSONFile(filePath string, fileName string, values []interface{}) error {
+	data, err := encodeNDJSON(values)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(filePath, data)
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// readNDJSONFile calls the decode function for each line of the NDJSON file (none if it doesn't exist),
+// truncating the file if its last line is incomplete (an append interrupted by a crash),
+// the file name describes the file in the errors and logs (e.g. "users history file")
+func readNDJSONFile(filePath string, fileName string, decode func(line []byte) error) error {
+	data, err := ioutil.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// only the complete lines (ending with a line break) are read
+	completeSize := bytes.LastIndexByte(data, '\n') + 1
+	if completeSize < len(data) {
+		log.WithFields(log.Fields{
+			"file": filePath,
+		}).Warnf("discarding incomplete %s line", fileName)
+
+		err = os.Truncate(filePath, int64(completeSize))
+		if err != nil {
+			return err
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data[:completeSize]))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		err = decode(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("%s %s line %d: %w", fileName, filePath, line, err)
+		}
+	}
+
+	return scanner.Err()
+}
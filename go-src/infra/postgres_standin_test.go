@@ -0,0 +1,616 @@
+package infra
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// standInReplacer translates the PostgreSQL-only constructs of the repo schema to SQLite
+var standInReplacer = strings.NewReplacer(
+	"BIGSERIAL PRIMARY KEY", "INTEGER PRIMARY KEY AUTOINCREMENT",
+	"BYTEA", "BLOB",
+	` COLLATE "C"`, "",
+)
+
+// standInPlaceholder matches the numbered query placeholders ($1, $2...)
+var standInPlaceholder = regexp.MustCompile(`\$([0-9]+)`)
+
+type (
+	// postgresStandIn is an in-process PostgreSQL stand-in for the tests, so no database server is needed:
+	// it speaks the PostgreSQL wire protocol (simple and extended query flows, cancel requests) and runs the queries
+	// on an SQLite database, translating the few PostgreSQL-only constructs used by the users Postgres repo
+	postgresStandIn struct {
+		listener net.Listener
+		db       *sql.DB
+
+		mutex    sync.Mutex
+		sessions map[uint32]*standInSession
+		nextPID  uint32
+		// beforeQuery is called before running each query (optional), its error fails the query
+		beforeQuery func(ctx context.Context, query string) error
+	}
+
+	// standInSession is a client connection
+	standInSession struct {
+		standIn *postgresStandIn
+		backend *pgproto3.Backend
+		conn    *sql.Conn
+		pid     uint32
+
+		statements map[string]string
+		portals    map[string]*standInPortal
+		// txStatus is the transaction status: idle ('I'), in a transaction ('T') or in a failed transaction ('E')
+		txStatus byte
+		// skipping ignores the extended query messages after an error, until the next Sync
+		skipping bool
+
+		// cancelMutex protects the cancellation of the running query
+		cancelMutex sync.Mutex
+		cancel      context.CancelFunc
+	}
+
+	// standInPortal is a bound statement, with its result once it's run
+	standInPortal struct {
+		query  string
+		args   []interface{}
+		result *standInResult
+	}
+
+	// standInResult is a query result
+	standInResult struct {
+		columns []string
+		rows    [][]interface{}
+		tag     string
+	}
+
+	// standInErrorResponse is an error sent to the client
+	standInErrorResponse pgproto3.ErrorResponse
+)
+
+// newPostgresStandIn starts a PostgreSQL stand-in over a temporary SQLite database, stopped with the test
+func newPostgresStandIn(t *testing.T) *postgresStandIn {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "postgres.db")+sqliteDSNParams)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	standIn := &postgresStandIn{
+		listener: listener,
+		db:       db,
+		sessions: map[uint32]*standInSession{},
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				standIn.serve(conn)
+			}()
+		}
+	}()
+
+	t.Cleanup(func() {
+		listener.Close()
+		wg.Wait()
+		db.Close()
+	})
+
+	return standIn
+}
+
+// url gets the connection URL of the stand-in (the byte arrays parameters are sent in binary, as the stand-in
+// doesn't infer the parameters types)
+func (s *postgresStandIn) url() string {
+	return fmt.Sprintf("postgres://test@%s/users?sslmode=disable&binary_parameters=yes", s.listener.Addr())
+}
+
+// setBeforeQuery sets the function called before running each query
+func (s *postgresStandIn) setBeforeQuery(beforeQuery func(ctx context.Context, query string) error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.beforeQuery = beforeQuery
+}
+
+// serve handles the client connection until it's closed
+func (s *postgresStandIn) serve(netConn net.Conn) {
+	defer netConn.Close()
+
+	backend := pgproto3.NewBackend(pgproto3.NewChunkReader(netConn), netConn)
+
+	for {
+		startup, err := backend.ReceiveStartupMessage()
+		if err != nil {
+			return
+		}
+
+		switch startup := startup.(type) {
+		case *pgproto3.SSLRequest:
+			// no SSL support
+			_, err = netConn.Write([]byte("N"))
+			if err != nil {
+				return
+			}
+			continue
+
+		case *pgproto3.CancelRequest:
+			s.mutex.Lock()
+			session := s.sessions[startup.ProcessID]
+			s.mutex.Unlock()
+			if session != nil {
+				session.cancelQuery()
+			}
+			return
+
+		case *pgproto3.StartupMessage:
+			conn, err := s.db.Conn(context.Background())
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			s.mutex.Lock()
+			s.nextPID++
+			session := &standInSession{
+				standIn:    s,
+				backend:    backend,
+				conn:       conn,
+				pid:        s.nextPID,
+				statements: map[string]string{},
+				portals:    map[string]*standInPortal{},
+				txStatus:   'I',
+			}
+			s.sessions[session.pid] = session
+			s.mutex.Unlock()
+
+			defer func() {
+				s.mutex.Lock()
+				delete(s.sessions, session.pid)
+				s.mutex.Unlock()
+			}()
+
+			session.run()
+			return
+
+		default:
+			return
+		}
+	}
+}
+
+// run handles the session messages until the client terminates it
+func (s *standInSession) run() {
+	for _, msg := range []pgproto3.BackendMessage{
+		&pgproto3.AuthenticationOk{},
+		&pgproto3.ParameterStatus{Name: "server_version", Value: "14.0"},
+		&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"},
+		&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"},
+		&pgproto3.ParameterStatus{Name: "TimeZone", Value: "UTC"},
+		&pgproto3.BackendKeyData{ProcessID: s.pid, SecretKey: s.pid},
+		&pgproto3.ReadyForQuery{TxStatus: s.txStatus},
+	} {
+		if s.backend.Send(msg) != nil {
+			return
+		}
+	}
+
+	for {
+		msg, err := s.backend.Receive()
+		if err != nil {
+			return
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.Query:
+			err = s.simpleQuery(msg.String)
+		case *pgproto3.Parse:
+			err = s.extended(func() error {
+				s.statements[msg.Name] = msg.Query
+				return s.backend.Send(&pgproto3.ParseComplete{})
+			})
+		case *pgproto3.Bind:
+			err = s.extended(func() error { return s.bind(msg) })
+		case *pgproto3.Describe:
+			err = s.extended(func() error { return s.describe(msg) })
+		case *pgproto3.Execute:
+			err = s.extended(func() error { return s.execute(msg) })
+		case *pgproto3.Close:
+			err = s.extended(func() error {
+				delete(s.statements, msg.Name)
+				delete(s.portals, msg.Name)
+				return s.backend.Send(&pgproto3.CloseComplete{})
+			})
+		case *pgproto3.Sync:
+			s.skipping = false
+			err = s.backend.Send(&pgproto3.ReadyForQuery{TxStatus: s.txStatus})
+		case *pgproto3.Terminate:
+			return
+		default:
+			err = fmt.Errorf("unsupported message %T", msg)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// simpleQuery runs the query (simple query flow), sending its result
+func (s *standInSession) simpleQuery(query string) error {
+	if strings.TrimSpace(query) == "" {
+		err := s.backend.Send(&pgproto3.EmptyQueryResponse{})
+		if err != nil {
+			return err
+		}
+	} else {
+		result, err := s.runQuery(query, nil)
+		var errResponse *standInErrorResponse
+		if errors.As(err, &errResponse) {
+			err = s.backend.Send((*pgproto3.ErrorResponse)(errResponse))
+		} else if err == nil {
+			err = s.sendResult(result, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.backend.Send(&pgproto3.ReadyForQuery{TxStatus: s.txStatus})
+}
+
+// extended handles the extended query flow message, unless it's skipped after an error
+func (s *standInSession) extended(handle func() error) error {
+	if s.skipping {
+		return nil
+	}
+
+	err := handle()
+	var errResponse *standInErrorResponse
+	if errors.As(err, &errResponse) {
+		s.skipping = true
+		return s.backend.Send((*pgproto3.ErrorResponse)(errResponse))
+	}
+	return err
+}
+
+// bind creates the portal of the statement with the parameters
+func (s *standInSession) bind(msg *pgproto3.Bind) error {
+	query, statementExists := s.statements[msg.PreparedStatement]
+	if !statementExists {
+		return standInError("26000", "prepared statement %q does not exist", msg.PreparedStatement)
+	}
+
+	args := make([]interface{}, len(msg.Parameters))
+	for i, param := range msg.Parameters {
+		if param == nil {
+			continue
+		}
+
+		format := int16(0)
+		if len(msg.ParameterFormatCodes) == 1 {
+			format = msg.ParameterFormatCodes[0]
+		} else if len(msg.ParameterFormatCodes) > i {
+			format = msg.ParameterFormatCodes[i]
+		}
+
+		// the message buffer is reused by the next messages
+		if format == 1 {
+			args[i] = append([]byte{}, param...)
+		} else {
+			args[i] = string(param)
+		}
+	}
+
+	s.portals[msg.DestinationPortal] = &standInPortal{query: query, args: args}
+	return s.backend.Send(&pgproto3.BindComplete{})
+}
+
+// describe sends the description of the statement (parameters and columns) or portal (columns, running it)
+func (s *standInSession) describe(msg *pgproto3.Describe) error {
+	if msg.ObjectType == 'P' {
+		portal, err := s.runPortal(msg.Name)
+		if err != nil {
+			return err
+		}
+		return s.sendRowDescription(portal.result)
+	}
+
+	query, statementExists := s.statements[msg.Name]
+	if !statementExists {
+		return standInError("26000", "prepared statement %q does not exist", msg.Name)
+	}
+
+	paramsCount := 0
+	for _, match := range standInPlaceholder.FindAllStringSubmatch(query, -1) {
+		n, _ := strconv.Atoi(match[1])
+		if n > paramsCount {
+			paramsCount = n
+		}
+	}
+	err := s.backend.Send(&pgproto3.ParameterDescription{ParameterOIDs: make([]uint32, paramsCount)})
+	if err != nil {
+		return err
+	}
+
+	if !isStandInQuery(query) {
+		return s.backend.Send(&pgproto3.NoData{})
+	}
+
+	// the columns are got by running the query without parameters (the queries have no side effects)
+	result, err := s.runQuery(query, make([]interface{}, paramsCount))
+	if err != nil {
+		return err
+	}
+	return s.sendRowDescription(result)
+}
+
+// execute sends the result of the portal, running it if it's not described yet
+func (s *standInSession) execute(msg *pgproto3.Execute) error {
+	portal, err := s.runPortal(msg.Portal)
+	if err != nil {
+		return err
+	}
+	return s.sendResult(portal.result, false)
+}
+
+// runPortal runs the portal, once
+func (s *standInSession) runPortal(name string) (*standInPortal, error) {
+	portal, portalExists := s.portals[name]
+	if !portalExists {
+		return nil, standInError("34000", "portal %q does not exist", name)
+	}
+
+	if portal.result == nil {
+		result, err := s.runQuery(portal.query, portal.args)
+		if err != nil {
+			return nil, err
+		}
+		portal.result = result
+	}
+	return portal, nil
+}
+
+// runQuery runs the query on the SQLite database (cancelable by a cancel request), the errors are error responses
+func (s *standInSession) runQuery(query string, args []interface{}) (*standInResult, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.cancelMutex.Lock()
+	s.cancel = cancel
+	s.cancelMutex.Unlock()
+
+	result, err := s.runStatement(ctx, query, args)
+	if err == nil {
+		return result, nil
+	}
+
+	if s.txStatus == 'T' {
+		s.txStatus = 'E'
+	}
+
+	var errResponse *standInErrorResponse
+	switch {
+	case errors.As(err, &errResponse):
+	case ctx.Err() != nil:
+		errResponse = standInError("57014", "canceling statement due to user request")
+	case isSQLiteUniqueViolation(err):
+		errResponse = standInError("23505", "duplicate key value violates unique constraint: %s", err)
+	default:
+		errResponse = standInError("XX000", "%s", err)
+	}
+	return nil, errResponse
+}
+
+// runStatement runs the statement, handling the transactions and locks
+func (s *standInSession) runStatement(ctx context.Context, query string, args []interface{}) (*standInResult, error) {
+	query = strings.TrimSpace(query)
+	keyword := strings.ToUpper(strings.Fields(query)[0])
+
+	switch keyword {
+	case "BEGIN", "START":
+		statement := "BEGIN IMMEDIATE"
+		if strings.Contains(strings.ToUpper(query), "READ ONLY") {
+			statement = "BEGIN"
+		}
+		_, err := s.conn.ExecContext(ctx, statement)
+		if err != nil {
+			return nil, err
+		}
+		s.txStatus = 'T'
+		return &standInResult{tag: "BEGIN"}, nil
+
+	case "COMMIT", "END", "ROLLBACK", "ABORT":
+		statement, tag := "COMMIT", "COMMIT"
+		if keyword == "ROLLBACK" || keyword == "ABORT" || s.txStatus == 'E' {
+			statement, tag = "ROLLBACK", "ROLLBACK"
+		}
+		_, err := s.conn.ExecContext(ctx, statement)
+		if err != nil && s.txStatus != 'I' {
+			return nil, err
+		}
+		s.txStatus = 'I'
+		return &standInResult{tag: tag}, nil
+	}
+
+	if s.txStatus == 'E' {
+		return nil, standInError("25P02", "current transaction is aborted, commands ignored until end of transaction block")
+	}
+
+	s.standIn.mutex.Lock()
+	beforeQuery := s.standIn.beforeQuery
+	s.standIn.mutex.Unlock()
+	if beforeQuery != nil {
+		err := beforeQuery(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if keyword == "LOCK" {
+		// the SQLite write transactions are exclusive already
+		return &standInResult{tag: "LOCK TABLE"}, nil
+	}
+
+	query = standInReplacer.Replace(query)
+
+	if !isStandInQuery(query) {
+		execResult, err := s.conn.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		rowsAffected, _ := execResult.RowsAffected()
+
+		tag := strings.ToUpper(strings.Join(strings.Fields(query)[:2], " "))
+		switch keyword {
+		case "INSERT":
+			tag = fmt.Sprintf("INSERT 0 %d", rowsAffected)
+		case "UPDATE", "DELETE":
+			tag = fmt.Sprintf("%s %d", keyword, rowsAffected)
+		}
+		return &standInResult{tag: tag}, nil
+	}
+
+	rows, err := s.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &standInResult{}
+	result.columns, err = rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		row := make([]interface{}, len(result.columns))
+		pointers := make([]interface{}, len(row))
+		for i := range row {
+			pointers[i] = &row[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+		result.rows = append(result.rows, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	result.tag = fmt.Sprintf("SELECT %d", len(result.rows))
+
+	return result, nil
+}
+
+// cancelQuery cancels the running query
+func (s *standInSession) cancelQuery() {
+	s.cancelMutex.Lock()
+	defer s.cancelMutex.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// sendRowDescription sends the columns of the result (all as text), or no data if it has none
+func (s *standInSession) sendRowDescription(result *standInResult) error {
+	if result.columns == nil {
+		return s.backend.Send(&pgproto3.NoData{})
+	}
+
+	fields := make([]pgproto3.FieldDescription, len(result.columns))
+	for i, column := range result.columns {
+		fields[i] = pgproto3.FieldDescription{
+			Name:         []byte(column),
+			DataTypeOID:  25, // text
+			DataTypeSize: -1,
+			TypeModifier: -1,
+		}
+	}
+	return s.backend.Send(&pgproto3.RowDescription{Fields: fields})
+}
+
+// sendResult sends the result rows (with their description in the simple query flow) and command tag
+func (s *standInSession) sendResult(result *standInResult, withDescription bool) error {
+	if withDescription && result.columns != nil {
+		err := s.sendRowDescription(result)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, row := range result.rows {
+		values := make([][]byte, len(row))
+		for i, value := range row {
+			values[i] = standInText(value)
+		}
+		err := s.backend.Send(&pgproto3.DataRow{Values: values})
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(result.tag)})
+}
+
+// isStandInQuery checks if the statement returns rows
+func isStandInQuery(query string) bool {
+	keyword := strings.ToUpper(strings.Fields(query)[0])
+	return keyword == "SELECT" || keyword == "WITH"
+}
+
+// standInText gets the value in the text format (nil for NULL)
+func standInText(value interface{}) []byte {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return value
+	case string:
+		return []byte(value)
+	case int64:
+		return []byte(strconv.FormatInt(value, 10))
+	case float64:
+		return []byte(strconv.FormatFloat(value, 'g', -1, 64))
+	case bool:
+		return []byte(strconv.FormatBool(value)[:1])
+	case time.Time:
+		return []byte(value.Format(time.RFC3339Nano))
+	default:
+		return []byte(fmt.Sprint(value))
+	}
+}
+
+// standInError creates an error response
+func standInError(code string, format string, args ...interface{}) *standInErrorResponse {
+	return &standInErrorResponse{
+		Severity: "ERROR",
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// Error gets the error message
+func (e *standInErrorResponse) Error() string {
+	return e.Message
+}
@@ -0,0 +1,59 @@
+package infra
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hbernardo/users/go-src/lib"
+)
+
+type (
+	auditRepo struct {
+		// mutex protects the audit entries against concurrent writes
+		mutex sync.RWMutex
+		// entriesMap has the audit entries of each user (by ID), in order
+		entriesMap map[string][]lib.AuditEntry
+
+		// persist is called with the new entry before it's added (optional),
+		// it's discarded if it returns an error
+		persist func(entry lib.AuditEntry) error
+	}
+)
+
+// NewAuditRepo creates a new audit repo, receives the audit entries (the whole audit trail, in order) as parameter
+func NewAuditRepo(entries []lib.AuditEntry) *auditRepo {
+	repo := &auditRepo{
+		entriesMap: make(map[string][]lib.AuditEntry),
+	}
+
+	for _, entry := range entries {
+		repo.entriesMap[entry.UserID] = append(repo.entriesMap[entry.UserID], entry)
+	}
+
+	return repo
+}
+
+// AddAuditEntry appends the entry to the audit trail
+func (r *auditRepo) AddAuditEntry(ctx context.Context, entry lib.AuditEntry) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.persist != nil {
+		err := r.persist(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.entriesMap[entry.UserID] = append(r.entriesMap[entry.UserID], entry)
+
+	return nil
+}
+
+// GetAuditEntries gets the audit entries of the user, in order (empty if there are none)
+func (r *auditRepo) GetAuditEntries(ctx context.Context, userID string) ([]lib.AuditEntry, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return append([]lib.AuditEntry{}, r.entriesMap[userID]...), nil
+}
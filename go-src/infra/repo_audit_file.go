@@ -0,0 +1,64 @@
+package infra
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/hbernardo/users/go-src/lib"
+)
+
+const (
+	// usersAuditSuffix replaces the users data file extension to get the users audit trail file path
+	usersAuditSuffix = ".audit.ndjson"
+
+	// usersAuditFileName describes the users audit trail file in the errors
+	usersAuditFileName = "users audit file"
+)
+
+type (
+	// auditFileRepo is an audit repo backed by an append-only NDJSON file (one entry per line),
+	// every added entry is persisted to disk
+	auditFileRepo struct {
+		*auditRepo
+
+		filePath string
+	}
+)
+
+// UsersAuditFilePath gets the path of the users audit trail file, next to the users data file received as parameter
+// (e.g. "data/users.audit.ndjson" for "data/users.json")
+func UsersAuditFilePath(usersDataFilePath string) string {
+	return strings.TrimSuffix(usersDataFilePath, filepath.Ext(usersDataFilePath)) + usersAuditSuffix
+}
+
+// NewAuditFileRepo creates a new audit repo backed by the NDJSON file received as parameter
+// (created on the first write if it doesn't exist), an incomplete last line (interrupted append) is discarded
+func NewAuditFileRepo(filePath string) (*auditFileRepo, error) {
+	entries := []lib.AuditEntry{}
+	err := readNDJSONFile(filePath, usersAuditFileName, func(line []byte) error {
+		var entry lib.AuditEntry
+		err := json.Unmarshal(line, &entry)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &auditFileRepo{
+		auditRepo: NewAuditRepo(entries),
+		filePath:  filePath,
+	}
+	repo.auditRepo.persist = repo.appendEntry
+
+	return repo, nil
+}
+
+// appendEntry appends the entry to the file, flushing it to disk
+func (r *auditFileRepo) appendEntry(entry lib.AuditEntry) error {
+	return appendNDJSONFile(r.filePath, usersAuditFileName, []interface{}{entry})
+}
@@ -0,0 +1,47 @@
+package infra
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsersAuditFilePath(t *testing.T) {
+	assert.Equal(t, "data/users.audit.ndjson", UsersAuditFilePath("data/users.json"))
+	assert.Equal(t, "users.audit.ndjson", UsersAuditFilePath("users"))
+}
+
+func TestAuditFileRepo(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "users.audit.ndjson")
+	ctx := context.Background()
+
+	// the file is created on the first write
+	repo, err := NewAuditFileRepo(filePath)
+	require.NoError(t, err)
+
+	for _, entry := range testAuditEntries {
+		err = repo.AddAuditEntry(ctx, entry)
+		require.NoError(t, err)
+	}
+
+	// entries must survive a restart
+	reopenedRepo, err := NewAuditFileRepo(filePath)
+	require.NoError(t, err)
+
+	entries, err := reopenedRepo.GetAuditEntries(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.AuditEntry{testAuditEntries[0], testAuditEntries[2]}, entries)
+}
+
+func TestAuditFileRepoInvalidLine(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "users.audit.ndjson")
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("{}\nnot json\n"), 0644))
+
+	_, err := NewAuditFileRepo(filePath)
+	assert.EqualError(t, err, "users audit file "+filePath+" line 2: invalid character 'o' in literal null (expecting 'u')")
+}
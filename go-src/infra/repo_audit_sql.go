@@ -0,0 +1,57 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
+)
+
+type (
+	// auditSQLRepo is an audit repo backed by the users_audit table of the SQL backends
+	auditSQLRepo struct {
+		sqlDB
+	}
+)
+
+// AuditRepo gets the audit repo sharing the users database
+func (r *usersSQLRepo) AuditRepo() *auditSQLRepo {
+	return &auditSQLRepo{sqlDB: r.sqlDB}
+}
+
+// AddAuditEntry appends the entry to the audit trail
+func (r *auditSQLRepo) AddAuditEntry(ctx context.Context, entry lib.AuditEntry) error {
+	_, err := r.querier(r.db).ExecContext(ctx, "INSERT INTO users_audit (user_id, action, actor, made_at) VALUES (?, ?, ?, ?)",
+		entry.UserID, string(entry.Action), entry.Actor, entry.Time.UTC().Format(time.RFC3339Nano))
+	return contextError(ctx, err)
+}
+
+// GetAuditEntries gets the audit entries of the user, in order (empty if there are none)
+func (r *auditSQLRepo) GetAuditEntries(ctx context.Context, userID string) ([]lib.AuditEntry, error) {
+	rows, err := r.querier(r.db).QueryContext(ctx,
+		"SELECT action, actor, made_at FROM users_audit WHERE user_id = ? ORDER BY seq", userID)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	entries := []lib.AuditEntry{}
+	for rows.Next() {
+		entry := lib.AuditEntry{UserID: userID}
+		var madeAt string
+		err = rows.Scan(&entry.Action, &entry.Actor, &madeAt)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Time, err = time.Parse(time.RFC3339Nano, madeAt)
+		if err != nil {
+			return nil, fmt.Errorf("user %s audit entry time: %w", userID, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, contextError(ctx, rows.Err())
+}
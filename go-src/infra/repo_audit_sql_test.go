@@ -0,0 +1,39 @@
+package infra
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditSQLRepo(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "users.db")
+	ctx := context.Background()
+
+	usersRepo, err := NewUsersSQLiteRepo(filePath, nil)
+	require.NoError(t, err)
+
+	for _, entry := range testAuditEntries {
+		err = usersRepo.AuditRepo().AddAuditEntry(ctx, entry)
+		require.NoError(t, err)
+	}
+	require.NoError(t, usersRepo.Close())
+
+	// the audit trail must survive a restart
+	usersRepo, err = NewUsersSQLiteRepo(filePath, nil)
+	require.NoError(t, err)
+	defer usersRepo.Close()
+	repo := usersRepo.AuditRepo()
+
+	entries, err := repo.GetAuditEntries(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.AuditEntry{testAuditEntries[0], testAuditEntries[2]}, entries)
+
+	entries, err = repo.GetAuditEntries(ctx, "unknown_id")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.AuditEntry{}, entries)
+}
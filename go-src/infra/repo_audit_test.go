@@ -0,0 +1,66 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+var testAuditEntries = []lib.AuditEntry{
+	{
+		UserID: "144bf891-f161-4c9a-8d83-38a275e088a5",
+		Action: lib.AuditDataExported,
+		Actor:  "admin",
+		Time:   time.Date(2022, time.January, 10, 12, 0, 0, 0, time.UTC),
+	},
+	{
+		UserID: "1311f914-1d4f-40b6-8886-80193265d5a4",
+		Action: lib.AuditDataExported,
+		Actor:  "admin",
+		Time:   time.Date(2022, time.January, 11, 12, 0, 0, 0, time.UTC),
+	},
+	{
+		UserID: "144bf891-f161-4c9a-8d83-38a275e088a5",
+		Action: lib.AuditUserErased,
+		Actor:  "admin",
+		Time:   time.Date(2022, time.January, 12, 12, 0, 0, 0, time.UTC),
+	},
+}
+
+func TestAuditRepo(t *testing.T) {
+	ctx := context.Background()
+	repo := NewAuditRepo(testAuditEntries[:1])
+
+	for _, entry := range testAuditEntries[1:] {
+		err := repo.AddAuditEntry(ctx, entry)
+		assert.NoError(t, err)
+	}
+
+	entries, err := repo.GetAuditEntries(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.AuditEntry{testAuditEntries[0], testAuditEntries[2]}, entries)
+
+	entries, err = repo.GetAuditEntries(ctx, "unknown_id")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.AuditEntry{}, entries)
+}
+
+func TestAuditRepoPersistError(t *testing.T) {
+	ctx := context.Background()
+	repo := NewAuditRepo(nil)
+	repo.persist = func(entry lib.AuditEntry) error {
+		return fmt.Errorf("disk full")
+	}
+
+	err := repo.AddAuditEntry(ctx, testAuditEntries[0])
+	assert.EqualError(t, err, "disk full")
+
+	// nothing is added if the entry could not be persisted
+	entries, err := repo.GetAuditEntries(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.AuditEntry{}, entries)
+}
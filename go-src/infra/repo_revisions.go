@@ -0,0 +1,127 @@
+package infra
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/hbernardo/users/go-src/lib"
+)
+
+type (
+	revisionsRepo struct {
+		// mutex protects the revisions against concurrent writes
+		mutex sync.RWMutex
+		// revisionsMap has the revisions of each user (by ID), in order
+		revisionsMap map[string][]lib.UserRevision
+
+		// persist is called with the new revisions before they are added (optional),
+		// they are discarded if it returns an error
+		persist func(revisions []lib.UserRevision) error
+		// persistAll is called with all the revisions (in order) before a user history is replaced (optional),
+		// it's not replaced if it returns an error
+		persistAll func(revisions []lib.UserRevision) error
+	}
+)
+
+// NewRevisionsRepo creates a new users revisions repo, receives the revisions (all the users histories, in order) as parameter
+func NewRevisionsRepo(revisions []lib.UserRevision) *revisionsRepo {
+	repo := &revisionsRepo{
+		revisionsMap: make(map[string][]lib.UserRevision),
+	}
+
+	for _, revision := range revisions {
+		repo.revisionsMap[revision.UserID] = append(repo.revisionsMap[revision.UserID], revision)
+	}
+
+	return repo
+}
+
+// AddRevisions appends the revisions to the users histories, numbering them
+func (r *revisionsRepo) AddRevisions(ctx context.Context, revisions []lib.UserRevision) error {
+	if len(revisions) == 0 {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// numbering a copy, the caller slice is never changed
+	revisions = append([]lib.UserRevision(nil), revisions...)
+	added := make(map[string]int, len(revisions))
+	for i := range revisions {
+		userID := revisions[i].UserID
+		added[userID]++
+		revisions[i].Number = len(r.revisionsMap[userID]) + added[userID]
+	}
+
+	if r.persist != nil {
+		err := r.persist(revisions)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, revision := range revisions {
+		r.revisionsMap[revision.UserID] = append(r.revisionsMap[revision.UserID], revision)
+	}
+
+	return nil
+}
+
+// GetRevisions gets the user history, in order (empty if there is none)
+func (r *revisionsRepo) GetRevisions(ctx context.Context, userID string) ([]lib.UserRevision, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return append([]lib.UserRevision{}, r.revisionsMap[userID]...), nil
+}
+
+// ReplaceRevisions replaces the whole user history (e.g. to erase the personal values recorded in it), renumbering it
+func (r *revisionsRepo) ReplaceRevisions(ctx context.Context, userID string, revisions []lib.UserRevision) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	revisions = append([]lib.UserRevision{}, revisions...)
+	for i := range revisions {
+		revisions[i].UserID = userID
+		revisions[i].Number = i + 1
+	}
+
+	if r.persistAll != nil {
+		err := r.persistAll(r.allRevisions(userID, revisions))
+		if err != nil {
+			return err
+		}
+	}
+
+	r.revisionsMap[userID] = revisions
+
+	return nil
+}
+
+// allRevisions gets all the users revisions, ordered by time (and then by user ID),
+// with the user history replaced by the revisions received as parameter
+func (r *revisionsRepo) allRevisions(userID string, userRevisions []lib.UserRevision) []lib.UserRevision {
+	userIDs := make([]string, 0, len(r.revisionsMap)+1)
+	for id := range r.revisionsMap {
+		if id != userID {
+			userIDs = append(userIDs, id)
+		}
+	}
+	sort.Strings(userIDs)
+
+	revisions := append([]lib.UserRevision{}, userRevisions...)
+	for _, id := range userIDs {
+		revisions = append(revisions, r.revisionsMap[id]...)
+	}
+
+	// the user histories are already in order, only merging them
+	sort.SliceStable(revisions, func(i, j int) bool {
+		if !revisions[i].Time.Equal(revisions[j].Time) {
+			return revisions[i].Time.Before(revisions[j].Time)
+		}
+		return revisions[i].UserID < revisions[j].UserID
+	})
+	return revisions
+}
@@ -0,0 +1,90 @@
+package infra
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/hbernardo/users/go-src/lib"
+)
+
+const (
+	// usersHistorySuffix replaces the users data file extension to get the users history file path
+	usersHistorySuffix = ".history.ndjson"
+
+	// usersHistoryFileName describes the users history file in the errors
+	usersHistoryFileName = "users history file"
+)
+
+type (
+	// revisionsFileRepo is a users revisions repo backed by an append-only NDJSON file (one revision per line),
+	// every added revision is persisted to disk (the file is only rewritten when a user history is replaced)
+	revisionsFileRepo struct {
+		*revisionsRepo
+
+		filePath string
+	}
+)
+
+// UsersHistoryFilePath gets the path of the users history file, next to the users data file received as parameter
+// (e.g. "data/users.history.ndjson" for "data/users.json")
+func UsersHistoryFilePath(usersDataFilePath string) string {
+	return strings.TrimSuffix(usersDataFilePath, filepath.Ext(usersDataFilePath)) + usersHistorySuffix
+}
+
+// NewRevisionsFileRepo creates a new users revisions repo backed by the NDJSON file received as parameter
+// (created on the first write if it doesn't exist), an incomplete last line (interrupted append) is discarded
+func NewRevisionsFileRepo(filePath string) (*revisionsFileRepo, error) {
+	revisions, err := readRevisionsFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &revisionsFileRepo{
+		revisionsRepo: NewRevisionsRepo(revisions),
+		filePath:      filePath,
+	}
+	repo.revisionsRepo.persist = repo.appendRevisions
+	repo.revisionsRepo.persistAll = repo.rewriteRevisions
+
+	return repo, nil
+}
+
+// appendRevisions appends the revisions to the file, flushing them to disk
+func (r *revisionsFileRepo) appendRevisions(revisions []lib.UserRevision) error {
+	return appendNDJSONFile(r.filePath, usersHistoryFileName, revisionsValues(revisions))
+}
+
+// rewriteRevisions replaces the file content by the revisions
+func (r *revisionsFileRepo) rewriteRevisions(revisions []lib.UserRevision) error {
+	return rewriteNDJSONFile(r.filePath, usersHistoryFileName, revisionsValues(revisions))
+}
+
+// revisionsValues converts the revisions to the values written to the NDJSON file
+func revisionsValues(revisions []lib.UserRevision) []interface{} {
+	values := make([]interface{}, len(revisions))
+	for i, revision := range revisions {
+		values[i] = revision
+	}
+	return values
+}
+
+// readRevisionsFile reads the revisions from the NDJSON file (none if it doesn't exist),
+// truncating the file if its last line is incomplete (an append interrupted by a crash)
+func readRevisionsFile(filePath string) ([]lib.UserRevision, error) {
+	revisions := []lib.UserRevision{}
+	err := readNDJSONFile(filePath, usersHistoryFileName, func(line []byte) error {
+		var revision lib.UserRevision
+		err := json.Unmarshal(line, &revision)
+		if err != nil {
+			return err
+		}
+		revisions = append(revisions, revision)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
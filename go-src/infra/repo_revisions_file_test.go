@@ -0,0 +1,117 @@
+package infra
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsersHistoryFilePath(t *testing.T) {
+	assert.Equal(t, "data/users.history.ndjson", UsersHistoryFilePath("data/users.json"))
+	assert.Equal(t, "users.history.ndjson", UsersHistoryFilePath("users"))
+}
+
+func TestRevisionsFileRepo(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "users.history.ndjson")
+	ctx := context.Background()
+
+	// the file is created on the first write
+	repo, err := NewRevisionsFileRepo(filePath)
+	require.NoError(t, err)
+
+	err = repo.AddRevisions(ctx, testRevisions[:2])
+	require.NoError(t, err)
+	err = repo.AddRevisions(ctx, testRevisions[2:])
+	require.NoError(t, err)
+
+	// revisions must survive a restart
+	reopenedRepo, err := NewRevisionsFileRepo(filePath)
+	require.NoError(t, err)
+
+	revisions, err := reopenedRepo.GetRevisions(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.UserRevision{numberedRevision(testRevisions[0], 1), numberedRevision(testRevisions[2], 2)}, revisions)
+
+	revisions, err = reopenedRepo.GetRevisions(ctx, "1311f914-1d4f-40b6-8886-80193265d5a4")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.UserRevision{numberedRevision(testRevisions[1], 1)}, revisions)
+}
+
+func TestRevisionsFileRepoIncompleteLine(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "users.history.ndjson")
+	ctx := context.Background()
+
+	repo, err := NewRevisionsFileRepo(filePath)
+	require.NoError(t, err)
+	err = repo.AddRevisions(ctx, testRevisions[:1])
+	require.NoError(t, err)
+
+	completeBytes, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+
+	// simulating an append interrupted by a crash
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"user_id":"1311f914-1d4f-40b6-8886-80193265d5a4","num`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	// the incomplete line is discarded (and truncated), so the next appends are readable
+	repo, err = NewRevisionsFileRepo(filePath)
+	require.NoError(t, err)
+
+	fileBytes, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, completeBytes, fileBytes)
+
+	err = repo.AddRevisions(ctx, testRevisions[1:2])
+	require.NoError(t, err)
+
+	repo, err = NewRevisionsFileRepo(filePath)
+	require.NoError(t, err)
+	revisions, err := repo.GetRevisions(ctx, "1311f914-1d4f-40b6-8886-80193265d5a4")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.UserRevision{numberedRevision(testRevisions[1], 1)}, revisions)
+}
+
+func TestRevisionsFileRepoInvalidLine(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "users.history.ndjson")
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("{}\nnot json\n"), 0644))
+
+	_, err := NewRevisionsFileRepo(filePath)
+	assert.EqualError(t, err, "users history file "+filePath+" line 2: invalid character 'o' in literal null (expecting 'u')")
+}
+
+func TestRevisionsFileRepoReplaceRevisions(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "users.history.ndjson")
+	ctx := context.Background()
+
+	repo, err := NewRevisionsFileRepo(filePath)
+	require.NoError(t, err)
+	err = repo.AddRevisions(ctx, testRevisions)
+	require.NoError(t, err)
+
+	err = repo.ReplaceRevisions(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5", testRevisions[2:])
+	require.NoError(t, err)
+
+	// the replaced history must survive a restart, and the file must remain appendable
+	err = repo.AddRevisions(ctx, testRevisions[:1])
+	require.NoError(t, err)
+
+	reopenedRepo, err := NewRevisionsFileRepo(filePath)
+	require.NoError(t, err)
+
+	revisions, err := reopenedRepo.GetRevisions(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.UserRevision{numberedRevision(testRevisions[2], 1), numberedRevision(testRevisions[0], 2)}, revisions)
+
+	revisions, err = reopenedRepo.GetRevisions(ctx, "1311f914-1d4f-40b6-8886-80193265d5a4")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.UserRevision{numberedRevision(testRevisions[1], 1)}, revisions)
+}
@@ -0,0 +1,108 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
+)
+
+type (
+	// revisionsSQLRepo is a users revisions repo backed by the users_revisions table of the SQL backends,
+	// the revisions are numbered by their order in the table when they're read, so the instances sharing
+	// the database never number them twice
+	revisionsSQLRepo struct {
+		sqlDB
+	}
+)
+
+// RevisionsRepo gets the users revisions repo sharing the users database
+func (r *usersSQLRepo) RevisionsRepo() *revisionsSQLRepo {
+	return &revisionsSQLRepo{sqlDB: r.sqlDB}
+}
+
+// AddRevisions appends the revisions to the users histories, in a single transaction
+func (r *revisionsSQLRepo) AddRevisions(ctx context.Context, revisions []lib.UserRevision) error {
+	if len(revisions) == 0 {
+		return nil
+	}
+
+	return r.inTx(ctx, false, func(q sqlQuerier) error {
+		return insertSQLRevisions(ctx, q, revisions)
+	})
+}
+
+// GetRevisions gets the user history, in order (empty if there is none)
+func (r *revisionsSQLRepo) GetRevisions(ctx context.Context, userID string) ([]lib.UserRevision, error) {
+	rows, err := r.querier(r.db).QueryContext(ctx,
+		"SELECT action, actor, made_at, changes FROM users_revisions WHERE user_id = ? ORDER BY seq", userID)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	revisions := []lib.UserRevision{}
+	for rows.Next() {
+		revision := lib.UserRevision{UserID: userID, Number: len(revisions) + 1}
+		var madeAt, changes string
+		err = rows.Scan(&revision.Action, &revision.Actor, &madeAt, &changes)
+		if err != nil {
+			return nil, err
+		}
+
+		revision.Time, err = time.Parse(time.RFC3339Nano, madeAt)
+		if err != nil {
+			return nil, fmt.Errorf("user %s revision %d time: %w", userID, revision.Number, err)
+		}
+		err = json.Unmarshal([]byte(changes), &revision.Changes)
+		if err != nil {
+			return nil, fmt.Errorf("user %s revision %d changes: %w", userID, revision.Number, err)
+		}
+
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, contextError(ctx, rows.Err())
+}
+
+// ReplaceRevisions replaces the whole user history (e.g. to erase the personal values recorded in it), in a single transaction
+func (r *revisionsSQLRepo) ReplaceRevisions(ctx context.Context, userID string, revisions []lib.UserRevision) error {
+	revisions = append([]lib.UserRevision{}, revisions...)
+	for i := range revisions {
+		revisions[i].UserID = userID
+	}
+
+	return r.inTx(ctx, false, func(q sqlQuerier) error {
+		_, err := q.ExecContext(ctx, "DELETE FROM users_revisions WHERE user_id = ?", userID)
+		if err != nil {
+			return err
+		}
+
+		return insertSQLRevisions(ctx, q, revisions)
+	})
+}
+
+// insertSQLRevisions inserts the revisions, in order
+func insertSQLRevisions(ctx context.Context, q sqlQuerier, revisions []lib.UserRevision) error {
+	stmt, err := q.PrepareContext(ctx, "INSERT INTO users_revisions (user_id, action, actor, made_at, changes) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, revision := range revisions {
+		changes, err := json.Marshal(revision.Changes)
+		if err != nil {
+			return err
+		}
+
+		_, err = stmt.ExecContext(ctx, revision.UserID, string(revision.Action), revision.Actor,
+			revision.Time.UTC().Format(time.RFC3339Nano), string(changes))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package infra
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevisionsSQLRepo(t *testing.T) {
+	newRepos := map[string]func(t *testing.T) *usersSQLRepo{
+		"sqlite": func(t *testing.T) *usersSQLRepo {
+			return newTestUsersSQLiteRepo(t, nil).usersSQLRepo
+		},
+		"postgres": func(t *testing.T) *usersSQLRepo {
+			repo, _ := newTestUsersPostgresRepo(t, nil)
+			return repo.usersSQLRepo
+		},
+	}
+
+	for name, newRepo := range newRepos {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newRepo(t).RevisionsRepo()
+
+			err := repo.AddRevisions(ctx, testRevisions[:2])
+			require.NoError(t, err)
+			err = repo.AddRevisions(ctx, testRevisions[2:])
+			require.NoError(t, err)
+
+			// the revisions are numbered in the user history
+			revisions, err := repo.GetRevisions(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5")
+			assert.NoError(t, err)
+			assert.Equal(t, []lib.UserRevision{numberedRevision(testRevisions[0], 1), numberedRevision(testRevisions[2], 2)}, revisions)
+
+			revisions, err = repo.GetRevisions(ctx, "unknown_id")
+			assert.NoError(t, err)
+			assert.Equal(t, []lib.UserRevision{}, revisions)
+
+			// the replaced history is renumbered, the other ones are kept
+			replacedRevision := testRevisions[2]
+			replacedRevision.Changes = []lib.FieldChange{{Field: "last_name", From: "User", To: "User"}}
+			err = repo.ReplaceRevisions(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5", []lib.UserRevision{replacedRevision})
+			assert.NoError(t, err)
+
+			revisions, err = repo.GetRevisions(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5")
+			assert.NoError(t, err)
+			assert.Equal(t, []lib.UserRevision{numberedRevision(replacedRevision, 1)}, revisions)
+
+			revisions, err = repo.GetRevisions(ctx, "1311f914-1d4f-40b6-8886-80193265d5a4")
+			assert.NoError(t, err)
+			assert.Equal(t, []lib.UserRevision{numberedRevision(testRevisions[1], 1)}, revisions)
+		})
+	}
+}
+
+func TestRevisionsSQLRepoOtherInstance(t *testing.T) {
+	ctx := context.Background()
+	repo, standIn := newTestUsersPostgresRepo(t, nil)
+
+	otherRepo, err := NewUsersPostgresRepo(standIn.url(), PostgresPoolConfig{}, nil)
+	require.NoError(t, err)
+	defer otherRepo.Close()
+
+	// the instances sharing the database append to the same histories
+	err = repo.RevisionsRepo().AddRevisions(ctx, testRevisions[:1])
+	require.NoError(t, err)
+	err = otherRepo.RevisionsRepo().AddRevisions(ctx, testRevisions[2:])
+	require.NoError(t, err)
+
+	revisions, err := repo.RevisionsRepo().GetRevisions(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.UserRevision{numberedRevision(testRevisions[0], 1), numberedRevision(testRevisions[2], 2)}, revisions)
+}
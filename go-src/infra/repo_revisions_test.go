@@ -0,0 +1,119 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+var testRevisions = []lib.UserRevision{
+	{
+		UserID:  "144bf891-f161-4c9a-8d83-38a275e088a5",
+		Action:  lib.RevisionUpdated,
+		Actor:   "admin",
+		Time:    time.Date(2022, time.January, 10, 12, 0, 0, 0, time.UTC),
+		Changes: []lib.FieldChange{{Field: "first_name", From: "Nicky", To: "Nick"}},
+	},
+	{
+		UserID:  "1311f914-1d4f-40b6-8886-80193265d5a4",
+		Action:  lib.RevisionDeleted,
+		Actor:   "client:63.119.6.98",
+		Time:    time.Date(2022, time.January, 11, 12, 0, 0, 0, time.UTC),
+		Changes: []lib.FieldChange{{Field: "deleted_at", To: "2022-01-11T12:00:00Z"}},
+	},
+	{
+		UserID:  "144bf891-f161-4c9a-8d83-38a275e088a5",
+		Action:  lib.RevisionUpdated,
+		Actor:   "admin",
+		Time:    time.Date(2022, time.January, 12, 12, 0, 0, 0, time.UTC),
+		Changes: []lib.FieldChange{{Field: "last_name", From: "Blasio", To: "Blaise"}},
+	},
+}
+
+// numberedRevision returns the revision with its number in the user history
+func numberedRevision(revision lib.UserRevision, number int) lib.UserRevision {
+	revision.Number = number
+	return revision
+}
+
+func TestRevisionsRepo(t *testing.T) {
+	ctx := context.Background()
+	repo := NewRevisionsRepo([]lib.UserRevision{numberedRevision(testRevisions[0], 1)})
+
+	err := repo.AddRevisions(ctx, testRevisions[1:])
+	assert.NoError(t, err)
+	// the added revisions are numbered in a copy
+	assert.Equal(t, 0, testRevisions[2].Number)
+
+	revisions, err := repo.GetRevisions(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.UserRevision{numberedRevision(testRevisions[0], 1), numberedRevision(testRevisions[2], 2)}, revisions)
+
+	revisions, err = repo.GetRevisions(ctx, "1311f914-1d4f-40b6-8886-80193265d5a4")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.UserRevision{numberedRevision(testRevisions[1], 1)}, revisions)
+
+	revisions, err = repo.GetRevisions(ctx, "unknown_id")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.UserRevision{}, revisions)
+}
+
+func TestRevisionsRepoPersistError(t *testing.T) {
+	ctx := context.Background()
+	repo := NewRevisionsRepo(nil)
+	repo.persist = func(revisions []lib.UserRevision) error {
+		return fmt.Errorf("disk full")
+	}
+
+	err := repo.AddRevisions(ctx, testRevisions)
+	assert.EqualError(t, err, "disk full")
+
+	// nothing is added if the revisions could not be persisted
+	revisions, err := repo.GetRevisions(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.UserRevision{}, revisions)
+}
+
+func TestRevisionsRepoReplaceRevisions(t *testing.T) {
+	ctx := context.Background()
+	repo := NewRevisionsRepo(nil)
+
+	err := repo.AddRevisions(ctx, testRevisions)
+	assert.NoError(t, err)
+
+	var persisted []lib.UserRevision
+	repo.persistAll = func(revisions []lib.UserRevision) error {
+		persisted = revisions
+		return nil
+	}
+
+	replacedRevision := testRevisions[2]
+	replacedRevision.Changes = []lib.FieldChange{{Field: "last_name", From: "User", To: "User"}}
+	err = repo.ReplaceRevisions(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5", []lib.UserRevision{replacedRevision})
+	assert.NoError(t, err)
+
+	// all the revisions are persisted in order, with the user history replaced and renumbered
+	assert.Equal(t, []lib.UserRevision{
+		numberedRevision(testRevisions[1], 1),
+		numberedRevision(replacedRevision, 1),
+	}, persisted)
+
+	revisions, err := repo.GetRevisions(ctx, "144bf891-f161-4c9a-8d83-38a275e088a5")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.UserRevision{numberedRevision(replacedRevision, 1)}, revisions)
+
+	// nothing is replaced if the revisions could not be persisted
+	repo.persistAll = func(revisions []lib.UserRevision) error {
+		return fmt.Errorf("disk full")
+	}
+	err = repo.ReplaceRevisions(ctx, "1311f914-1d4f-40b6-8886-80193265d5a4", nil)
+	assert.EqualError(t, err, "disk full")
+
+	revisions, err = repo.GetRevisions(ctx, "1311f914-1d4f-40b6-8886-80193265d5a4")
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.UserRevision{numberedRevision(testRevisions[1], 1)}, revisions)
+}
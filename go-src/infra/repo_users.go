@@ -3,59 +3,399 @@ package infra
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/hbernardo/users/go-src/lib"
 )
 
 type (
+	// UsersRepo is the users repo implemented by the storage backends
+	UsersRepo interface {
+		GetUsers(ctx context.Context, query lib.UsersQuery) (lib.UsersPage, error)
+		GetUser(ctx context.Context, userID string) (lib.User, error)
+		GetUsersByIDs(ctx context.Context, userIDs []string) (lib.UsersBatch, error)
+		SearchUsers(ctx context.Context, query lib.UsersSearchQuery) (lib.UsersPage, error)
+		GetUserByEmail(ctx context.Context, email string) (lib.User, error)
+		CreateUser(ctx context.Context, user lib.User) (lib.User, error)
+		UpdateUser(ctx context.Context, user lib.User, version string) (lib.User, error)
+		DeleteUser(ctx context.Context, userID string) error
+		UpsertUsers(ctx context.Context, users []lib.User) error
+	}
+
 	usersRepo struct {
+		// mutex protects the users data against concurrent writes
+		mutex     sync.RWMutex
 		usersData []lib.User
-		usersMap  map[string]*lib.User
+		usersMap  map[string]int
+		// searchIndex is the full-text search index, kept up to date by the writes
+		searchIndex *usersSearchIndex
+
+		// persist is called with the new users data before any write is applied (optional),
+		// the write is discarded if it returns an error
+		persist func(usersData []lib.User) error
 	}
 )
 
 // NewUsersRepo creates a new users repo, receives the users data as parameter
 func NewUsersRepo(usersData []lib.User) *usersRepo {
-	repo := &usersRepo{
-		usersData: usersData,
-		usersMap:  make(map[string]*lib.User, len(usersData)),
-	}
+	repo := &usersRepo{}
+	repo.replaceUsersData(usersData)
+
+	return repo
+}
+
+// ReplaceUsersData replaces all the users data at once (e.g. reloaded from its source, without persisting it),
+// the reads see either the previous or the new data
+func (r *usersRepo) ReplaceUsersData(usersData []lib.User) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.replaceUsersData(usersData)
+}
+
+// replaceUsersData replaces all the users data (and its indexes) at once, must be called with the write lock held
+// (or before the repo is shared)
+func (r *usersRepo) replaceUsersData(usersData []lib.User) {
+	// copying the data, so the writes never change the caller slice
+	r.usersData = append([]lib.User(nil), usersData...)
+	r.usersMap = make(map[string]int, len(usersData))
 
 	// map for direct/instant access when querying a single user
-	for i, user := range repo.usersData {
-		repo.usersMap[user.ID] = &(repo.usersData[i])
+	// (storing the user index in the data slice)
+	for i, user := range r.usersData {
+		r.usersMap[user.ID] = i
 	}
 
-	return repo
+	r.searchIndex = newUsersSearchIndex(r.usersData)
 }
 
-// GetUsers gets users based on pagination (limit and offset)
-func (r *usersRepo) GetUsers(ctx context.Context, limit int, offset int) ([]lib.User, error) {
+// GetUsers gets users based on pagination (limit and offset, or cursor), filters and sorting
+func (r *usersRepo) GetUsers(ctx context.Context, query lib.UsersQuery) (lib.UsersPage, error) {
+	limit, offset := query.Limit, query.Offset
+
 	// validating pagination parameters
 	if limit < 0 || offset < 0 {
-		return nil, fmt.Errorf("'limit' nor 'offset' cannot be negative: %w", lib.ErrPreconditionFailed)
+		return lib.UsersPage{}, fmt.Errorf("'limit' nor 'offset' cannot be negative: %w", lib.ErrPreconditionFailed)
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if query.Cursor != nil {
+		return usersPageAfterCursor(filterUsers(r.usersData, query.Filter), limit, query.Sort, *query.Cursor), nil
+	}
+
+	usersData := r.usersData
+	if !query.Filter.IsEmpty() || !query.Filter.IncludeDeleted || len(query.Sort) > 0 {
+		// filtering and sorting a copy of the data
+		usersData = filterUsers(r.usersData, query.Filter)
+		lib.SortUsers(usersData, query.Sort)
 	}
 
 	// fixing out of bonds slice access
 	// empty slice should be expected in that case
-	if offset > len(r.usersData) {
-		offset = len(r.usersData)
+	if offset > len(usersData) {
+		offset = len(usersData)
 	}
-	if (offset + limit) > len(r.usersData) {
-		limit = len(r.usersData) - offset
+	if (offset + limit) > len(usersData) {
+		limit = len(usersData) - offset
 	}
 
-	return r.usersData[offset : offset+limit], nil
+	// copying the page, so the caller is not affected by later writes
+	users := make([]lib.User, limit)
+	copy(users, usersData[offset:offset+limit])
+
+	return lib.UsersPage{Users: users, Total: len(usersData)}, nil
+}
+
+// GetUsersSnapshot gets all the users matching the filter at once, ordered by the sorting and then by ID
+// (filtered and sorted only once, e.g. to export them, instead of for every cursor page)
+func (r *usersRepo) GetUsersSnapshot(ctx context.Context, filter lib.UsersFilter, sort []lib.SortField) ([]lib.User, error) {
+	r.mutex.RLock()
+	users := filterUsers(r.usersData, filter)
+	r.mutex.RUnlock()
+
+	// sorting the copy without holding the lock
+	lib.SortUsers(users, lib.KeysetSort(sort))
+
+	return users, nil
 }
 
 // GetUser gets user based on its ID
 func (r *usersRepo) GetUser(ctx context.Context, userID string) (lib.User, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
 	// direct access to queried user
 	// returning "not found" error if user doesn't exists
-	user, userExists := r.usersMap[userID]
-	if !userExists || user == nil {
+	i, userExists := r.usersMap[userID]
+	if !userExists {
+		return lib.User{}, lib.ErrNotFound
+	}
+
+	return r.usersData[i], nil
+}
+
+// GetUsersByIDs gets the users based on their IDs (in the same order), reporting the IDs that were not found
+func (r *usersRepo) GetUsersByIDs(ctx context.Context, userIDs []string) (lib.UsersBatch, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	batch := lib.UsersBatch{
+		Users:      make([]lib.User, 0, len(userIDs)),
+		MissingIDs: []string{},
+	}
+
+	// direct access to each queried user
+	for _, userID := range userIDs {
+		i, userExists := r.usersMap[userID]
+		if !userExists {
+			batch.MissingIDs = append(batch.MissingIDs, userID)
+			continue
+		}
+		batch.Users = append(batch.Users, r.usersData[i])
+	}
+
+	return batch, nil
+}
+
+// SearchUsers gets the users matching the full-text search (names and email), ordered by relevance
+// (and then by data order), paginated by limit and offset
+func (r *usersRepo) SearchUsers(ctx context.Context, query lib.UsersSearchQuery) (lib.UsersPage, error) {
+	limit, offset := query.Limit, query.Offset
+
+	// validating pagination parameters
+	if limit < 0 || offset < 0 {
+		return lib.UsersPage{}, fmt.Errorf("'limit' nor 'offset' cannot be negative: %w", lib.ErrPreconditionFailed)
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	hits := r.searchIndex.search(query.Text)
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return r.usersMap[hits[i].UserID] < r.usersMap[hits[j].UserID]
+	})
+
+	// fixing out of bonds slice access
+	if offset > len(hits) {
+		offset = len(hits)
+	}
+	if (offset + limit) > len(hits) {
+		limit = len(hits) - offset
+	}
+
+	users := make([]lib.User, limit)
+	for i, hit := range hits[offset : offset+limit] {
+		users[i] = r.usersData[r.usersMap[hit.UserID]]
+	}
+
+	return lib.UsersPage{Users: users, Total: len(hits)}, nil
+}
+
+// GetUserByEmail gets user based on its email (case-insensitive)
+func (r *usersRepo) GetUserByEmail(ctx context.Context, email string) (lib.User, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, user := range r.usersData {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+
+	return lib.User{}, lib.ErrNotFound
+}
+
+// CreateUser adds a new user to the end of the users data
+func (r *usersRepo) CreateUser(ctx context.Context, user lib.User) (lib.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, userExists := r.usersMap[user.ID]; userExists {
+		return lib.User{}, fmt.Errorf("user %s already exists: %w", user.ID, lib.ErrConflict)
+	}
+
+	usersData := make([]lib.User, 0, len(r.usersData)+1)
+	usersData = append(usersData, r.usersData...)
+	usersData = append(usersData, user)
+
+	err := r.commit(usersData)
+	if err != nil {
+		return lib.User{}, err
+	}
+	r.usersMap[user.ID] = len(r.usersData) - 1
+	r.searchIndex.add(user)
+
+	return user, nil
+}
+
+// UpdateUser replaces an existing user data based on its ID, if its current version is the expected one (any version if empty)
+func (r *usersRepo) UpdateUser(ctx context.Context, user lib.User, version string) (lib.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i, userExists := r.usersMap[user.ID]
+	if !userExists {
 		return lib.User{}, lib.ErrNotFound
 	}
 
-	return *user, nil
+	previousUser := r.usersData[i]
+	if version != "" && lib.UserVersion(previousUser) != version {
+		return lib.User{}, versionMismatchError(user.ID)
+	}
+	usersData := append([]lib.User(nil), r.usersData...)
+	usersData[i] = user
+
+	err := r.commit(usersData)
+	if err != nil {
+		return lib.User{}, err
+	}
+	r.searchIndex.remove(previousUser)
+	r.searchIndex.add(user)
+
+	return user, nil
+}
+
+// DeleteUser removes an existing user based on its ID, keeping the order of the remaining users
+func (r *usersRepo) DeleteUser(ctx context.Context, userID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i, userExists := r.usersMap[userID]
+	if !userExists {
+		return lib.ErrNotFound
+	}
+
+	deletedUser := r.usersData[i]
+	usersData := make([]lib.User, 0, len(r.usersData)-1)
+	usersData = append(usersData, r.usersData[:i]...)
+	usersData = append(usersData, r.usersData[i+1:]...)
+
+	err := r.commit(usersData)
+	if err != nil {
+		return err
+	}
+
+	r.searchIndex.remove(deletedUser)
+
+	// the following users moved one position back
+	delete(r.usersMap, userID)
+	for j := i; j < len(r.usersData); j++ {
+		r.usersMap[r.usersData[j].ID] = j
+	}
+
+	return nil
+}
+
+// UpsertUsers creates or replaces (based on their IDs) multiple users at once, in a single write:
+// the existing users are replaced in place and the new ones are added to the end of the users data
+func (r *usersRepo) UpsertUsers(ctx context.Context, users []lib.User) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	usersData := make([]lib.User, len(r.usersData), len(r.usersData)+len(users))
+	copy(usersData, r.usersData)
+
+	// positions of the written users and the replaced ones,
+	// the map and the search index are only updated after the commit
+	written := make(map[string]int, len(users))
+	var replaced []lib.User
+
+	for _, user := range users {
+		i, userExists := written[user.ID]
+		if !userExists {
+			i, userExists = r.usersMap[user.ID]
+			if userExists {
+				replaced = append(replaced, r.usersData[i])
+			}
+		}
+
+		if userExists {
+			usersData[i] = user
+		} else {
+			i = len(usersData)
+			usersData = append(usersData, user)
+		}
+		written[user.ID] = i
+	}
+
+	err := r.commit(usersData)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range replaced {
+		r.searchIndex.remove(user)
+	}
+	for userID, i := range written {
+		r.usersMap[userID] = i
+		r.searchIndex.add(usersData[i])
+	}
+
+	return nil
+}
+
+// commit persists (if configured) and applies the new users data, must be called with the write lock held
+func (r *usersRepo) commit(usersData []lib.User) error {
+	if r.persist != nil {
+		err := r.persist(usersData)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.usersData = usersData
+
+	return nil
+}
+
+// filterUsers gets a copy of the users that match the filter
+func filterUsers(usersData []lib.User, filter lib.UsersFilter) []lib.User {
+	users := make([]lib.User, 0, len(usersData))
+
+	for _, user := range usersData {
+		if filter.Matches(user) {
+			users = append(users, user)
+		}
+	}
+
+	return users
+}
+
+// usersPageAfterCursor gets the page (limit) of users right after the cursor (keyset pagination),
+// the users are sorted in place (by the sort fields and then by ID)
+func usersPageAfterCursor(users []lib.User, limit int, sortFields []lib.SortField, cursor lib.UsersCursor) lib.UsersPage {
+	lib.SortUsers(users, lib.KeysetSort(sortFields))
+
+	// the users are sorted, so the first one after the cursor can be binary searched
+	start := sort.Search(len(users), func(i int) bool {
+		return cursor.IsBefore(users[i])
+	})
+
+	end := start + limit
+	if end > len(users) {
+		end = len(users)
+	}
+
+	page := lib.UsersPage{
+		Users: users[start:end],
+		Total: len(users),
+	}
+	if end > start && end < len(users) {
+		nextCursor := lib.NewUsersCursor(users[end-1], sortFields)
+		page.NextCursor = &nextCursor
+	}
+
+	return page
+}
+
+// versionMismatchError is the error of a conditional write of a user modified in the meantime
+func versionMismatchError(userID string) error {
+	return fmt.Errorf("user '%s' was modified (version mismatch): %w", userID, lib.ErrPreconditionFailed)
 }
@@ -0,0 +1,36 @@
+package infra_test
+
+import (
+	"testing"
+
+	"github.com/hbernardo/users/go-src/infra"
+	"github.com/hbernardo/users/go-src/infra/infratest"
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsersRepoConformance(t *testing.T) {
+	repos := map[string]infratest.UsersRepoFactory{
+		"memory": func(t *testing.T, usersData []lib.User) infra.UsersRepo {
+			return infra.NewUsersRepo(usersData)
+		},
+		"json file": func(t *testing.T, usersData []lib.User) infra.UsersRepo {
+			repo, err := infra.NewUsersFileRepo(infra.WriteTestUsersDataFile(t, usersData), true)
+			require.NoError(t, err)
+			return repo
+		},
+		"sqlite": func(t *testing.T, usersData []lib.User) infra.UsersRepo {
+			return infra.NewTestUsersSQLiteRepo(t, usersData)
+		},
+		"postgres": func(t *testing.T, usersData []lib.User) infra.UsersRepo {
+			repo, _ := infra.NewTestUsersPostgresRepo(t, usersData)
+			return repo
+		},
+	}
+
+	for name, newRepo := range repos {
+		t.Run(name, func(t *testing.T) {
+			infratest.RunUsersRepoConformance(t, newRepo)
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package infra
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/hbernardo/users/go-src/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// usersJournalSuffix is appended to the users data file path to get the write-ahead journal path
+	usersJournalSuffix = ".journal"
+)
+
+type (
+	// usersFileRepo is a users repo backed by a JSON data file, every write is persisted to disk
+	usersFileRepo struct {
+		*usersRepo
+
+		filePath         string
+		journalPath      string
+		strictValidation bool
+		// compressed is set if the data file is gzip-compressed (kept on the writes)
+		compressed bool
+
+		// versionMutex protects the data version, that is read concurrently to the writes
+		versionMutex sync.RWMutex
+		dataVersion  string
+	}
+
+	// usersJournalEntry is the write-ahead journal content, the checksum detects incomplete (torn) journal writes
+	usersJournalEntry struct {
+		Checksum string          `json:"checksum"`
+		Data     json.RawMessage `json:"data"`
+		// Compressed is set if the data is written gzip-compressed to the data file
+		Compressed bool `json:"compressed,omitempty"`
+	}
+
+	// usersDataFile is the users data read from a data file
+	usersDataFile struct {
+		usersData []lib.User
+		// dateErrs are the invalid dates of the users, by position (see lib.DecodeUsersData)
+		dateErrs map[int]error
+		// version is the SHA1 of the file content
+		version string
+		// compressed is set if the file is gzip-compressed
+		compressed bool
+		// hashedPasswords is the number of plaintext passwords hashed on load (legacy data file)
+		hashedPasswords int
+	}
+)
+
+// NewUsersFileRepo creates a new users repo backed by the JSON data file received as parameter,
+// recovering any write interrupted by a crash before loading the data,
+// the invalid users of the data file are logged (or refused, with strict validation)
+func NewUsersFileRepo(filePath string, strictValidation bool) (*usersFileRepo, error) {
+	repo := &usersFileRepo{
+		filePath:         filePath,
+		journalPath:      filePath + usersJournalSuffix,
+		strictValidation: strictValidation,
+	}
+
+	err := repo.recoverJournal()
+	if err != nil {
+		return nil, err
+	}
+
+	dataFile, err := loadUsersDataFile(filePath, strictValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.usersRepo = NewUsersRepo(dataFile.usersData)
+	repo.usersRepo.persist = repo.writeUsersData
+	repo.compressed = dataFile.compressed
+	repo.dataVersion = dataFile.version
+
+	err = repo.saveHashedPasswords(dataFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// DataVersion gets the current users data version (SHA1 of the data file content)
+func (r *usersFileRepo) DataVersion() string {
+	r.versionMutex.RLock()
+	defer r.versionMutex.RUnlock()
+
+	return r.dataVersion
+}
+
+// Reload reloads the users data from the data file if it was changed by someone else (e.g. edited by hand),
+// validated as by NewUsersFileRepo, returning if the data was reloaded. The data is swapped at once
+// (the reads see either the previous or the new data), and the current data is kept if the data file is invalid
+func (r *usersFileRepo) Reload() (bool, error) {
+	dataVersion := r.DataVersion()
+
+	// reading outside the lock, as it may be slow (e.g. hashing plaintext passwords)
+	dataFile, err := loadUsersDataFile(r.filePath, r.strictValidation)
+	if err != nil {
+		return false, err
+	}
+	if dataFile.version == dataVersion {
+		return false, nil // unchanged, or written by this repo
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// the data file was written by this repo meanwhile, so the data read may be older than the current one
+	// (the data file holds the data of that write now)
+	if r.DataVersion() != dataVersion {
+		return false, nil
+	}
+
+	r.replaceUsersData(dataFile.usersData)
+	r.compressed = dataFile.compressed
+
+	r.versionMutex.Lock()
+	r.dataVersion = dataFile.version
+	r.versionMutex.Unlock()
+
+	return true, r.saveHashedPasswords(dataFile)
+}
+
+// saveHashedPasswords persists the users data loaded from the data file if its plaintext passwords were hashed
+// (legacy data file), so they are only hashed once, must be called with the write lock held (or before the repo is used)
+func (r *usersFileRepo) saveHashedPasswords(dataFile usersDataFile) error {
+	if dataFile.hashedPasswords == 0 {
+		return nil
+	}
+
+	err := r.writeUsersData(r.usersData)
+	if err != nil {
+		return fmt.Errorf("saving hashed passwords: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"file":             r.filePath,
+		"hashed_passwords": dataFile.hashedPasswords,
+	}).Warn("users data file contained plaintext passwords, they were hashed and saved")
+	return nil
+}
+
+// Save persists the current users data to disk (e.g. after the plaintext passwords were hashed on load)
+func (r *usersFileRepo) Save() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.writeUsersData(r.usersData)
+}
+
+// writeUsersData persists the users data to disk:
+// 1. the data is written to the write-ahead journal (with checksum)
+// 2. the data file is atomically replaced (temp file + rename), gzip-compressed if it was
+// 3. the journal is removed, as the write is complete
+func (r *usersFileRepo) writeUsersData(usersData []lib.User) error {
+	jsonBytes, err := json.MarshalIndent(usersData, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	journalBytes, err := json.Marshal(usersJournalEntry{
+		Checksum:   dataChecksum(jsonBytes),
+		Data:       jsonBytes,
+		Compressed: r.compressed,
+	})
+	if err != nil {
+		return err
+	}
+
+	fileBytes, err := usersDataFileBytes(jsonBytes, r.compressed)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(r.journalPath, journalBytes)
+	if err != nil {
+		return fmt.Errorf("writing users journal: %w", err)
+	}
+
+	err = writeFileAtomic(r.filePath, fileBytes)
+	if err != nil {
+		return fmt.Errorf("writing users data file: %w", err)
+	}
+
+	// the write is already complete at this point, a leftover journal
+	// only rolls forward the same data on the next startup
+	err = os.Remove(r.journalPath)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Warn("removing users journal")
+	}
+
+	r.versionMutex.Lock()
+	r.dataVersion = dataChecksum(fileBytes)
+	r.versionMutex.Unlock()
+
+	return nil
+}
+
+// recoverJournal finishes a write interrupted after the journal was written (rolling it forward),
+// or discards the journal if its own write was interrupted (the data file is still intact in that case)
+func (r *usersFileRepo) recoverJournal() error {
+	journalBytes, err := ioutil.ReadFile(r.journalPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // nothing to recover
+	}
+	if err != nil {
+		return err
+	}
+
+	var entry usersJournalEntry
+	err = json.Unmarshal(journalBytes, &entry)
+	if err != nil || entry.Checksum != dataChecksum(entry.Data) {
+		log.WithFields(log.Fields{
+			"journal": r.journalPath,
+		}).Warn("discarding incomplete users journal")
+
+		return os.Remove(r.journalPath)
+	}
+
+	log.WithFields(log.Fields{
+		"journal": r.journalPath,
+	}).Warn("recovering interrupted users data write from journal")
+
+	fileBytes, err := usersDataFileBytes(entry.Data, entry.Compressed)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(r.filePath, fileBytes)
+	if err != nil {
+		return fmt.Errorf("recovering users data file: %w", err)
+	}
+
+	return os.Remove(r.journalPath)
+}
+
+// ReadUsersDataFile reads the users data from the JSON file (e.g. to fill other repos), validated as by NewUsersFileRepo
+// and with the plaintext passwords hashed (not saved, see warnPlaintextPasswords)
+func ReadUsersDataFile(filePath string, strictValidation bool) ([]lib.User, error) {
+	dataFile, err := loadUsersDataFile(filePath, strictValidation)
+	warnPlaintextPasswords(filePath, dataFile.hashedPasswords)
+	return dataFile.usersData, err
+}
+
+// loadUsersDataFile reads the users data from the JSON file (with its version), validating it
+// and hashing the plaintext passwords (legacy data file)
+func loadUsersDataFile(filePath string, strictValidation bool) (usersDataFile, error) {
+	dataFile, err := readUsersDataJSONFile(filePath)
+	if err != nil {
+		return usersDataFile{}, err
+	}
+
+	dataFile.usersData, dataFile.hashedPasswords, err = prepareUsersData(filePath, dataFile.usersData, dataFile.dateErrs, strictValidation)
+	if err != nil {
+		return usersDataFile{}, err
+	}
+
+	return dataFile, nil
+}
+
+// readUsersDataJSONFile reads the users data from the JSON file (gzip-compressed or not), with its version (SHA1 of the content)
+func readUsersDataJSONFile(filePath string) (usersDataFile, error) {
+	fileBytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return usersDataFile{}, err
+	}
+
+	usersData, dateErrs, err := decodeUsersData(fileBytes)
+	if err != nil {
+		return usersDataFile{}, err
+	}
+
+	return usersDataFile{
+		usersData:  usersData,
+		dateErrs:   dateErrs,
+		version:    dataChecksum(fileBytes),
+		compressed: isGzipped(fileBytes),
+	}, nil
+}
+
+// decodeUsersData decodes the users data JSON (with the date errors of the users, see lib.DecodeUsersData),
+// decompressing it first if it's gzip-compressed
+func decodeUsersData(data []byte) ([]lib.User, map[int]error, error) {
+	jsonBytes, err := decompressData(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return lib.DecodeUsersData(jsonBytes)
+}
+
+// prepareUsersData validates the users data read from the source (file path or URL), with its date errors,
+// and hashes the plaintext passwords (legacy data file), returning the number of hashed passwords
+func prepareUsersData(source string, usersData []lib.User, dateErrs map[int]error, strictValidation bool) ([]lib.User, int, error) {
+	err := validateUsersData(source, usersData, dateErrs, strictValidation)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hashedPasswords, err := lib.HashUsersPasswords(usersData)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return usersData, hashedPasswords, nil
+}
+
+// warnPlaintextPasswords logs the plaintext passwords hashed on load of a read-only users data source,
+// as they are hashed again on every load until the data is migrated
+func warnPlaintextPasswords(source string, hashedPasswords int) {
+	if hashedPasswords == 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"source":           source,
+		"hashed_passwords": hashedPasswords,
+	}).Warn("users data contains plaintext passwords, hashed on every load: they should be migrated (migrate-passwords command)")
+}
+
+// usersDataFileBytes gets the data file content of the users data JSON, gzip-compressed or not
+func usersDataFileBytes(jsonBytes []byte, compressed bool) ([]byte, error) {
+	if !compressed {
+		return jsonBytes, nil
+	}
+	return compressData(jsonBytes)
+}
+
+// validateUsersData logs each invalid user of the data file, failing if there is any with strict validation
+func validateUsersData(filePath string, usersData []lib.User, dateErrs map[int]error, strictValidation bool) error {
+	invalidUsers := lib.ValidateUsersData(usersData, dateErrs)
+	if len(invalidUsers) == 0 {
+		return nil
+	}
+
+	// logging in the data order
+	positions := make([]int, 0, len(invalidUsers))
+	for i := range invalidUsers {
+		positions = append(positions, i)
+	}
+	sort.Ints(positions)
+
+	for _, i := range positions {
+		log.WithFields(log.Fields{
+			"file":     filePath,
+			"position": i,
+			"id":       usersData[i].ID,
+			"error":    invalidUsers[i].Error(),
+		}).Warn("users data file contains an invalid user")
+	}
+
+	if strictValidation {
+		return fmt.Errorf("users data file %s contains %d invalid users: %w", filePath, len(invalidUsers), lib.ErrValidation)
+	}
+	return nil
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory and renames it to the final path,
+// so readers (and crashes) never see a partially written file
+func writeFileAtomic(filePath string, data []byte) error {
+	dir := filepath.Dir(filePath)
+
+	tmpFile, err := ioutil.TempFile(dir, filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// removing the temp file if anything fails before the rename (no-op after it)
+	defer os.Remove(tmpFile.Name())
+
+	// temp files are created only readable by the owner
+	err = tmpFile.Chmod(0644)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	_, err = tmpFile.Write(data)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	// flushing the content to disk before renaming
+	err = tmpFile.Sync()
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpFile.Name(), filePath)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir flushes the directory entries to disk (making renames and removals durable)
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// dataChecksum gets the SHA1 checksum of the data in hexadecimal format
+func dataChecksum(data []byte) string {
+	return fmt.Sprintf("%x", sha1.Sum(data))
+}
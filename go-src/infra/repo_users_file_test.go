@@ -0,0 +1,425 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashedTestUsersData is the test users data with hashed passwords (as stored in a migrated data file)
+var hashedTestUsersData = func() []lib.User {
+	usersData := append([]lib.User(nil), testUsersData...)
+	for i := range usersData {
+		hash, _ := bcrypt.GenerateFromPassword([]byte(usersData[i].Password), bcrypt.MinCost)
+		usersData[i].Password = string(hash)
+	}
+	return usersData
+}()
+
+// writeTestUsersDataFile writes the users data to a JSON file in a temporary directory, returning its path
+func writeTestUsersDataFile(t *testing.T, usersData []lib.User) string {
+	filePath := filepath.Join(t.TempDir(), "users.json")
+	writeTestUsersDataFileAt(t, filePath, usersData)
+
+	return filePath
+}
+
+// writeTestUsersDataFileAt writes the users data to the JSON file (replacing it atomically, as the editors do)
+func writeTestUsersDataFileAt(t *testing.T, filePath string, usersData []lib.User) {
+	jsonBytes, err := json.Marshal(usersData)
+	require.NoError(t, err)
+	require.NoError(t, writeFileAtomic(filePath, jsonBytes))
+}
+
+func TestNewUsersFileRepo(t *testing.T) {
+	filePath := writeTestUsersDataFile(t, hashedTestUsersData)
+	fileBytes, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+
+	repo, err := NewUsersFileRepo(filePath, true)
+	require.NoError(t, err)
+
+	page, err := repo.GetUsers(context.Background(), lib.UsersQuery{Limit: 10, Offset: 0})
+	users := page.Users
+	assert.NoError(t, err)
+	assert.Equal(t, hashedTestUsersData, users)
+	assert.Equal(t, dataChecksum(fileBytes), repo.DataVersion())
+
+	_, err = NewUsersFileRepo(filepath.Join(t.TempDir(), "unknown.json"), true)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestNewUsersFileRepoValidation(t *testing.T) {
+	usersData := append([]lib.User(nil), hashedTestUsersData...)
+	usersData[1].Email = "Terrence <ttrillow1@feedburner.com>"
+	filePath := writeTestUsersDataFile(t, usersData)
+
+	// the invalid users are only logged by default
+	repo, err := NewUsersFileRepo(filePath, false)
+	require.NoError(t, err)
+	user, err := repo.GetUser(context.Background(), usersData[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, usersData[1], user)
+
+	// and refused with strict validation
+	_, err = NewUsersFileRepo(filePath, true)
+	assert.True(t, errors.Is(err, lib.ErrValidation))
+}
+
+func TestNewUsersFileRepoInvalidDates(t *testing.T) {
+	jsonBytes, err := json.Marshal(hashedTestUsersData)
+	require.NoError(t, err)
+
+	// the invalid dates and the unknown fields do not stop the data file from being read
+	jsonBytes = bytes.Replace(jsonBytes, []byte(`"creation_date":"2021-04-19T00:00:00Z"`),
+		[]byte(`"creation_date":"31/02/2020","age":30`), 1)
+	filePath := filepath.Join(t.TempDir(), "users.json")
+	require.NoError(t, ioutil.WriteFile(filePath, jsonBytes, 0644))
+
+	// the invalid users are only logged by default
+	repo, err := NewUsersFileRepo(filePath, false)
+	require.NoError(t, err)
+
+	expectedUser := hashedTestUsersData[1]
+	expectedUser.CreationDate = time.Time{}
+	user, err := repo.GetUser(context.Background(), expectedUser.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedUser, user)
+
+	// and refused with strict validation
+	_, err = NewUsersFileRepo(filePath, true)
+	assert.True(t, errors.Is(err, lib.ErrValidation))
+}
+
+func TestUsersFileRepoWrites(t *testing.T) {
+	filePath := writeTestUsersDataFile(t, hashedTestUsersData)
+	ctx := context.Background()
+
+	repo, err := NewUsersFileRepo(filePath, true)
+	require.NoError(t, err)
+	initialVersion := repo.DataVersion()
+
+	newUser := lib.User{
+		ID:           "f3f1612d-8239-4933-9891-71b5ee127844",
+		FirstName:    "Loralie",
+		LastName:     "Yeoland",
+		Email:        "lyeoland3@ucla.edu",
+		Password:     hashedTestUsersData[0].Password,
+		IPAddress:    "105.22.43.36",
+		CreationDate: time.Date(2021, time.December, 24, 0, 0, 0, 0, time.UTC),
+	}
+	_, err = repo.CreateUser(ctx, newUser)
+	require.NoError(t, err)
+
+	updatedUser := hashedTestUsersData[1]
+	updatedUser.FirstName = "Terry"
+	_, err = repo.UpdateUser(ctx, updatedUser, "")
+	require.NoError(t, err)
+
+	err = repo.DeleteUser(ctx, hashedTestUsersData[0].ID)
+	require.NoError(t, err)
+
+	expectedData := []lib.User{updatedUser, hashedTestUsersData[2], newUser}
+
+	// version must follow the file content
+	fileBytes, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.NotEqual(t, initialVersion, repo.DataVersion())
+	assert.Equal(t, dataChecksum(fileBytes), repo.DataVersion())
+
+	// no journal or temp files must be left behind
+	files, err := ioutil.ReadDir(filepath.Dir(filePath))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// data must survive a restart
+	reopenedRepo, err := NewUsersFileRepo(filePath, true)
+	require.NoError(t, err)
+
+	page, err := reopenedRepo.GetUsers(ctx, lib.UsersQuery{Limit: 10, Offset: 0})
+	users := page.Users
+	assert.NoError(t, err)
+	assert.Equal(t, expectedData, users)
+	assert.Equal(t, repo.DataVersion(), reopenedRepo.DataVersion())
+}
+
+func TestUsersFileRepoCompressed(t *testing.T) {
+	ctx := context.Background()
+
+	jsonBytes, err := json.Marshal(hashedTestUsersData)
+	require.NoError(t, err)
+	compressedBytes, err := compressData(jsonBytes)
+	require.NoError(t, err)
+
+	filePath := filepath.Join(t.TempDir(), "users.json.gz")
+	require.NoError(t, ioutil.WriteFile(filePath, compressedBytes, 0644))
+
+	repo, err := NewUsersFileRepo(filePath, true)
+	require.NoError(t, err)
+	assert.Equal(t, dataChecksum(compressedBytes), repo.DataVersion())
+
+	err = repo.DeleteUser(ctx, hashedTestUsersData[0].ID)
+	require.NoError(t, err)
+
+	// the data file is still compressed, and its version follows the file content
+	fileBytes, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.True(t, isGzipped(fileBytes))
+	assert.Equal(t, dataChecksum(fileBytes), repo.DataVersion())
+
+	reopenedRepo, err := NewUsersFileRepo(filePath, true)
+	require.NoError(t, err)
+
+	page, err := reopenedRepo.GetUsers(ctx, lib.UsersQuery{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, hashedTestUsersData[1:], page.Users)
+}
+
+func TestUsersFileRepoReload(t *testing.T) {
+	filePath := writeTestUsersDataFile(t, hashedTestUsersData)
+	ctx := context.Background()
+
+	repo, err := NewUsersFileRepo(filePath, true)
+	require.NoError(t, err)
+
+	// unchanged data file
+	reloaded, err := repo.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	// data file written by the repo itself
+	updatedUser := hashedTestUsersData[0]
+	updatedUser.FirstName = "Nick"
+	_, err = repo.UpdateUser(ctx, updatedUser, "")
+	require.NoError(t, err)
+
+	reloaded, err = repo.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	// data file changed by someone else
+	changedData := []lib.User{hashedTestUsersData[2], hashedTestUsersData[1]}
+	changedData[0].LastName = "Paik"
+	writeTestUsersDataFileAt(t, filePath, changedData)
+
+	reloaded, err = repo.Reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+
+	fileBytes, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, dataChecksum(fileBytes), repo.DataVersion())
+
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, lib.UsersPage{Users: changedData, Total: 2}, page)
+
+	_, err = repo.GetUser(ctx, updatedUser.ID)
+	assert.Equal(t, lib.ErrNotFound, err)
+
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "paik", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{changedData[0]}, page.Users)
+
+	// invalid data files are refused, keeping the current data
+	invalidData := append([]lib.User(nil), changedData...)
+	invalidData[1].Email = "Terrence <ttrillow1@feedburner.com>"
+
+	for name, fileBytes := range map[string][]byte{
+		"malformed JSON": []byte(`[{"id": `),
+		"invalid user": func() []byte {
+			b, _ := json.Marshal(invalidData)
+			return b
+		}(),
+	} {
+		require.NoError(t, ioutil.WriteFile(filePath, fileBytes, 0644), name)
+
+		reloaded, err = repo.Reload()
+		assert.Error(t, err, name)
+		assert.False(t, reloaded, name)
+
+		page, err = repo.GetUsers(ctx, lib.UsersQuery{Limit: 10})
+		assert.NoError(t, err, name)
+		assert.Equal(t, changedData, page.Users, name)
+	}
+}
+
+func TestUsersFileRepoReloadConsistency(t *testing.T) {
+	datasets := [][]lib.User{hashedTestUsersData, hashedTestUsersData[1:]}
+	filePath := writeTestUsersDataFile(t, datasets[0])
+	ctx := context.Background()
+
+	repo, err := NewUsersFileRepo(filePath, true)
+	require.NoError(t, err)
+
+	datasetsBytes := make([][]byte, len(datasets))
+	for i, usersData := range datasets {
+		datasetsBytes[i], err = json.Marshal(usersData)
+		require.NoError(t, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 20; i++ {
+			assert.NoError(t, writeFileAtomic(filePath, datasetsBytes[i%2]))
+			_, err := repo.Reload()
+			assert.NoError(t, err)
+		}
+	}()
+
+	// the reads always see one of the datasets as a whole
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 10})
+		require.NoError(t, err)
+		assert.Contains(t, []lib.UsersPage{
+			{Users: datasets[0], Total: len(datasets[0])},
+			{Users: datasets[1], Total: len(datasets[1])},
+		}, page)
+	}
+}
+
+func TestUsersFileRepoJournalRecovery(t *testing.T) {
+	recoveredData := []lib.User{hashedTestUsersData[2]}
+	recoveredBytes, err := json.Marshal(recoveredData)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		journalBytes  []byte
+		expectedUsers []lib.User
+	}{
+		{
+			name: "complete journal - rolled forward",
+			journalBytes: func() []byte {
+				b, _ := json.Marshal(usersJournalEntry{
+					Checksum: dataChecksum(recoveredBytes),
+					Data:     recoveredBytes,
+				})
+				return b
+			}(),
+			expectedUsers: recoveredData,
+		},
+		{
+			name: "complete compressed journal - rolled forward",
+			journalBytes: func() []byte {
+				b, _ := json.Marshal(usersJournalEntry{
+					Checksum:   dataChecksum(recoveredBytes),
+					Data:       recoveredBytes,
+					Compressed: true,
+				})
+				return b
+			}(),
+			expectedUsers: recoveredData,
+		},
+		{
+			name:          "torn journal - discarded",
+			journalBytes:  []byte(`{"checksum":"abc","data":[{"id":"144b`),
+			expectedUsers: hashedTestUsersData,
+		},
+		{
+			name: "checksum mismatch - discarded",
+			journalBytes: func() []byte {
+				b, _ := json.Marshal(usersJournalEntry{
+					Checksum: "invalid",
+					Data:     recoveredBytes,
+				})
+				return b
+			}(),
+			expectedUsers: hashedTestUsersData,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filePath := writeTestUsersDataFile(t, hashedTestUsersData)
+			require.NoError(t, ioutil.WriteFile(filePath+usersJournalSuffix, tc.journalBytes, 0644))
+
+			repo, err := NewUsersFileRepo(filePath, true)
+			require.NoError(t, err)
+
+			page, err := repo.GetUsers(context.Background(), lib.UsersQuery{Limit: 10, Offset: 0})
+			users := page.Users
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedUsers, users)
+
+			_, err = os.Stat(filePath + usersJournalSuffix)
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestUsersFileRepoPasswordsMigration(t *testing.T) {
+	filePath := writeTestUsersDataFile(t, testUsersData)
+	ctx := context.Background()
+
+	repo, err := NewUsersFileRepo(filePath, true)
+	require.NoError(t, err)
+
+	// plaintext passwords are hashed on load
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 10, Offset: 0})
+	users := page.Users
+	require.NoError(t, err)
+	require.Len(t, users, len(testUsersData))
+	for i, user := range users {
+		assert.True(t, lib.IsPasswordHashed(user.Password))
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(testUsersData[i].Password)))
+	}
+
+	// and persisted, so they are only hashed once
+	savedFile, err := readUsersDataJSONFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, users, savedFile.usersData)
+	assert.Equal(t, savedFile.version, repo.DataVersion())
+
+	// the same for the plaintext passwords of a reloaded data file
+	writeTestUsersDataFileAt(t, filePath, testUsersData[:1])
+
+	reloaded, err := repo.Reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+
+	savedFile, err = readUsersDataJSONFile(filePath)
+	require.NoError(t, err)
+	require.Len(t, savedFile.usersData, 1)
+	assert.True(t, lib.IsPasswordHashed(savedFile.usersData[0].Password))
+	assert.Equal(t, savedFile.version, repo.DataVersion())
+
+	reloaded, err = repo.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+}
+
+func TestReadUsersDataFile(t *testing.T) {
+	filePath := writeTestUsersDataFile(t, testUsersData)
+
+	// the plaintext passwords are hashed
+	usersData, err := ReadUsersDataFile(filePath, true)
+	require.NoError(t, err)
+	require.Len(t, usersData, len(testUsersData))
+	for i, user := range usersData {
+		assert.True(t, lib.CheckPassword(user.Password, testUsersData[i].Password))
+		user.Password = testUsersData[i].Password
+		assert.Equal(t, testUsersData[i], user)
+	}
+
+	_, err = ReadUsersDataFile(filepath.Join(t.TempDir(), "unknown.json"), true)
+	assert.True(t, os.IsNotExist(err))
+}
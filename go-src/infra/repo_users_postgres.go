@@ -0,0 +1,202 @@
+package infra
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/lib/pq"
+)
+
+const (
+	// postgresUniqueViolation is the unique constraint violation error code
+	postgresUniqueViolation = "23505"
+)
+
+// usersPostgresMigrations are the database schema migrations, in order: the schema version is the number of applied
+// migrations (recorded in the users_schema_migrations table), the pending ones are applied on startup (all at once, in a transaction)
+var usersPostgresMigrations = []string{
+	// seq keeps the data order (insertion order, as the other repos), the *_key columns are the users sort keys
+	// (see lib.UserSortKey) and the email domain, so the filters and sorting are done by the database
+	// (the "C" collation compares the bytes, as the other repos)
+	`CREATE TABLE users (
+		seq BIGSERIAL PRIMARY KEY,
+		id TEXT COLLATE "C" NOT NULL UNIQUE,
+		first_name TEXT NOT NULL,
+		last_name TEXT NOT NULL,
+		email TEXT NOT NULL,
+		password TEXT NOT NULL,
+		ip_address TEXT NOT NULL,
+		creation_date TEXT NOT NULL,
+		deleted_at TEXT,
+		first_name_key TEXT COLLATE "C" NOT NULL,
+		last_name_key TEXT COLLATE "C" NOT NULL,
+		email_key TEXT COLLATE "C" NOT NULL,
+		email_domain_key TEXT COLLATE "C" NOT NULL,
+		ip_address_key BYTEA NOT NULL,
+		creation_date_key TEXT COLLATE "C" NOT NULL
+	);
+	CREATE INDEX users_email_key ON users (email_key);
+	CREATE INDEX users_creation_date_key ON users (creation_date_key);`,
+	// the emails are unique (case-insensitive), so the concurrent writes cannot take the same email
+	`DROP INDEX users_email_key;
+	CREATE UNIQUE INDEX users_email_key ON users (email_key);`,
+	// the version (see lib.UserVersion) is checked by the conditional updates, it's filled for the existing users
+	// (see sqlUsersVersionMigration)
+	`ALTER TABLE users ADD COLUMN version TEXT NOT NULL DEFAULT '';`,
+	// the users history and audit trail, next to the users (seq keeps their order, the revisions are numbered when read)
+	`CREATE TABLE users_revisions (
+		seq BIGSERIAL PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		made_at TEXT NOT NULL,
+		changes TEXT NOT NULL
+	);
+	CREATE INDEX users_revisions_user_id ON users_revisions (user_id, seq);
+	CREATE TABLE users_audit (
+		seq BIGSERIAL PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		made_at TEXT NOT NULL
+	);
+	CREATE INDEX users_audit_user_id ON users_audit (user_id, seq);`,
+}
+
+// postgresDialect is the PostgreSQL dialect of the users SQL repo
+var postgresDialect = sqlDialect{
+	rebind:            rebindPostgres,
+	isUniqueViolation: isPostgresUniqueViolation,
+}
+
+// PostgresPoolConfig is the database connections pool configuration (the non-positive values keep the database/sql defaults)
+type PostgresPoolConfig struct {
+	// MaxOpenConns is the maximum number of open connections (in use or idle)
+	MaxOpenConns int
+	// MaxIdleConns is the maximum number of idle connections
+	MaxIdleConns int
+	// ConnMaxLifetime is the maximum time a connection is reused
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime is the maximum time a connection stays idle
+	ConnMaxIdleTime time.Duration
+}
+
+// usersPostgresRepo is a users repo backed by a PostgreSQL database, the queries are canceled with their context,
+// the search index only follows the writes of this repo (the writes of other instances are indexed on the next startup)
+type usersPostgresRepo struct {
+	*usersSQLRepo
+}
+
+// NewUsersPostgresRepo creates a new users repo backed by the PostgreSQL database of the connection URL (or DSN) received as parameter,
+// applying the pending schema migrations, the database is filled with the users got from the seed function (optional)
+// when its schema is created
+func NewUsersPostgresRepo(url string, pool PostgresPoolConfig, seed func() ([]lib.User, error)) (*usersPostgresRepo, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	if pool.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+	if pool.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+	}
+
+	err = migratePostgres(context.Background(), db, seed)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	repo, err := newUsersSQLRepo(context.Background(), db, postgresDialect)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &usersPostgresRepo{usersSQLRepo: repo}, nil
+}
+
+// migratePostgres applies the pending schema migrations, the seed users are inserted along with the schema creation
+func migratePostgres(ctx context.Context, db *sql.DB, seed func() ([]lib.User, error)) error {
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS users_schema_migrations (version INTEGER PRIMARY KEY)")
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the instances starting at the same time apply the migrations one at a time
+	_, err = tx.ExecContext(ctx, "LOCK TABLE users_schema_migrations IN EXCLUSIVE MODE")
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM users_schema_migrations").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > len(usersPostgresMigrations) {
+		return fmt.Errorf("users database schema version %d is newer than the supported one (%d)", version, len(usersPostgresMigrations))
+	}
+	if version == len(usersPostgresMigrations) {
+		return nil
+	}
+
+	q := dialectQuerier{sqlQuerier: tx, rebind: rebindPostgres}
+	err = migrateSQLUsers(ctx, q, postgresDialect, usersPostgresMigrations, version, seed)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.ExecContext(ctx, "INSERT INTO users_schema_migrations (version) VALUES (?)", len(usersPostgresMigrations))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// rebindPostgres replaces the ? placeholders of the query by the numbered ones ($1, $2...)
+func rebindPostgres(query string) string {
+	var (
+		builder strings.Builder
+		n       int
+	)
+	for _, r := range query {
+		if r != '?' {
+			builder.WriteRune(r)
+			continue
+		}
+		n++
+		builder.WriteString("$" + strconv.Itoa(n))
+	}
+	return builder.String()
+}
+
+// isPostgresUniqueViolation checks if the error is a unique constraint violation
+func isPostgresUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == postgresUniqueViolation
+}
@@ -0,0 +1,288 @@
+package infra
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestUsersPostgresRepo creates a users Postgres repo over a stand-in database, seeded with the users data
+func newTestUsersPostgresRepo(t *testing.T, usersData []lib.User) (*usersPostgresRepo, *postgresStandIn) {
+	standIn := newPostgresStandIn(t)
+
+	repo, err := NewUsersPostgresRepo(standIn.url(), PostgresPoolConfig{}, func() ([]lib.User, error) {
+		return usersData, nil
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+	return repo, standIn
+}
+
+func TestNewUsersPostgresRepo(t *testing.T) {
+	standIn := newPostgresStandIn(t)
+	ctx := context.Background()
+
+	seeded := 0
+	seed := func() ([]lib.User, error) {
+		seeded++
+		return testUsersData, nil
+	}
+	pool := PostgresPoolConfig{MaxOpenConns: 4, MaxIdleConns: 2, ConnMaxLifetime: time.Minute, ConnMaxIdleTime: time.Minute}
+
+	repo, err := NewUsersPostgresRepo(standIn.url(), pool, seed)
+	require.NoError(t, err)
+
+	err = repo.DeleteUser(ctx, testUsersData[0].ID)
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	// the schema is already up to date, so the deleted user is not seeded again
+	repo, err = NewUsersPostgresRepo(standIn.url(), pool, seed)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	assert.Equal(t, 1, seeded)
+	assert.Equal(t, 4, repo.db.Stats().MaxOpenConnections)
+
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, lib.UsersPage{Users: testUsersData[1:], Total: 2}, page)
+
+	var version int
+	err = repo.db.QueryRow("SELECT MAX(version) FROM users_schema_migrations").Scan(&version)
+	assert.NoError(t, err)
+	assert.Equal(t, len(usersPostgresMigrations), version)
+}
+
+func TestNewUsersPostgresRepoNewerSchema(t *testing.T) {
+	standIn := newPostgresStandIn(t)
+
+	db, err := sql.Open("postgres", standIn.url())
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE users_schema_migrations (version INTEGER PRIMARY KEY)")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO users_schema_migrations (version) VALUES (99)")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = NewUsersPostgresRepo(standIn.url(), PostgresPoolConfig{}, nil)
+	assert.EqualError(t, err, "users database schema version 99 is newer than the supported one (4)")
+}
+
+func TestNewUsersPostgresRepoUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, err = NewUsersPostgresRepo("postgres://test@"+addr+"/users?sslmode=disable", PostgresPoolConfig{}, nil)
+	assert.Error(t, err)
+}
+
+func TestUsersPostgresRepoGetUsers(t *testing.T) {
+	deletedAt := time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC)
+	deletedUser := testUsersData[1]
+	deletedUser.DeletedAt = &deletedAt
+	usersData := []lib.User{
+		testUsersData[0],
+		deletedUser,
+		testUsersData[2],
+		{ID: "40d1b4ad-6b1b-4b37-8b7e-2c3c1c3c7f0e", FirstName: "nicky", LastName: "Zed", Email: "NZ@Jiathis.com", IPAddress: "2001:db8::1"},
+	}
+
+	_, ipNet, _ := net.ParseCIDR("43.113.46.0/24")
+
+	queries := map[string]lib.UsersQuery{
+		"all data":           {Limit: 10},
+		"all data - deleted": {Limit: 10, Filter: lib.UsersFilter{IncludeDeleted: true}},
+		"offset":             {Limit: 2, Offset: 1},
+		"first name prefix":  {Limit: 10, Filter: lib.UsersFilter{FirstName: &lib.StringFilter{Value: "ni", Prefix: true}}},
+		"email domain":       {Limit: 10, Filter: lib.UsersFilter{EmailDomain: "JIATHIS.com"}},
+		"ip network":         {Limit: 10, Filter: lib.UsersFilter{IPNet: ipNet}},
+		"created after":      {Limit: 10, Filter: lib.UsersFilter{CreatedAfter: time.Date(2021, time.January, 19, 0, 0, 0, 0, time.UTC)}},
+		"sorted by ip and creation date desc": {Limit: 10, Filter: lib.UsersFilter{IncludeDeleted: true},
+			Sort: []lib.SortField{{Field: "ip_address"}, {Field: "creation_date", Descending: true}}},
+		"cursor sorted by first name desc": {Limit: 2, Cursor: &lib.UsersCursor{Sort: []lib.SortField{{Field: "first_name", Descending: true}}},
+			Sort: []lib.SortField{{Field: "first_name", Descending: true}}},
+	}
+
+	ctx := context.Background()
+	memoryRepo := NewUsersRepo(usersData)
+	postgresRepo, _ := newTestUsersPostgresRepo(t, usersData)
+
+	for name, query := range queries {
+		t.Run(name, func(t *testing.T) {
+			expectedPage, err := memoryRepo.GetUsers(ctx, query)
+			require.NoError(t, err)
+
+			page, err := postgresRepo.GetUsers(ctx, query)
+			assert.NoError(t, err)
+			assert.Equal(t, expectedPage, page)
+		})
+	}
+}
+
+func TestUsersPostgresRepoReadsAndWrites(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newTestUsersPostgresRepo(t, testUsersData[:2])
+
+	user, err := repo.GetUserByEmail(ctx, "TTrillow1@Feedburner.com")
+	assert.NoError(t, err)
+	assert.Equal(t, testUsersData[1], user)
+
+	newUser := testUsersData[2]
+	createdUser, err := repo.CreateUser(ctx, newUser)
+	assert.NoError(t, err)
+	assert.Equal(t, newUser, createdUser)
+
+	// the unique constraint violation is a conflict
+	_, err = repo.CreateUser(ctx, newUser)
+	assert.ErrorIs(t, err, lib.ErrConflict)
+	assert.EqualError(t, err, "user 3e601207-0e80-4e7e-ae87-bb802b16a179 or its email already exists: conflict")
+
+	// the emails are unique (case-insensitive)
+	sameEmailUser := lib.User{ID: "40d1b4ad-6b1b-4b37-8b7e-2c3c1c3c7f0e", Email: "TTrillow1@Feedburner.com"}
+	_, err = repo.CreateUser(ctx, sameEmailUser)
+	assert.ErrorIs(t, err, lib.ErrConflict)
+
+	sameEmailUser = testUsersData[0]
+	sameEmailUser.Email = testUsersData[1].Email
+	_, err = repo.UpdateUser(ctx, sameEmailUser, "")
+	assert.ErrorIs(t, err, lib.ErrConflict)
+
+	err = repo.UpsertUsers(ctx, []lib.User{conflictFreeUser(), sameEmailUser, testUsersData[1]})
+	assert.ErrorIs(t, err, lib.ErrConflict)
+	_, err = repo.GetUser(ctx, conflictFreeUser().ID)
+	assert.Equal(t, lib.ErrNotFound, err)
+
+	updatedUser := testUsersData[0]
+	updatedUser.FirstName = "Nick"
+	_, err = repo.UpdateUser(ctx, updatedUser, "")
+	assert.NoError(t, err)
+
+	err = repo.DeleteUser(ctx, testUsersData[1].ID)
+	assert.NoError(t, err)
+
+	err = repo.DeleteUser(ctx, testUsersData[1].ID)
+	assert.Equal(t, lib.ErrNotFound, err)
+
+	replacedUser := testUsersData[2]
+	replacedUser.LastName = "Paik"
+	err = repo.UpsertUsers(ctx, []lib.User{testUsersData[1], replacedUser})
+	assert.NoError(t, err)
+
+	batch, err := repo.GetUsersByIDs(ctx, []string{testUsersData[1].ID, "unknown_id", testUsersData[0].ID})
+	assert.NoError(t, err)
+	assert.Equal(t, lib.UsersBatch{
+		Users:      []lib.User{testUsersData[1], updatedUser},
+		MissingIDs: []string{"unknown_id"},
+	}, batch)
+
+	page, err := repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "paik", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{replacedUser}, page.Users)
+}
+
+func TestUsersPostgresRepoSearchOtherInstanceWrites(t *testing.T) {
+	ctx := context.Background()
+	repo, standIn := newTestUsersPostgresRepo(t, testUsersData)
+
+	otherRepo, err := NewUsersPostgresRepo(standIn.url(), PostgresPoolConfig{}, nil)
+	require.NoError(t, err)
+	defer otherRepo.Close()
+
+	// the users removed or deleted by the other instance are not found
+	err = otherRepo.DeleteUser(ctx, testUsersData[0].ID)
+	require.NoError(t, err)
+	deletedAt := time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC)
+	deletedUser := testUsersData[1]
+	deletedUser.DeletedAt = &deletedAt
+	_, err = otherRepo.UpdateUser(ctx, deletedUser, "")
+	require.NoError(t, err)
+
+	for _, text := range []string{"nicky", "trillow"} {
+		page, err := repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: text, Limit: 10})
+		assert.NoError(t, err)
+		assert.Equal(t, lib.UsersPage{Users: []lib.User{}, Total: 0}, page)
+	}
+
+	page, err := repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: testUsersData[2].LastName, Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, lib.UsersPage{Users: []lib.User{testUsersData[2]}, Total: 1}, page)
+}
+
+func TestUsersPostgresRepoCanceledContext(t *testing.T) {
+	repo, standIn := newTestUsersPostgresRepo(t, testUsersData)
+
+	// the count query runs until it's canceled by the client
+	standIn.setBeforeQuery(func(ctx context.Context, query string) error {
+		if !strings.Contains(query, "COUNT(*)") {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return errors.New("query not canceled")
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 10})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	standIn.setBeforeQuery(nil)
+
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = repo.CreateUser(canceledCtx, lib.User{ID: "40d1b4ad-6b1b-4b37-8b7e-2c3c1c3c7f0e"})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// the repo is still usable
+	user, err := repo.GetUser(context.Background(), testUsersData[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, testUsersData[0], user)
+}
+
+func TestRebindPostgres(t *testing.T) {
+	assert.Equal(t, "SELECT 1 FROM users WHERE id = $1 AND seq > $2 LIMIT $3",
+		rebindPostgres("SELECT 1 FROM users WHERE id = ? AND seq > ? LIMIT ?"))
+	assert.Equal(t, "SELECT 1", rebindPostgres("SELECT 1"))
+}
+
+func TestUsersPostgresRepoUpdateOtherInstanceWrites(t *testing.T) {
+	ctx := context.Background()
+	repo, standIn := newTestUsersPostgresRepo(t, testUsersData)
+
+	otherRepo, err := NewUsersPostgresRepo(standIn.url(), PostgresPoolConfig{}, nil)
+	require.NoError(t, err)
+	defer otherRepo.Close()
+
+	// both instances got the same user version, only the first update is applied
+	version := lib.UserVersion(testUsersData[0])
+
+	updatedUser := testUsersData[0]
+	updatedUser.FirstName = "Nick"
+	_, err = otherRepo.UpdateUser(ctx, updatedUser, version)
+	require.NoError(t, err)
+
+	lostUser := testUsersData[0]
+	lostUser.LastName = "Lost"
+	_, err = repo.UpdateUser(ctx, lostUser, version)
+	assert.ErrorIs(t, err, lib.ErrPreconditionFailed)
+
+	user, err := repo.GetUser(ctx, testUsersData[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, updatedUser, user)
+}
@@ -0,0 +1,852 @@
+package infra
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hbernardo/users/go-src/lib"
+)
+
+const (
+	// sqlUserColumns are the selected users columns, in the order scanned by scanSQLUser
+	sqlUserColumns = "seq, id, first_name, last_name, email, password, ip_address, creation_date, deleted_at"
+
+	// sqlInsertUser inserts a user, with its sort keys and version (see sqlUserValues)
+	sqlInsertUser = `INSERT INTO users (id, first_name, last_name, email, password, ip_address, creation_date, deleted_at,
+		first_name_key, last_name_key, email_key, email_domain_key, ip_address_key, creation_date_key, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	// sqlUpsertUser inserts a user or replaces it (keeping its position in the data order)
+	sqlUpsertUser = sqlInsertUser + ` ON CONFLICT (id) DO UPDATE SET
+		first_name = excluded.first_name, last_name = excluded.last_name, email = excluded.email,
+		password = excluded.password, ip_address = excluded.ip_address, creation_date = excluded.creation_date,
+		deleted_at = excluded.deleted_at, first_name_key = excluded.first_name_key, last_name_key = excluded.last_name_key,
+		email_key = excluded.email_key, email_domain_key = excluded.email_domain_key,
+		ip_address_key = excluded.ip_address_key, creation_date_key = excluded.creation_date_key, version = excluded.version`
+
+	// sqlUpdateUser replaces a user (the values of sqlUserValues, without the leading ID, followed by the ID)
+	sqlUpdateUser = `UPDATE users SET first_name = ?, last_name = ?, email = ?, password = ?, ip_address = ?,
+		creation_date = ?, deleted_at = ?, first_name_key = ?, last_name_key = ?, email_key = ?, email_domain_key = ?,
+		ip_address_key = ?, creation_date_key = ?, version = ? WHERE id = ?`
+
+	// sqlUsersVersionMigration is the schema version adding the users version column (see lib.UserVersion),
+	// the versions of the existing users are filled by the migration
+	sqlUsersVersionMigration = 3
+
+	// sqlMaxQueryIDs is the maximum number of IDs queried at once (bound variables limit)
+	sqlMaxQueryIDs = 500
+)
+
+// usersSortColumns maps the sortable user fields (JSON names) to their sort key columns
+var usersSortColumns = map[string]string{
+	"id":            "id",
+	"first_name":    "first_name_key",
+	"last_name":     "last_name_key",
+	"email":         "email_key",
+	"ip_address":    "ip_address_key",
+	"creation_date": "creation_date_key",
+}
+
+type (
+	// usersSQLRepo is a users repo backed by an SQL database (the users table of the SQL backends), the data doesn't need
+	// to fit in memory, only the full-text search index (the users terms) is kept in memory
+	usersSQLRepo struct {
+		sqlDB
+
+		// mutex serializes the writes, so the search index is updated in the same order as the database,
+		// and protects the search index against concurrent writes
+		mutex       sync.RWMutex
+		searchIndex *usersSearchIndex
+	}
+
+	// sqlDB is the database of the SQL backends, shared by their users, revisions and audit repos
+	sqlDB struct {
+		db      *sql.DB
+		dialect sqlDialect
+	}
+
+	// sqlDialect is what differs between the SQL databases
+	sqlDialect struct {
+		// rebind replaces the ? placeholders of the query by the database ones (nil if they are supported)
+		rebind func(query string) string
+		// isUniqueViolation checks if the error is a unique constraint violation
+		isUniqueViolation func(err error) bool
+	}
+
+	// sqlQuerier is the database or a transaction
+	sqlQuerier interface {
+		QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+		QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+		ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+		PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	}
+
+	// dialectQuerier runs the queries rebound to the database dialect
+	dialectQuerier struct {
+		sqlQuerier
+		rebind func(query string) string
+	}
+)
+
+// newUsersSQLRepo creates a new users repo over the database (its schema must be up to date), loading the search index
+func newUsersSQLRepo(ctx context.Context, db *sql.DB, dialect sqlDialect) (*usersSQLRepo, error) {
+	repo := &usersSQLRepo{
+		sqlDB: sqlDB{db: db, dialect: dialect},
+	}
+
+	err := repo.loadSearchIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// Close closes the database
+func (r *usersSQLRepo) Close() error {
+	return r.db.Close()
+}
+
+// GetUsers gets users based on pagination (limit and offset, or cursor), filters and sorting
+func (r *usersSQLRepo) GetUsers(ctx context.Context, query lib.UsersQuery) (lib.UsersPage, error) {
+	limit, offset := query.Limit, query.Offset
+
+	// validating pagination parameters
+	if limit < 0 || offset < 0 {
+		return lib.UsersPage{}, fmt.Errorf("'limit' nor 'offset' cannot be negative: %w", lib.ErrPreconditionFailed)
+	}
+
+	var page lib.UsersPage
+	err := r.inTx(ctx, true, func(q sqlQuerier) error {
+		where, args := usersFilterSQL(query.Filter)
+
+		// the total and the page are read from the same snapshot
+		err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE "+where, args...).Scan(&page.Total)
+		if err != nil {
+			return err
+		}
+
+		if query.Cursor != nil {
+			page.Users, page.NextCursor, err = queryUsersAfterCursor(ctx, q, where, args, limit, query.Sort, *query.Cursor)
+			return err
+		}
+
+		page.Users, err = queryUsers(ctx, q,
+			"SELECT "+sqlUserColumns+" FROM users WHERE "+where+" ORDER BY "+usersOrderSQL(query.Sort)+" LIMIT ? OFFSET ?",
+			append(args, limit, offset)...)
+		return err
+	})
+	if err != nil {
+		return lib.UsersPage{}, err
+	}
+
+	return page, nil
+}
+
+// queryUsersAfterCursor gets the page (limit) of users right after the cursor (keyset pagination),
+// with the cursor of the next page (nil if there are no more users)
+func queryUsersAfterCursor(ctx context.Context, q sqlQuerier, where string, args []interface{},
+	limit int, sortFields []lib.SortField, cursor lib.UsersCursor) ([]lib.User, *lib.UsersCursor, error) {
+	keysetSort := lib.KeysetSort(sortFields)
+
+	if !cursor.IsStart() {
+		afterWhere, afterArgs := usersAfterCursorSQL(keysetSort, cursor)
+		where += " AND " + afterWhere
+		args = append(args, afterArgs...)
+	}
+
+	// one more user is got to know if there is a next page
+	users, err := queryUsers(ctx, q,
+		"SELECT "+sqlUserColumns+" FROM users WHERE "+where+" ORDER BY "+usersOrderSQL(keysetSort)+" LIMIT ?",
+		append(args, limit+1)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(users) <= limit {
+		return users, nil, nil
+	}
+
+	users = users[:limit]
+	if limit == 0 {
+		return users, nil, nil
+	}
+	nextCursor := lib.NewUsersCursor(users[limit-1], sortFields)
+	return users, &nextCursor, nil
+}
+
+// GetUser gets user based on its ID
+func (r *usersSQLRepo) GetUser(ctx context.Context, userID string) (lib.User, error) {
+	user, err := getSQLUser(ctx, r.querier(r.db), "id = ?", userID)
+	return user, contextError(ctx, err)
+}
+
+// GetUsersByIDs gets the users based on their IDs (in the same order), reporting the IDs that were not found
+func (r *usersSQLRepo) GetUsersByIDs(ctx context.Context, userIDs []string) (lib.UsersBatch, error) {
+	var usersMap map[string]sqlUser
+	err := r.inTx(ctx, true, func(q sqlQuerier) error {
+		var err error
+		usersMap, err = queryUsersByIDs(ctx, q, userIDs)
+		return err
+	})
+	if err != nil {
+		return lib.UsersBatch{}, err
+	}
+
+	batch := lib.UsersBatch{
+		Users:      make([]lib.User, 0, len(userIDs)),
+		MissingIDs: []string{},
+	}
+	for _, userID := range userIDs {
+		user, userExists := usersMap[userID]
+		if !userExists {
+			batch.MissingIDs = append(batch.MissingIDs, userID)
+			continue
+		}
+		batch.Users = append(batch.Users, user.User)
+	}
+
+	return batch, nil
+}
+
+// SearchUsers gets the users matching the full-text search (names and email), ordered by relevance
+// (and then by data order), paginated by limit and offset
+func (r *usersSQLRepo) SearchUsers(ctx context.Context, query lib.UsersSearchQuery) (lib.UsersPage, error) {
+	limit, offset := query.Limit, query.Offset
+
+	// validating pagination parameters
+	if limit < 0 || offset < 0 {
+		return lib.UsersPage{}, fmt.Errorf("'limit' nor 'offset' cannot be negative: %w", lib.ErrPreconditionFailed)
+	}
+
+	// the read lock is held until the users are read, so they are the ones indexed
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	hits := r.searchIndex.search(query.Text)
+	userIDs := make([]string, len(hits))
+	for i, hit := range hits {
+		userIDs[i] = hit.UserID
+	}
+
+	var usersMap map[string]sqlUser
+	err := r.inTx(ctx, true, func(q sqlQuerier) error {
+		var err error
+		usersMap, err = queryUsersByIDs(ctx, q, userIDs)
+		return err
+	})
+	if err != nil {
+		return lib.UsersPage{}, err
+	}
+
+	// the index only follows the writes of this instance, so the users removed or deleted by the others are dropped
+	foundHits := hits[:0]
+	for _, hit := range hits {
+		user, userExists := usersMap[hit.UserID]
+		if userExists && !user.IsDeleted() {
+			foundHits = append(foundHits, hit)
+		}
+	}
+	hits = foundHits
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return usersMap[hits[i].UserID].seq < usersMap[hits[j].UserID].seq
+	})
+
+	// fixing out of bonds slice access
+	if offset > len(hits) {
+		offset = len(hits)
+	}
+	if (offset + limit) > len(hits) {
+		limit = len(hits) - offset
+	}
+
+	users := make([]lib.User, limit)
+	for i, hit := range hits[offset : offset+limit] {
+		users[i] = usersMap[hit.UserID].User
+	}
+
+	return lib.UsersPage{Users: users, Total: len(hits)}, nil
+}
+
+// GetUserByEmail gets user based on its email (case-insensitive)
+func (r *usersSQLRepo) GetUserByEmail(ctx context.Context, email string) (lib.User, error) {
+	user, err := getSQLUser(ctx, r.querier(r.db), "email_key = ?", strings.ToLower(email))
+	return user, contextError(ctx, err)
+}
+
+// CreateUser adds a new user to the end of the users data
+func (r *usersSQLRepo) CreateUser(ctx context.Context, user lib.User) (lib.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	err := r.inTx(ctx, false, func(q sqlQuerier) error {
+		_, err := q.ExecContext(ctx, sqlInsertUser, sqlUserValues(user)...)
+		if r.dialect.isUniqueViolation(err) {
+			return fmt.Errorf("user %s or its email already exists: %w", user.ID, lib.ErrConflict)
+		}
+		return err
+	})
+	if err != nil {
+		return lib.User{}, err
+	}
+	r.searchIndex.add(user)
+
+	return user, nil
+}
+
+// UpdateUser replaces an existing user data based on its ID, if its current version is the expected one (any version if empty),
+// the version is checked by the update itself, so the concurrent writes of other instances are not lost
+func (r *usersSQLRepo) UpdateUser(ctx context.Context, user lib.User, version string) (lib.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var previousUser lib.User
+	err := r.inTx(ctx, false, func(q sqlQuerier) error {
+		var err error
+		previousUser, err = getSQLUser(ctx, q, "id = ?", user.ID)
+		if err != nil {
+			return err
+		}
+
+		query := sqlUpdateUser
+		args := append(sqlUserValues(user)[1:], user.ID)
+		if version != "" {
+			query += " AND version = ?"
+			args = append(args, version)
+		}
+
+		result, err := q.ExecContext(ctx, query, args...)
+		if r.dialect.isUniqueViolation(err) {
+			return fmt.Errorf("user %s email already exists: %w", user.ID, lib.ErrConflict)
+		}
+		if err != nil {
+			return err
+		}
+
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return versionMismatchError(user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return lib.User{}, err
+	}
+	r.searchIndex.remove(previousUser)
+	r.searchIndex.add(user)
+
+	return user, nil
+}
+
+// DeleteUser removes an existing user based on its ID, keeping the order of the remaining users
+func (r *usersSQLRepo) DeleteUser(ctx context.Context, userID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var deletedUser lib.User
+	err := r.inTx(ctx, false, func(q sqlQuerier) error {
+		var err error
+		deletedUser, err = getSQLUser(ctx, q, "id = ?", userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	r.searchIndex.remove(deletedUser)
+
+	return nil
+}
+
+// UpsertUsers creates or replaces (based on their IDs) multiple users at once, in a single transaction:
+// the existing users are replaced in place and the new ones are added to the end of the users data
+func (r *usersSQLRepo) UpsertUsers(ctx context.Context, users []lib.User) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	userIDs := make([]string, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+
+	var replaced map[string]sqlUser
+	err := r.inTx(ctx, false, func(q sqlQuerier) error {
+		var err error
+		replaced, err = queryUsersByIDs(ctx, q, userIDs)
+		if err != nil {
+			return err
+		}
+
+		return upsertSQLUsers(ctx, q, r.dialect, users)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the last write of each user wins
+	written := make(map[string]lib.User, len(users))
+	for _, user := range users {
+		written[user.ID] = user
+	}
+	for _, user := range replaced {
+		r.searchIndex.remove(user.User)
+	}
+	for _, user := range written {
+		r.searchIndex.add(user)
+	}
+
+	return nil
+}
+
+// inTx calls the function in a transaction, committed if it returns no error (or else rolled back),
+// the reads of a read-only transaction see the same snapshot of the database
+func (r sqlDB) inTx(ctx context.Context, readOnly bool, fn func(q sqlQuerier) error) error {
+	txOptions := &sql.TxOptions{ReadOnly: readOnly}
+	if readOnly {
+		txOptions.Isolation = sql.LevelRepeatableRead
+	}
+
+	tx, err := r.db.BeginTx(ctx, txOptions)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	err = fn(r.querier(tx))
+	if err != nil {
+		tx.Rollback()
+		return contextError(ctx, err)
+	}
+
+	return contextError(ctx, tx.Commit())
+}
+
+// querier gets the querier running the queries in the database dialect
+func (r sqlDB) querier(q sqlQuerier) sqlQuerier {
+	if r.dialect.rebind == nil {
+		return q
+	}
+	return dialectQuerier{sqlQuerier: q, rebind: r.dialect.rebind}
+}
+
+// loadSearchIndex creates the search index from the users in the database (only their searchable fields are read)
+func (r *usersSQLRepo) loadSearchIndex(ctx context.Context) error {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, first_name, last_name, email FROM users WHERE deleted_at IS NULL")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var users []lib.User
+	for rows.Next() {
+		var user lib.User
+		err = rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email)
+		if err != nil {
+			return err
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	r.searchIndex = newUsersSearchIndex(users)
+	return nil
+}
+
+// QueryContext runs the query rebound to the database dialect
+func (q dialectQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return q.sqlQuerier.QueryContext(ctx, q.rebind(query), args...)
+}
+
+// QueryRowContext runs the query rebound to the database dialect
+func (q dialectQuerier) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return q.sqlQuerier.QueryRowContext(ctx, q.rebind(query), args...)
+}
+
+// ExecContext runs the statement rebound to the database dialect
+func (q dialectQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return q.sqlQuerier.ExecContext(ctx, q.rebind(query), args...)
+}
+
+// PrepareContext prepares the statement rebound to the database dialect
+func (q dialectQuerier) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return q.sqlQuerier.PrepareContext(ctx, q.rebind(query))
+}
+
+// contextError gets the context error if it's done (the query was canceled or timed out), or else the error itself
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// sqlUser is the user read from the database, with its position in the data order
+type sqlUser struct {
+	lib.User
+	seq int64
+}
+
+// migrateSQLUsers applies the migrations following the schema version, with the querier of a transaction (so they are
+// all applied or none), the seed users (optional) are inserted once the schema is created (migrated from version 0)
+func migrateSQLUsers(ctx context.Context, q sqlQuerier, dialect sqlDialect, migrations []string, version int,
+	seed func() ([]lib.User, error)) error {
+	created := version == 0
+
+	for ; version < len(migrations); version++ {
+		_, err := q.ExecContext(ctx, migrations[version])
+		if err == nil && version+1 == sqlUsersVersionMigration {
+			err = fillSQLUsersVersions(ctx, q)
+		}
+		if err != nil {
+			return fmt.Errorf("users database migration %d: %w", version+1, err)
+		}
+	}
+
+	if created && seed != nil {
+		err := seedSQLUsers(ctx, q, dialect, seed)
+		if err != nil {
+			return fmt.Errorf("seeding the users database: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// fillSQLUsersVersions fills the versions of the users without one, in batches
+func fillSQLUsersVersions(ctx context.Context, q sqlQuerier) error {
+	for {
+		users, err := queryUsers(ctx, q, "SELECT "+sqlUserColumns+" FROM users WHERE version = '' ORDER BY seq LIMIT ?", sqlMaxQueryIDs)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return nil
+		}
+
+		for _, user := range users {
+			_, err = q.ExecContext(ctx, "UPDATE users SET version = ? WHERE id = ?", lib.UserVersion(user), user.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// seedSQLUsers inserts the users got from the seed function, in order
+func seedSQLUsers(ctx context.Context, q sqlQuerier, dialect sqlDialect, seed func() ([]lib.User, error)) error {
+	users, err := seed()
+	if err != nil {
+		return err
+	}
+
+	return upsertSQLUsers(ctx, q, dialect, users)
+}
+
+// upsertSQLUsers inserts or replaces the users, in order, an email already used by another user is a conflict
+func upsertSQLUsers(ctx context.Context, q sqlQuerier, dialect sqlDialect, users []lib.User) error {
+	stmt, err := q.PrepareContext(ctx, sqlUpsertUser)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, user := range users {
+		_, err = stmt.ExecContext(ctx, sqlUserValues(user)...)
+		if dialect.isUniqueViolation(err) {
+			return fmt.Errorf("user %s email already exists: %w", user.ID, lib.ErrConflict)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSQLUser gets the first user (in data order) matching the condition, not found if there is none
+func getSQLUser(ctx context.Context, q sqlQuerier, where string, args ...interface{}) (lib.User, error) {
+	users, err := queryUsers(ctx, q, "SELECT "+sqlUserColumns+" FROM users WHERE "+where+" ORDER BY seq LIMIT 1", args...)
+	if err != nil {
+		return lib.User{}, err
+	}
+	if len(users) == 0 {
+		return lib.User{}, lib.ErrNotFound
+	}
+	return users[0], nil
+}
+
+// queryUsers gets the users selected by the query (the sqlUserColumns must be selected)
+func queryUsers(ctx context.Context, q sqlQuerier, query string, args ...interface{}) ([]lib.User, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []lib.User{}
+	for rows.Next() {
+		user, err := scanSQLUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user.User)
+	}
+
+	return users, rows.Err()
+}
+
+// queryUsersByIDs gets the existing users with the IDs, by ID
+func queryUsersByIDs(ctx context.Context, q sqlQuerier, userIDs []string) (map[string]sqlUser, error) {
+	users := make(map[string]sqlUser, len(userIDs))
+
+	for start := 0; start < len(userIDs); start += sqlMaxQueryIDs {
+		end := start + sqlMaxQueryIDs
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+
+		args := make([]interface{}, 0, end-start)
+		for _, userID := range userIDs[start:end] {
+			args = append(args, userID)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+
+		rows, err := q.QueryContext(ctx, "SELECT "+sqlUserColumns+" FROM users WHERE id IN ("+placeholders+")", args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			user, err := scanSQLUser(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			users[user.ID] = user
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return users, nil
+}
+
+// scanSQLUser scans the user from the row (the sqlUserColumns)
+func scanSQLUser(rows *sql.Rows) (sqlUser, error) {
+	var (
+		user         sqlUser
+		creationDate string
+		deletedAt    sql.NullString
+	)
+	err := rows.Scan(&user.seq, &user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password, &user.IPAddress,
+		&creationDate, &deletedAt)
+	if err != nil {
+		return sqlUser{}, err
+	}
+
+	user.CreationDate, err = time.Parse(time.RFC3339Nano, creationDate)
+	if err != nil {
+		return sqlUser{}, fmt.Errorf("user %s creation date: %w", user.ID, err)
+	}
+	if deletedAt.Valid {
+		date, err := time.Parse(time.RFC3339Nano, deletedAt.String)
+		if err != nil {
+			return sqlUser{}, fmt.Errorf("user %s deletion date: %w", user.ID, err)
+		}
+		user.DeletedAt = &date
+	}
+
+	return user, nil
+}
+
+// sqlUserValues gets the values inserted by the sqlInsertUser statement
+func sqlUserValues(user lib.User) []interface{} {
+	var deletedAt interface{}
+	if user.DeletedAt != nil {
+		deletedAt = user.DeletedAt.Format(time.RFC3339Nano)
+	}
+
+	emailDomain := ""
+	if at := strings.LastIndex(user.Email, "@"); at >= 0 {
+		emailDomain = strings.ToLower(user.Email[at+1:])
+	}
+
+	return []interface{}{
+		user.ID,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		user.Password,
+		user.IPAddress,
+		user.CreationDate.Format(time.RFC3339Nano),
+		deletedAt,
+		lib.UserSortKey(user, "first_name"),
+		lib.UserSortKey(user, "last_name"),
+		lib.UserSortKey(user, "email"),
+		emailDomain,
+		ipAddressKeyArg(lib.UserSortKey(user, "ip_address")),
+		lib.UserSortKey(user, "creation_date"),
+		lib.UserVersion(user),
+	}
+}
+
+// usersFilterSQL gets the SQL condition (and its arguments) matching the users filter (see lib.UsersFilter.Matches)
+func usersFilterSQL(filter lib.UsersFilter) (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+
+	if filter.OnlyDeleted {
+		conditions = append(conditions, "deleted_at IS NOT NULL")
+	} else if !filter.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+
+	stringFilter := func(column string, f *lib.StringFilter) {
+		value := strings.ToLower(f.Value)
+		if f.Prefix {
+			conditions = append(conditions, fmt.Sprintf("substr(%s, 1, ?) = ?", column))
+			args = append(args, utf8.RuneCountInString(value), value)
+			return
+		}
+		conditions = append(conditions, column+" = ?")
+		args = append(args, value)
+	}
+	if filter.FirstName != nil {
+		stringFilter("first_name_key", filter.FirstName)
+	}
+	if filter.LastName != nil {
+		stringFilter("last_name_key", filter.LastName)
+	}
+
+	if filter.EmailDomain != "" {
+		conditions = append(conditions, "email_domain_key = ?")
+		args = append(args, strings.ToLower(filter.EmailDomain))
+	}
+
+	if filter.IPNet != nil {
+		first, last := ipNetRange(filter.IPNet)
+		conditions = append(conditions, "ip_address_key BETWEEN ? AND ?")
+		args = append(args, first, last)
+	}
+
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, "creation_date_key > ?")
+		args = append(args, lib.UserSortKey(lib.User{CreationDate: filter.CreatedAfter}, "creation_date"))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, "creation_date_key < ?")
+		args = append(args, lib.UserSortKey(lib.User{CreationDate: filter.CreatedBefore}, "creation_date"))
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// usersOrderSQL gets the SQL ordering of the sort fields, then by data order (as the stable sorting of the other repos)
+func usersOrderSQL(sortFields []lib.SortField) string {
+	orderBy := make([]string, 0, len(sortFields)+1)
+	for _, sortField := range sortFields {
+		orderBy = append(orderBy, usersSortColumns[sortField.Field]+sortDirectionSQL(sortField.Descending))
+	}
+	return strings.Join(append(orderBy, "seq"), ", ")
+}
+
+// usersAfterCursorSQL gets the SQL condition (and its arguments) matching the users after the cursor in the keyset sorting:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ... (with < for the descending fields)
+func usersAfterCursorSQL(keysetSort []lib.SortField, cursor lib.UsersCursor) (string, []interface{}) {
+	var (
+		alternatives []string
+		args         []interface{}
+	)
+
+	for i := range keysetSort {
+		conditions := make([]string, 0, i+1)
+		for j, sortField := range keysetSort[:i+1] {
+			operator := "="
+			if j == i {
+				operator = ">"
+				if sortField.Descending {
+					operator = "<"
+				}
+			}
+			conditions = append(conditions, fmt.Sprintf("%s %s ?", usersSortColumns[sortField.Field], operator))
+			args = append(args, cursorKeyArg(sortField, cursorKey(cursor, j)))
+		}
+		alternatives = append(alternatives, "("+strings.Join(conditions, " AND ")+")")
+	}
+
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+// cursorKey gets the cursor sort key at the keyset sorting position (the ID after the cursor sort keys)
+func cursorKey(cursor lib.UsersCursor, i int) string {
+	if i < len(cursor.SortKeys) {
+		return cursor.SortKeys[i]
+	}
+	return cursor.ID
+}
+
+// cursorKeyArg gets the sort key as the type of its column (the IP address keys are binary)
+func cursorKeyArg(sortField lib.SortField, key string) interface{} {
+	if sortField.Field == "ip_address" {
+		return ipAddressKeyArg(key)
+	}
+	return key
+}
+
+// ipAddressKeyArg gets the IP address sort key as binary, but the empty key (missing IP address) is bound as text:
+// the SQLite driver binds the empty binary as NULL, and the empty text sorts before any binary as well
+func ipAddressKeyArg(key string) interface{} {
+	if key == "" {
+		return ""
+	}
+	return []byte(key)
+}
+
+// sortDirectionSQL gets the SQL sort direction
+func sortDirectionSQL(descending bool) string {
+	if descending {
+		return " DESC"
+	}
+	return " ASC"
+}
+
+// ipNetRange gets the first and last addresses (16 bytes form, as the IP address sort keys) of the network
+func ipNetRange(ipNet *net.IPNet) ([]byte, []byte) {
+	ip := ipNet.IP.To16()
+	mask := ipNet.Mask
+	if len(mask) == net.IPv4len {
+		// IPv4 networks are in the IPv4-mapped IPv6 range (::ffff:0:0/96)
+		mask = append(net.CIDRMask(96, 8*net.IPv6len)[:12:12], mask...)
+	}
+	if ip == nil || len(mask) != net.IPv6len {
+		return []byte{0xff}, []byte{} // no address in range
+	}
+
+	first, last := make([]byte, net.IPv6len), make([]byte, net.IPv6len)
+	for i := range ip {
+		first[i] = ip[i] & mask[i]
+		last[i] = first[i] | ^mask[i]
+	}
+	return first, last
+}
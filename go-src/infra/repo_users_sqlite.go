@@ -0,0 +1,143 @@
+package infra
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	// sqliteDSNParams are the connection parameters: waiting for the locks instead of failing right away,
+	// and write-ahead logging, so the reads are not blocked by the writes
+	sqliteDSNParams = "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+)
+
+// usersSQLiteMigrations are the database schema migrations, in order: the schema version (user_version pragma)
+// is the number of applied migrations, the pending ones are applied on startup (all at once, in a transaction)
+var usersSQLiteMigrations = []string{
+	// seq keeps the data order (insertion order, as the other repos), the *_key columns are the users sort keys
+	// (see lib.UserSortKey) and the email domain, so the filters and sorting are done by the database
+	`CREATE TABLE users (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		id TEXT NOT NULL UNIQUE,
+		first_name TEXT NOT NULL,
+		last_name TEXT NOT NULL,
+		email TEXT NOT NULL,
+		password TEXT NOT NULL,
+		ip_address TEXT NOT NULL,
+		creation_date TEXT NOT NULL,
+		deleted_at TEXT,
+		first_name_key TEXT NOT NULL,
+		last_name_key TEXT NOT NULL,
+		email_key TEXT NOT NULL,
+		email_domain_key TEXT NOT NULL,
+		ip_address_key BLOB NOT NULL,
+		creation_date_key TEXT NOT NULL
+	);
+	CREATE INDEX users_email_key ON users (email_key);
+	CREATE INDEX users_creation_date_key ON users (creation_date_key);`,
+	// the emails are unique (case-insensitive), so the concurrent writes cannot take the same email
+	`DROP INDEX users_email_key;
+	CREATE UNIQUE INDEX users_email_key ON users (email_key);`,
+	// the version (see lib.UserVersion) is checked by the conditional updates, it's filled for the existing users
+	// (see sqlUsersVersionMigration)
+	`ALTER TABLE users ADD COLUMN version TEXT NOT NULL DEFAULT '';`,
+	// the users history and audit trail, next to the users (seq keeps their order, the revisions are numbered when read)
+	`CREATE TABLE users_revisions (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		made_at TEXT NOT NULL,
+		changes TEXT NOT NULL
+	);
+	CREATE INDEX users_revisions_user_id ON users_revisions (user_id, seq);
+	CREATE TABLE users_audit (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		made_at TEXT NOT NULL
+	);
+	CREATE INDEX users_audit_user_id ON users_audit (user_id, seq);`,
+}
+
+// sqliteDialect is the SQLite dialect of the users SQL repo
+var sqliteDialect = sqlDialect{isUniqueViolation: isSQLiteUniqueViolation}
+
+// usersSQLiteRepo is a users repo backed by an SQLite database (pure-Go driver)
+type usersSQLiteRepo struct {
+	*usersSQLRepo
+}
+
+// NewUsersSQLiteRepo creates a new users repo backed by the SQLite database file received as parameter (created if it doesn't exist),
+// applying the pending schema migrations, the database is filled with the users got from the seed function (optional)
+// when its schema is created
+func NewUsersSQLiteRepo(filePath string, seed func() ([]lib.User, error)) (*usersSQLiteRepo, error) {
+	db, err := sql.Open("sqlite", filePath+sqliteDSNParams)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrateSQLite(context.Background(), db, seed)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	repo, err := newUsersSQLRepo(context.Background(), db, sqliteDialect)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &usersSQLiteRepo{usersSQLRepo: repo}, nil
+}
+
+// migrateSQLite applies the pending schema migrations, the seed users are inserted along with the schema creation
+func migrateSQLite(ctx context.Context, db *sql.DB, seed func() ([]lib.User, error)) error {
+	var version int
+	err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > len(usersSQLiteMigrations) {
+		return fmt.Errorf("users database schema version %d is newer than the supported one (%d)", version, len(usersSQLiteMigrations))
+	}
+	if version == len(usersSQLiteMigrations) {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = migrateSQLUsers(ctx, tx, sqliteDialect, usersSQLiteMigrations, version, seed)
+	if err != nil {
+		return err
+	}
+
+	// the pragma doesn't accept bound variables
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", len(usersSQLiteMigrations)))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isSQLiteUniqueViolation checks if the error is a unique constraint violation
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
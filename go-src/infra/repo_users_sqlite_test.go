@@ -0,0 +1,377 @@
+package infra
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestUsersSQLiteRepo creates a users SQLite repo in a temporary database, seeded with the users data
+func newTestUsersSQLiteRepo(t *testing.T, usersData []lib.User) *usersSQLiteRepo {
+	repo, err := NewUsersSQLiteRepo(filepath.Join(t.TempDir(), "users.db"), func() ([]lib.User, error) {
+		return usersData, nil
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestNewUsersSQLiteRepo(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "users.db")
+	ctx := context.Background()
+
+	seeded := 0
+	seed := func() ([]lib.User, error) {
+		seeded++
+		return testUsersData, nil
+	}
+
+	repo, err := NewUsersSQLiteRepo(filePath, seed)
+	require.NoError(t, err)
+
+	err = repo.DeleteUser(ctx, testUsersData[0].ID)
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	// the schema is already up to date, so the deleted user is not seeded again
+	repo, err = NewUsersSQLiteRepo(filePath, seed)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	assert.Equal(t, 1, seeded)
+
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, lib.UsersPage{Users: testUsersData[1:], Total: 2}, page)
+
+	var version int
+	err = repo.db.QueryRow("PRAGMA user_version").Scan(&version)
+	assert.NoError(t, err)
+	assert.Equal(t, len(usersSQLiteMigrations), version)
+
+	// the email (unique) and creation date are indexed
+	var indexes []string
+	rows, err := repo.db.Query("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'users' AND sql IS NOT NULL ORDER BY name")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		indexes = append(indexes, name)
+	}
+	assert.Equal(t, []string{"users_creation_date_key", "users_email_key"}, indexes)
+}
+
+func TestNewUsersSQLiteRepoVersionMigration(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "users.db")
+	ctx := context.Background()
+
+	repo, err := NewUsersSQLiteRepo(filePath, func() ([]lib.User, error) {
+		return testUsersData, nil
+	})
+	require.NoError(t, err)
+
+	// going back to the schema without the users versions (and the tables added after them)
+	_, err = repo.db.Exec("DROP TABLE users_revisions; DROP TABLE users_audit; ALTER TABLE users DROP COLUMN version")
+	require.NoError(t, err)
+	_, err = repo.db.Exec("PRAGMA user_version = 2")
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	repo, err = NewUsersSQLiteRepo(filePath, nil)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	// the existing users versions are filled, so their conditional updates are applied
+	for _, user := range testUsersData {
+		var version string
+		err = repo.db.QueryRow("SELECT version FROM users WHERE id = ?", user.ID).Scan(&version)
+		assert.NoError(t, err)
+		assert.Equal(t, lib.UserVersion(user), version)
+	}
+
+	updatedUser := testUsersData[0]
+	updatedUser.FirstName = "Nick"
+	_, err = repo.UpdateUser(ctx, updatedUser, lib.UserVersion(testUsersData[0]))
+	assert.NoError(t, err)
+}
+
+func TestNewUsersSQLiteRepoNewerSchema(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "users.db")
+
+	db, err := sql.Open("sqlite", filePath)
+	require.NoError(t, err)
+	_, err = db.Exec("PRAGMA user_version = 99")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = NewUsersSQLiteRepo(filePath, nil)
+	assert.EqualError(t, err, "users database schema version 99 is newer than the supported one (4)")
+}
+
+func TestUsersSQLiteRepoGetUsers(t *testing.T) {
+	deletedAt := time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC)
+	deletedUser := testUsersData[1]
+	deletedUser.DeletedAt = &deletedAt
+	usersData := []lib.User{
+		testUsersData[0],
+		deletedUser,
+		testUsersData[2],
+		{ID: "40d1b4ad-6b1b-4b37-8b7e-2c3c1c3c7f0e", FirstName: "nicky", LastName: "Zed", Email: "NZ@Jiathis.com", IPAddress: "2001:db8::1"},
+		{ID: "0a64ef1e-3b67-4a0b-9c4e-7a2a5e3c2a11", FirstName: "Ana", LastName: "Blasio", Email: "ana@phoca.cz", IPAddress: "43.113.46.200",
+			CreationDate: time.Date(2021, time.June, 6, 0, 0, 0, 0, time.UTC)},
+	}
+
+	_, ipNet, _ := net.ParseCIDR("43.113.46.0/24")
+
+	queries := map[string]lib.UsersQuery{
+		"all data":            {Limit: 10},
+		"all data - deleted":  {Limit: 10, Filter: lib.UsersFilter{IncludeDeleted: true}},
+		"offset":              {Limit: 2, Offset: 1},
+		"offset out of range": {Limit: 2, Offset: 10},
+		"no limit":            {Limit: 0},
+		"first name":          {Limit: 10, Filter: lib.UsersFilter{FirstName: &lib.StringFilter{Value: "NICKY"}}},
+		"first name prefix":   {Limit: 10, Filter: lib.UsersFilter{FirstName: &lib.StringFilter{Value: "ni", Prefix: true}}},
+		"last name":           {Limit: 10, Filter: lib.UsersFilter{LastName: &lib.StringFilter{Value: "blasio"}}},
+		"email domain":        {Limit: 10, Filter: lib.UsersFilter{EmailDomain: "JIATHIS.com"}},
+		"ip network":          {Limit: 10, Filter: lib.UsersFilter{IPNet: ipNet}},
+		"created between": {Limit: 10, Filter: lib.UsersFilter{
+			CreatedAfter:  time.Date(2021, time.January, 19, 0, 0, 0, 0, time.UTC),
+			CreatedBefore: time.Date(2021, time.June, 7, 0, 0, 0, 0, time.UTC),
+		}},
+		"sorted by last name": {Limit: 10, Sort: []lib.SortField{{Field: "last_name"}}},
+		"sorted by ip and creation date desc": {Limit: 10, Filter: lib.UsersFilter{IncludeDeleted: true},
+			Sort: []lib.SortField{{Field: "ip_address"}, {Field: "creation_date", Descending: true}}},
+		"sorted by first name desc - offset": {Limit: 2, Offset: 1, Sort: []lib.SortField{{Field: "first_name", Descending: true}}},
+	}
+
+	ctx := context.Background()
+	memoryRepo := NewUsersRepo(usersData)
+	sqliteRepo := newTestUsersSQLiteRepo(t, usersData)
+
+	for name, query := range queries {
+		t.Run(name, func(t *testing.T) {
+			expectedPage, err := memoryRepo.GetUsers(ctx, query)
+			require.NoError(t, err)
+
+			page, err := sqliteRepo.GetUsers(ctx, query)
+			assert.NoError(t, err)
+			assert.Equal(t, expectedPage, page)
+		})
+	}
+
+	t.Run("negative limit", func(t *testing.T) {
+		_, err := sqliteRepo.GetUsers(ctx, lib.UsersQuery{Limit: -1})
+		assert.ErrorIs(t, err, lib.ErrPreconditionFailed)
+	})
+}
+
+func TestUsersSQLiteRepoGetUsersCursor(t *testing.T) {
+	usersData := append([]lib.User{
+		{ID: "40d1b4ad-6b1b-4b37-8b7e-2c3c1c3c7f0e", FirstName: "Nicky", LastName: "Zed", Email: "nz@jiathis.com", IPAddress: "43.113.46.36"},
+	}, testUsersData...)
+
+	ctx := context.Background()
+	memoryRepo := NewUsersRepo(usersData)
+	sqliteRepo := newTestUsersSQLiteRepo(t, usersData)
+
+	for _, sort := range [][]lib.SortField{
+		nil,
+		{{Field: "first_name"}},
+		{{Field: "ip_address", Descending: true}},
+		{{Field: "first_name", Descending: true}, {Field: "creation_date"}},
+	} {
+		// walking all the pages, both repos must return the same pages and cursors
+		expectedCursor, cursor := &lib.UsersCursor{Sort: sort}, &lib.UsersCursor{Sort: sort}
+		for expectedCursor != nil {
+			expectedPage, err := memoryRepo.GetUsers(ctx, lib.UsersQuery{Limit: 1, Cursor: expectedCursor, Sort: sort})
+			require.NoError(t, err)
+
+			page, err := sqliteRepo.GetUsers(ctx, lib.UsersQuery{Limit: 1, Cursor: cursor, Sort: sort})
+			require.NoError(t, err)
+			assert.Equal(t, expectedPage, page)
+
+			expectedCursor, cursor = expectedPage.NextCursor, page.NextCursor
+		}
+		assert.Nil(t, cursor)
+	}
+}
+
+func TestUsersSQLiteRepoReads(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestUsersSQLiteRepo(t, testUsersData)
+
+	user, err := repo.GetUser(ctx, testUsersData[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, testUsersData[1], user)
+
+	_, err = repo.GetUser(ctx, "unknown_id")
+	assert.Equal(t, lib.ErrNotFound, err)
+
+	user, err = repo.GetUserByEmail(ctx, "TTrillow1@Feedburner.com")
+	assert.NoError(t, err)
+	assert.Equal(t, testUsersData[1], user)
+
+	_, err = repo.GetUserByEmail(ctx, "unknown@feedburner.com")
+	assert.Equal(t, lib.ErrNotFound, err)
+
+	batch, err := repo.GetUsersByIDs(ctx, []string{testUsersData[2].ID, "unknown_id", testUsersData[0].ID})
+	assert.NoError(t, err)
+	assert.Equal(t, lib.UsersBatch{
+		Users:      []lib.User{testUsersData[2], testUsersData[0]},
+		MissingIDs: []string{"unknown_id"},
+	}, batch)
+}
+
+func TestUsersSQLiteRepoWrites(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestUsersSQLiteRepo(t, testUsersData[:2])
+
+	newUser := testUsersData[2]
+	createdUser, err := repo.CreateUser(ctx, newUser)
+	assert.NoError(t, err)
+	assert.Equal(t, newUser, createdUser)
+
+	_, err = repo.CreateUser(ctx, newUser)
+	assert.EqualError(t, err, "user 3e601207-0e80-4e7e-ae87-bb802b16a179 or its email already exists: conflict")
+
+	// the emails are unique (case-insensitive)
+	sameEmailUser := lib.User{ID: "40d1b4ad-6b1b-4b37-8b7e-2c3c1c3c7f0e", Email: "TTrillow1@Feedburner.com"}
+	_, err = repo.CreateUser(ctx, sameEmailUser)
+	assert.ErrorIs(t, err, lib.ErrConflict)
+
+	sameEmailUser = testUsersData[0]
+	sameEmailUser.Email = testUsersData[1].Email
+	_, err = repo.UpdateUser(ctx, sameEmailUser, "")
+	assert.EqualError(t, err, "user 144bf891-f161-4c9a-8d83-38a275e088a5 email already exists: conflict")
+
+	// the updated user keeps its position
+	updatedUser := testUsersData[0]
+	updatedUser.FirstName = "Nick"
+	_, err = repo.UpdateUser(ctx, updatedUser, "")
+	assert.NoError(t, err)
+
+	_, err = repo.UpdateUser(ctx, lib.User{ID: "unknown_id"}, "")
+	assert.Equal(t, lib.ErrNotFound, err)
+
+	err = repo.DeleteUser(ctx, testUsersData[1].ID)
+	assert.NoError(t, err)
+
+	err = repo.DeleteUser(ctx, testUsersData[1].ID)
+	assert.Equal(t, lib.ErrNotFound, err)
+
+	// the replaced users keep their positions and the new ones are added to the end (the last write wins)
+	replacedUser := testUsersData[2]
+	replacedUser.LastName = "Paik"
+	err = repo.UpsertUsers(ctx, []lib.User{testUsersData[1], testUsersData[2], replacedUser})
+	assert.NoError(t, err)
+
+	// the upserted users emails are unique too, nothing is written if one is already used
+	sameEmailUser = lib.User{ID: "40d1b4ad-6b1b-4b37-8b7e-2c3c1c3c7f0e", Email: "TTrillow1@Feedburner.com"}
+	err = repo.UpsertUsers(ctx, []lib.User{conflictFreeUser(), sameEmailUser})
+	assert.EqualError(t, err, "user 40d1b4ad-6b1b-4b37-8b7e-2c3c1c3c7f0e email already exists: conflict")
+
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{updatedUser, replacedUser, testUsersData[1]}, page.Users)
+
+	// the search index follows the writes
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "paik", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{replacedUser}, page.Users)
+
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "macpaik", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{}, page.Users)
+}
+
+// conflictFreeUser gets a user whose ID and email are not used by the test users
+func conflictFreeUser() lib.User {
+	return lib.User{ID: "0a64ef1e-3b67-4a0b-9c4e-7a2a5e3c2a11", FirstName: "Ana", LastName: "Blasio", Email: "ana@phoca.cz"}
+}
+
+func TestUsersSQLiteRepoUserWithoutIPAddress(t *testing.T) {
+	ctx := context.Background()
+
+	seededUser := testUsersData[0]
+	seededUser.IPAddress = ""
+	repo := newTestUsersSQLiteRepo(t, []lib.User{seededUser, testUsersData[1]})
+
+	newUser := testUsersData[2]
+	newUser.IPAddress = ""
+	createdUser, err := repo.CreateUser(ctx, newUser)
+	assert.NoError(t, err)
+	assert.Equal(t, newUser, createdUser)
+
+	// the missing IP addresses sort first, also across the cursor pages
+	sort := []lib.SortField{{Field: "ip_address"}}
+	var users []lib.User
+	for cursor := (&lib.UsersCursor{Sort: sort}); cursor != nil; {
+		page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 1, Cursor: cursor, Sort: sort})
+		require.NoError(t, err)
+		users = append(users, page.Users...)
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, []lib.User{seededUser, newUser, testUsersData[1]}, users)
+}
+
+func TestUsersSQLiteRepoSearchUsers(t *testing.T) {
+	deletedAt := time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC)
+	deletedUser := testUsersData[1]
+	deletedUser.DeletedAt = &deletedAt
+	usersData := []lib.User{testUsersData[0], deletedUser, testUsersData[2]}
+
+	ctx := context.Background()
+	memoryRepo := NewUsersRepo(usersData)
+	sqliteRepo := newTestUsersSQLiteRepo(t, usersData)
+
+	for _, query := range []lib.UsersSearchQuery{
+		{Text: "ni", Limit: 10},
+		{Text: "ni", Limit: 1, Offset: 1},
+		{Text: "trillow", Limit: 10},
+		{Text: "blasoi", Limit: 10},
+		{Text: "", Limit: 10},
+	} {
+		expectedPage, err := memoryRepo.SearchUsers(ctx, query)
+		require.NoError(t, err)
+
+		page, err := sqliteRepo.SearchUsers(ctx, query)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedPage, page, query.Text)
+	}
+}
+
+func TestUsersSQLiteRepoCanceledContext(t *testing.T) {
+	repo := newTestUsersSQLiteRepo(t, testUsersData)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 10})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = repo.CreateUser(ctx, lib.User{ID: "40d1b4ad-6b1b-4b37-8b7e-2c3c1c3c7f0e"})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = repo.GetUser(context.Background(), "40d1b4ad-6b1b-4b37-8b7e-2c3c1c3c7f0e")
+	assert.Equal(t, lib.ErrNotFound, err)
+}
+
+func TestIPNetRange(t *testing.T) {
+	_, ipv4Net, _ := net.ParseCIDR("43.113.46.0/24")
+	first, last := ipNetRange(ipv4Net)
+	assert.Equal(t, []byte(net.ParseIP("43.113.46.0").To16()), first)
+	assert.Equal(t, []byte(net.ParseIP("43.113.46.255").To16()), last)
+
+	_, ipv6Net, _ := net.ParseCIDR("2001:db8::/32")
+	first, last = ipNetRange(ipv6Net)
+	assert.Equal(t, []byte(net.ParseIP("2001:db8::").To16()), first)
+	assert.Equal(t, []byte(net.ParseIP("2001:db8:ffff:ffff:ffff:ffff:ffff:ffff").To16()), last)
+}
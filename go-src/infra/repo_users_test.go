@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/hbernardo/users/go-src/lib"
 	"github.com/stretchr/testify/assert"
@@ -17,7 +18,7 @@ var testUsersData = []lib.User{
 		Email:        "nblasio0@jiathis.com",
 		Password:     "rKJKin",
 		IPAddress:    "43.113.46.36",
-		CreationDate: "06/06/2021",
+		CreationDate: time.Date(2021, time.June, 6, 0, 0, 0, 0, time.UTC),
 	},
 	{
 		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
@@ -26,7 +27,7 @@ var testUsersData = []lib.User{
 		Email:        "ttrillow1@feedburner.com",
 		Password:     "5YLItbmdkfC1",
 		IPAddress:    "63.119.6.98",
-		CreationDate: "19/04/2021",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
 	},
 	{
 		ID:           "3e601207-0e80-4e7e-ae87-bb802b16a179",
@@ -35,17 +36,25 @@ var testUsersData = []lib.User{
 		Email:        "nmacpaik2@phoca.cz",
 		Password:     "Vae1mnI",
 		IPAddress:    "94.47.183.190",
-		CreationDate: "19/01/2021",
+		CreationDate: time.Date(2021, time.January, 19, 0, 0, 0, 0, time.UTC),
 	},
 }
 
 func TestGetUsers(t *testing.T) {
+	deletedAt := time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC)
+	deletedUser := testUsersData[1]
+	deletedUser.DeletedAt = &deletedAt
+	usersDataWithDeleted := []lib.User{testUsersData[0], deletedUser, testUsersData[2]}
+
 	testCases := []struct {
 		name          string
 		usersData     []lib.User
 		limit         int
 		offset        int
+		filter        lib.UsersFilter
+		sort          []lib.SortField
 		expectedUsers []lib.User
+		expectedTotal int
 		expectedError error
 	}{
 		{
@@ -54,6 +63,7 @@ func TestGetUsers(t *testing.T) {
 			limit:         3,
 			offset:        0,
 			expectedUsers: testUsersData,
+			expectedTotal: 3,
 			expectedError: nil,
 		},
 		{
@@ -69,7 +79,7 @@ func TestGetUsers(t *testing.T) {
 					Email:        "nblasio0@jiathis.com",
 					Password:     "rKJKin",
 					IPAddress:    "43.113.46.36",
-					CreationDate: "06/06/2021",
+					CreationDate: time.Date(2021, time.June, 6, 0, 0, 0, 0, time.UTC),
 				},
 				{
 					ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
@@ -78,9 +88,10 @@ func TestGetUsers(t *testing.T) {
 					Email:        "ttrillow1@feedburner.com",
 					Password:     "5YLItbmdkfC1",
 					IPAddress:    "63.119.6.98",
-					CreationDate: "19/04/2021",
+					CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
 				},
 			},
+			expectedTotal: 3,
 			expectedError: nil,
 		},
 		{
@@ -96,7 +107,7 @@ func TestGetUsers(t *testing.T) {
 					Email:        "ttrillow1@feedburner.com",
 					Password:     "5YLItbmdkfC1",
 					IPAddress:    "63.119.6.98",
-					CreationDate: "19/04/2021",
+					CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
 				},
 				{
 					ID:           "3e601207-0e80-4e7e-ae87-bb802b16a179",
@@ -105,9 +116,10 @@ func TestGetUsers(t *testing.T) {
 					Email:        "nmacpaik2@phoca.cz",
 					Password:     "Vae1mnI",
 					IPAddress:    "94.47.183.190",
-					CreationDate: "19/01/2021",
+					CreationDate: time.Date(2021, time.January, 19, 0, 0, 0, 0, time.UTC),
 				},
 			},
+			expectedTotal: 3,
 			expectedError: nil,
 		},
 		{
@@ -123,9 +135,10 @@ func TestGetUsers(t *testing.T) {
 					Email:        "nmacpaik2@phoca.cz",
 					Password:     "Vae1mnI",
 					IPAddress:    "94.47.183.190",
-					CreationDate: "19/01/2021",
+					CreationDate: time.Date(2021, time.January, 19, 0, 0, 0, 0, time.UTC),
 				},
 			},
+			expectedTotal: 3,
 			expectedError: nil,
 		},
 		{
@@ -134,6 +147,77 @@ func TestGetUsers(t *testing.T) {
 			limit:         10,
 			offset:        4,
 			expectedUsers: []lib.User{},
+			expectedTotal: 3,
+			expectedError: nil,
+		},
+		{
+			name:          "filtered",
+			usersData:     testUsersData,
+			limit:         10,
+			offset:        0,
+			filter:        lib.UsersFilter{FirstName: &lib.StringFilter{Value: "ni", Prefix: true}},
+			expectedUsers: []lib.User{testUsersData[0], testUsersData[2]},
+			expectedTotal: 2,
+			expectedError: nil,
+		},
+		{
+			name:          "filtered - limit and offset over the matching users",
+			usersData:     testUsersData,
+			limit:         1,
+			offset:        1,
+			filter:        lib.UsersFilter{FirstName: &lib.StringFilter{Value: "ni", Prefix: true}},
+			expectedUsers: []lib.User{testUsersData[2]},
+			expectedTotal: 2,
+			expectedError: nil,
+		},
+		{
+			name:          "filtered - no matching users",
+			usersData:     testUsersData,
+			limit:         10,
+			offset:        0,
+			filter:        lib.UsersFilter{EmailDomain: "unknown.com"},
+			expectedUsers: []lib.User{},
+			expectedTotal: 0,
+			expectedError: nil,
+		},
+		{
+			name:          "sorted",
+			usersData:     testUsersData,
+			limit:         10,
+			offset:        0,
+			sort:          []lib.SortField{{Field: "creation_date"}},
+			expectedUsers: []lib.User{testUsersData[2], testUsersData[1], testUsersData[0]},
+			expectedTotal: 3,
+			expectedError: nil,
+		},
+		{
+			name:          "filtered and sorted - pagination over the sorted users",
+			usersData:     testUsersData,
+			limit:         1,
+			offset:        1,
+			filter:        lib.UsersFilter{FirstName: &lib.StringFilter{Value: "ni", Prefix: true}},
+			sort:          []lib.SortField{{Field: "last_name", Descending: true}},
+			expectedUsers: []lib.User{testUsersData[0]},
+			expectedTotal: 2,
+			expectedError: nil,
+		},
+		{
+			name:          "deleted users excluded",
+			usersData:     usersDataWithDeleted,
+			limit:         3,
+			offset:        0,
+			expectedUsers: []lib.User{testUsersData[0], testUsersData[2]},
+			expectedTotal: 2,
+			expectedError: nil,
+		},
+		{
+			name:          "deleted users included",
+			usersData:     usersDataWithDeleted,
+			limit:         3,
+			offset:        0,
+			filter:        lib.UsersFilter{IncludeDeleted: true},
+			expectedUsers: usersDataWithDeleted,
+			expectedTotal: 3,
 			expectedError: nil,
 		},
 		{
@@ -142,6 +226,7 @@ func TestGetUsers(t *testing.T) {
 			limit:         -2,
 			offset:        0,
 			expectedUsers: nil,
+			expectedTotal: 0,
 			expectedError: fmt.Errorf("'limit' nor 'offset' cannot be negative: %w", lib.ErrPreconditionFailed),
 		},
 		{
@@ -150,6 +235,7 @@ func TestGetUsers(t *testing.T) {
 			limit:         1,
 			offset:        -2,
 			expectedUsers: nil,
+			expectedTotal: 0,
 			expectedError: fmt.Errorf("'limit' nor 'offset' cannot be negative: %w", lib.ErrPreconditionFailed),
 		},
 	}
@@ -158,14 +244,235 @@ func TestGetUsers(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			repo := NewUsersRepo(tc.usersData)
 
-			users, err := repo.GetUsers(context.Background(), tc.limit, tc.offset)
+			page, err := repo.GetUsers(context.Background(), lib.UsersQuery{
+				Limit:  tc.limit,
+				Offset: tc.offset,
+				Filter: tc.filter,
+				Sort:   tc.sort,
+			})
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedUsers, page.Users)
+			assert.Equal(t, tc.expectedTotal, page.Total)
+			assert.Nil(t, page.NextCursor)
+		})
+	}
+}
+
+func TestGetUsersCursor(t *testing.T) {
+	ctx := context.Background()
+	sort := []lib.SortField{{Field: "first_name"}}
+
+	repo := NewUsersRepo(testUsersData)
+
+	// first page
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 1, Cursor: &lib.UsersCursor{Sort: sort}, Sort: sort})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{testUsersData[0]}, page.Users) // Nicky
+	assert.NotNil(t, page.NextCursor)
+
+	// a user inserted before the cursor position is neither returned nor shifts the next pages
+	_, err = repo.CreateUser(ctx, lib.User{ID: "f3f1612d-8239-4933-9891-71b5ee127844", FirstName: "Amie"})
+	assert.NoError(t, err)
+
+	page, err = repo.GetUsers(ctx, lib.UsersQuery{Limit: 1, Cursor: page.NextCursor, Sort: sort})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{testUsersData[2]}, page.Users) // Niels
+	assert.NotNil(t, page.NextCursor)
+
+	// last page
+	page, err = repo.GetUsers(ctx, lib.UsersQuery{Limit: 1, Cursor: page.NextCursor, Sort: sort})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{testUsersData[1]}, page.Users) // Terrence
+	assert.Nil(t, page.NextCursor)
+
+	// no sorting - ordered by ID, filtered
+	page, err = repo.GetUsers(ctx, lib.UsersQuery{
+		Limit:  10,
+		Cursor: &lib.UsersCursor{},
+		Filter: lib.UsersFilter{FirstName: &lib.StringFilter{Value: "ni", Prefix: true}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{testUsersData[0], testUsersData[2]}, page.Users)
+	assert.Nil(t, page.NextCursor)
+}
+
+func TestGetUsersSnapshot(t *testing.T) {
+	ctx := context.Background()
+	repo := NewUsersRepo(testUsersData)
+
+	users, err := repo.GetUsersSnapshot(ctx, lib.UsersFilter{}, []lib.SortField{{Field: "first_name"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{testUsersData[0], testUsersData[2], testUsersData[1]}, users)
+
+	// no sorting - ordered by ID, filtered
+	users, err = repo.GetUsersSnapshot(ctx, lib.UsersFilter{FirstName: &lib.StringFilter{Value: "ni", Prefix: true}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{testUsersData[0], testUsersData[2]}, users)
+
+	// the snapshot is a copy
+	users[0].FirstName = "Nick"
+	user, err := repo.GetUser(ctx, testUsersData[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, testUsersData[0], user)
+}
+
+func TestGetUsersByIDs(t *testing.T) {
+	testCases := []struct {
+		name          string
+		userIDs       []string
+		expectedBatch lib.UsersBatch
+	}{
+		{
+			name:    "requested order",
+			userIDs: []string{testUsersData[2].ID, testUsersData[0].ID},
+			expectedBatch: lib.UsersBatch{
+				Users:      []lib.User{testUsersData[2], testUsersData[0]},
+				MissingIDs: []string{},
+			},
+		},
+		{
+			name:    "missing IDs",
+			userIDs: []string{"unknown-1", testUsersData[1].ID, "unknown-2"},
+			expectedBatch: lib.UsersBatch{
+				Users:      []lib.User{testUsersData[1]},
+				MissingIDs: []string{"unknown-1", "unknown-2"},
+			},
+		},
+		{
+			name:    "none found",
+			userIDs: []string{"unknown"},
+			expectedBatch: lib.UsersBatch{
+				Users:      []lib.User{},
+				MissingIDs: []string{"unknown"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := NewUsersRepo(testUsersData)
+
+			batch, err := repo.GetUsersByIDs(context.Background(), tc.userIDs)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedBatch, batch)
+		})
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	testCases := []struct {
+		name          string
+		query         lib.UsersSearchQuery
+		expectedUsers []lib.User
+		expectedTotal int
+		expectedError error
+	}{
+		{
+			name:          "same relevance - data order",
+			query:         lib.UsersSearchQuery{Text: "ni", Limit: 10},
+			expectedUsers: []lib.User{testUsersData[0], testUsersData[2]},
+			expectedTotal: 2,
+			expectedError: nil,
+		},
+		{
+			name:          "exact match",
+			query:         lib.UsersSearchQuery{Text: "niels", Limit: 10},
+			expectedUsers: []lib.User{testUsersData[2]},
+			expectedTotal: 1,
+			expectedError: nil,
+		},
+		{
+			name:          "paginated",
+			query:         lib.UsersSearchQuery{Text: "ni", Limit: 1, Offset: 1},
+			expectedUsers: []lib.User{testUsersData[2]},
+			expectedTotal: 2,
+			expectedError: nil,
+		},
+		{
+			name:          "offset greater than the hits",
+			query:         lib.UsersSearchQuery{Text: "ni", Limit: 1, Offset: 5},
+			expectedUsers: []lib.User{},
+			expectedTotal: 2,
+			expectedError: nil,
+		},
+		{
+			name:          "typo",
+			query:         lib.UsersSearchQuery{Text: "terence trilow", Limit: 10},
+			expectedUsers: []lib.User{testUsersData[1]},
+			expectedTotal: 1,
+			expectedError: nil,
+		},
+		{
+			name:          "error - invalid limit",
+			query:         lib.UsersSearchQuery{Text: "ni", Limit: -1},
+			expectedUsers: nil,
+			expectedTotal: 0,
+			expectedError: fmt.Errorf("'limit' nor 'offset' cannot be negative: %w", lib.ErrPreconditionFailed),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := NewUsersRepo(testUsersData)
+
+			page, err := repo.SearchUsers(context.Background(), tc.query)
 
 			assert.Equal(t, tc.expectedError, err)
-			assert.Equal(t, tc.expectedUsers, users)
+			assert.Equal(t, tc.expectedUsers, page.Users)
+			assert.Equal(t, tc.expectedTotal, page.Total)
 		})
 	}
 }
 
+func TestSearchUsersAfterWrites(t *testing.T) {
+	ctx := context.Background()
+	repo := NewUsersRepo(testUsersData)
+
+	newUser := lib.User{ID: "f3f1612d-8239-4933-9891-71b5ee127844", FirstName: "Amie", LastName: "Blasio", Email: "amie@phoca.cz"}
+	_, err := repo.CreateUser(ctx, newUser)
+	assert.NoError(t, err)
+
+	page, err := repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "blasio", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{testUsersData[0], newUser}, page.Users)
+
+	updatedUser := testUsersData[0]
+	updatedUser.LastName = "Trillow"
+	_, err = repo.UpdateUser(ctx, updatedUser, "")
+	assert.NoError(t, err)
+
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "blasio", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{newUser}, page.Users)
+
+	err = repo.DeleteUser(ctx, testUsersData[1].ID)
+	assert.NoError(t, err)
+
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "trillow", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{updatedUser}, page.Users)
+
+	// soft deleted users are not searchable until restored
+	deletedAt := time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC)
+	deletedUser := newUser
+	deletedUser.DeletedAt = &deletedAt
+	_, err = repo.UpdateUser(ctx, deletedUser, "")
+	assert.NoError(t, err)
+
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "blasio", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{}, page.Users)
+
+	_, err = repo.UpdateUser(ctx, newUser, "")
+	assert.NoError(t, err)
+
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "blasio", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{newUser}, page.Users)
+}
+
 func TestGetUser(t *testing.T) {
 	testCases := []struct {
 		name          string
@@ -185,7 +492,7 @@ func TestGetUser(t *testing.T) {
 				Email:        "ttrillow1@feedburner.com",
 				Password:     "5YLItbmdkfC1",
 				IPAddress:    "63.119.6.98",
-				CreationDate: "19/04/2021",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
 			},
 			expectedError: nil,
 		},
@@ -209,3 +516,318 @@ func TestGetUser(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateUser(t *testing.T) {
+	newUser := lib.User{
+		ID:           "f3f1612d-8239-4933-9891-71b5ee127844",
+		FirstName:    "Loralie",
+		LastName:     "Yeoland",
+		Email:        "lyeoland3@ucla.edu",
+		Password:     "2kyEOSV3",
+		IPAddress:    "105.22.43.36",
+		CreationDate: time.Date(2021, time.December, 24, 0, 0, 0, 0, time.UTC),
+	}
+
+	testCases := []struct {
+		name          string
+		usersData     []lib.User
+		user          lib.User
+		expectedUser  lib.User
+		expectedError error
+		expectedData  []lib.User
+	}{
+		{
+			name:          "base case",
+			usersData:     testUsersData,
+			user:          newUser,
+			expectedUser:  newUser,
+			expectedError: nil,
+			expectedData:  append(append([]lib.User(nil), testUsersData...), newUser),
+		},
+		{
+			name:          "already exists",
+			usersData:     testUsersData,
+			user:          testUsersData[1],
+			expectedUser:  lib.User{},
+			expectedError: fmt.Errorf("user 1311f914-1d4f-40b6-8886-80193265d5a4 already exists: %w", lib.ErrConflict),
+			expectedData:  testUsersData,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := NewUsersRepo(tc.usersData)
+
+			user, err := repo.CreateUser(context.Background(), tc.user)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedUser, user)
+
+			page, _ := repo.GetUsers(context.Background(), lib.UsersQuery{Limit: len(tc.usersData) + 1, Offset: 0})
+			users := page.Users
+			assert.Equal(t, tc.expectedData, users)
+
+			if tc.expectedError == nil {
+				createdUser, err := repo.GetUser(context.Background(), tc.user.ID)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedUser, createdUser)
+			}
+		})
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	updatedUser := lib.User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terry",
+		LastName:     "Trillow",
+		Email:        "terry@feedburner.com",
+		Password:     "5YLItbmdkfC1",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+
+	testCases := []struct {
+		name          string
+		usersData     []lib.User
+		user          lib.User
+		expectedUser  lib.User
+		expectedError error
+		expectedData  []lib.User
+	}{
+		{
+			name:          "base case",
+			usersData:     testUsersData,
+			user:          updatedUser,
+			expectedUser:  updatedUser,
+			expectedError: nil,
+			expectedData:  []lib.User{testUsersData[0], updatedUser, testUsersData[2]},
+		},
+		{
+			name:          "not found",
+			usersData:     testUsersData,
+			user:          lib.User{ID: "unknown_id"},
+			expectedUser:  lib.User{},
+			expectedError: lib.ErrNotFound,
+			expectedData:  testUsersData,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := NewUsersRepo(tc.usersData)
+
+			user, err := repo.UpdateUser(context.Background(), tc.user, "")
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedUser, user)
+
+			page, _ := repo.GetUsers(context.Background(), lib.UsersQuery{Limit: len(tc.usersData), Offset: 0})
+			users := page.Users
+			assert.Equal(t, tc.expectedData, users)
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	testCases := []struct {
+		name          string
+		usersData     []lib.User
+		userID        string
+		expectedError error
+		expectedData  []lib.User
+	}{
+		{
+			name:          "base case",
+			usersData:     testUsersData,
+			userID:        "144bf891-f161-4c9a-8d83-38a275e088a5",
+			expectedError: nil,
+			expectedData:  []lib.User{testUsersData[1], testUsersData[2]},
+		},
+		{
+			name:          "not found",
+			usersData:     testUsersData,
+			userID:        "unknown_id",
+			expectedError: lib.ErrNotFound,
+			expectedData:  testUsersData,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := NewUsersRepo(tc.usersData)
+
+			err := repo.DeleteUser(context.Background(), tc.userID)
+
+			assert.Equal(t, tc.expectedError, err)
+
+			page, _ := repo.GetUsers(context.Background(), lib.UsersQuery{Limit: len(tc.usersData), Offset: 0})
+			users := page.Users
+			assert.Equal(t, tc.expectedData, users)
+
+			// remaining users must still be directly accessible
+			for _, expectedUser := range tc.expectedData {
+				user, err := repo.GetUser(context.Background(), expectedUser.ID)
+				assert.NoError(t, err)
+				assert.Equal(t, expectedUser, user)
+			}
+
+			_, err = repo.GetUser(context.Background(), tc.userID)
+			assert.Equal(t, lib.ErrNotFound, err)
+		})
+	}
+}
+
+func TestUpsertUsers(t *testing.T) {
+	ctx := context.Background()
+
+	updatedUser := testUsersData[1]
+	updatedUser.LastName = "Blasio"
+	newUser := lib.User{ID: "f3f1612d-8239-4933-9891-71b5ee127844", FirstName: "Amie", LastName: "Old"}
+	newUserAgain := lib.User{ID: "f3f1612d-8239-4933-9891-71b5ee127844", FirstName: "Amie", LastName: "Trillow"}
+
+	persisted := 0
+	repo := NewUsersRepo(testUsersData)
+	repo.persist = func(usersData []lib.User) error {
+		persisted++
+		return nil
+	}
+
+	err := repo.UpsertUsers(ctx, []lib.User{newUser, updatedUser, newUserAgain})
+	assert.NoError(t, err)
+
+	// a single write, replaced in place and added to the end (the last duplicated one wins)
+	assert.Equal(t, 1, persisted)
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{testUsersData[0], updatedUser, testUsersData[2], newUserAgain}, page.Users)
+
+	user, err := repo.GetUser(ctx, newUser.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, newUserAgain, user)
+
+	// the search index is updated
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "blasio", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{testUsersData[0], updatedUser}, page.Users)
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "trillow", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{newUserAgain}, page.Users)
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "old", Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, page.Users)
+}
+
+func TestReplaceUsersData(t *testing.T) {
+	ctx := context.Background()
+
+	persisted := 0
+	repo := NewUsersRepo(testUsersData)
+	repo.persist = func(usersData []lib.User) error {
+		persisted++
+		return nil
+	}
+
+	replacedData := []lib.User{testUsersData[2], testUsersData[0]}
+	repo.ReplaceUsersData(replacedData)
+
+	// replaced without being persisted
+	assert.Equal(t, 0, persisted)
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, lib.UsersPage{Users: replacedData, Total: 2}, page)
+
+	// the indexes are rebuilt
+	_, err = repo.GetUser(ctx, testUsersData[1].ID)
+	assert.Equal(t, lib.ErrNotFound, err)
+	user, err := repo.GetUser(ctx, testUsersData[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, testUsersData[0], user)
+
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "trillow", Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, page.Users)
+	page, err = repo.SearchUsers(ctx, lib.UsersSearchQuery{Text: "macpaik", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []lib.User{testUsersData[2]}, page.Users)
+
+	// the caller slice is copied
+	replacedData[0].FirstName = "Changed"
+	user, err = repo.GetUser(ctx, testUsersData[2].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, testUsersData[2], user)
+}
+
+func TestWritePersistError(t *testing.T) {
+	ctx := context.Background()
+	persistErr := fmt.Errorf("disk full")
+
+	repo := NewUsersRepo(testUsersData)
+	repo.persist = func(usersData []lib.User) error {
+		return persistErr
+	}
+
+	_, err := repo.CreateUser(ctx, lib.User{ID: "f3f1612d-8239-4933-9891-71b5ee127844"})
+	assert.Equal(t, persistErr, err)
+
+	_, err = repo.UpdateUser(ctx, lib.User{ID: testUsersData[0].ID}, "")
+	assert.Equal(t, persistErr, err)
+
+	err = repo.DeleteUser(ctx, testUsersData[1].ID)
+	assert.Equal(t, persistErr, err)
+
+	err = repo.UpsertUsers(ctx, []lib.User{{ID: "f3f1612d-8239-4933-9891-71b5ee127844"}})
+	assert.Equal(t, persistErr, err)
+
+	// nothing must be applied if the data cannot be persisted
+	page, err := repo.GetUsers(ctx, lib.UsersQuery{Limit: 10, Offset: 0})
+	users := page.Users
+	assert.NoError(t, err)
+	assert.Equal(t, testUsersData, users)
+
+	_, err = repo.GetUser(ctx, "f3f1612d-8239-4933-9891-71b5ee127844")
+	assert.Equal(t, lib.ErrNotFound, err)
+}
+
+func TestGetUserByEmail(t *testing.T) {
+	testCases := []struct {
+		name          string
+		usersData     []lib.User
+		email         string
+		expectedUser  lib.User
+		expectedError error
+	}{
+		{
+			name:          "base case",
+			usersData:     testUsersData,
+			email:         "ttrillow1@feedburner.com",
+			expectedUser:  testUsersData[1],
+			expectedError: nil,
+		},
+		{
+			name:          "case-insensitive",
+			usersData:     testUsersData,
+			email:         "NMacPaik2@Phoca.cz",
+			expectedUser:  testUsersData[2],
+			expectedError: nil,
+		},
+		{
+			name:          "not found",
+			usersData:     testUsersData,
+			email:         "unknown@phoca.cz",
+			expectedUser:  lib.User{},
+			expectedError: lib.ErrNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := NewUsersRepo(tc.usersData)
+
+			user, err := repo.GetUserByEmail(context.Background(), tc.email)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedUser, user)
+		})
+	}
+}
@@ -0,0 +1,396 @@
+package infra
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/hbernardo/users/go-src/lib"
+)
+
+const (
+	// nameFieldWeight is the weight of the terms found in the first and last names
+	nameFieldWeight = 2
+	// emailFieldWeight is the weight of the terms found in the email
+	emailFieldWeight = 1
+
+	// exactMatchScore, prefixMatchScore and typoMatchScore are the scores of a query token
+	// matching a term exactly, as a prefix or with a typo (multiplied by the term field weight)
+	exactMatchScore  = 1.0
+	prefixMatchScore = 0.6
+	typoMatchScore   = 0.4
+
+	// minTypoTokenLength is the minimum query token length to tolerate 1 typo,
+	// and minTwoTyposTokenLength to tolerate 2 typos
+	minTypoTokenLength     = 4
+	minTwoTyposTokenLength = 8
+)
+
+type (
+	// usersSearchIndex is an inverted index of the users names and email terms,
+	// it is not safe for concurrent use (protected by the repo mutex)
+	usersSearchIndex struct {
+		// postings maps each term to the IDs of the users containing it (with the term field weight)
+		postings map[string]map[string]int
+		// terms are the indexed terms in order, for the prefix matching
+		terms []string
+		// deletions maps the variants of the terms with some runes deleted (up to the typos they can be matched with)
+		// to the terms, for the typo matching: a term and a query token within the edit distance share a variant
+		deletions map[string][]string
+	}
+
+	// usersSearchHit represents a user matching the search, with its relevance score
+	usersSearchHit struct {
+		UserID string
+		Score  float64
+	}
+)
+
+// newUsersSearchIndex creates a new users search index, receives the users data to be indexed as parameter
+// (the deleted users are not indexed)
+func newUsersSearchIndex(usersData []lib.User) *usersSearchIndex {
+	index := &usersSearchIndex{
+		postings:  make(map[string]map[string]int),
+		deletions: make(map[string][]string),
+	}
+
+	for _, user := range usersData {
+		if user.IsDeleted() {
+			continue
+		}
+		for term, weight := range userSearchTerms(user) {
+			index.addPosting(term, user.ID, weight)
+		}
+	}
+
+	// sorting the terms only once, instead of inserting them in order
+	index.terms = make([]string, 0, len(index.postings))
+	for term := range index.postings {
+		index.terms = append(index.terms, term)
+		index.addDeletions(term)
+	}
+	sort.Strings(index.terms)
+
+	return index
+}
+
+// add indexes the user terms (the deleted users are not indexed)
+func (x *usersSearchIndex) add(user lib.User) {
+	if user.IsDeleted() {
+		return
+	}
+
+	for term, weight := range userSearchTerms(user) {
+		if _, ok := x.postings[term]; !ok {
+			// new term, inserting it in order
+			i := sort.SearchStrings(x.terms, term)
+			x.terms = append(x.terms, "")
+			copy(x.terms[i+1:], x.terms[i:])
+			x.terms[i] = term
+			x.addDeletions(term)
+		}
+		x.addPosting(term, user.ID, weight)
+	}
+}
+
+// remove removes the user terms from the index
+func (x *usersSearchIndex) remove(user lib.User) {
+	for term := range userSearchTerms(user) {
+		userIDs, ok := x.postings[term]
+		if !ok {
+			continue
+		}
+
+		delete(userIDs, user.ID)
+		if len(userIDs) > 0 {
+			continue
+		}
+
+		// no more users containing the term
+		delete(x.postings, term)
+		i := sort.SearchStrings(x.terms, term)
+		if i < len(x.terms) && x.terms[i] == term {
+			x.terms = append(x.terms[:i], x.terms[i+1:]...)
+		}
+		x.removeDeletions(term)
+	}
+}
+
+// search gets the users matching all the query tokens (exactly, as a prefix or with a typo),
+// the hits are not sorted
+func (x *usersSearchIndex) search(query string) []usersSearchHit {
+	tokens := searchTokens(query)
+	if len(tokens) == 0 {
+		return []usersSearchHit{}
+	}
+
+	var scores map[string]float64
+	for _, token := range tokens {
+		tokenScores := x.tokenScores(token)
+
+		if scores == nil {
+			scores = tokenScores
+			continue
+		}
+
+		// all the query tokens must match
+		for userID, score := range scores {
+			tokenScore, ok := tokenScores[userID]
+			if !ok {
+				delete(scores, userID)
+				continue
+			}
+			scores[userID] = score + tokenScore
+		}
+	}
+
+	hits := make([]usersSearchHit, 0, len(scores))
+	for userID, score := range scores {
+		hits = append(hits, usersSearchHit{UserID: userID, Score: score})
+	}
+
+	return hits
+}
+
+// tokenScores gets the score of the users matching the query token, the best matching term of each user counts
+func (x *usersSearchIndex) tokenScores(token string) map[string]float64 {
+	scores := make(map[string]float64)
+
+	addTermScores := func(term string, matchScore float64) {
+		for userID, weight := range x.postings[term] {
+			score := matchScore * float64(weight)
+			if score > scores[userID] {
+				scores[userID] = score
+			}
+		}
+	}
+
+	// exact and prefix matches, the terms starting with the token are contiguous in the ordered terms
+	for i := sort.SearchStrings(x.terms, token); i < len(x.terms) && strings.HasPrefix(x.terms[i], token); i++ {
+		if x.terms[i] == token {
+			addTermScores(x.terms[i], exactMatchScore)
+		} else {
+			addTermScores(x.terms[i], prefixMatchScore)
+		}
+	}
+
+	// typo tolerance, only for tokens long enough to not match almost everything
+	maxDistance := maxTypos(token)
+	if maxDistance == 0 {
+		return scores
+	}
+
+	// only the terms sharing a deletion variant with the token can be within the distance
+	tokenRunes := []rune(token)
+	candidates := make(map[string]struct{})
+	for variant := range deletionVariants(tokenRunes, maxDistance) {
+		for _, term := range x.deletions[variant] {
+			candidates[term] = struct{}{}
+		}
+	}
+
+	for term := range candidates {
+		if strings.HasPrefix(term, token) {
+			continue // already matched
+		}
+		if editDistance(tokenRunes, []rune(term), maxDistance) <= maxDistance {
+			addTermScores(term, typoMatchScore)
+		}
+	}
+
+	return scores
+}
+
+// addDeletions indexes the deletion variants of the new term
+func (x *usersSearchIndex) addDeletions(term string) {
+	termRunes := []rune(term)
+	for variant := range deletionVariants(termRunes, termMaxTypos(len(termRunes))) {
+		x.deletions[variant] = append(x.deletions[variant], term)
+	}
+}
+
+// removeDeletions removes the deletion variants of the removed term
+func (x *usersSearchIndex) removeDeletions(term string) {
+	termRunes := []rune(term)
+	for variant := range deletionVariants(termRunes, termMaxTypos(len(termRunes))) {
+		terms := x.deletions[variant]
+		for i := range terms {
+			if terms[i] == term {
+				terms = append(terms[:i], terms[i+1:]...)
+				break
+			}
+		}
+
+		if len(terms) == 0 {
+			delete(x.deletions, variant)
+		} else {
+			x.deletions[variant] = terms
+		}
+	}
+}
+
+// addPosting adds the user to the term postings, keeping the greatest field weight
+func (x *usersSearchIndex) addPosting(term string, userID string, weight int) {
+	userIDs, ok := x.postings[term]
+	if !ok {
+		userIDs = make(map[string]int)
+		x.postings[term] = userIDs
+	}
+
+	if weight > userIDs[userID] {
+		userIDs[userID] = weight
+	}
+}
+
+// userSearchTerms gets the user searchable terms with their greatest field weight
+func userSearchTerms(user lib.User) map[string]int {
+	terms := make(map[string]int)
+
+	for _, field := range []struct {
+		value  string
+		weight int
+	}{
+		{user.FirstName, nameFieldWeight},
+		{user.LastName, nameFieldWeight},
+		{user.Email, emailFieldWeight},
+	} {
+		for _, term := range searchTokens(field.value) {
+			if field.weight > terms[term] {
+				terms[term] = field.weight
+			}
+		}
+	}
+
+	return terms
+}
+
+// searchTokens splits the text into lowercase tokens (sequences of letters and digits),
+// e.g. "ttrillow1@feedburner.com" results in "ttrillow1", "feedburner" and "com"
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// maxTypos gets the number of typos tolerated for the query token, based on its length
+func maxTypos(token string) int {
+	length := len([]rune(token))
+
+	switch {
+	case length >= minTwoTyposTokenLength:
+		return 2
+	case length >= minTypoTokenLength:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// termMaxTypos gets the number of typos an indexed term can be matched with, based on its length
+// (the query tokens can be longer than the term, up to their tolerated typos)
+func termMaxTypos(length int) int {
+	switch {
+	case length+2 >= minTwoTyposTokenLength:
+		return 2
+	case length+1 >= minTypoTokenLength:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// deletionVariants gets the variants of the text with up to maxDeletions runes deleted (the text itself included),
+// none if no deletion is allowed
+func deletionVariants(text []rune, maxDeletions int) map[string]struct{} {
+	variants := make(map[string]struct{})
+	if maxDeletions == 0 {
+		return variants
+	}
+
+	var addVariants func(text []rune, deletions int)
+	addVariants = func(text []rune, deletions int) {
+		variant := string(text)
+		if _, ok := variants[variant]; ok {
+			return // its variants were already added
+		}
+		variants[variant] = struct{}{}
+
+		if deletions == 0 {
+			return
+		}
+		for i := range text {
+			deleted := make([]rune, 0, len(text)-1)
+			deleted = append(append(deleted, text[:i]...), text[i+1:]...)
+			addVariants(deleted, deletions-1)
+		}
+	}
+	addVariants(text, maxDeletions)
+
+	return variants
+}
+
+// editDistance gets the number of edits (insertions, deletions, substitutions and adjacent transpositions)
+// to turn a into b, stopping as soon as it is greater than maxDistance (returning maxDistance+1)
+func editDistance(a, b []rune, maxDistance int) int {
+	if abs(len(a)-len(b)) > maxDistance {
+		return maxDistance + 1
+	}
+
+	// only the last 2 rows of the distance matrix are needed (plus the current one)
+	prevPrev := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				curr[j] = minInt(curr[j], prevPrev[j-2]+1)
+			}
+
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+
+		// the distance never decreases on the next rows
+		if rowMin > maxDistance {
+			return maxDistance + 1
+		}
+
+		prevPrev, prev, curr = prev, curr, prevPrev
+	}
+
+	if prev[len(b)] > maxDistance {
+		return maxDistance + 1
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
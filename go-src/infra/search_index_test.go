@@ -0,0 +1,142 @@
+package infra
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchTokens(t *testing.T) {
+	assert.Equal(t, []string{"ttrillow1", "feedburner", "com"}, searchTokens("TTrillow1@feedburner.com"))
+	assert.Equal(t, []string{"mary", "ann", "o", "brien"}, searchTokens(" Mary-Ann O'Brien "))
+	assert.Equal(t, []string{}, searchTokens("@.-"))
+}
+
+func TestEditDistance(t *testing.T) {
+	testCases := []struct {
+		a, b        string
+		maxDistance int
+		expected    int
+	}{
+		{a: "trillow", b: "trillow", maxDistance: 1, expected: 0},
+		{a: "trilow", b: "trillow", maxDistance: 1, expected: 1},   // insertion
+		{a: "trilllow", b: "trillow", maxDistance: 1, expected: 1}, // deletion
+		{a: "trollow", b: "trillow", maxDistance: 1, expected: 1},  // substitution
+		{a: "tirllow", b: "trillow", maxDistance: 1, expected: 1},  // transposition
+		{a: "torllow", b: "trillow", maxDistance: 2, expected: 2},  // transposition + substitution
+		{a: "terence", b: "trillow", maxDistance: 2, expected: 3},  // stopped at the max distance
+		{a: "nick", b: "nicolette", maxDistance: 2, expected: 3},   // length difference
+		{a: "müller", b: "muller", maxDistance: 1, expected: 1},    // runes
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.a+"/"+tc.b, func(t *testing.T) {
+			assert.Equal(t, tc.expected, editDistance([]rune(tc.a), []rune(tc.b), tc.maxDistance))
+		})
+	}
+}
+
+func TestDeletionVariants(t *testing.T) {
+	assert.Equal(t, map[string]struct{}{}, deletionVariants([]rune("nick"), 0))
+	assert.Equal(t, map[string]struct{}{"abb": {}, "bb": {}, "ab": {}}, deletionVariants([]rune("abb"), 1))
+	assert.Len(t, deletionVariants([]rune("trillow"), 2), 23) // not 1+7+21, the repeated runes result in the same variants
+}
+
+func TestUsersSearchIndexSearch(t *testing.T) {
+	index := newUsersSearchIndex([]lib.User{
+		{ID: "1", FirstName: "Nicky", LastName: "Blasio", Email: "nblasio0@jiathis.com"},
+		{ID: "2", FirstName: "Terrence", LastName: "Trillow", Email: "ttrillow1@feedburner.com"},
+		{ID: "3", FirstName: "Niels", LastName: "MacPaik", Email: "nicky.fan@phoca.cz"},
+	})
+
+	testCases := []struct {
+		name         string
+		query        string
+		expectedHits []usersSearchHit
+	}{
+		{
+			name:         "exact match - case-insensitive",
+			query:        "TRILLOW",
+			expectedHits: []usersSearchHit{{UserID: "2", Score: exactMatchScore * nameFieldWeight}},
+		},
+		{
+			name:  "names rank over email",
+			query: "nicky",
+			expectedHits: []usersSearchHit{
+				{UserID: "1", Score: exactMatchScore * nameFieldWeight},
+				{UserID: "3", Score: exactMatchScore * emailFieldWeight},
+			},
+		},
+		{
+			name:  "token prefix",
+			query: "ni",
+			expectedHits: []usersSearchHit{
+				{UserID: "1", Score: prefixMatchScore * nameFieldWeight},
+				{UserID: "3", Score: prefixMatchScore * nameFieldWeight},
+			},
+		},
+		{
+			name:         "typo",
+			query:        "terence",
+			expectedHits: []usersSearchHit{{UserID: "2", Score: typoMatchScore * nameFieldWeight}},
+		},
+		{
+			name:         "short tokens have no typo tolerance",
+			query:        "nuk",
+			expectedHits: []usersSearchHit{},
+		},
+		{
+			name:         "all tokens must match - scores summed",
+			query:        "terrence feedburner",
+			expectedHits: []usersSearchHit{{UserID: "2", Score: exactMatchScore*nameFieldWeight + exactMatchScore*emailFieldWeight}},
+		},
+		{
+			name:         "all tokens must match - no match",
+			query:        "terrence blasio",
+			expectedHits: []usersSearchHit{},
+		},
+		{
+			name:         "no tokens",
+			query:        "@",
+			expectedHits: []usersSearchHit{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hits := index.search(tc.query)
+			sort.Slice(hits, func(i, j int) bool { return hits[i].UserID < hits[j].UserID })
+
+			assert.Equal(t, tc.expectedHits, hits)
+		})
+	}
+}
+
+func TestUsersSearchIndexAddRemove(t *testing.T) {
+	nicky := lib.User{ID: "1", FirstName: "Nicky", LastName: "Blasio", Email: "nblasio0@jiathis.com"}
+	niels := lib.User{ID: "3", FirstName: "Niels", LastName: "Blasio", Email: "nmacpaik2@phoca.cz"}
+
+	index := newUsersSearchIndex([]lib.User{nicky})
+	index.add(niels)
+
+	assert.Len(t, index.search("blasio"), 2)
+	assert.True(t, sort.StringsAreSorted(index.terms))
+
+	index.remove(nicky)
+
+	assert.Equal(t, []usersSearchHit{{UserID: "3", Score: exactMatchScore * nameFieldWeight}}, index.search("blasio"))
+	assert.Empty(t, index.search("nicky"))
+	assert.Equal(t, []string{"blasio", "cz", "niels", "nmacpaik2", "phoca"}, index.terms)
+
+	// the typo matching follows the removed terms
+	assert.Equal(t, []usersSearchHit{{UserID: "3", Score: typoMatchScore * nameFieldWeight}}, index.search("nieks"))
+
+	index.remove(niels)
+
+	assert.Empty(t, index.postings)
+	assert.Empty(t, index.terms)
+	assert.Empty(t, index.deletions)
+	assert.Empty(t, index.search("nieks"))
+}
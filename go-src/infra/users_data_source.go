@@ -0,0 +1,196 @@
+package infra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
+)
+
+const (
+	// UsersDataStdin is the users data source of the standard input
+	UsersDataStdin = "-"
+
+	// usersDataFetchTimeout is the maximum time of a users data request (http sources)
+	usersDataFetchTimeout = 30 * time.Second
+)
+
+// UsersDataSource is where the users data is read from: a file (path or file:// URL), an http(s):// URL
+// or the standard input ("-"), the data is JSON, gzip-compressed or not (detected from the content)
+type UsersDataSource struct {
+	source   string
+	filePath string
+	url      string
+	client   *http.Client
+	stdin    io.Reader
+	// maxSize is the maximum size of the data read from the http sources and the standard input (no maximum if not positive)
+	maxSize int64
+
+	// mutex protects the state of the last fetch
+	mutex   sync.Mutex
+	fetched bool
+	etag    string
+	version string
+}
+
+// NewUsersDataSource parses the users data source: a file path, a file:// URL, an http(s):// URL or "-" (standard input),
+// the data of the http sources and the standard input cannot be larger than the maximum size (no maximum if not positive)
+func NewUsersDataSource(source string, maxSize int64) (*UsersDataSource, error) {
+	dataSource := &UsersDataSource{source: source, maxSize: maxSize}
+
+	if source == "" {
+		return nil, errors.New("empty users data source")
+	}
+	if source == UsersDataStdin {
+		dataSource.stdin = os.Stdin
+		return dataSource, nil
+	}
+
+	sourceURL, err := url.Parse(source)
+	if err != nil || sourceURL.Scheme == "" || len(sourceURL.Scheme) == 1 { // a Windows drive letter is not a scheme
+		dataSource.filePath = source
+		return dataSource, nil
+	}
+
+	switch sourceURL.Scheme {
+	case "file":
+		if sourceURL.Host != "" && sourceURL.Host != "localhost" {
+			return nil, fmt.Errorf("invalid users data source '%s' (expected a local file URL)", source)
+		}
+		if sourceURL.Path == "" {
+			return nil, fmt.Errorf("invalid users data source '%s' (expected a file path)", source)
+		}
+		dataSource.filePath = sourceURL.Path
+	case "http", "https":
+		dataSource.url = source
+		dataSource.client = &http.Client{Timeout: usersDataFetchTimeout}
+	default:
+		return nil, fmt.Errorf("invalid users data source '%s' (expected a file path, a file, http or https URL, or - for stdin)", source)
+	}
+
+	return dataSource, nil
+}
+
+// String gets the users data source as configured
+func (s *UsersDataSource) String() string {
+	return s.source
+}
+
+// FilePath gets the file path of the file sources (empty for the others)
+func (s *UsersDataSource) FilePath() string {
+	return s.filePath
+}
+
+// IsURL checks if it's an http(s) source
+func (s *UsersDataSource) IsURL() bool {
+	return s.url != ""
+}
+
+// Fetch gets the users data if it changed since the last fetch (always on the first one), validated as by NewUsersFileRepo
+// and with the plaintext passwords hashed, returning if it changed. The http sources are fetched with conditional requests
+// (If-None-Match with the ETag of the last response), and the standard input is only read once
+func (s *UsersDataSource) Fetch(ctx context.Context, strictValidation bool) ([]lib.User, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var (
+		data []byte
+		etag string
+		err  error
+	)
+	switch {
+	case s.filePath != "":
+		data, err = ioutil.ReadFile(s.filePath)
+	case s.url != "":
+		data, etag, err = s.fetchURL(ctx)
+	default:
+		if s.fetched {
+			return nil, false, nil // already consumed
+		}
+		data, err = s.readData(s.stdin)
+		if err != nil {
+			err = fmt.Errorf("reading users data from %s: %w", s.source, err)
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// not modified (http), or the same content
+	if s.fetched && (data == nil || dataChecksum(data) == s.version) {
+		s.etag = etag
+		return nil, false, nil
+	}
+
+	usersData, dateErrs, err := decodeUsersData(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("decoding users data from %s: %w", s.source, err)
+	}
+
+	usersData, hashedPasswords, err := prepareUsersData(s.source, usersData, dateErrs, strictValidation)
+	if err != nil {
+		return nil, false, err
+	}
+	warnPlaintextPasswords(s.source, hashedPasswords)
+
+	s.fetched = true
+	s.etag = etag
+	s.version = dataChecksum(data)
+
+	return usersData, true, nil
+}
+
+// fetchURL gets the data of the http source with its ETag, the data is nil if not modified since the last fetch
+func (s *UsersDataSource) fetchURL(ctx context.Context) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.fetched && s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		data, err := s.readData(resp.Body)
+		if err != nil {
+			return nil, "", fmt.Errorf("reading users data from %s: %w", s.url, err)
+		}
+		return data, resp.Header.Get("ETag"), nil
+	case http.StatusNotModified:
+		return nil, s.etag, nil
+	default:
+		return nil, "", fmt.Errorf("fetching users data from %s: unexpected status %s", s.url, resp.Status)
+	}
+}
+
+// readData reads all the data, failing if it's larger than the maximum size
+func (s *UsersDataSource) readData(r io.Reader) ([]byte, error) {
+	if s.maxSize <= 0 {
+		return ioutil.ReadAll(r)
+	}
+
+	// reading one more byte tells if the data is larger than the maximum
+	data, err := ioutil.ReadAll(io.LimitReader(r, s.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.maxSize {
+		return nil, fmt.Errorf("users data is larger than %d bytes", s.maxSize)
+	}
+	return data, nil
+}
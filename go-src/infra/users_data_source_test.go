@@ -0,0 +1,284 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUsersDataSource(t *testing.T) {
+	testCases := []struct {
+		name             string
+		source           string
+		expectedFilePath string
+		expectedURL      bool
+		expectedError    string
+	}{
+		{name: "relative path", source: "data/users.json", expectedFilePath: "data/users.json"},
+		{name: "absolute path", source: "/srv/data/users.json.gz", expectedFilePath: "/srv/data/users.json.gz"},
+		{name: "file URL", source: "file:///srv/data/users.json", expectedFilePath: "/srv/data/users.json"},
+		{name: "localhost file URL", source: "file://localhost/srv/data/users.json", expectedFilePath: "/srv/data/users.json"},
+		{name: "http URL", source: "http://example.com/users.json", expectedURL: true},
+		{name: "https URL", source: "https://example.com/users.json?version=2", expectedURL: true},
+		{name: "stdin", source: "-"},
+		{name: "empty", source: "", expectedError: "empty users data source"},
+		{name: "remote file URL", source: "file://example.com/users.json",
+			expectedError: "invalid users data source 'file://example.com/users.json' (expected a local file URL)"},
+		{name: "file URL without path", source: "file://",
+			expectedError: "invalid users data source 'file://' (expected a file path)"},
+		{name: "unsupported scheme", source: "ftp://example.com/users.json",
+			expectedError: "invalid users data source 'ftp://example.com/users.json' (expected a file path, a file, http or https URL, or - for stdin)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			source, err := NewUsersDataSource(tc.source, 0)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.source, source.String())
+			assert.Equal(t, tc.expectedFilePath, source.FilePath())
+			assert.Equal(t, tc.expectedURL, source.IsURL())
+		})
+	}
+}
+
+func TestUsersDataSourceFetchFile(t *testing.T) {
+	ctx := context.Background()
+
+	for _, compressed := range []bool{false, true} {
+		filePath := filepath.Join(t.TempDir(), "users.json")
+		writeUsersData := func(usersData []lib.User) {
+			jsonBytes, err := json.Marshal(usersData)
+			require.NoError(t, err)
+			fileBytes, err := usersDataFileBytes(jsonBytes, compressed)
+			require.NoError(t, err)
+			require.NoError(t, writeFileAtomic(filePath, fileBytes))
+		}
+		writeUsersData(hashedTestUsersData)
+
+		for _, sourcePath := range []string{filePath, "file://" + filePath} {
+			source, err := NewUsersDataSource(sourcePath, 0)
+			require.NoError(t, err)
+
+			usersData, changed, err := source.Fetch(ctx, true)
+			assert.NoError(t, err)
+			assert.True(t, changed)
+			assert.Equal(t, hashedTestUsersData, usersData)
+
+			// unchanged file
+			usersData, changed, err = source.Fetch(ctx, true)
+			assert.NoError(t, err)
+			assert.False(t, changed)
+			assert.Nil(t, usersData)
+		}
+
+		source, err := NewUsersDataSource(filePath, 0)
+		require.NoError(t, err)
+		_, _, err = source.Fetch(ctx, true)
+		require.NoError(t, err)
+
+		writeUsersData(hashedTestUsersData[1:])
+
+		usersData, changed, err := source.Fetch(ctx, true)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, hashedTestUsersData[1:], usersData)
+	}
+}
+
+func TestUsersDataSourceFetchHTTP(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		mutex        sync.Mutex
+		body         []byte
+		etag         string
+		status       int
+		requests     int
+		conditionals int
+	)
+	setContent := func(usersData []lib.User, compressed bool, contentETag string) {
+		jsonBytes, err := json.Marshal(usersData)
+		require.NoError(t, err)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		body, err = usersDataFileBytes(jsonBytes, compressed)
+		require.NoError(t, err)
+		etag = contentETag
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		requests++
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+			conditionals++
+			if ifNoneMatch == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	source, err := NewUsersDataSource(server.URL+"/users.json.gz", 0)
+	require.NoError(t, err)
+
+	// gzip-compressed content
+	setContent(hashedTestUsersData, true, `"v1"`)
+
+	usersData, changed, err := source.Fetch(ctx, true)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, hashedTestUsersData, usersData)
+
+	// not modified, with a conditional request
+	usersData, changed, err = source.Fetch(ctx, true)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Nil(t, usersData)
+	assert.Equal(t, 1, conditionals)
+
+	// modified, with a new ETag
+	setContent(hashedTestUsersData[:1], false, `"v2"`)
+
+	usersData, changed, err = source.Fetch(ctx, true)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, hashedTestUsersData[:1], usersData)
+
+	// the server errors keep the last content
+	mutex.Lock()
+	status = http.StatusServiceUnavailable
+	mutex.Unlock()
+
+	_, changed, err = source.Fetch(ctx, true)
+	assert.EqualError(t, err, "fetching users data from "+server.URL+"/users.json.gz: unexpected status 503 Service Unavailable")
+	assert.False(t, changed)
+
+	mutex.Lock()
+	status = 0
+	mutex.Unlock()
+
+	usersData, changed, err = source.Fetch(ctx, true)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Nil(t, usersData)
+
+	// without ETag, the same content is not a change
+	setContent(hashedTestUsersData[:1], false, "")
+
+	usersData, changed, err = source.Fetch(ctx, true)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Nil(t, usersData)
+	assert.Equal(t, 6, requests)
+
+	// invalid content
+	mutex.Lock()
+	body = []byte(`[{"id": `)
+	mutex.Unlock()
+
+	_, changed, err = source.Fetch(ctx, true)
+	assert.Error(t, err)
+	assert.False(t, changed)
+}
+
+func TestUsersDataSourceFetchStdin(t *testing.T) {
+	ctx := context.Background()
+
+	jsonBytes, err := json.Marshal(hashedTestUsersData)
+	require.NoError(t, err)
+	compressedBytes, err := compressData(jsonBytes)
+	require.NoError(t, err)
+
+	for _, stdinBytes := range [][]byte{jsonBytes, compressedBytes} {
+		source, err := NewUsersDataSource("-", 0)
+		require.NoError(t, err)
+		source.stdin = bytes.NewReader(stdinBytes)
+
+		usersData, changed, err := source.Fetch(ctx, true)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, hashedTestUsersData, usersData)
+
+		// the standard input is only read once
+		usersData, changed, err = source.Fetch(ctx, true)
+		assert.NoError(t, err)
+		assert.False(t, changed)
+		assert.Nil(t, usersData)
+	}
+
+	// the invalid users are refused with strict validation
+	usersData := append([]lib.User(nil), hashedTestUsersData...)
+	usersData[1].Email = "Terrence <ttrillow1@feedburner.com>"
+	jsonBytes, err = json.Marshal(usersData)
+	require.NoError(t, err)
+
+	source, err := NewUsersDataSource("-", 0)
+	require.NoError(t, err)
+	source.stdin = bytes.NewReader(jsonBytes)
+
+	_, _, err = source.Fetch(ctx, true)
+	assert.ErrorIs(t, err, lib.ErrValidation)
+}
+
+func TestUsersDataSourceFetchMaxSize(t *testing.T) {
+	ctx := context.Background()
+
+	jsonBytes, err := json.Marshal(hashedTestUsersData)
+	require.NoError(t, err)
+	maxSize := int64(len(jsonBytes))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(jsonBytes)
+	}))
+	defer server.Close()
+
+	// the data of the maximum size is read
+	source, err := NewUsersDataSource("-", maxSize)
+	require.NoError(t, err)
+	source.stdin = bytes.NewReader(jsonBytes)
+
+	usersData, _, err := source.Fetch(ctx, true)
+	assert.NoError(t, err)
+	assert.Equal(t, hashedTestUsersData, usersData)
+
+	// the larger data is refused, from the standard input and the http sources
+	source, err = NewUsersDataSource("-", maxSize-1)
+	require.NoError(t, err)
+	source.stdin = bytes.NewReader(jsonBytes)
+
+	_, _, err = source.Fetch(ctx, true)
+	assert.EqualError(t, err, fmt.Sprintf("reading users data from -: users data is larger than %d bytes", maxSize-1))
+
+	source, err = NewUsersDataSource(server.URL, maxSize-1)
+	require.NoError(t, err)
+
+	_, _, err = source.Fetch(ctx, true)
+	assert.EqualError(t, err, fmt.Sprintf("reading users data from %s: users data is larger than %d bytes", server.URL, maxSize-1))
+}
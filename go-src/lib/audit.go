@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"context"
+	"time"
+)
+
+// AuditAction represents the kind of operation recorded by an audit entry
+type AuditAction string
+
+const (
+	// AuditDataExported means all the data held about the user was exported (right of access)
+	AuditDataExported AuditAction = "data_exported"
+	// AuditUserErased means the user personal data was erased (right to erasure)
+	AuditUserErased AuditAction = "user_erased"
+)
+
+// AuditEntry represents an immutable record of a sensitive operation on a user data: who made it and when
+type AuditEntry struct {
+	UserID string      `json:"user_id"`
+	Action AuditAction `json:"action"`
+	Actor  string      `json:"actor"`
+	Time   time.Time   `json:"time"`
+}
+
+// newAuditEntry creates the audit entry of the operation on the user, made now by the actor in the context
+func newAuditEntry(ctx context.Context, action AuditAction, userID string) AuditEntry {
+	return AuditEntry{
+		UserID: userID,
+		Action: action,
+		Actor:  ContextActor(ctx),
+		Time:   timeNow().UTC(),
+	}
+}
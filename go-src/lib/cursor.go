@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// UsersCursor represents a position in the sorted users (keyset pagination), right after the last seen user,
+// the users are ordered by the sort fields and then by ID, so the position is stable even if the data changes
+type UsersCursor struct {
+	Sort     []SortField `json:"s,omitempty"`
+	SortKeys []string    `json:"k,omitempty"`
+	ID       string      `json:"id,omitempty"`
+}
+
+// NewUsersCursor creates a cursor positioned right after the user in the sorting received as parameter
+func NewUsersCursor(user User, sort []SortField) UsersCursor {
+	return UsersCursor{
+		Sort:     sort,
+		SortKeys: userSortKeysOf(user, sort),
+		ID:       user.ID,
+	}
+}
+
+// IsStart checks if the cursor is positioned at the start (no user seen yet)
+func (c UsersCursor) IsStart() bool {
+	return c.ID == ""
+}
+
+// IsBefore checks if the cursor is positioned before the user
+func (c UsersCursor) IsBefore(user User) bool {
+	if c.IsStart() {
+		return true
+	}
+
+	cmp := compareSortKeys(c.SortKeys, userSortKeysOf(user, c.Sort), c.Sort)
+	if cmp != 0 {
+		return cmp < 0
+	}
+
+	// IDs are unique, the final tiebreaker
+	return strings.Compare(c.ID, user.ID) < 0
+}
+
+// KeysetSort gets the sorting used by the cursor pagination (the sort fields followed by the ID)
+func KeysetSort(sort []SortField) []SortField {
+	for _, sortField := range sort {
+		if sortField.Field == "id" {
+			return sort // already a total order
+		}
+	}
+
+	keysetSort := make([]SortField, 0, len(sort)+1)
+	keysetSort = append(keysetSort, sort...)
+	return append(keysetSort, SortField{Field: "id"})
+}
+
+// Encode encodes the cursor as an opaque token
+func (c UsersCursor) Encode() string {
+	jsonBytes, _ := json.Marshal(c) // cannot fail, only strings and bools
+	return base64.RawURLEncoding.EncodeToString(jsonBytes)
+}
+
+// DecodeUsersCursor decodes the cursor token, it must have been created for the same sorting
+func DecodeUsersCursor(token string, sort []SortField) (UsersCursor, error) {
+	jsonBytes, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return UsersCursor{}, errors.New("invalid cursor")
+	}
+
+	var cursor UsersCursor
+	err = json.Unmarshal(jsonBytes, &cursor)
+	if err != nil || cursor.IsStart() || len(cursor.SortKeys) != len(cursor.Sort) {
+		return UsersCursor{}, errors.New("invalid cursor")
+	}
+
+	if len(cursor.Sort) != len(sort) || (len(sort) > 0 && !reflect.DeepEqual(cursor.Sort, sort)) {
+		return UsersCursor{}, errors.New("cursor was created for a different sorting")
+	}
+
+	return cursor, nil
+}
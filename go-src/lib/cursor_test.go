@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsersCursorEncodeDecode(t *testing.T) {
+	sort := []SortField{{Field: "last_name"}, {Field: "creation_date", Descending: true}}
+	cursor := NewUsersCursor(User{ID: "1", LastName: "Blasio", CreationDate: time.Date(2021, time.June, 6, 0, 0, 0, 0, time.UTC)}, sort)
+
+	testCases := []struct {
+		name           string
+		token          string
+		sort           []SortField
+		expectedCursor UsersCursor
+		expectedError  error
+	}{
+		{
+			name:  "roundtrip",
+			token: cursor.Encode(),
+			sort:  sort,
+			expectedCursor: UsersCursor{
+				Sort:     sort,
+				SortKeys: []string{"blasio", "20210606000000.000000000"},
+				ID:       "1",
+			},
+			expectedError: nil,
+		},
+		{
+			name:           "roundtrip - no sorting",
+			token:          UsersCursor{ID: "1"}.Encode(),
+			sort:           nil,
+			expectedCursor: UsersCursor{ID: "1"},
+			expectedError:  nil,
+		},
+		{
+			name:           "error - not base64",
+			token:          "not a cursor!",
+			sort:           sort,
+			expectedCursor: UsersCursor{},
+			expectedError:  errors.New("invalid cursor"),
+		},
+		{
+			name:           "error - not JSON",
+			token:          "bm90IGpzb24",
+			sort:           sort,
+			expectedCursor: UsersCursor{},
+			expectedError:  errors.New("invalid cursor"),
+		},
+		{
+			name:           "error - missing sort keys",
+			token:          UsersCursor{Sort: sort, ID: "1"}.Encode(),
+			sort:           sort,
+			expectedCursor: UsersCursor{},
+			expectedError:  errors.New("invalid cursor"),
+		},
+		{
+			name:           "error - different sorting",
+			token:          cursor.Encode(),
+			sort:           []SortField{{Field: "last_name"}},
+			expectedCursor: UsersCursor{},
+			expectedError:  errors.New("cursor was created for a different sorting"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cursor, err := DecodeUsersCursor(tc.token, tc.sort)
+
+			assert.Equal(t, tc.expectedCursor, cursor)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestUsersCursorIsBefore(t *testing.T) {
+	sort := []SortField{{Field: "last_name"}}
+	cursor := NewUsersCursor(User{ID: "2", LastName: "Blasio"}, sort)
+
+	testCases := []struct {
+		name     string
+		cursor   UsersCursor
+		user     User
+		expected bool
+	}{
+		{
+			name:     "start cursor is before everything",
+			cursor:   UsersCursor{Sort: sort},
+			user:     User{ID: "1", LastName: "Aaron"},
+			expected: true,
+		},
+		{
+			name:     "greater sort key",
+			cursor:   cursor,
+			user:     User{ID: "1", LastName: "Trillow"},
+			expected: true,
+		},
+		{
+			name:     "lower sort key",
+			cursor:   cursor,
+			user:     User{ID: "3", LastName: "Aaron"},
+			expected: false,
+		},
+		{
+			name:     "same sort key - greater ID",
+			cursor:   cursor,
+			user:     User{ID: "3", LastName: "blasio"},
+			expected: true,
+		},
+		{
+			name:     "same user",
+			cursor:   cursor,
+			user:     User{ID: "2", LastName: "Blasio"},
+			expected: false,
+		},
+		{
+			name:     "descending",
+			cursor:   NewUsersCursor(User{ID: "2", LastName: "Blasio"}, []SortField{{Field: "last_name", Descending: true}}),
+			user:     User{ID: "1", LastName: "Aaron"},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.cursor.IsBefore(tc.user))
+		})
+	}
+}
+
+func TestKeysetSort(t *testing.T) {
+	testCases := []struct {
+		name     string
+		sort     []SortField
+		expected []SortField
+	}{
+		{
+			name:     "no sorting",
+			sort:     nil,
+			expected: []SortField{{Field: "id"}},
+		},
+		{
+			name:     "ID appended",
+			sort:     []SortField{{Field: "last_name", Descending: true}},
+			expected: []SortField{{Field: "last_name", Descending: true}, {Field: "id"}},
+		},
+		{
+			name:     "already sorted by ID",
+			sort:     []SortField{{Field: "id", Descending: true}, {Field: "last_name"}},
+			expected: []SortField{{Field: "id", Descending: true}, {Field: "last_name"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, KeysetSort(tc.sort))
+		})
+	}
+}
@@ -7,4 +7,12 @@ var (
 	ErrNotFound = errors.New("not found")
 	// ErrPreconditionFailed represents precondition failed error
 	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrConflict represents conflict with the stored data error (e.g. unique constraint violation)
+	ErrConflict = errors.New("conflict")
+	// ErrUnauthorized represents invalid credentials error
+	ErrUnauthorized = errors.New("invalid credentials")
+	// ErrTooManyAttempts represents too many failed attempts error (e.g. locked account)
+	ErrTooManyAttempts = errors.New("too many failed attempts")
+	// ErrValidation represents invalid user data error (the invalid fields are got from the ValidationError)
+	ErrValidation = errors.New("validation failed")
 )
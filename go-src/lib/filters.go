@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+type (
+	// StringFilter represents a filter over a string field, matched case-insensitively
+	StringFilter struct {
+		Value string
+		// Prefix matches the field prefix instead of the whole field
+		Prefix bool
+	}
+
+	// UsersFilter represents the filters applied when getting multiple users,
+	// only the filters that are set (non-nil or non-zero) are applied
+	UsersFilter struct {
+		FirstName   *StringFilter
+		LastName    *StringFilter
+		EmailDomain string
+		IPNet       *net.IPNet
+		// CreatedAfter and CreatedBefore are exclusive bounds over the creation date
+		CreatedAfter  time.Time
+		CreatedBefore time.Time
+		// IncludeDeleted matches the deleted (soft deleted) users too, they never match otherwise
+		IncludeDeleted bool
+		// OnlyDeleted matches only the deleted users (e.g. to purge them)
+		OnlyDeleted bool
+	}
+)
+
+// Matches checks if the string matches the filter
+func (f StringFilter) Matches(s string) bool {
+	if f.Prefix {
+		return len(s) >= len(f.Value) && strings.EqualFold(s[:len(f.Value)], f.Value)
+	}
+	return strings.EqualFold(s, f.Value)
+}
+
+// IsEmpty checks if no filter is set (the deleted users are still excluded, see IncludeDeleted)
+func (f UsersFilter) IsEmpty() bool {
+	return f.FirstName == nil &&
+		f.LastName == nil &&
+		f.EmailDomain == "" &&
+		f.IPNet == nil &&
+		f.CreatedAfter.IsZero() &&
+		f.CreatedBefore.IsZero() &&
+		!f.OnlyDeleted
+}
+
+// Matches checks if the user matches all the filters that are set
+func (f UsersFilter) Matches(user User) bool {
+	if user.IsDeleted() && !f.IncludeDeleted && !f.OnlyDeleted {
+		return false
+	}
+	if f.OnlyDeleted && !user.IsDeleted() {
+		return false
+	}
+
+	if f.FirstName != nil && !f.FirstName.Matches(user.FirstName) {
+		return false
+	}
+
+	if f.LastName != nil && !f.LastName.Matches(user.LastName) {
+		return false
+	}
+
+	if f.EmailDomain != "" {
+		at := strings.LastIndex(user.Email, "@")
+		if at < 0 || !strings.EqualFold(user.Email[at+1:], f.EmailDomain) {
+			return false
+		}
+	}
+
+	if f.IPNet != nil {
+		ip := net.ParseIP(user.IPAddress)
+		if ip == nil || !f.IPNet.Contains(ip) {
+			return false
+		}
+	}
+
+	if !f.CreatedAfter.IsZero() && !user.CreationDate.After(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !user.CreationDate.Before(f.CreatedBefore) {
+		return false
+	}
+
+	return true
+}
+
+// ParseCreationDate parses the user creation date, in RFC 3339 or in the legacy format (dd/mm/yyyy, at midnight UTC)
+func ParseCreationDate(creationDate string) (time.Time, error) {
+	date, err := time.Parse(time.RFC3339, creationDate)
+	if err == nil {
+		return date, nil
+	}
+	return time.Parse(LegacyCreationDateLayout, creationDate)
+}
+
+// FormatCreationDate formats the user creation date in RFC 3339, or in the legacy format (dd/mm/yyyy)
+func FormatCreationDate(creationDate time.Time, legacy bool) string {
+	if legacy {
+		return creationDate.Format(LegacyCreationDateLayout)
+	}
+	return creationDate.Format(time.RFC3339)
+}
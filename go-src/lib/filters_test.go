@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsersFilterMatches(t *testing.T) {
+	user := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+
+	_, ipNet, _ := net.ParseCIDR("63.119.0.0/16")
+	_, otherIPNet, _ := net.ParseCIDR("10.0.0.0/8")
+
+	deletedAt := time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC)
+	deletedUser := user
+	deletedUser.DeletedAt = &deletedAt
+
+	testCases := []struct {
+		name            string
+		filter          UsersFilter
+		user            *User
+		expectedMatches bool
+	}{
+		{
+			name:            "empty filter",
+			filter:          UsersFilter{},
+			expectedMatches: true,
+		},
+		{
+			name:            "deleted user excluded",
+			filter:          UsersFilter{},
+			user:            &deletedUser,
+			expectedMatches: false,
+		},
+		{
+			name:            "deleted user included",
+			filter:          UsersFilter{IncludeDeleted: true, EmailDomain: "feedburner.com"},
+			user:            &deletedUser,
+			expectedMatches: true,
+		},
+		{
+			name:            "only deleted",
+			filter:          UsersFilter{OnlyDeleted: true, EmailDomain: "feedburner.com"},
+			user:            &deletedUser,
+			expectedMatches: true,
+		},
+		{
+			name:            "only deleted - not deleted user",
+			filter:          UsersFilter{OnlyDeleted: true, IncludeDeleted: true},
+			expectedMatches: false,
+		},
+		{
+			name:            "first name exact match (case-insensitive)",
+			filter:          UsersFilter{FirstName: &StringFilter{Value: "terrence"}},
+			expectedMatches: true,
+		},
+		{
+			name:            "first name exact mismatch",
+			filter:          UsersFilter{FirstName: &StringFilter{Value: "Terr"}},
+			expectedMatches: false,
+		},
+		{
+			name:            "last name prefix match",
+			filter:          UsersFilter{LastName: &StringFilter{Value: "tri", Prefix: true}},
+			expectedMatches: true,
+		},
+		{
+			name:            "last name prefix longer than the name",
+			filter:          UsersFilter{LastName: &StringFilter{Value: "Trillowson", Prefix: true}},
+			expectedMatches: false,
+		},
+		{
+			name:            "email domain match",
+			filter:          UsersFilter{EmailDomain: "FeedBurner.com"},
+			expectedMatches: true,
+		},
+		{
+			name:            "email domain mismatch (no subdomain match)",
+			filter:          UsersFilter{EmailDomain: "burner.com"},
+			expectedMatches: false,
+		},
+		{
+			name:            "IP in range",
+			filter:          UsersFilter{IPNet: ipNet},
+			expectedMatches: true,
+		},
+		{
+			name:            "IP out of range",
+			filter:          UsersFilter{IPNet: otherIPNet},
+			expectedMatches: false,
+		},
+		{
+			name: "created inside the range",
+			filter: UsersFilter{
+				CreatedAfter:  time.Date(2021, time.April, 18, 0, 0, 0, 0, time.UTC),
+				CreatedBefore: time.Date(2021, time.April, 20, 0, 0, 0, 0, time.UTC),
+			},
+			expectedMatches: true,
+		},
+		{
+			name:            "created after is exclusive",
+			filter:          UsersFilter{CreatedAfter: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC)},
+			expectedMatches: false,
+		},
+		{
+			name:            "created before is exclusive",
+			filter:          UsersFilter{CreatedBefore: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC)},
+			expectedMatches: false,
+		},
+		{
+			name: "all filters must match",
+			filter: UsersFilter{
+				FirstName:   &StringFilter{Value: "Ter", Prefix: true},
+				EmailDomain: "jiathis.com",
+			},
+			expectedMatches: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			matchedUser := user
+			if tc.user != nil {
+				matchedUser = *tc.user
+			}
+
+			assert.Equal(t, tc.expectedMatches, tc.filter.Matches(matchedUser))
+		})
+	}
+}
+
+func TestUsersFilterMatchesInvalidData(t *testing.T) {
+	_, ipNet, _ := net.ParseCIDR("0.0.0.0/0")
+
+	// without creation date
+	user := User{
+		Email:     "invalid-email",
+		IPAddress: "invalid-ip",
+	}
+
+	assert.False(t, UsersFilter{EmailDomain: "invalid-email"}.Matches(user))
+	assert.False(t, UsersFilter{IPNet: ipNet}.Matches(user))
+	assert.False(t, UsersFilter{CreatedAfter: time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)}.Matches(user))
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// loginAttempts tracks the failed login attempts per account, locking it after too many failures
+	loginAttempts struct {
+		maxFailedAttempts int
+		lockoutDuration   time.Duration
+
+		mutex     sync.Mutex
+		accounts  map[string]*accountAttempts
+		lastSweep time.Time
+	}
+
+	accountAttempts struct {
+		failures    int
+		lastFailure time.Time
+		lockedUntil time.Time
+	}
+)
+
+// newLoginAttempts creates a new login attempts tracker, the account is locked for the lockout duration
+// after the maximum failed attempts (a non-positive maximum disables the lockout)
+func newLoginAttempts(maxFailedAttempts int, lockoutDuration time.Duration) *loginAttempts {
+	return &loginAttempts{
+		maxFailedAttempts: maxFailedAttempts,
+		lockoutDuration:   lockoutDuration,
+		accounts:          make(map[string]*accountAttempts),
+	}
+}
+
+// isLocked checks if the account is currently locked
+func (l *loginAttempts) isLocked(account string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	attempts, ok := l.accounts[accountKey(account)]
+	return ok && timeNow().Before(attempts.lockedUntil)
+}
+
+// recordFailure records a failed attempt for the account, locking it if the maximum is reached
+func (l *loginAttempts) recordFailure(account string) {
+	if l.maxFailedAttempts <= 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := timeNow()
+	l.sweep(now)
+
+	attempts, ok := l.accounts[accountKey(account)]
+	// failures older than the lockout duration are forgotten
+	if !ok || now.Sub(attempts.lastFailure) > l.lockoutDuration {
+		attempts = &accountAttempts{}
+		l.accounts[accountKey(account)] = attempts
+	}
+
+	attempts.failures++
+	attempts.lastFailure = now
+	if attempts.failures >= l.maxFailedAttempts {
+		attempts.lockedUntil = now.Add(l.lockoutDuration)
+		attempts.failures = 0
+	}
+}
+
+// recordSuccess forgets the failed attempts of the account
+func (l *loginAttempts) recordSuccess(account string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.accounts, accountKey(account))
+}
+
+// sweep releases the memory of the accounts without recent failures nor active lock,
+// runs at most once per lockout duration and must be called with the lock held
+func (l *loginAttempts) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.lockoutDuration {
+		return
+	}
+	l.lastSweep = now
+
+	for account, attempts := range l.accounts {
+		if now.Sub(attempts.lastFailure) > l.lockoutDuration && now.After(attempts.lockedUntil) {
+			delete(l.accounts, account)
+		}
+	}
+}
+
+// accountKey normalizes the account (email) to be used as key, emails are case-insensitive
+func accountKey(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginAttempts(t *testing.T) {
+	now := time.Date(2021, time.December, 24, 10, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	attempts := newLoginAttempts(3, time.Minute)
+
+	// locked only after the maximum failed attempts
+	attempts.recordFailure("ttrillow1@feedburner.com")
+	attempts.recordFailure("TTrillow1@feedburner.com") // emails are case-insensitive
+	assert.False(t, attempts.isLocked("ttrillow1@feedburner.com"))
+	attempts.recordFailure("ttrillow1@feedburner.com")
+	assert.True(t, attempts.isLocked("ttrillow1@feedburner.com"))
+
+	// other accounts are not affected
+	assert.False(t, attempts.isLocked("nblasio0@jiathis.com"))
+
+	// unlocked after the lockout duration
+	now = now.Add(time.Minute + time.Second)
+	assert.False(t, attempts.isLocked("ttrillow1@feedburner.com"))
+
+	// old failures are forgotten
+	attempts.recordFailure("nblasio0@jiathis.com")
+	attempts.recordFailure("nblasio0@jiathis.com")
+	now = now.Add(2 * time.Minute)
+	attempts.recordFailure("nblasio0@jiathis.com")
+	assert.False(t, attempts.isLocked("nblasio0@jiathis.com"))
+
+	// a success resets the failures
+	attempts.recordFailure("nblasio0@jiathis.com")
+	attempts.recordSuccess("nblasio0@jiathis.com")
+	attempts.recordFailure("nblasio0@jiathis.com")
+	assert.False(t, attempts.isLocked("nblasio0@jiathis.com"))
+
+	// stale accounts are released from memory
+	now = now.Add(2 * time.Minute)
+	attempts.recordFailure("lyeoland3@ucla.edu")
+	assert.Len(t, attempts.accounts, 1)
+}
+
+func TestLoginAttemptsDisabled(t *testing.T) {
+	attempts := newLoginAttempts(0, time.Minute)
+
+	for i := 0; i < 10; i++ {
+		attempts.recordFailure("ttrillow1@feedburner.com")
+	}
+
+	assert.False(t, attempts.isLocked("ttrillow1@feedburner.com"))
+}
@@ -1,7 +1,45 @@
 package lib
 
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
 // User represents the user model, contains JSON tags for responses
 type User struct {
+	ID           string    `json:"id"`
+	FirstName    string    `json:"first_name"`
+	LastName     string    `json:"last_name"`
+	Email        string    `json:"email"`
+	Password     string    `json:"password"`
+	IPAddress    string    `json:"ip_address"`
+	CreationDate time.Time `json:"creation_date"`
+	// DeletedAt is the deletion date of the soft deleted users (nil if not deleted), they are kept until purged
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// IsDeleted checks if the user is deleted (soft deleted)
+func (u User) IsDeleted() bool {
+	return u.DeletedAt != nil
+}
+
+// UserVersion gets the version of the user data (SHA1 of its content), it changes on every write of the user,
+// so it's used for optimistic concurrency and caching (e.g. HTTP ETag and If-Match)
+func UserVersion(user User) string {
+	// the struct fields are always encoded in the same order
+	jsonBytes, _ := json.Marshal(user)
+	sum := sha1.Sum(jsonBytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// userRecord represents the user as read from the users data (JSON or CSV),
+// the creation date can be in any accepted format (see ParseCreationDate)
+type userRecord struct {
 	ID           string `json:"id"`
 	FirstName    string `json:"first_name"`
 	LastName     string `json:"last_name"`
@@ -9,4 +47,158 @@ type User struct {
 	Password     string `json:"password"`
 	IPAddress    string `json:"ip_address"`
 	CreationDate string `json:"creation_date"`
+	DeletedAt    string `json:"deleted_at"`
+}
+
+// UnmarshalJSON decodes the user, accepting the creation date in RFC 3339 or in the legacy format (dd/mm/yyyy),
+// the unknown fields are rejected
+func (u *User) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var record userRecord
+	err := decoder.Decode(&record)
+	if err != nil {
+		return err
+	}
+
+	// the user is decoded even if the creation date is invalid (e.g. to report its ID)
+	user, err := record.user()
+	*u = user
+	return err
+}
+
+// DecodeUsersData decodes the users data (JSON array of users) of the data files leniently: the unknown fields are ignored
+// and the users with invalid dates are kept (with zero dates), their date errors are returned by position, with the invalid
+// values, to be reported by ValidateUsersData
+func DecodeUsersData(data []byte) ([]User, map[int]error, error) {
+	var records []userRecord
+	err := json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	usersData := make([]User, len(records))
+	dateErrs := make(map[int]error)
+	for i, record := range records {
+		user, err := record.user()
+		usersData[i] = user
+
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			for j, fieldErr := range validationErr.Fields {
+				validationErr.Fields[j].Message = fmt.Sprintf("%s, got '%s'", fieldErr.Message, record.dateValue(fieldErr.Field))
+			}
+			dateErrs[i] = validationErr
+		}
+	}
+
+	return usersData, dateErrs, nil
+}
+
+// dateValue gets the raw value of the date field
+func (r userRecord) dateValue(field string) string {
+	if field == "deleted_at" {
+		return r.DeletedAt
+	}
+	return r.CreationDate
+}
+
+// user converts the record to the user model, the creation date is zero if missing
+// (the other fields are still converted if the dates are invalid)
+func (r userRecord) user() (User, error) {
+	user := User{
+		ID:        r.ID,
+		FirstName: r.FirstName,
+		LastName:  r.LastName,
+		Email:     r.Email,
+		Password:  r.Password,
+		IPAddress: r.IPAddress,
+	}
+
+	validationErr := &ValidationError{}
+
+	if r.CreationDate != "" {
+		creationDate, err := ParseCreationDate(r.CreationDate)
+		if err != nil {
+			validationErr.add("creation_date", invalidCreationDateMessage)
+		}
+		user.CreationDate = creationDate
+	}
+
+	if r.DeletedAt != "" {
+		deletedAt, err := time.Parse(time.RFC3339, r.DeletedAt)
+		if err != nil {
+			validationErr.add("deleted_at", "must be an RFC 3339 date")
+		} else {
+			user.DeletedAt = &deletedAt
+		}
+	}
+
+	return user, validationErr.orNil()
+}
+
+// UsersQuery represents the parameters for getting multiple users:
+// pagination (limit and offset, or cursor), filters and sorting (data order if empty)
+type UsersQuery struct {
+	Limit  int
+	Offset int
+	// Cursor enables the cursor (keyset) pagination instead of the offset one, ordered by the sorting and then by ID
+	Cursor *UsersCursor
+	Filter UsersFilter
+	Sort   []SortField
+}
+
+// UsersSearchQuery represents the parameters for the users full-text search (names and email),
+// paginated by limit and offset
+type UsersSearchQuery struct {
+	Text   string
+	Limit  int
+	Offset int
+}
+
+// UsersPage represents a page of users
+type UsersPage struct {
+	Users []User
+	// Total is the number of users matching the filters (before the pagination)
+	Total int
+	// NextCursor is the position after the last user of the page (cursor pagination only), nil if there are no more users
+	NextCursor *UsersCursor
+}
+
+// UsersBatch represents the result of getting multiple users by their IDs
+type UsersBatch struct {
+	// Users are the found users, in the requested IDs order
+	Users []User
+	// MissingIDs are the requested IDs that were not found
+	MissingIDs []string
+}
+
+// UserPatch represents a partial update of the user model, only the non-nil fields are applied
+type UserPatch struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Email     *string `json:"email"`
+	Password  *string `json:"password"`
+	IPAddress *string `json:"ip_address"`
+}
+
+// Apply applies the non-nil patch fields to the user received as parameter
+func (p UserPatch) Apply(user User) User {
+	if p.FirstName != nil {
+		user.FirstName = *p.FirstName
+	}
+	if p.LastName != nil {
+		user.LastName = *p.LastName
+	}
+	if p.Email != nil {
+		user.Email = *p.Email
+	}
+	if p.Password != nil {
+		user.Password = *p.Password
+	}
+	if p.IPAddress != nil {
+		user.IPAddress = *p.IPAddress
+	}
+	return user
 }
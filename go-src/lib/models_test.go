@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserUnmarshalJSON(t *testing.T) {
+	deletedAt := time.Date(2022, time.January, 10, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		json          string
+		expectedUser  User
+		expectedError error
+	}{
+		{
+			name: "RFC 3339 creation date",
+			json: `{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","email":"ttrillow1@feedburner.com","creation_date":"2021-04-19T10:30:00-03:00"}`,
+			expectedUser: User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				Email:        "ttrillow1@feedburner.com",
+				CreationDate: time.Date(2021, time.April, 19, 13, 30, 0, 0, time.UTC),
+			},
+			expectedError: nil,
+		},
+		{
+			name: "legacy creation date",
+			json: `{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","creation_date":"19/04/2021"}`,
+			expectedUser: User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			expectedError: nil,
+		},
+		{
+			name: "no creation date",
+			json: `{"id":"1311f914-1d4f-40b6-8886-80193265d5a4"}`,
+			expectedUser: User{
+				ID: "1311f914-1d4f-40b6-8886-80193265d5a4",
+			},
+			expectedError: nil,
+		},
+		{
+			name: "deleted user",
+			json: `{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","deleted_at":"2022-01-10T12:00:00Z"}`,
+			expectedUser: User{
+				ID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+				DeletedAt: &deletedAt,
+			},
+			expectedError: nil,
+		},
+		{
+			name: "error - invalid deleted_at",
+			json: `{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","deleted_at":"10/01/2022"}`,
+			expectedUser: User{
+				ID: "1311f914-1d4f-40b6-8886-80193265d5a4",
+			},
+			expectedError: &ValidationError{Fields: []FieldError{
+				{Field: "deleted_at", Message: "must be an RFC 3339 date"},
+			}},
+		},
+		{
+			name: "error - invalid creation date, the other fields are decoded",
+			json: `{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","creation_date":"04/19/2021"}`,
+			expectedUser: User{
+				ID: "1311f914-1d4f-40b6-8886-80193265d5a4",
+			},
+			expectedError: &ValidationError{Fields: []FieldError{
+				{Field: "creation_date", Message: invalidCreationDateMessage},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var user User
+			err := json.Unmarshal([]byte(tc.json), &user)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.True(t, tc.expectedUser.CreationDate.Equal(user.CreationDate))
+			user.CreationDate = tc.expectedUser.CreationDate
+			assert.Equal(t, tc.expectedUser, user)
+		})
+	}
+}
+
+func TestUserUnmarshalJSONUnknownField(t *testing.T) {
+	var user User
+	err := json.Unmarshal([]byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","age":30}`), &user)
+
+	assert.EqualError(t, err, `json: unknown field "age"`)
+}
+
+func TestDecodeUsersData(t *testing.T) {
+	usersData, dateErrs, err := DecodeUsersData([]byte(`[
+		{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","creation_date":"19/04/2021","age":30},
+		{"id":"3e601207-0e80-4e7e-ae87-bb802b16a179","creation_date":"31/02/2020"},
+		{"id":"144bf891-f161-4c9a-8d83-38a275e088a5","creation_date":"2021-06-06T00:00:00Z","deleted_at":"10/01/2022"}
+	]`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []User{
+		{ID: "1311f914-1d4f-40b6-8886-80193265d5a4", CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC)},
+		{ID: "3e601207-0e80-4e7e-ae87-bb802b16a179"},
+		{ID: "144bf891-f161-4c9a-8d83-38a275e088a5", CreationDate: time.Date(2021, time.June, 6, 0, 0, 0, 0, time.UTC)},
+	}, usersData)
+	assert.Equal(t, map[int]error{
+		1: &ValidationError{Fields: []FieldError{
+			{Field: "creation_date", Message: invalidCreationDateMessage + ", got '31/02/2020'"},
+		}},
+		2: &ValidationError{Fields: []FieldError{
+			{Field: "deleted_at", Message: "must be an RFC 3339 date, got '10/01/2022'"},
+		}},
+	}, dateErrs)
+
+	_, _, err = DecodeUsersData([]byte(`[{"id": `))
+	assert.Error(t, err)
+}
+
+func TestUserVersion(t *testing.T) {
+	user := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+	changedUser := user
+	changedUser.FirstName = "Terry"
+
+	assert.Len(t, UserVersion(user), 40)
+	assert.Equal(t, UserVersion(user), UserVersion(user))
+	assert.NotEqual(t, UserVersion(user), UserVersion(changedUser))
+}
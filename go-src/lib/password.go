@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordHashCost is the bcrypt cost used when hashing passwords
+var passwordHashCost = bcrypt.DefaultCost
+
+// HashPassword hashes the plaintext password (bcrypt)
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword checks (in constant time) if the plaintext password matches the hash
+func CheckPassword(hash string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// dummyPasswordHash gets a valid hash that matches no real password,
+// used to spend the same comparison time when there is no hash to compare with
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword(uuid.NewString())
+	})
+	return dummyHash
+}
+
+// IsPasswordHashed checks if the password is already a bcrypt hash
+func IsPasswordHashed(password string) bool {
+	_, err := bcrypt.Cost([]byte(password))
+	return err == nil
+}
+
+// HashUsersPasswords hashes (in place) the users passwords that are still in plaintext,
+// returns the number of hashed passwords
+func HashUsersPasswords(users []User) (int, error) {
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		hashed   int
+		firstErr error
+	)
+
+	// hashing is CPU bound and slow by design, so spreading it over all CPUs
+	indexes := make(chan int)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				hash, err := HashPassword(users[i].Password)
+
+				mutex.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if err == nil {
+					users[i].Password = hash
+					hashed++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for i := range users {
+		if !IsPasswordHashed(users[i].Password) {
+			indexes <- i
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	return hashed, firstErr
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	// hashing with the minimum cost, tests don't need the real (slow) one
+	passwordHashCost = bcrypt.MinCost
+
+	os.Exit(m.Run())
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("5YLItbmdkfC1")
+	require.NoError(t, err)
+
+	assert.NotEqual(t, "5YLItbmdkfC1", hash)
+	assert.True(t, IsPasswordHashed(hash))
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(hash), []byte("5YLItbmdkfC1")))
+}
+
+func TestIsPasswordHashed(t *testing.T) {
+	testCases := []struct {
+		name           string
+		password       string
+		expectedHashed bool
+	}{
+		{
+			name:           "bcrypt hash",
+			password:       "$2a$04$/Wv9d.olqIFBGaMj3SR4O.Oq4GgM5r1urmuxGQNFdE6gcd90wqE4a",
+			expectedHashed: true,
+		},
+		{
+			name:           "plaintext",
+			password:       "5YLItbmdkfC1",
+			expectedHashed: false,
+		},
+		{
+			name:           "plaintext with hash prefix",
+			password:       "$2a$10$notahash",
+			expectedHashed: false,
+		},
+		{
+			name:           "empty",
+			password:       "",
+			expectedHashed: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedHashed, IsPasswordHashed(tc.password))
+		})
+	}
+}
+
+func TestHashUsersPasswords(t *testing.T) {
+	alreadyHashed, err := HashPassword("Vae1mnI")
+	require.NoError(t, err)
+
+	users := []User{
+		{ID: "144bf891-f161-4c9a-8d83-38a275e088a5", Password: "rKJKin"},
+		{ID: "1311f914-1d4f-40b6-8886-80193265d5a4", Password: "5YLItbmdkfC1"},
+		{ID: "3e601207-0e80-4e7e-ae87-bb802b16a179", Password: alreadyHashed},
+	}
+
+	hashed, err := HashUsersPasswords(users)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, hashed)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(users[0].Password), []byte("rKJKin")))
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(users[1].Password), []byte("5YLItbmdkfC1")))
+	// already hashed passwords are kept untouched
+	assert.Equal(t, alreadyHashed, users[2].Password)
+}
@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"context"
+	"time"
+)
+
+// RevisionAction represents the kind of change recorded by a user revision
+type RevisionAction string
+
+const (
+	// RevisionCreated means the user was created (by the API or an import)
+	RevisionCreated RevisionAction = "created"
+	// RevisionUpdated means the user data was changed (replaced, patched or imported)
+	RevisionUpdated RevisionAction = "updated"
+	// RevisionDeleted means the user was marked as deleted (soft delete)
+	RevisionDeleted RevisionAction = "deleted"
+	// RevisionRestored means the deleted user was restored
+	RevisionRestored RevisionAction = "restored"
+	// RevisionPurged means the deleted user was permanently removed
+	RevisionPurged RevisionAction = "purged"
+	// RevisionErased means the user personal data was erased (anonymized), its values are not recorded
+	RevisionErased RevisionAction = "erased"
+)
+
+// systemActor is the actor of the changes made without an actor in the context (e.g. the purge job)
+const systemActor = "system"
+
+type (
+	// UserRevision represents an immutable record of a change of a user: who made it, when, and the changed fields
+	UserRevision struct {
+		UserID string `json:"user_id"`
+		// Number is the revision position in the user history (starting at 1), set by the revisions repo
+		Number  int            `json:"number"`
+		Action  RevisionAction `json:"action"`
+		Actor   string         `json:"actor"`
+		Time    time.Time      `json:"time"`
+		Changes []FieldChange  `json:"changes"`
+	}
+
+	// FieldChange represents the change of a user field (JSON name), with its values before and after it,
+	// the password values are never recorded (only that it changed)
+	FieldChange struct {
+		Field string `json:"field"`
+		From  string `json:"from"`
+		To    string `json:"to"`
+	}
+
+	// actorContextKey is the context key of the actor making the changes
+	actorContextKey struct{}
+)
+
+// WithActor returns a copy of the context with the actor making the changes (e.g. "admin"), recorded in the user revisions
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// ContextActor gets the actor making the changes from the context, the system actor if not set
+func ContextActor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorContextKey{}).(string)
+	if actor == "" {
+		return systemActor
+	}
+	return actor
+}
+
+// newUserRevision creates the revision of the change from the user before it to the user after it (empty users if they don't exist)
+func newUserRevision(ctx context.Context, action RevisionAction, before User, after User) UserRevision {
+	userID := after.ID
+	if userID == "" {
+		userID = before.ID
+	}
+
+	return UserRevision{
+		UserID:  userID,
+		Action:  action,
+		Actor:   ContextActor(ctx),
+		Time:    timeNow().UTC(),
+		Changes: diffUsers(before, after),
+	}
+}
+
+// diffUsers gets the changed fields from the user before the change to the user after it
+func diffUsers(before User, after User) []FieldChange {
+	changes := []FieldChange{}
+	for _, field := range UserCSVColumns {
+		from, to := revisionValue(before, field), revisionValue(after, field)
+		if from == to {
+			continue
+		}
+
+		if field == "password" {
+			from, to = "", ""
+		}
+		changes = append(changes, FieldChange{Field: field, From: from, To: to})
+	}
+	return changes
+}
+
+// revertChanges gets the user as it was before the changes (the password is kept, as its values are not recorded)
+func revertChanges(user User, changes []FieldChange) (User, error) {
+	values := make([]string, len(UserCSVColumns))
+	for i, field := range UserCSVColumns {
+		values[i] = revisionValue(user, field)
+	}
+
+	for _, change := range changes {
+		for i, field := range UserCSVColumns {
+			if field == change.Field && field != "password" {
+				values[i] = change.From
+			}
+		}
+	}
+
+	return userFromCSVRecord(UserCSVColumns, values)
+}
+
+// revisionValue gets the user field value recorded in the revisions (empty for a missing creation date)
+func revisionValue(user User, field string) string {
+	if field == "creation_date" && user.CreationDate.IsZero() {
+		return ""
+	}
+	return userColumnValue(user, field, false)
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffUsers(t *testing.T) {
+	deletedAt := time.Date(2022, time.January, 10, 12, 0, 0, 0, time.UTC)
+	user := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		Password:     "$2a$10$hash",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+	changedUser := user
+	changedUser.Email = "terry@feedburner.com"
+	changedUser.Password = "$2a$10$otherhash"
+	deletedUser := user
+	deletedUser.DeletedAt = &deletedAt
+
+	testCases := []struct {
+		name            string
+		before          User
+		after           User
+		expectedChanges []FieldChange
+	}{
+		{
+			name:   "created",
+			before: User{},
+			after:  user,
+			expectedChanges: []FieldChange{
+				{Field: "id", From: "", To: "1311f914-1d4f-40b6-8886-80193265d5a4"},
+				{Field: "first_name", From: "", To: "Terrence"},
+				{Field: "last_name", From: "", To: "Trillow"},
+				{Field: "email", From: "", To: "ttrillow1@feedburner.com"},
+				{Field: "password", From: "", To: ""},
+				{Field: "ip_address", From: "", To: "63.119.6.98"},
+				{Field: "creation_date", From: "", To: "2021-04-19T00:00:00Z"},
+			},
+		},
+		{
+			name:   "updated - the password values are not recorded",
+			before: user,
+			after:  changedUser,
+			expectedChanges: []FieldChange{
+				{Field: "email", From: "ttrillow1@feedburner.com", To: "terry@feedburner.com"},
+				{Field: "password", From: "", To: ""},
+			},
+		},
+		{
+			name:   "deleted",
+			before: user,
+			after:  deletedUser,
+			expectedChanges: []FieldChange{
+				{Field: "deleted_at", From: "", To: "2022-01-10T12:00:00Z"},
+			},
+		},
+		{
+			name:            "not changed",
+			before:          user,
+			after:           user,
+			expectedChanges: []FieldChange{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedChanges, diffUsers(tc.before, tc.after))
+		})
+	}
+}
+
+func TestRevertChanges(t *testing.T) {
+	user := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "terry@feedburner.com",
+		Password:     "$2a$10$otherhash",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+	previousUser := user
+	previousUser.Email = "ttrillow1@feedburner.com"
+	previousUser.Password = "$2a$10$hash"
+
+	revertedUser, err := revertChanges(user, diffUsers(previousUser, user))
+	assert.NoError(t, err)
+
+	// the password is kept, as its values are not recorded
+	previousUser.Password = user.Password
+	assert.Equal(t, previousUser, revertedUser)
+
+	revertedUser, err = revertChanges(user, diffUsers(User{}, user))
+	assert.NoError(t, err)
+	assert.Equal(t, User{Password: user.Password}, revertedUser)
+}
+
+func TestNewUserRevision(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2022, time.January, 10, 12, 0, 0, 0, time.FixedZone("BRT", -3*60*60))
+	}
+	defer func() { timeNow = time.Now }()
+
+	before := User{ID: "1311f914-1d4f-40b6-8886-80193265d5a4", FirstName: "Terrence"}
+	after := User{ID: "1311f914-1d4f-40b6-8886-80193265d5a4", FirstName: "Terry"}
+
+	revision := newUserRevision(WithActor(context.Background(), "admin"), RevisionUpdated, before, after)
+	assert.Equal(t, UserRevision{
+		UserID:  "1311f914-1d4f-40b6-8886-80193265d5a4",
+		Action:  RevisionUpdated,
+		Actor:   "admin",
+		Time:    time.Date(2022, time.January, 10, 15, 0, 0, 0, time.UTC),
+		Changes: []FieldChange{{Field: "first_name", From: "Terrence", To: "Terry"}},
+	}, revision)
+
+	// purged user, without actor in the context
+	revision = newUserRevision(context.Background(), RevisionPurged, before, User{})
+	assert.Equal(t, "1311f914-1d4f-40b6-8886-80193265d5a4", revision.UserID)
+	assert.Equal(t, systemActor, revision.Actor)
+}
@@ -2,34 +2,514 @@ package lib
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// LegacyCreationDateLayout is the layout of the legacy users creation date (dd/mm/yyyy),
+	// still accepted when reading the users data and returned on demand
+	LegacyCreationDateLayout = "02/01/2006"
 )
 
+// timeNow returns the current time (replaceable in tests)
+var timeNow = time.Now
+
 type (
 	usersRepo interface {
-		GetUsers(ctx context.Context, limit int, offset int) ([]User, error)
+		GetUsers(ctx context.Context, query UsersQuery) (UsersPage, error)
 		GetUser(ctx context.Context, userID string) (User, error)
+		GetUsersByIDs(ctx context.Context, userIDs []string) (UsersBatch, error)
+		SearchUsers(ctx context.Context, query UsersSearchQuery) (UsersPage, error)
+		GetUserByEmail(ctx context.Context, email string) (User, error)
+		CreateUser(ctx context.Context, user User) (User, error)
+		// UpdateUser replaces the user, if its current version is the expected one (any version if empty),
+		// failing with ErrPreconditionFailed otherwise
+		UpdateUser(ctx context.Context, user User, version string) (User, error)
+		DeleteUser(ctx context.Context, userID string) error
+		UpsertUsers(ctx context.Context, users []User) error
+	}
+
+	// usersSnapshotRepo is implemented by the repos holding the users data in memory (optional), that get all the users
+	// matching the filter at once, instead of filtering and sorting the whole data again for each batch
+	usersSnapshotRepo interface {
+		// GetUsersSnapshot gets all the users matching the filter, ordered by the sorting and then by ID
+		GetUsersSnapshot(ctx context.Context, filter UsersFilter, sort []SortField) ([]User, error)
+	}
+
+	revisionsRepo interface {
+		// AddRevisions appends the revisions to the users histories, numbering them
+		AddRevisions(ctx context.Context, revisions []UserRevision) error
+		// GetRevisions gets the user history, in order (empty if there is none)
+		GetRevisions(ctx context.Context, userID string) ([]UserRevision, error)
+		// ReplaceRevisions replaces the whole user history (e.g. to erase the personal values recorded in it)
+		ReplaceRevisions(ctx context.Context, userID string, revisions []UserRevision) error
+	}
+
+	auditRepo interface {
+		// AddAuditEntry appends the entry to the audit trail
+		AddAuditEntry(ctx context.Context, entry AuditEntry) error
+		// GetAuditEntries gets the audit entries of the user, in order (empty if there are none)
+		GetAuditEntries(ctx context.Context, userID string) ([]AuditEntry, error)
 	}
 
 	usersService struct {
 		usersRepo
+		revisionsRepo revisionsRepo
+		auditRepo     auditRepo
+		loginAttempts *loginAttempts
+		// writeMutex serializes the conditional writes (version or email uniqueness check and write) with the other writes
+		writeMutex sync.Mutex
 	}
 )
 
-// NewUsersRepo creates a new users service, receives the users repo as parameter
-func NewUsersService(usersRepo usersRepo) *usersService {
+// NewUsersRepo creates a new users service, receives the users repo, the revisions repo (users change history),
+// the audit repo (audit trail of the sensitive operations) and the login lockout configuration as parameters:
+// - maxFailedLogins: failed authentications allowed before the account is locked (non-positive disables the lockout)
+// - loginLockoutDuration: duration of the account lock
+func NewUsersService(usersRepo usersRepo, revisionsRepo revisionsRepo, auditRepo auditRepo, maxFailedLogins int, loginLockoutDuration time.Duration) *usersService {
 	return &usersService{
-		usersRepo,
+		usersRepo:     usersRepo,
+		revisionsRepo: revisionsRepo,
+		auditRepo:     auditRepo,
+		loginAttempts: newLoginAttempts(maxFailedLogins, loginLockoutDuration),
 	}
 }
 
-// GetUsers gets users based on pagination (limit and offset)
-func (s *usersService) GetUsers(ctx context.Context, limit int, offset int) ([]User, error) {
+// GetUsers gets users based on pagination (limit and offset, or cursor), filters and sorting
+func (s *usersService) GetUsers(ctx context.Context, query UsersQuery) (UsersPage, error) {
 	// only forwarding request to repo, no extra logic required for now
-	return s.usersRepo.GetUsers(ctx, limit, offset)
+	return s.usersRepo.GetUsers(ctx, query)
 }
 
-// GetUser gets user based on its ID
-func (s *usersService) GetUser(ctx context.Context, userID string) (User, error) {
+// GetUser gets user based on its ID, the deleted (soft deleted) users are not found unless they are included
+func (s *usersService) GetUser(ctx context.Context, userID string, includeDeleted bool) (User, error) {
+	user, err := s.usersRepo.GetUser(ctx, userID)
+	if err != nil {
+		return User{}, err
+	}
+	if user.IsDeleted() && !includeDeleted {
+		return User{}, ErrNotFound
+	}
+
+	return user, nil
+}
+
+// GetUsersByIDs gets the users based on their IDs, the duplicated IDs are ignored (only the first one counts)
+// and the deleted (soft deleted) users are reported as missing
+func (s *usersService) GetUsersByIDs(ctx context.Context, userIDs []string) (UsersBatch, error) {
+	uniqueIDs := make([]string, 0, len(userIDs))
+	seen := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if !seen[userID] {
+			seen[userID] = true
+			uniqueIDs = append(uniqueIDs, userID)
+		}
+	}
+
+	batch, err := s.usersRepo.GetUsersByIDs(ctx, uniqueIDs)
+	if err != nil {
+		return UsersBatch{}, err
+	}
+
+	users := make([]User, 0, len(batch.Users))
+	for _, user := range batch.Users {
+		if user.IsDeleted() {
+			batch.MissingIDs = append(batch.MissingIDs, user.ID)
+			continue
+		}
+		users = append(users, user)
+	}
+	batch.Users = users
+
+	return batch, nil
+}
+
+// SearchUsers gets the users matching the full-text search, ordered by relevance
+func (s *usersService) SearchUsers(ctx context.Context, query UsersSearchQuery) (UsersPage, error) {
 	// only forwarding request to repo, no extra logic required for now
-	return s.usersRepo.GetUser(ctx, userID)
+	return s.usersRepo.SearchUsers(ctx, query)
+}
+
+// CreateUser creates a new user, its ID and creation date are generated by the service and its password is hashed
+func (s *usersService) CreateUser(ctx context.Context, user User) (User, error) {
+	err := validateRequiredFields(user)
+	if err != nil {
+		return User{}, err
+	}
+
+	user.ID = uuid.NewString()
+	user.CreationDate = newCreationDate()
+	user.DeletedAt = nil
+
+	// hashing is slow by design, so it's done before serializing the writes
+	user.Password, err = HashPassword(user.Password)
+	if err != nil {
+		return User{}, err
+	}
+
+	// the email must still be unused when the user is created
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	err = s.validateUser(ctx, user)
+	if err != nil {
+		return User{}, err
+	}
+
+	user, err = s.usersRepo.CreateUser(ctx, user)
+	if err != nil {
+		return User{}, err
+	}
+
+	err = s.recordRevision(ctx, RevisionCreated, User{}, user)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// UpdateUser replaces the user data based on its ID (not deleted), the creation date is kept unchanged,
+// the current user version must be one of the expected versions (any version if empty)
+func (s *usersService) UpdateUser(ctx context.Context, user User, versions []string) (User, error) {
+	err := validateRequiredFields(user)
+	if err != nil {
+		return User{}, err
+	}
+
+	// hashing is slow by design, so it's done before serializing the writes
+	user.Password, err = HashPassword(user.Password)
+	if err != nil {
+		return User{}, err
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	currentUser, err := s.GetUser(ctx, user.ID, false)
+	if err != nil {
+		return User{}, err
+	}
+	err = checkUserVersion(currentUser, versions)
+	if err != nil {
+		return User{}, err
+	}
+	user.CreationDate = currentUser.CreationDate
+	user.DeletedAt = currentUser.DeletedAt
+
+	err = s.validateUser(ctx, user)
+	if err != nil {
+		return User{}, err
+	}
+
+	// the user must not have been modified since it was got (e.g. by another instance sharing the database)
+	user, err = s.usersRepo.UpdateUser(ctx, user, UserVersion(currentUser))
+	if err != nil {
+		return User{}, err
+	}
+
+	err = s.recordRevision(ctx, RevisionUpdated, currentUser, user)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// PatchUser partially updates the user data based on its ID (not deleted), only the fields present in the patch are changed,
+// the current user version must be one of the expected versions (any version if empty)
+func (s *usersService) PatchUser(ctx context.Context, userID string, patch UserPatch, versions []string) (User, error) {
+	// the current password is already hashed, only a new one must be (before serializing the writes, as it's slow by design)
+	var passwordHash string
+	if patch.Password != nil && *patch.Password != "" {
+		var err error
+		passwordHash, err = HashPassword(*patch.Password)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	currentUser, err := s.GetUser(ctx, userID, false)
+	if err != nil {
+		return User{}, err
+	}
+	err = checkUserVersion(currentUser, versions)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := patch.Apply(currentUser)
+
+	err = validateRequiredFields(user)
+	if err != nil {
+		return User{}, err
+	}
+
+	err = s.validateUser(ctx, user)
+	if err != nil {
+		return User{}, err
+	}
+
+	if passwordHash != "" {
+		user.Password = passwordHash
+	}
+
+	user, err = s.usersRepo.UpdateUser(ctx, user, UserVersion(currentUser))
+	if err != nil {
+		return User{}, err
+	}
+
+	err = s.recordRevision(ctx, RevisionUpdated, currentUser, user)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// DeleteUser marks the user as deleted (soft delete) based on its ID, it's kept (hidden) until it's restored or purged,
+// the current user version must be one of the expected versions (any version if empty)
+func (s *usersService) DeleteUser(ctx context.Context, userID string, versions []string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	user, err := s.GetUser(ctx, userID, false)
+	if err != nil {
+		return err
+	}
+	err = checkUserVersion(user, versions)
+	if err != nil {
+		return err
+	}
+
+	deletedUser := user
+	deletedAt := timeNow().UTC().Truncate(time.Second)
+	deletedUser.DeletedAt = &deletedAt
+
+	deletedUser, err = s.usersRepo.UpdateUser(ctx, deletedUser, UserVersion(user))
+	if err != nil {
+		return err
+	}
+
+	return s.recordRevision(ctx, RevisionDeleted, user, deletedUser)
+}
+
+// RestoreUser restores a deleted (soft deleted) user based on its ID
+func (s *usersService) RestoreUser(ctx context.Context, userID string) (User, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	user, err := s.usersRepo.GetUser(ctx, userID)
+	if err != nil {
+		return User{}, err
+	}
+	if !user.IsDeleted() {
+		return User{}, fmt.Errorf("user '%s' is not deleted: %w", userID, ErrPreconditionFailed)
+	}
+
+	restoredUser := user
+	restoredUser.DeletedAt = nil
+
+	restoredUser, err = s.usersRepo.UpdateUser(ctx, restoredUser, UserVersion(user))
+	if err != nil {
+		return User{}, err
+	}
+
+	err = s.recordRevision(ctx, RevisionRestored, user, restoredUser)
+	if err != nil {
+		return User{}, err
+	}
+
+	return restoredUser, nil
+}
+
+// PurgeDeletedUsers permanently removes the users deleted (soft deleted) for longer than the retention period,
+// their personal values recorded in the history are anonymized (as by EraseUser), returns the number of purged users
+func (s *usersService) PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int, error) {
+	deletedBefore := timeNow().Add(-retention)
+	isExpired := func(user User) bool {
+		return user.IsDeleted() && user.DeletedAt.Before(deletedBefore)
+	}
+
+	// selecting the deleted users at once (e.g. a single pass over the in-memory data)
+	var userIDs []string
+	err := s.ExportUsers(ctx, UsersFilter{OnlyDeleted: true}, nil, func(user User) error {
+		if isExpired(user) {
+			userIDs = append(userIDs, user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	purged := 0
+	for _, userID := range userIDs {
+		// the user could have been restored in the meantime
+		user, err := s.usersRepo.GetUser(ctx, userID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		if !isExpired(user) {
+			continue
+		}
+
+		err = s.usersRepo.DeleteUser(ctx, userID)
+		if err != nil {
+			return purged, err
+		}
+		purged++
+
+		// the purged user personal values are no longer kept, in its history neither
+		erasedUser := anonymizeUser(user)
+		err = s.eraseHistory(ctx, userID, erasedUser)
+		if err != nil {
+			return purged, err
+		}
+		err = s.recordRevision(ctx, RevisionPurged, erasedUser, User{})
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	return purged, nil
+}
+
+// Authenticate checks the user credentials (email and password), returning the user if they are valid,
+// the failed attempts are counted per account (normalized email), locking it after too many of them
+func (s *usersService) Authenticate(ctx context.Context, email string, password string) (User, error) {
+	email = strings.TrimSpace(email)
+	account := accountKey(email)
+
+	if s.loginAttempts.isLocked(account) {
+		return User{}, ErrTooManyAttempts
+	}
+
+	user, err := s.usersRepo.GetUserByEmail(ctx, email)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return User{}, err
+	}
+	// the deleted users cannot authenticate
+	if err == nil && user.IsDeleted() {
+		err = ErrNotFound
+	}
+
+	// comparing against a dummy hash for unknown emails as well,
+	// so the response time doesn't reveal which emails exist
+	passwordHash := user.Password
+	if errors.Is(err, ErrNotFound) {
+		passwordHash = dummyPasswordHash()
+	}
+
+	if !CheckPassword(passwordHash, password) || errors.Is(err, ErrNotFound) {
+		s.loginAttempts.recordFailure(account)
+		return User{}, ErrUnauthorized
+	}
+
+	s.loginAttempts.recordSuccess(account)
+
+	return user, nil
+}
+
+// recordRevision records the revision of the user change (from the user before it to the user after it),
+// nothing is recorded if no field changed
+func (s *usersService) recordRevision(ctx context.Context, action RevisionAction, before User, after User) error {
+	revision := newUserRevision(ctx, action, before, after)
+	if len(revision.Changes) == 0 {
+		return nil
+	}
+
+	err := s.revisionsRepo.AddRevisions(ctx, []UserRevision{revision})
+	if err != nil {
+		return fmt.Errorf("recording user '%s' revision: %w", revision.UserID, err)
+	}
+	return nil
+}
+
+// checkUserVersion checks that the current user version is one of the expected versions (any version if empty)
+func checkUserVersion(user User, versions []string) error {
+	if len(versions) == 0 {
+		return nil
+	}
+
+	currentVersion := UserVersion(user)
+	for _, version := range versions {
+		if version == currentVersion {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("user '%s' was modified (version mismatch): %w", user.ID, ErrPreconditionFailed)
+}
+
+// newCreationDate gets the creation date of a new user (the current time in UTC, with seconds precision)
+func newCreationDate() time.Time {
+	return timeNow().UTC().Truncate(time.Second)
+}
+
+// validateRequiredFields checks that the user fields that cannot be empty are filled,
+// returning a *ValidationError with all the missing fields
+func validateRequiredFields(user User) error {
+	requiredFields := []struct {
+		name  string
+		value string
+	}{
+		{"first_name", user.FirstName},
+		{"last_name", user.LastName},
+		{"email", user.Email},
+		{"password", user.Password},
+	}
+
+	validationErr := &ValidationError{}
+	for _, field := range requiredFields {
+		if field.value == "" {
+			validationErr.add(field.name, requiredMessage)
+		}
+	}
+
+	return validationErr.orNil()
+}
+
+// validateUser checks the user fields format and that its email is not used by another user,
+// returning a *ValidationError with all the invalid fields
+func (s *usersService) validateUser(ctx context.Context, user User) error {
+	validationErr := validateUserFields(user)
+
+	emailUsed, err := s.isEmailUsedByOther(ctx, user)
+	if err != nil {
+		return err
+	}
+	if emailUsed {
+		validationErr.add("email", emailUsedMessage)
+	}
+
+	return validationErr.orNil()
+}
+
+// isEmailUsedByOther checks if the user email is already used by another user (case-insensitive)
+func (s *usersService) isEmailUsedByOther(ctx context.Context, user User) (bool, error) {
+	existingUser, err := s.usersRepo.GetUserByEmail(ctx, user.Email)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return existingUser.ID != user.ID, nil
 }
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"context"
+)
+
+// exportBatchSize is the number of users got from the repo at once when exporting
+const exportBatchSize = 1000
+
+// ExportUsers calls the function for each user matching the filter, ordered by the sorting and then by ID,
+// the users are got from the repo in batches (cursor pagination), so the whole data is never copied at once,
+// or as a single snapshot from the in-memory repos (see usersSnapshotRepo).
+// It stops at the first error returned by the function
+func (s *usersService) ExportUsers(ctx context.Context, filter UsersFilter, sort []SortField, fn func(user User) error) error {
+	if snapshotRepo, ok := s.usersRepo.(usersSnapshotRepo); ok {
+		users, err := snapshotRepo.GetUsersSnapshot(ctx, filter, sort)
+		if err != nil {
+			return err
+		}
+
+		for i, user := range users {
+			// the export can take long, stopping if the caller gave up (checked once per batch)
+			if i%exportBatchSize == 0 {
+				err = ctx.Err()
+				if err != nil {
+					return err
+				}
+			}
+
+			err = fn(user)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	cursor := &UsersCursor{Sort: sort}
+
+	for cursor != nil {
+		// the export can take long, stopping if the caller gave up (e.g. the client disconnected)
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		page, err := s.usersRepo.GetUsers(ctx, UsersQuery{
+			Limit:  exportBatchSize,
+			Cursor: cursor,
+			Filter: filter,
+			Sort:   sort,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, user := range page.Users {
+			err = fn(user)
+			if err != nil {
+				return err
+			}
+		}
+
+		cursor = page.NextCursor
+	}
+
+	return nil
+}
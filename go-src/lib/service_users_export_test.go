@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportUsers(t *testing.T) {
+	sort := []SortField{{Field: "first_name"}}
+	filter := UsersFilter{EmailDomain: "phoca.cz"}
+
+	firstBatch := make([]User, exportBatchSize)
+	for i := range firstBatch {
+		firstBatch[i] = User{ID: "1"}
+	}
+	lastUser := User{ID: "2"}
+	nextCursor := NewUsersCursor(firstBatch[exportBatchSize-1], sort)
+
+	testCases := []struct {
+		name          string
+		fnError       error
+		expectedCalls int
+		expectedError error
+	}{
+		{
+			name:          "success - all the batches",
+			fnError:       nil,
+			expectedCalls: exportBatchSize + 1,
+			expectedError: nil,
+		},
+		{
+			name:          "function error stops the export",
+			fnError:       errors.New("write error"),
+			expectedCalls: 1,
+			expectedError: errors.New("write error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+			mockUsersRepo.On("GetUsers", context.Background(), UsersQuery{
+				Limit:  exportBatchSize,
+				Cursor: &UsersCursor{Sort: sort},
+				Filter: filter,
+				Sort:   sort,
+			}).Return(UsersPage{Users: firstBatch, NextCursor: &nextCursor}, nil)
+			mockUsersRepo.On("GetUsers", context.Background(), UsersQuery{
+				Limit:  exportBatchSize,
+				Cursor: &nextCursor,
+				Filter: filter,
+				Sort:   sort,
+			}).Return(UsersPage{Users: []User{lastUser}}, nil)
+
+			calls := 0
+			usersSvc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+			err := usersSvc.ExportUsers(context.Background(), filter, sort, func(user User) error {
+				calls++
+				return tc.fnError
+			})
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedCalls, calls)
+		})
+	}
+}
+
+// mockUsersSnapshotRepo is a users repo getting the users snapshots (see usersSnapshotRepo)
+type mockUsersSnapshotRepo struct {
+	mockUsersRepo
+}
+
+func (m *mockUsersSnapshotRepo) GetUsersSnapshot(ctx context.Context, filter UsersFilter, sort []SortField) ([]User, error) {
+	args := m.Called(ctx, filter, sort)
+	return args.Get(0).([]User), args.Error(1)
+}
+
+func TestExportUsersSnapshot(t *testing.T) {
+	sort := []SortField{{Field: "first_name"}}
+	filter := UsersFilter{EmailDomain: "phoca.cz"}
+	users := make([]User, exportBatchSize+1)
+
+	mockUsersRepo := new(mockUsersSnapshotRepo)
+	mockUsersRepo.On("GetUsersSnapshot", context.Background(), filter, sort).Return(users, nil).Once()
+
+	calls := 0
+	usersSvc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+	err := usersSvc.ExportUsers(context.Background(), filter, sort, func(user User) error {
+		calls++
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, len(users), calls)
+	mockUsersRepo.AssertExpectations(t) // no batches got
+}
+
+func TestExportUsersCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	usersSvc := NewUsersService(new(mockUsersRepo), newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+	err := usersSvc.ExportUsers(ctx, UsersFilter{}, nil, func(user User) error {
+		return nil
+	})
+
+	assert.Equal(t, context.Canceled, err)
+
+	// the snapshots too
+	mockUsersRepo := new(mockUsersSnapshotRepo)
+	mockUsersRepo.On("GetUsersSnapshot", ctx, UsersFilter{}, []SortField(nil)).Return([]User{{ID: "1"}}, nil)
+
+	usersSvc = NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+	err = usersSvc.ExportUsers(ctx, UsersFilter{}, nil, func(user User) error {
+		return nil
+	})
+
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,173 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// erasedFirstName and erasedLastName replace the names of the erased users
+	erasedFirstName = "Erased"
+	erasedLastName  = "User"
+	// erasedEmailDomain is the domain of the emails replacing the erased users emails (reserved, never deliverable)
+	erasedEmailDomain = "erased.invalid"
+)
+
+// erasedFields are the user personal fields (JSON names) anonymized by the erasure
+var erasedFields = map[string]bool{
+	"first_name": true,
+	"last_name":  true,
+	"email":      true,
+	"ip_address": true,
+}
+
+// UserDataExport represents all the data held about a user (right of access): the user itself (even if deleted),
+// its change history and its audit entries (including the export itself)
+type UserDataExport struct {
+	ExportedAt   time.Time
+	User         User
+	History      []UserRevision
+	AuditEntries []AuditEntry
+}
+
+// ExportUserData gets all the data held about the user based on its ID (deleted users included),
+// the export is recorded in the audit trail
+func (s *usersService) ExportUserData(ctx context.Context, userID string) (UserDataExport, error) {
+	user, err := s.usersRepo.GetUser(ctx, userID)
+	if err != nil {
+		return UserDataExport{}, err
+	}
+
+	revisions, err := s.revisionsRepo.GetRevisions(ctx, userID)
+	if err != nil {
+		return UserDataExport{}, err
+	}
+
+	// recorded before getting the audit entries, so the export includes it
+	entry, err := s.recordAuditEntry(ctx, AuditDataExported, userID)
+	if err != nil {
+		return UserDataExport{}, err
+	}
+
+	entries, err := s.auditRepo.GetAuditEntries(ctx, userID)
+	if err != nil {
+		return UserDataExport{}, err
+	}
+
+	return UserDataExport{
+		ExportedAt:   entry.Time,
+		User:         user,
+		History:      revisions,
+		AuditEntries: entries,
+	}, nil
+}
+
+// EraseUser irreversibly anonymizes the user personal data (names, email and IP address) based on its ID (deleted users included),
+// the ID is kept (so the references to the user remain valid), its password is replaced by a random one,
+// and the personal values recorded in its history are replaced by the anonymized ones,
+// the erasure is recorded in the audit trail
+func (s *usersService) EraseUser(ctx context.Context, userID string) (User, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	user, err := s.usersRepo.GetUser(ctx, userID)
+	if err != nil {
+		return User{}, err
+	}
+
+	erasedUser := anonymizeUser(user)
+	// no one knows the new password, so the erased user can no longer authenticate
+	erasedUser.Password, err = HashPassword(uuid.NewString())
+	if err != nil {
+		return User{}, err
+	}
+
+	erasedUser, err = s.usersRepo.UpdateUser(ctx, erasedUser, UserVersion(user))
+	if err != nil {
+		return User{}, err
+	}
+
+	err = s.eraseHistory(ctx, userID, erasedUser)
+	if err != nil {
+		return User{}, err
+	}
+
+	revision := newUserRevision(ctx, RevisionErased, user, erasedUser)
+	revision.Changes = eraseChanges(revision.Changes, User{})
+	err = s.revisionsRepo.AddRevisions(ctx, []UserRevision{revision})
+	if err != nil {
+		return User{}, fmt.Errorf("recording user '%s' revision: %w", userID, err)
+	}
+
+	_, err = s.recordAuditEntry(ctx, AuditUserErased, userID)
+	if err != nil {
+		return User{}, err
+	}
+
+	return erasedUser, nil
+}
+
+// recordAuditEntry records the operation on the user in the audit trail, returning the recorded entry
+func (s *usersService) recordAuditEntry(ctx context.Context, action AuditAction, userID string) (AuditEntry, error) {
+	entry := newAuditEntry(ctx, action, userID)
+
+	err := s.auditRepo.AddAuditEntry(ctx, entry)
+	if err != nil {
+		return AuditEntry{}, fmt.Errorf("recording user '%s' audit entry: %w", userID, err)
+	}
+	return entry, nil
+}
+
+// anonymizeUser gets a copy of the user with the personal values (names, email and IP address) anonymized
+func anonymizeUser(user User) User {
+	user.FirstName = erasedFirstName
+	user.LastName = erasedLastName
+	user.Email = fmt.Sprintf("%s@%s", user.ID, erasedEmailDomain)
+	user.IPAddress = ""
+	return user
+}
+
+// eraseHistory replaces the personal values recorded in the user history by the erased user ones
+func (s *usersService) eraseHistory(ctx context.Context, userID string, erasedUser User) error {
+	revisions, err := s.revisionsRepo.GetRevisions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.revisionsRepo.ReplaceRevisions(ctx, userID, eraseRevisions(revisions, erasedUser))
+	if err != nil {
+		return fmt.Errorf("erasing user '%s' history: %w", userID, err)
+	}
+	return nil
+}
+
+// eraseRevisions gets a copy of the revisions with the personal values replaced by the erased user ones
+func eraseRevisions(revisions []UserRevision, erasedUser User) []UserRevision {
+	erasedRevisions := make([]UserRevision, len(revisions))
+	for i, revision := range revisions {
+		revision.Changes = eraseChanges(revision.Changes, erasedUser)
+		erasedRevisions[i] = revision
+	}
+	return erasedRevisions
+}
+
+// eraseChanges gets a copy of the changes with the personal values (not empty) replaced by the erased user ones
+func eraseChanges(changes []FieldChange, erasedUser User) []FieldChange {
+	erasedChanges := make([]FieldChange, len(changes))
+	for i, change := range changes {
+		if erasedFields[change.Field] {
+			erasedValue := revisionValue(erasedUser, change.Field)
+			if change.From != "" {
+				change.From = erasedValue
+			}
+			if change.To != "" {
+				change.To = erasedValue
+			}
+		}
+		erasedChanges[i] = change
+	}
+	return erasedChanges
+}
@@ -0,0 +1,224 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExportUserData(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2022, time.April, 1, 12, 0, 0, 0, time.UTC)
+	}
+	defer func() { timeNow = time.Now }()
+
+	user, revisions := testUserHistory()
+	exportEntry := AuditEntry{
+		UserID: user.ID,
+		Action: AuditDataExported,
+		Actor:  "admin",
+		Time:   timeNow(),
+	}
+
+	testCases := []struct {
+		name           string
+		repoResponse   User
+		repoError      error
+		auditError     error
+		expectedExport UserDataExport
+		expectedError  error
+	}{
+		{
+			name:         "base case - deleted user included",
+			repoResponse: user,
+			expectedExport: UserDataExport{
+				ExportedAt:   timeNow(),
+				User:         user,
+				History:      revisions,
+				AuditEntries: []AuditEntry{exportEntry},
+			},
+			expectedError: nil,
+		},
+		{
+			name:           "not found",
+			repoError:      ErrNotFound,
+			expectedExport: UserDataExport{},
+			expectedError:  ErrNotFound,
+		},
+		{
+			name:           "audit error",
+			repoResponse:   user,
+			auditError:     fmt.Errorf("repo error"),
+			expectedExport: UserDataExport{},
+			expectedError:  fmt.Errorf("recording user '1311f914-1d4f-40b6-8886-80193265d5a4' audit entry: %w", fmt.Errorf("repo error")),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+			mockRevisionsRepo := new(mockRevisionsRepo)
+			mockAuditRepo := new(mockAuditRepo)
+
+			ctx := WithActor(context.Background(), "admin")
+
+			mockUsersRepo.On("GetUser", ctx, user.ID).Return(tc.repoResponse, tc.repoError)
+			mockRevisionsRepo.On("GetRevisions", ctx, user.ID).Return(revisions, nil)
+			mockAuditRepo.On("AddAuditEntry", ctx, exportEntry).Return(tc.auditError)
+			mockAuditRepo.On("GetAuditEntries", ctx, user.ID).Return([]AuditEntry{exportEntry}, nil)
+
+			svc := NewUsersService(mockUsersRepo, mockRevisionsRepo, mockAuditRepo, 0, 0)
+
+			export, err := svc.ExportUserData(ctx, user.ID)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedExport, export)
+		})
+	}
+}
+
+func TestEraseUser(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2022, time.April, 1, 12, 0, 0, 0, time.UTC)
+	}
+	defer func() { timeNow = time.Now }()
+
+	user, revisions := testUserHistory()
+	erasedUser := user
+	erasedUser.FirstName = "Erased"
+	erasedUser.LastName = "User"
+	erasedUser.Email = "1311f914-1d4f-40b6-8886-80193265d5a4@erased.invalid"
+	erasedUser.IPAddress = ""
+	erasedUser.Password = "$2a$10$erasedhash"
+
+	matchErasedUser := mock.MatchedBy(func(u User) bool {
+		// the password is replaced by an unknown one
+		if u.Password == user.Password || !IsPasswordHashed(u.Password) {
+			return false
+		}
+		u.Password = erasedUser.Password
+		return u == erasedUser
+	})
+
+	erasedRevisions := append([]UserRevision(nil), revisions...)
+	erasedRevisions[0].Changes = []FieldChange{
+		{Field: "id", To: "1311f914-1d4f-40b6-8886-80193265d5a4"},
+		{Field: "first_name", To: "Erased"},
+		{Field: "last_name", To: "User"},
+		{Field: "email", To: "1311f914-1d4f-40b6-8886-80193265d5a4@erased.invalid"},
+		{Field: "password"},
+		{Field: "ip_address"},
+		{Field: "creation_date", To: "2022-01-01T00:00:00Z"},
+	}
+	erasedRevisions[1].Changes = []FieldChange{{
+		Field: "email",
+		From:  "1311f914-1d4f-40b6-8886-80193265d5a4@erased.invalid",
+		To:    "1311f914-1d4f-40b6-8886-80193265d5a4@erased.invalid",
+	}}
+
+	erasedRevision := UserRevision{
+		UserID: user.ID,
+		Action: RevisionErased,
+		Actor:  "admin",
+		Time:   timeNow(),
+		Changes: []FieldChange{
+			{Field: "first_name"},
+			{Field: "last_name"},
+			{Field: "email"},
+			{Field: "password"},
+			{Field: "ip_address"},
+		},
+	}
+	erasedEntry := AuditEntry{
+		UserID: user.ID,
+		Action: AuditUserErased,
+		Actor:  "admin",
+		Time:   timeNow(),
+	}
+
+	testCases := []struct {
+		name          string
+		repoError     error
+		replaceError  error
+		expectedError error
+	}{
+		{
+			name:          "base case",
+			expectedError: nil,
+		},
+		{
+			name:          "not found",
+			repoError:     ErrNotFound,
+			expectedError: ErrNotFound,
+		},
+		{
+			name:          "history error",
+			replaceError:  fmt.Errorf("repo error"),
+			expectedError: fmt.Errorf("erasing user '1311f914-1d4f-40b6-8886-80193265d5a4' history: %w", fmt.Errorf("repo error")),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+			mockRevisionsRepo := new(mockRevisionsRepo)
+			mockAuditRepo := new(mockAuditRepo)
+
+			ctx := WithActor(context.Background(), "admin")
+
+			mockUsersRepo.On("GetUser", ctx, user.ID).Return(user, tc.repoError)
+			mockUsersRepo.On("UpdateUser", ctx, matchErasedUser, UserVersion(user)).Return(erasedUser, nil)
+			mockRevisionsRepo.On("GetRevisions", ctx, user.ID).Return(revisions, nil)
+			mockRevisionsRepo.On("ReplaceRevisions", ctx, user.ID, erasedRevisions).Return(tc.replaceError)
+			mockRevisionsRepo.On("AddRevisions", ctx, []UserRevision{erasedRevision}).Return(nil)
+			mockAuditRepo.On("AddAuditEntry", ctx, erasedEntry).Return(nil)
+
+			svc := NewUsersService(mockUsersRepo, mockRevisionsRepo, mockAuditRepo, 0, 0)
+
+			erased, err := svc.EraseUser(ctx, user.ID)
+
+			assert.Equal(t, tc.expectedError, err)
+			if tc.expectedError == nil {
+				assert.Equal(t, erasedUser, erased)
+				mockRevisionsRepo.AssertExpectations(t)
+				mockAuditRepo.AssertExpectations(t)
+			} else {
+				mockAuditRepo.AssertNotCalled(t, "AddAuditEntry", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestGetUserAsOfErasedUser(t *testing.T) {
+	user, revisions := testUserHistory()
+	erasedUser := user
+	erasedUser.FirstName = "Erased"
+	erasedUser.LastName = "User"
+	erasedUser.Email = "1311f914-1d4f-40b6-8886-80193265d5a4@erased.invalid"
+	erasedUser.IPAddress = ""
+	erasedUser.DeletedAt = nil
+
+	erasedRevision := newUserRevision(context.Background(), RevisionErased, user, erasedUser)
+	erasedRevision.Time = time.Date(2022, time.April, 1, 0, 0, 0, 0, time.UTC)
+	erasedRevision.Changes = eraseChanges(erasedRevision.Changes, User{})
+	erasedRevisions := append(eraseRevisions(revisions[:2], erasedUser), erasedRevision)
+
+	mockUsersRepo := new(mockUsersRepo)
+	mockRevisionsRepo := new(mockRevisionsRepo)
+
+	ctx := context.Background()
+
+	mockUsersRepo.On("GetUser", ctx, user.ID).Return(erasedUser, nil)
+	mockRevisionsRepo.On("GetRevisions", ctx, user.ID).Return(erasedRevisions, nil)
+
+	svc := NewUsersService(mockUsersRepo, mockRevisionsRepo, new(mockAuditRepo), 0, 0)
+
+	// the personal values before the erasure are not known anymore
+	userInJanuary, err := svc.GetUserAsOf(ctx, user.ID, time.Date(2022, time.January, 15, 0, 0, 0, 0, time.UTC), false)
+	assert.NoError(t, err)
+	assert.Equal(t, erasedUser, userInJanuary)
+}
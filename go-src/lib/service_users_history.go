@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// GetUserHistory gets the revisions of the user based on its ID, in order,
+// the history of the deleted (or purged) users is not found unless they are included
+func (s *usersService) GetUserHistory(ctx context.Context, userID string, includeDeleted bool) ([]UserRevision, error) {
+	user, err := s.usersRepo.GetUser(ctx, userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	userExists := err == nil
+
+	revisions, err := s.revisionsRepo.GetRevisions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the purged users only remain in their history
+	if !userExists && len(revisions) == 0 {
+		return nil, ErrNotFound
+	}
+	if (!userExists || user.IsDeleted()) && !includeDeleted {
+		return nil, ErrNotFound
+	}
+
+	return revisions, nil
+}
+
+// GetUserAsOf gets the user as it was at the time received as parameter (point-in-time read),
+// by reverting the changes of its revisions made after that time to the current user,
+// the user is not found if it didn't exist at that time, or if it was deleted (unless the deleted users are included)
+func (s *usersService) GetUserAsOf(ctx context.Context, userID string, asOf time.Time, includeDeleted bool) (User, error) {
+	user, err := s.usersRepo.GetUser(ctx, userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return User{}, err
+	}
+	userExists := err == nil
+
+	revisions, err := s.revisionsRepo.GetRevisions(ctx, userID)
+	if err != nil {
+		return User{}, err
+	}
+
+	for i := len(revisions) - 1; i >= 0 && revisions[i].Time.After(asOf); i-- {
+		// the erased values are not recorded (the previous revisions hold the anonymized values instead)
+		if revisions[i].Action != RevisionErased {
+			user, err = revertChanges(user, revisions[i].Changes)
+			if err != nil {
+				return User{}, err
+			}
+		}
+
+		switch revisions[i].Action {
+		case RevisionCreated:
+			userExists = false
+		case RevisionPurged:
+			userExists = true
+		}
+	}
+
+	// the users without history (e.g. from the initial data) are only known since their creation
+	if !userExists || asOf.Before(user.CreationDate) {
+		return User{}, ErrNotFound
+	}
+	if user.IsDeleted() && !includeDeleted {
+		return User{}, ErrNotFound
+	}
+
+	return user, nil
+}
@@ -0,0 +1,327 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// testUserHistory returns a user and its history: created (January 1st), email changed (February 1st)
+// and deleted (March 1st)
+func testUserHistory() (User, []UserRevision) {
+	deletedAt := time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC)
+	user := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "terry@feedburner.com",
+		Password:     "$2a$10$hash",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+		DeletedAt:    &deletedAt,
+	}
+
+	revisions := []UserRevision{
+		{
+			UserID: user.ID,
+			Number: 1,
+			Action: RevisionCreated,
+			Actor:  "client:63.119.6.98",
+			Time:   time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+			Changes: []FieldChange{
+				{Field: "id", To: "1311f914-1d4f-40b6-8886-80193265d5a4"},
+				{Field: "first_name", To: "Terrence"},
+				{Field: "last_name", To: "Trillow"},
+				{Field: "email", To: "ttrillow1@feedburner.com"},
+				{Field: "password"},
+				{Field: "ip_address", To: "63.119.6.98"},
+				{Field: "creation_date", To: "2022-01-01T00:00:00Z"},
+			},
+		},
+		{
+			UserID:  user.ID,
+			Number:  2,
+			Action:  RevisionUpdated,
+			Actor:   "client:63.119.6.98",
+			Time:    time.Date(2022, time.February, 1, 0, 0, 0, 0, time.UTC),
+			Changes: []FieldChange{{Field: "email", From: "ttrillow1@feedburner.com", To: "terry@feedburner.com"}},
+		},
+		{
+			UserID:  user.ID,
+			Number:  3,
+			Action:  RevisionDeleted,
+			Actor:   "admin",
+			Time:    deletedAt,
+			Changes: []FieldChange{{Field: "deleted_at", To: "2022-03-01T00:00:00Z"}},
+		},
+	}
+
+	return user, revisions
+}
+
+func TestGetUserHistory(t *testing.T) {
+	user, revisions := testUserHistory()
+	notDeletedUser := user
+	notDeletedUser.DeletedAt = nil
+
+	testCases := []struct {
+		name              string
+		includeDeleted    bool
+		repoResponse      User
+		repoError         error
+		revisions         []UserRevision
+		expectedRevisions []UserRevision
+		expectedError     error
+	}{
+		{
+			name:              "base case",
+			repoResponse:      notDeletedUser,
+			revisions:         revisions[:2],
+			expectedRevisions: revisions[:2],
+			expectedError:     nil,
+		},
+		{
+			name:              "user without history",
+			repoResponse:      notDeletedUser,
+			revisions:         []UserRevision{},
+			expectedRevisions: []UserRevision{},
+			expectedError:     nil,
+		},
+		{
+			name:              "deleted user - not found",
+			repoResponse:      user,
+			revisions:         revisions,
+			expectedRevisions: nil,
+			expectedError:     ErrNotFound,
+		},
+		{
+			name:              "deleted user - included",
+			includeDeleted:    true,
+			repoResponse:      user,
+			revisions:         revisions,
+			expectedRevisions: revisions,
+			expectedError:     nil,
+		},
+		{
+			name:              "purged user - included",
+			includeDeleted:    true,
+			repoError:         ErrNotFound,
+			revisions:         revisions,
+			expectedRevisions: revisions,
+			expectedError:     nil,
+		},
+		{
+			name:              "unknown user",
+			includeDeleted:    true,
+			repoError:         ErrNotFound,
+			revisions:         []UserRevision{},
+			expectedRevisions: nil,
+			expectedError:     ErrNotFound,
+		},
+		{
+			name:              "repo error",
+			repoError:         fmt.Errorf("repo error"),
+			expectedRevisions: nil,
+			expectedError:     fmt.Errorf("repo error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+			mockRevisionsRepo := new(mockRevisionsRepo)
+
+			ctx := context.Background()
+
+			mockUsersRepo.On("GetUser", ctx, user.ID).Return(tc.repoResponse, tc.repoError)
+			mockRevisionsRepo.On("GetRevisions", ctx, user.ID).Return(tc.revisions, nil)
+
+			svc := NewUsersService(mockUsersRepo, mockRevisionsRepo, new(mockAuditRepo), 0, 0)
+
+			revisions, err := svc.GetUserHistory(ctx, user.ID, tc.includeDeleted)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedRevisions, revisions)
+		})
+	}
+}
+
+func TestGetUserAsOf(t *testing.T) {
+	user, revisions := testUserHistory()
+	purgedRevision := UserRevision{
+		UserID:  user.ID,
+		Number:  4,
+		Action:  RevisionPurged,
+		Actor:   "system",
+		Time:    time.Date(2022, time.April, 1, 0, 0, 0, 0, time.UTC),
+		Changes: diffUsers(user, User{}),
+	}
+
+	userInJanuary := user
+	userInJanuary.Email = "ttrillow1@feedburner.com"
+	userInJanuary.DeletedAt = nil
+	userInFebruary := user
+	userInFebruary.DeletedAt = nil
+
+	testCases := []struct {
+		name           string
+		asOf           time.Time
+		includeDeleted bool
+		repoResponse   User
+		repoError      error
+		revisions      []UserRevision
+		expectedUser   User
+		expectedError  error
+	}{
+		{
+			name:          "before the email change",
+			asOf:          time.Date(2022, time.January, 15, 0, 0, 0, 0, time.UTC),
+			repoResponse:  user,
+			revisions:     revisions,
+			expectedUser:  userInJanuary,
+			expectedError: nil,
+		},
+		{
+			name:          "at the email change",
+			asOf:          time.Date(2022, time.February, 1, 0, 0, 0, 0, time.UTC),
+			repoResponse:  user,
+			revisions:     revisions,
+			expectedUser:  userInFebruary,
+			expectedError: nil,
+		},
+		{
+			name:          "deleted - not found",
+			asOf:          time.Date(2022, time.March, 15, 0, 0, 0, 0, time.UTC),
+			repoResponse:  user,
+			revisions:     revisions,
+			expectedUser:  User{},
+			expectedError: ErrNotFound,
+		},
+		{
+			name:           "deleted - included",
+			asOf:           time.Date(2022, time.March, 15, 0, 0, 0, 0, time.UTC),
+			includeDeleted: true,
+			repoResponse:   user,
+			revisions:      revisions,
+			expectedUser:   user,
+			expectedError:  nil,
+		},
+		{
+			name:          "before the creation - not found",
+			asOf:          time.Date(2021, time.December, 31, 0, 0, 0, 0, time.UTC),
+			repoResponse:  user,
+			revisions:     revisions,
+			expectedUser:  User{},
+			expectedError: ErrNotFound,
+		},
+		{
+			name:          "purged user - before the email change",
+			asOf:          time.Date(2022, time.January, 15, 0, 0, 0, 0, time.UTC),
+			repoError:     ErrNotFound,
+			revisions:     append(revisions, purgedRevision),
+			expectedUser:  User{ID: userInJanuary.ID, FirstName: "Terrence", LastName: "Trillow", Email: "ttrillow1@feedburner.com", IPAddress: "63.119.6.98", CreationDate: userInJanuary.CreationDate},
+			expectedError: nil,
+		},
+		{
+			name:           "purged user - after the purge",
+			asOf:           time.Date(2022, time.April, 15, 0, 0, 0, 0, time.UTC),
+			includeDeleted: true,
+			repoError:      ErrNotFound,
+			revisions:      append(revisions, purgedRevision),
+			expectedUser:   User{},
+			expectedError:  ErrNotFound,
+		},
+		{
+			name:          "user without history",
+			asOf:          time.Date(2022, time.January, 15, 0, 0, 0, 0, time.UTC),
+			repoResponse:  userInFebruary,
+			revisions:     []UserRevision{},
+			expectedUser:  userInFebruary,
+			expectedError: nil,
+		},
+		{
+			name:          "repo error",
+			asOf:          time.Date(2022, time.January, 15, 0, 0, 0, 0, time.UTC),
+			repoError:     fmt.Errorf("repo error"),
+			expectedUser:  User{},
+			expectedError: fmt.Errorf("repo error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+			mockRevisionsRepo := new(mockRevisionsRepo)
+
+			ctx := context.Background()
+
+			mockUsersRepo.On("GetUser", ctx, user.ID).Return(tc.repoResponse, tc.repoError)
+			mockRevisionsRepo.On("GetRevisions", ctx, user.ID).Return(tc.revisions, nil)
+
+			svc := NewUsersService(mockUsersRepo, mockRevisionsRepo, new(mockAuditRepo), 0, 0)
+
+			user, err := svc.GetUserAsOf(ctx, user.ID, tc.asOf, tc.includeDeleted)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedUser, user)
+		})
+	}
+}
+
+func TestUserWritesRevisions(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2022, time.January, 10, 12, 0, 0, 0, time.UTC)
+	}
+	defer func() { timeNow = time.Now }()
+
+	user := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+	deletedAt := timeNow()
+	deletedUser := user
+	deletedUser.DeletedAt = &deletedAt
+
+	mockUsersRepo := new(mockUsersRepo)
+	mockRevisionsRepo := new(mockRevisionsRepo)
+
+	ctx := WithActor(context.Background(), "admin")
+
+	mockUsersRepo.On("GetUser", ctx, user.ID).Return(user, nil).Once()
+	mockUsersRepo.On("UpdateUser", ctx, deletedUser, UserVersion(user)).Return(deletedUser, nil).Once()
+	mockUsersRepo.On("GetUser", ctx, user.ID).Return(deletedUser, nil).Once()
+	mockUsersRepo.On("UpdateUser", ctx, user, UserVersion(deletedUser)).Return(user, nil).Once()
+	mockRevisionsRepo.On("AddRevisions", ctx, []UserRevision{{
+		UserID:  user.ID,
+		Action:  RevisionDeleted,
+		Actor:   "admin",
+		Time:    timeNow(),
+		Changes: []FieldChange{{Field: "deleted_at", To: "2022-01-10T12:00:00Z"}},
+	}}).Return(nil).Once()
+	mockRevisionsRepo.On("AddRevisions", ctx, []UserRevision{{
+		UserID:  user.ID,
+		Action:  RevisionRestored,
+		Actor:   "admin",
+		Time:    timeNow(),
+		Changes: []FieldChange{{Field: "deleted_at", From: "2022-01-10T12:00:00Z"}},
+	}}).Return(fmt.Errorf("repo error")).Once()
+
+	svc := NewUsersService(mockUsersRepo, mockRevisionsRepo, new(mockAuditRepo), 0, 0)
+
+	err := svc.DeleteUser(ctx, user.ID, nil)
+	assert.NoError(t, err)
+
+	_, err = svc.RestoreUser(ctx, user.ID)
+	assert.Equal(t, fmt.Errorf("recording user '1311f914-1d4f-40b6-8886-80193265d5a4' revision: %w", fmt.Errorf("repo error")), err)
+
+	mockUsersRepo.AssertExpectations(t)
+	mockRevisionsRepo.AssertExpectations(t)
+	mockRevisionsRepo.AssertNotCalled(t, "GetRevisions", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ImportStatus represents the result of importing a users row
+type ImportStatus string
+
+const (
+	// ImportCreated means the row created a new user (or would create it, if not applied)
+	ImportCreated ImportStatus = "created"
+	// ImportUpdated means the row replaced an existing user with the same ID (or would replace it, if not applied)
+	ImportUpdated ImportStatus = "updated"
+	// ImportRejected means the row is invalid, it is never applied
+	ImportRejected ImportStatus = "rejected"
+)
+
+type (
+	// ImportOptions represents the users import modes
+	ImportOptions struct {
+		// DryRun only validates the rows and reports what would be done, nothing is applied
+		DryRun bool
+		// AllOrNothing applies the rows only if none of them is rejected
+		AllOrNothing bool
+	}
+
+	// ImportRowReport represents the result of importing a users row
+	ImportRowReport struct {
+		Row    int          `json:"row"`
+		ID     string       `json:"id,omitempty"`
+		Status ImportStatus `json:"status"`
+		Reason string       `json:"reason,omitempty"`
+	}
+
+	// ImportReport represents the result of a users import, with the result of each row
+	ImportReport struct {
+		// Applied tells if the valid rows were written (false for dry runs and rejected all-or-nothing imports)
+		Applied  bool              `json:"applied"`
+		Created  int               `json:"created"`
+		Updated  int               `json:"updated"`
+		Rejected int               `json:"rejected"`
+		Rows     []ImportRowReport `json:"rows"`
+	}
+)
+
+// ImportUsers imports the users from the data in the format received as parameter:
+// - rows with an existing ID replace the user (keeping its creation date), the other ones create a new user
+// - rows without ID get a generated one, and rows without creation date get the current date
+// - plaintext passwords are hashed, already hashed ones are kept
+// All the valid rows are written at once (a single repo write), and their revisions are recorded.
+// The checks and the write hold the write lock, so they do not interleave with the other conditional writes
+func (s *usersService) ImportUsers(ctx context.Context, r io.Reader, format DataFormat, options ImportOptions) (ImportReport, error) {
+	rows, err := ReadUsersRows(r, format)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	// hashing is slow by design, so the plaintext passwords are hashed before taking the lock
+	// (the empty ones are kept, to be rejected as missing)
+	if !options.DryRun {
+		err = hashRowsPasswords(rows)
+		if err != nil {
+			return ImportReport{}, err
+		}
+	}
+
+	// the checks and the write must not interleave with the other conditional writes
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	// getting the existing users at once, to tell the creations from the updates
+	var rowsIDs []string
+	for _, row := range rows {
+		if row.Err == nil && row.User.ID != "" {
+			rowsIDs = append(rowsIDs, row.User.ID)
+		}
+	}
+	existingUsers := make(map[string]User, len(rowsIDs))
+	if len(rowsIDs) > 0 {
+		batch, err := s.usersRepo.GetUsersByIDs(ctx, rowsIDs)
+		if err != nil {
+			return ImportReport{}, err
+		}
+		for _, user := range batch.Users {
+			existingUsers[user.ID] = user
+		}
+	}
+
+	existingEmails, err := s.getUsersEmails(ctx)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	report := ImportReport{
+		Rows: make([]ImportRowReport, 0, len(rows)),
+	}
+	users := make([]User, 0, len(rows))
+	importedIDs := make(map[string]bool, len(rows))
+	importedEmails := make(map[string]bool, len(rows))
+
+	for _, row := range rows {
+		user, status, err := prepareImportedUser(row, existingUsers, importedIDs)
+
+		// the emails must be unique among the imported users and the existing ones
+		if status != ImportRejected {
+			email := strings.ToLower(user.Email)
+			emailUserID, emailExists := existingEmails[email]
+			if (emailExists && emailUserID != user.ID) || importedEmails[email] {
+				status = ImportRejected
+				err = &ValidationError{Fields: []FieldError{{Field: "email", Message: emailUsedMessage}}}
+			}
+		}
+
+		rowReport := ImportRowReport{
+			Row:    row.Row,
+			ID:     user.ID,
+			Status: status,
+		}
+		switch status {
+		case ImportCreated:
+			report.Created++
+		case ImportUpdated:
+			report.Updated++
+		case ImportRejected:
+			report.Rejected++
+			rowReport.Reason = err.Error()
+		}
+		report.Rows = append(report.Rows, rowReport)
+
+		if status != ImportRejected {
+			users = append(users, user)
+			importedIDs[user.ID] = true
+			importedEmails[strings.ToLower(user.Email)] = true
+		}
+	}
+
+	if options.DryRun || (options.AllOrNothing && report.Rejected > 0) || len(users) == 0 {
+		return report, nil
+	}
+
+	err = s.usersRepo.UpsertUsers(ctx, users)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	report.Applied = true
+
+	revisions := make([]UserRevision, 0, len(users))
+	for _, user := range users {
+		currentUser, userExists := existingUsers[user.ID]
+		action := RevisionCreated
+		if userExists {
+			action = RevisionUpdated
+		}
+
+		revision := newUserRevision(ctx, action, currentUser, user)
+		if len(revision.Changes) > 0 {
+			revisions = append(revisions, revision)
+		}
+	}
+	err = s.revisionsRepo.AddRevisions(ctx, revisions)
+	if err != nil {
+		return ImportReport{}, fmt.Errorf("recording imported users revisions: %w", err)
+	}
+
+	return report, nil
+}
+
+// hashRowsPasswords hashes (in place) the plaintext passwords of the valid rows
+func hashRowsPasswords(rows []UsersRow) error {
+	var (
+		users   []User
+		indexes []int
+	)
+	for i, row := range rows {
+		if row.Err == nil && row.User.Password != "" {
+			users = append(users, row.User)
+			indexes = append(indexes, i)
+		}
+	}
+
+	_, err := HashUsersPasswords(users)
+	if err != nil {
+		return err
+	}
+
+	for i, index := range indexes {
+		rows[index].User.Password = users[i].Password
+	}
+
+	return nil
+}
+
+// getUsersEmails gets the emails (lower case) of all the users, deleted ones included, with the IDs of their users
+func (s *usersService) getUsersEmails(ctx context.Context) (map[string]string, error) {
+	emails := make(map[string]string)
+	err := s.ExportUsers(ctx, UsersFilter{IncludeDeleted: true}, nil, func(user User) error {
+		emails[strings.ToLower(user.Email)] = user.ID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// prepareImportedUser validates the imported row and completes its user, reporting if it is a creation or an update
+func prepareImportedUser(row UsersRow, existingUsers map[string]User, importedIDs map[string]bool) (User, ImportStatus, error) {
+	user := row.User
+	if row.Err != nil {
+		return user, ImportRejected, row.Err
+	}
+
+	err := validateRequiredFields(user)
+	if err != nil {
+		return user, ImportRejected, err
+	}
+
+	if importedIDs[user.ID] {
+		return user, ImportRejected, fmt.Errorf("user %s is duplicated in the imported data", user.ID)
+	}
+
+	if currentUser, userExists := existingUsers[user.ID]; userExists {
+		user.CreationDate = currentUser.CreationDate
+		user.DeletedAt = currentUser.DeletedAt
+
+		err = ValidateUser(user)
+		if err != nil {
+			return user, ImportRejected, err
+		}
+		return user, ImportUpdated, nil
+	}
+
+	if user.ID == "" {
+		user.ID = uuid.NewString()
+	}
+
+	if user.CreationDate.IsZero() {
+		user.CreationDate = newCreationDate()
+	}
+
+	err = ValidateUser(user)
+	if err != nil {
+		return user, ImportRejected, err
+	}
+
+	return user, ImportCreated, nil
+}
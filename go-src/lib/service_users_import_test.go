@@ -0,0 +1,203 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportUsers(t *testing.T) {
+	existingUser := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		Password:     "$2a$04$/Wv9d.olqIFBGaMj3SR4O.Oq4GgM5r1urmuxGQNFdE6gcd90wqE4a",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+
+	validData := strings.Join([]string{
+		`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"terrence@feedburner.com","password":"$2a$04$/Wv9d.olqIFBGaMj3SR4O.Oq4GgM5r1urmuxGQNFdE6gcd90wqE4a","creation_date":"01/01/2000"}`,
+		`{"id":"3e601207-0e80-4e7e-ae87-bb802b16a179","first_name":"Niels","last_name":"MacPaik","email":"nmacpaik2@phoca.cz","password":"$2a$04$/Wv9d.olqIFBGaMj3SR4O.Oq4GgM5r1urmuxGQNFdE6gcd90wqE4a","creation_date":"19/01/2021"}`,
+	}, "\n")
+	invalidData := strings.Join([]string{
+		validData,
+		`{"id":"144bf891-f161-4c9a-8d83-38a275e088a5","first_name":"Nicky","email":"nblasio0@jiathis.com","password":"rKJKin"}`,
+		`{"id":"3e601207-0e80-4e7e-ae87-bb802b16a179","first_name":"Niels","last_name":"MacPaik","email":"nmacpaik2@phoca.cz","password":"Vae1mnI"}`,
+		`{"id":"144bf891-f161-4c9a-8d83-38a275e088a5","first_name":"Nicky","last_name":"Blasio","email":"nblasio0@jiathis.com","password":"rKJKin","creation_date":"2021-06-06"}`,
+		`{"id":`,
+		`{"id":"9d3f0a4e-2c1b-4f6a-8e7d-5b4c3a2f1e0d","first_name":"Tim","last_name":"Tom","email":"tim@tom.com","password":"x","ip_address":"300.1.1.1"}`,
+		`{"id":"5a0c0d5e-8f0e-4a4b-9a57-3c1d2e7f4b10","first_name":"Tim","last_name":"Trillow","email":"TTRILLOW1@feedburner.com","password":"x"}`,
+		`{"id":"7c2e9b1a-3d4f-4e5a-9b8c-1a2b3c4d5e6f","first_name":"Nils","last_name":"MacPaik","email":"NMACPAIK2@phoca.cz","password":"x"}`,
+	}, "\n")
+
+	// the existing user keeps its creation date
+	expectedUsers := []User{
+		{
+			ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+			FirstName:    "Terrence",
+			LastName:     "Trillow",
+			Email:        "terrence@feedburner.com",
+			Password:     "$2a$04$/Wv9d.olqIFBGaMj3SR4O.Oq4GgM5r1urmuxGQNFdE6gcd90wqE4a",
+			CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			ID:           "3e601207-0e80-4e7e-ae87-bb802b16a179",
+			FirstName:    "Niels",
+			LastName:     "MacPaik",
+			Email:        "nmacpaik2@phoca.cz",
+			Password:     "$2a$04$/Wv9d.olqIFBGaMj3SR4O.Oq4GgM5r1urmuxGQNFdE6gcd90wqE4a",
+			CreationDate: time.Date(2021, time.January, 19, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	validRows := []ImportRowReport{
+		{Row: 1, ID: "1311f914-1d4f-40b6-8886-80193265d5a4", Status: ImportUpdated},
+		{Row: 2, ID: "3e601207-0e80-4e7e-ae87-bb802b16a179", Status: ImportCreated},
+	}
+	invalidRows := append(append([]ImportRowReport(nil), validRows...),
+		ImportRowReport{Row: 3, ID: "144bf891-f161-4c9a-8d83-38a275e088a5", Status: ImportRejected, Reason: "invalid user: 'last_name' is required"},
+		ImportRowReport{Row: 4, ID: "3e601207-0e80-4e7e-ae87-bb802b16a179", Status: ImportRejected, Reason: "user 3e601207-0e80-4e7e-ae87-bb802b16a179 is duplicated in the imported data"},
+		ImportRowReport{Row: 5, ID: "144bf891-f161-4c9a-8d83-38a275e088a5", Status: ImportRejected, Reason: "invalid user: 'creation_date' must be a valid date (RFC 3339 or dd/mm/yyyy)"},
+		ImportRowReport{Row: 6, Status: ImportRejected, Reason: "invalid JSON: unexpected EOF"},
+		ImportRowReport{Row: 7, ID: "9d3f0a4e-2c1b-4f6a-8e7d-5b4c3a2f1e0d", Status: ImportRejected, Reason: "invalid user: 'ip_address' must be an IPv4 or IPv6 address"},
+		ImportRowReport{Row: 8, ID: "5a0c0d5e-8f0e-4a4b-9a57-3c1d2e7f4b10", Status: ImportRejected, Reason: "invalid user: 'email' is already used by another user"},
+		ImportRowReport{Row: 9, ID: "7c2e9b1a-3d4f-4e5a-9b8c-1a2b3c4d5e6f", Status: ImportRejected, Reason: "invalid user: 'email' is already used by another user"},
+	)
+
+	testCases := []struct {
+		name           string
+		data           string
+		options        ImportOptions
+		upsertCalled   bool
+		upsertError    error
+		expectedReport ImportReport
+		expectedError  error
+	}{
+		{
+			name:           "base case",
+			data:           validData,
+			options:        ImportOptions{},
+			upsertCalled:   true,
+			expectedReport: ImportReport{Applied: true, Created: 1, Updated: 1, Rows: validRows},
+			expectedError:  nil,
+		},
+		{
+			name:           "rejected rows - valid rows applied",
+			data:           invalidData,
+			options:        ImportOptions{},
+			upsertCalled:   true,
+			expectedReport: ImportReport{Applied: true, Created: 1, Updated: 1, Rejected: 7, Rows: invalidRows},
+			expectedError:  nil,
+		},
+		{
+			name:           "all or nothing - nothing applied",
+			data:           invalidData,
+			options:        ImportOptions{AllOrNothing: true},
+			upsertCalled:   false,
+			expectedReport: ImportReport{Applied: false, Created: 1, Updated: 1, Rejected: 7, Rows: invalidRows},
+			expectedError:  nil,
+		},
+		{
+			name:           "all or nothing - all applied",
+			data:           validData,
+			options:        ImportOptions{AllOrNothing: true},
+			upsertCalled:   true,
+			expectedReport: ImportReport{Applied: true, Created: 1, Updated: 1, Rows: validRows},
+			expectedError:  nil,
+		},
+		{
+			name:           "dry run",
+			data:           validData,
+			options:        ImportOptions{DryRun: true},
+			upsertCalled:   false,
+			expectedReport: ImportReport{Applied: false, Created: 1, Updated: 1, Rows: validRows},
+			expectedError:  nil,
+		},
+		{
+			name:           "repo error",
+			data:           validData,
+			options:        ImportOptions{},
+			upsertCalled:   true,
+			upsertError:    fmt.Errorf("repo error"),
+			expectedReport: ImportReport{},
+			expectedError:  fmt.Errorf("repo error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+
+			ctx := context.Background()
+
+			mockUsersRepo.On("GetUsersByIDs", ctx, mock.Anything).Return(UsersBatch{Users: []User{existingUser}}, nil)
+			// the emails of all the users are got at once, deleted ones included
+			mockUsersRepo.On("GetUsers", ctx, mock.MatchedBy(func(query UsersQuery) bool {
+				return query.Filter.IncludeDeleted
+			})).Return(UsersPage{Users: []User{existingUser}, Total: 1}, nil)
+			mockUsersRepo.On("UpsertUsers", ctx, expectedUsers).Return(tc.upsertError)
+
+			svc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+
+			report, err := svc.ImportUsers(ctx, strings.NewReader(tc.data), FormatNDJSON, tc.options)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedReport, report)
+			mockUsersRepo.AssertNotCalled(t, "GetUserByEmail", mock.Anything, mock.Anything)
+			if tc.upsertCalled {
+				mockUsersRepo.AssertCalled(t, "UpsertUsers", ctx, expectedUsers)
+			} else {
+				mockUsersRepo.AssertNotCalled(t, "UpsertUsers", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestImportUsersGeneratedFields(t *testing.T) {
+	timeNow = func() time.Time { return time.Date(2021, time.December, 24, 10, 0, 0, 0, time.UTC) }
+	defer func() { timeNow = time.Now }()
+
+	ctx := context.Background()
+
+	var upsertedUsers []User
+	mockUsersRepo := new(mockUsersRepo)
+	mockUsersRepo.On("GetUsers", ctx, mock.Anything).Return(UsersPage{}, nil)
+	mockUsersRepo.On("UpsertUsers", ctx, mock.Anything).Run(func(args mock.Arguments) {
+		upsertedUsers = args.Get(1).([]User)
+	}).Return(nil)
+
+	svc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+
+	// without IDs, the existing users are not even got
+	report, err := svc.ImportUsers(ctx, strings.NewReader("first_name,last_name,email,password\nNicky,Blasio,nblasio0@jiathis.com,rKJKin\n"), FormatCSV, ImportOptions{})
+	require.NoError(t, err)
+	require.Len(t, upsertedUsers, 1)
+
+	user := upsertedUsers[0]
+	assert.NotEmpty(t, user.ID)
+	assert.Equal(t, time.Date(2021, time.December, 24, 10, 0, 0, 0, time.UTC), user.CreationDate)
+	assert.True(t, CheckPassword(user.Password, "rKJKin"))
+	assert.Equal(t, ImportReport{
+		Applied: true,
+		Created: 1,
+		Rows:    []ImportRowReport{{Row: 1, ID: user.ID, Status: ImportCreated}},
+	}, report)
+
+	mockUsersRepo.AssertNotCalled(t, "GetUsersByIDs", mock.Anything, mock.Anything)
+}
+
+func TestImportUsersReadError(t *testing.T) {
+	svc := NewUsersService(new(mockUsersRepo), newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+
+	_, err := svc.ImportUsers(context.Background(), strings.NewReader("age\n"), FormatCSV, ImportOptions{})
+
+	assert.True(t, errors.Is(err, ErrPreconditionFailed))
+}
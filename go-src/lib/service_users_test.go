@@ -2,19 +2,24 @@ package lib
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type mockUsersRepo struct {
 	mock.Mock
 }
 
-func (m *mockUsersRepo) GetUsers(ctx context.Context, limit int, offset int) ([]User, error) {
-	args := m.Called(ctx, limit, offset)
-	return args.Get(0).([]User), args.Error(1)
+func (m *mockUsersRepo) GetUsers(ctx context.Context, query UsersQuery) (UsersPage, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).(UsersPage), args.Error(1)
 }
 
 func (m *mockUsersRepo) GetUser(ctx context.Context, userID string) (User, error) {
@@ -22,21 +27,123 @@ func (m *mockUsersRepo) GetUser(ctx context.Context, userID string) (User, error
 	return args.Get(0).(User), args.Error(1)
 }
 
+func (m *mockUsersRepo) GetUsersByIDs(ctx context.Context, userIDs []string) (UsersBatch, error) {
+	args := m.Called(ctx, userIDs)
+	return args.Get(0).(UsersBatch), args.Error(1)
+}
+
+func (m *mockUsersRepo) SearchUsers(ctx context.Context, query UsersSearchQuery) (UsersPage, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).(UsersPage), args.Error(1)
+}
+
+func (m *mockUsersRepo) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	args := m.Called(ctx, email)
+	return args.Get(0).(User), args.Error(1)
+}
+
+func (m *mockUsersRepo) CreateUser(ctx context.Context, user User) (User, error) {
+	args := m.Called(ctx, user)
+	// the response can depend on the received user (e.g. generated ID)
+	if fn, ok := args.Get(0).(func(context.Context, User) User); ok {
+		return fn(ctx, user), args.Error(1)
+	}
+	return args.Get(0).(User), args.Error(1)
+}
+
+func (m *mockUsersRepo) UpdateUser(ctx context.Context, user User, version string) (User, error) {
+	args := m.Called(ctx, user, version)
+	return args.Get(0).(User), args.Error(1)
+}
+
+func (m *mockUsersRepo) DeleteUser(ctx context.Context, userID string) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *mockUsersRepo) UpsertUsers(ctx context.Context, users []User) error {
+	args := m.Called(ctx, users)
+	return args.Error(0)
+}
+
+type mockRevisionsRepo struct {
+	mock.Mock
+}
+
+func (m *mockRevisionsRepo) AddRevisions(ctx context.Context, revisions []UserRevision) error {
+	args := m.Called(ctx, revisions)
+	return args.Error(0)
+}
+
+func (m *mockRevisionsRepo) GetRevisions(ctx context.Context, userID string) ([]UserRevision, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]UserRevision), args.Error(1)
+}
+
+func (m *mockRevisionsRepo) ReplaceRevisions(ctx context.Context, userID string, revisions []UserRevision) error {
+	args := m.Called(ctx, userID, revisions)
+	return args.Error(0)
+}
+
+// newMockRevisionsRepo creates a revisions repo mock accepting any revisions
+func newMockRevisionsRepo() *mockRevisionsRepo {
+	mockRevisionsRepo := new(mockRevisionsRepo)
+	mockRevisionsRepo.On("AddRevisions", mock.Anything, mock.Anything).Return(nil)
+	return mockRevisionsRepo
+}
+
+type mockAuditRepo struct {
+	mock.Mock
+}
+
+func (m *mockAuditRepo) AddAuditEntry(ctx context.Context, entry AuditEntry) error {
+	args := m.Called(ctx, entry)
+	return args.Error(0)
+}
+
+func (m *mockAuditRepo) GetAuditEntries(ctx context.Context, userID string) ([]AuditEntry, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]AuditEntry), args.Error(1)
+}
+
+// matchUserWithPassword matches the user with the expected one, checking the password against its hash
+func matchUserWithPassword(expectedUser User, password string) interface{} {
+	return mock.MatchedBy(func(user User) bool {
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+			return false
+		}
+		user.Password = expectedUser.Password
+		return user == expectedUser
+	})
+}
+
+// mockEmailOwner mocks the user owning any email (not found if the owner ID is empty)
+func mockEmailOwner(mockUsersRepo *mockUsersRepo, ownerID string) {
+	if ownerID == "" {
+		mockUsersRepo.On("GetUserByEmail", mock.Anything, mock.Anything).Return(User{}, ErrNotFound)
+		return
+	}
+	mockUsersRepo.On("GetUserByEmail", mock.Anything, mock.Anything).Return(User{ID: ownerID}, nil)
+}
+
 func TestGetUsers(t *testing.T) {
 	testCases := []struct {
 		name          string
-		limit         int
-		offset        int
-		repoResponse  []User
+		query         UsersQuery
+		repoResponse  UsersPage
 		repoError     error
-		expectedUsers []User
+		expectedPage  UsersPage
 		expectedError error
 	}{
 		{
-			name:   "base case",
-			limit:  1,
-			offset: 5,
-			repoResponse: []User{
+			name: "base case",
+			query: UsersQuery{
+				Limit:  1,
+				Offset: 5,
+				Filter: UsersFilter{EmailDomain: "feedburner.com"},
+				Sort:   []SortField{{Field: "last_name"}},
+			},
+			repoResponse: UsersPage{Users: []User{
 				{
 					ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
 					FirstName:    "Terrence",
@@ -44,11 +151,11 @@ func TestGetUsers(t *testing.T) {
 					Email:        "ttrillow1@feedburner.com",
 					Password:     "5YLItbmdkfC1",
 					IPAddress:    "63.119.6.98",
-					CreationDate: "19/04/2021",
+					CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
 				},
-			},
+			}},
 			repoError: nil,
-			expectedUsers: []User{
+			expectedPage: UsersPage{Users: []User{
 				{
 					ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
 					FirstName:    "Terrence",
@@ -56,18 +163,17 @@ func TestGetUsers(t *testing.T) {
 					Email:        "ttrillow1@feedburner.com",
 					Password:     "5YLItbmdkfC1",
 					IPAddress:    "63.119.6.98",
-					CreationDate: "19/04/2021",
+					CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
 				},
-			},
+			}},
 			expectedError: nil,
 		},
 		{
 			name:          "repo error",
-			limit:         -1,
-			offset:        -1,
-			repoResponse:  nil,
+			query:         UsersQuery{Limit: -1, Offset: -1},
+			repoResponse:  UsersPage{},
 			repoError:     ErrPreconditionFailed,
-			expectedUsers: nil,
+			expectedPage:  UsersPage{},
 			expectedError: ErrPreconditionFailed,
 		}}
 
@@ -77,28 +183,146 @@ func TestGetUsers(t *testing.T) {
 
 			ctx := context.Background()
 
-			mockUsersRepo.On("GetUsers", ctx, tc.limit, tc.offset).Return(tc.repoResponse, tc.repoError)
+			mockUsersRepo.On("GetUsers", ctx, tc.query).Return(tc.repoResponse, tc.repoError)
 
-			svc := NewUsersService(mockUsersRepo)
+			svc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
 
-			users, err := svc.GetUsers(ctx, tc.limit, tc.offset)
+			page, err := svc.GetUsers(ctx, tc.query)
 
 			mockUsersRepo.AssertExpectations(t)
 
 			assert.Equal(t, tc.expectedError, err)
-			assert.Equal(t, tc.expectedUsers, users)
+			assert.Equal(t, tc.expectedPage, page)
 		})
 	}
 }
 
-func TestGetUser(t *testing.T) {
+func TestGetUsersByIDs(t *testing.T) {
 	testCases := []struct {
 		name          string
-		userID        string
-		repoResponse  User
+		userIDs       []string
+		expectedIDs   []string
+		repoResponse  UsersBatch
 		repoError     error
-		expectedUser  User
+		expectedBatch UsersBatch
 		expectedError error
+	}{
+		{
+			name:          "base case",
+			userIDs:       []string{"1", "2"},
+			expectedIDs:   []string{"1", "2"},
+			repoResponse:  UsersBatch{Users: []User{{ID: "1"}}, MissingIDs: []string{"2"}},
+			repoError:     nil,
+			expectedBatch: UsersBatch{Users: []User{{ID: "1"}}, MissingIDs: []string{"2"}},
+			expectedError: nil,
+		},
+		{
+			name:          "duplicated IDs ignored",
+			userIDs:       []string{"2", "1", "2", "1"},
+			expectedIDs:   []string{"2", "1"},
+			repoResponse:  UsersBatch{Users: []User{{ID: "2"}, {ID: "1"}}, MissingIDs: []string{}},
+			repoError:     nil,
+			expectedBatch: UsersBatch{Users: []User{{ID: "2"}, {ID: "1"}}, MissingIDs: []string{}},
+			expectedError: nil,
+		},
+		{
+			name:          "deleted users missing",
+			userIDs:       []string{"1", "2", "3"},
+			expectedIDs:   []string{"1", "2", "3"},
+			repoResponse:  UsersBatch{Users: []User{{ID: "1", DeletedAt: &time.Time{}}, {ID: "2"}}, MissingIDs: []string{"3"}},
+			repoError:     nil,
+			expectedBatch: UsersBatch{Users: []User{{ID: "2"}}, MissingIDs: []string{"3", "1"}},
+			expectedError: nil,
+		},
+		{
+			name:          "repo error",
+			userIDs:       []string{"1"},
+			expectedIDs:   []string{"1"},
+			repoResponse:  UsersBatch{},
+			repoError:     fmt.Errorf("repo error"),
+			expectedBatch: UsersBatch{},
+			expectedError: fmt.Errorf("repo error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+
+			ctx := context.Background()
+
+			mockUsersRepo.On("GetUsersByIDs", ctx, tc.expectedIDs).Return(tc.repoResponse, tc.repoError)
+
+			svc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+
+			batch, err := svc.GetUsersByIDs(ctx, tc.userIDs)
+
+			mockUsersRepo.AssertExpectations(t)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedBatch, batch)
+		})
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	testCases := []struct {
+		name          string
+		query         UsersSearchQuery
+		repoResponse  UsersPage
+		repoError     error
+		expectedPage  UsersPage
+		expectedError error
+	}{
+		{
+			name:          "base case",
+			query:         UsersSearchQuery{Text: "trillow", Limit: 10},
+			repoResponse:  UsersPage{Users: []User{{ID: "1311f914-1d4f-40b6-8886-80193265d5a4", LastName: "Trillow"}}, Total: 1},
+			repoError:     nil,
+			expectedPage:  UsersPage{Users: []User{{ID: "1311f914-1d4f-40b6-8886-80193265d5a4", LastName: "Trillow"}}, Total: 1},
+			expectedError: nil,
+		},
+		{
+			name:          "repo error",
+			query:         UsersSearchQuery{Text: "trillow", Limit: -1},
+			repoResponse:  UsersPage{},
+			repoError:     ErrPreconditionFailed,
+			expectedPage:  UsersPage{},
+			expectedError: ErrPreconditionFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+
+			ctx := context.Background()
+
+			mockUsersRepo.On("SearchUsers", ctx, tc.query).Return(tc.repoResponse, tc.repoError)
+
+			svc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+
+			page, err := svc.SearchUsers(ctx, tc.query)
+
+			mockUsersRepo.AssertExpectations(t)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedPage, page)
+		})
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	deletedAt := time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		userID         string
+		includeDeleted bool
+		repoResponse   User
+		repoError      error
+		expectedUser   User
+		expectedError  error
 	}{
 		{
 			name:   "base case",
@@ -110,7 +334,7 @@ func TestGetUser(t *testing.T) {
 				Email:        "ttrillow1@feedburner.com",
 				Password:     "5YLItbmdkfC1",
 				IPAddress:    "63.119.6.98",
-				CreationDate: "19/04/2021",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
 			},
 			repoError: nil,
 			expectedUser: User{
@@ -120,10 +344,27 @@ func TestGetUser(t *testing.T) {
 				Email:        "ttrillow1@feedburner.com",
 				Password:     "5YLItbmdkfC1",
 				IPAddress:    "63.119.6.98",
-				CreationDate: "19/04/2021",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
 			},
 			expectedError: nil,
 		},
+		{
+			name:          "deleted user - not found",
+			userID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+			repoResponse:  User{ID: "1311f914-1d4f-40b6-8886-80193265d5a4", DeletedAt: &deletedAt},
+			repoError:     nil,
+			expectedUser:  User{},
+			expectedError: ErrNotFound,
+		},
+		{
+			name:           "deleted user - included",
+			userID:         "1311f914-1d4f-40b6-8886-80193265d5a4",
+			includeDeleted: true,
+			repoResponse:   User{ID: "1311f914-1d4f-40b6-8886-80193265d5a4", DeletedAt: &deletedAt},
+			repoError:      nil,
+			expectedUser:   User{ID: "1311f914-1d4f-40b6-8886-80193265d5a4", DeletedAt: &deletedAt},
+			expectedError:  nil,
+		},
 		{
 			name:          "repo error",
 			userID:        "unknown_id",
@@ -142,9 +383,9 @@ func TestGetUser(t *testing.T) {
 
 			mockUsersRepo.On("GetUser", ctx, tc.userID).Return(tc.repoResponse, tc.repoError)
 
-			svc := NewUsersService(mockUsersRepo)
+			svc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
 
-			user, err := svc.GetUser(ctx, tc.userID)
+			user, err := svc.GetUser(ctx, tc.userID, tc.includeDeleted)
 
 			mockUsersRepo.AssertExpectations(t)
 
@@ -153,3 +394,844 @@ func TestGetUser(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateUser(t *testing.T) {
+	// the creation date is in UTC, with seconds precision
+	timeNow = func() time.Time {
+		return time.Date(2021, time.December, 24, 10, 0, 0, 500, time.FixedZone("BRT", -3*60*60))
+	}
+	defer func() { timeNow = time.Now }()
+
+	testCases := []struct {
+		name          string
+		user          User
+		emailOwnerID  string
+		repoNotCalled bool
+		repoError     error
+		expectedUser  User
+		expectedError error
+	}{
+		{
+			name: "base case",
+			user: User{
+				ID:        "ignored-id",
+				FirstName: "Terrence",
+				LastName:  "Trillow",
+				Email:     "ttrillow1@feedburner.com",
+				Password:  "5YLItbmdkfC1",
+				IPAddress: "63.119.6.98",
+			},
+			repoError: nil,
+			expectedUser: User{
+				FirstName:    "Terrence",
+				LastName:     "Trillow",
+				Email:        "ttrillow1@feedburner.com",
+				Password:     "5YLItbmdkfC1",
+				IPAddress:    "63.119.6.98",
+				CreationDate: time.Date(2021, time.December, 24, 13, 0, 0, 0, time.UTC),
+			},
+			expectedError: nil,
+		},
+		{
+			name: "missing required field",
+			user: User{
+				FirstName: "Terrence",
+				LastName:  "Trillow",
+				Password:  "5YLItbmdkfC1",
+			},
+			repoNotCalled: true,
+			expectedUser:  User{},
+			expectedError: &ValidationError{Fields: []FieldError{{Field: "email", Message: "is required"}}},
+		},
+		{
+			name: "invalid fields",
+			user: User{
+				FirstName: "Terrence",
+				LastName:  "Trillow",
+				Email:     "Terrence <ttrillow1@feedburner.com>",
+				Password:  "5YLItbmdkfC1",
+				IPAddress: "63.119.6",
+			},
+			repoNotCalled: true,
+			expectedUser:  User{},
+			expectedError: &ValidationError{Fields: []FieldError{
+				{Field: "email", Message: "must be a valid email address"},
+				{Field: "ip_address", Message: "must be an IPv4 or IPv6 address"},
+			}},
+		},
+		{
+			name: "email already used (case-insensitive)",
+			user: User{
+				FirstName: "Terrence",
+				LastName:  "Trillow",
+				Email:     "TTrillow1@feedburner.com",
+				Password:  "5YLItbmdkfC1",
+			},
+			emailOwnerID:  "3e601207-0e80-4e7e-ae87-bb802b16a179",
+			repoNotCalled: true,
+			expectedUser:  User{},
+			expectedError: &ValidationError{Fields: []FieldError{
+				{Field: "email", Message: "is already used by another user"},
+			}},
+		},
+		{
+			name: "repo error",
+			user: User{
+				FirstName: "Terrence",
+				LastName:  "Trillow",
+				Email:     "ttrillow1@feedburner.com",
+				Password:  "5YLItbmdkfC1",
+			},
+			repoError:     fmt.Errorf("repo error"),
+			expectedUser:  User{},
+			expectedError: fmt.Errorf("repo error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+
+			ctx := context.Background()
+
+			mockEmailOwner(mockUsersRepo, tc.emailOwnerID)
+			mockUsersRepo.On("CreateUser", ctx, mock.AnythingOfType("User")).Return(
+				func(ctx context.Context, user User) User {
+					if tc.repoError != nil {
+						return User{}
+					}
+					return user
+				}, tc.repoError,
+			)
+
+			svc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+
+			user, err := svc.CreateUser(ctx, tc.user)
+
+			if tc.repoNotCalled {
+				mockUsersRepo.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything)
+			} else {
+				mockUsersRepo.AssertCalled(t, "CreateUser", ctx, mock.AnythingOfType("User"))
+			}
+
+			assert.Equal(t, tc.expectedError, err)
+			if tc.expectedUser.FirstName != "" {
+				// the ID is randomly generated
+				assert.NotEmpty(t, user.ID)
+				assert.NotEqual(t, tc.user.ID, user.ID)
+				tc.expectedUser.ID = user.ID
+				// the password is hashed
+				assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(tc.user.Password)))
+				tc.expectedUser.Password = user.Password
+			}
+			assert.Equal(t, tc.expectedUser, user)
+		})
+	}
+}
+
+// emailsUsersRepo is a users repo mock keeping the emails of the created users, slow to find them
+// (so the concurrent creations overlap)
+type emailsUsersRepo struct {
+	mockUsersRepo
+	mutex  sync.Mutex
+	emails map[string]string
+}
+
+func (r *emailsUsersRepo) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	time.Sleep(10 * time.Millisecond)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	userID, ok := r.emails[strings.ToLower(email)]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return User{ID: userID, Email: email}, nil
+}
+
+func (r *emailsUsersRepo) CreateUser(ctx context.Context, user User) (User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.emails[strings.ToLower(user.Email)] = user.ID
+	return user, nil
+}
+
+func TestCreateUserConcurrentEmail(t *testing.T) {
+	usersRepo := &emailsUsersRepo{emails: make(map[string]string)}
+	svc := NewUsersService(usersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+
+	const creations = 4
+	errs := make(chan error, creations)
+	for i := 0; i < creations; i++ {
+		go func() {
+			_, err := svc.CreateUser(context.Background(), User{
+				FirstName: "Terrence",
+				LastName:  "Trillow",
+				Email:     "ttrillow1@feedburner.com",
+				Password:  "5YLItbmdkfC1",
+			})
+			errs <- err
+		}()
+	}
+
+	// only the first creation uses the email
+	created := 0
+	for i := 0; i < creations; i++ {
+		err := <-errs
+		if err == nil {
+			created++
+			continue
+		}
+		assert.Equal(t, &ValidationError{Fields: []FieldError{{Field: "email", Message: "is already used by another user"}}}, err)
+	}
+	assert.Equal(t, 1, created)
+}
+
+func TestUpdateUser(t *testing.T) {
+	currentUser := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		Password:     "5YLItbmdkfC1",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+
+	currentVersion := UserVersion(currentUser)
+
+	testCases := []struct {
+		name          string
+		user          User
+		versions      []string
+		emailOwnerID  string
+		getError      error
+		updateCalled  bool
+		expectedUser  User
+		expectedError error
+	}{
+		{
+			name: "base case - creation date kept",
+			user: User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terry",
+				LastName:     "Trillow",
+				Email:        "terry@feedburner.com",
+				Password:     "newPassword",
+				CreationDate: time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+			},
+			updateCalled: true,
+			expectedUser: User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terry",
+				LastName:     "Trillow",
+				Email:        "terry@feedburner.com",
+				Password:     "newPassword",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			expectedError: nil,
+		},
+		{
+			name: "expected version matched",
+			user: User{
+				ID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName: "Terry",
+				LastName:  "Trillow",
+				Email:     "terry@feedburner.com",
+				Password:  "newPassword",
+			},
+			versions:     []string{"outdated_version", currentVersion},
+			updateCalled: true,
+			expectedUser: User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terry",
+				LastName:     "Trillow",
+				Email:        "terry@feedburner.com",
+				Password:     "newPassword",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			expectedError: nil,
+		},
+		{
+			name: "version mismatch",
+			user: User{
+				ID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName: "Terry",
+				LastName:  "Trillow",
+				Email:     "terry@feedburner.com",
+				Password:  "newPassword",
+			},
+			versions:      []string{"outdated_version"},
+			expectedUser:  User{},
+			expectedError: fmt.Errorf("user '1311f914-1d4f-40b6-8886-80193265d5a4' was modified (version mismatch): %w", ErrPreconditionFailed),
+		},
+		{
+			name: "not found",
+			user: User{
+				ID:        "unknown_id",
+				FirstName: "Terry",
+				LastName:  "Trillow",
+				Email:     "terry@feedburner.com",
+				Password:  "newPassword",
+			},
+			getError:      ErrNotFound,
+			expectedUser:  User{},
+			expectedError: ErrNotFound,
+		},
+		{
+			name: "missing required field",
+			user: User{
+				ID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName: "Terry",
+				Email:     "terry@feedburner.com",
+				Password:  "newPassword",
+			},
+			expectedUser:  User{},
+			expectedError: &ValidationError{Fields: []FieldError{{Field: "last_name", Message: "is required"}}},
+		},
+		{
+			name: "own email kept",
+			user: User{
+				ID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName: "Terry",
+				LastName:  "Trillow",
+				Email:     "ttrillow1@feedburner.com",
+				Password:  "newPassword",
+			},
+			emailOwnerID: "1311f914-1d4f-40b6-8886-80193265d5a4",
+			updateCalled: true,
+			expectedUser: User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terry",
+				LastName:     "Trillow",
+				Email:        "ttrillow1@feedburner.com",
+				Password:     "newPassword",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			expectedError: nil,
+		},
+		{
+			name: "email used by another user",
+			user: User{
+				ID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName: "Terry",
+				LastName:  "Trillow",
+				Email:     "nmacpaik2@phoca.cz",
+				Password:  "newPassword",
+			},
+			emailOwnerID: "3e601207-0e80-4e7e-ae87-bb802b16a179",
+			expectedUser: User{},
+			expectedError: &ValidationError{Fields: []FieldError{
+				{Field: "email", Message: "is already used by another user"},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+
+			ctx := context.Background()
+
+			mockUsersRepo.On("GetUser", ctx, tc.user.ID).Return(currentUser, tc.getError)
+			mockEmailOwner(mockUsersRepo, tc.emailOwnerID)
+			mockUsersRepo.On("UpdateUser", ctx, matchUserWithPassword(tc.expectedUser, tc.user.Password), UserVersion(currentUser)).Return(tc.expectedUser, nil)
+
+			svc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+
+			user, err := svc.UpdateUser(ctx, tc.user, tc.versions)
+
+			if tc.updateCalled {
+				mockUsersRepo.AssertExpectations(t)
+			} else {
+				mockUsersRepo.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything, mock.Anything)
+			}
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedUser, user)
+		})
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	currentUser := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		Password:     "5YLItbmdkfC1",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+	newFirstName := "Terry"
+	emptyEmail := ""
+	invalidEmail := "ttrillow1"
+	currentVersion := UserVersion(currentUser)
+
+	testCases := []struct {
+		name          string
+		userID        string
+		patch         UserPatch
+		versions      []string
+		getError      error
+		updateCalled  bool
+		expectedUser  User
+		expectedError error
+	}{
+		{
+			name:         "base case - only first name changed",
+			userID:       "1311f914-1d4f-40b6-8886-80193265d5a4",
+			patch:        UserPatch{FirstName: &newFirstName},
+			updateCalled: true,
+			expectedUser: User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terry",
+				LastName:     "Trillow",
+				Email:        "ttrillow1@feedburner.com",
+				Password:     "5YLItbmdkfC1",
+				IPAddress:    "63.119.6.98",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			expectedError: nil,
+		},
+		{
+			name:         "expected version matched",
+			userID:       "1311f914-1d4f-40b6-8886-80193265d5a4",
+			patch:        UserPatch{FirstName: &newFirstName},
+			versions:     []string{currentVersion},
+			updateCalled: true,
+			expectedUser: User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terry",
+				LastName:     "Trillow",
+				Email:        "ttrillow1@feedburner.com",
+				Password:     "5YLItbmdkfC1",
+				IPAddress:    "63.119.6.98",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			expectedError: nil,
+		},
+		{
+			name:          "version mismatch",
+			userID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+			patch:         UserPatch{FirstName: &newFirstName},
+			versions:      []string{"outdated_version"},
+			expectedUser:  User{},
+			expectedError: fmt.Errorf("user '1311f914-1d4f-40b6-8886-80193265d5a4' was modified (version mismatch): %w", ErrPreconditionFailed),
+		},
+		{
+			name:          "not found",
+			userID:        "unknown_id",
+			patch:         UserPatch{FirstName: &newFirstName},
+			getError:      ErrNotFound,
+			expectedUser:  User{},
+			expectedError: ErrNotFound,
+		},
+		{
+			name:          "required field emptied",
+			userID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+			patch:         UserPatch{Email: &emptyEmail},
+			expectedUser:  User{},
+			expectedError: &ValidationError{Fields: []FieldError{{Field: "email", Message: "is required"}}},
+		},
+		{
+			name:         "invalid email",
+			userID:       "1311f914-1d4f-40b6-8886-80193265d5a4",
+			patch:        UserPatch{Email: &invalidEmail},
+			expectedUser: User{},
+			expectedError: &ValidationError{Fields: []FieldError{
+				{Field: "email", Message: "must be a valid email address"},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+
+			ctx := context.Background()
+
+			mockUsersRepo.On("GetUser", ctx, tc.userID).Return(currentUser, tc.getError)
+			mockEmailOwner(mockUsersRepo, currentUser.ID)
+			mockUsersRepo.On("UpdateUser", ctx, tc.expectedUser, UserVersion(currentUser)).Return(tc.expectedUser, nil)
+
+			svc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+
+			user, err := svc.PatchUser(ctx, tc.userID, tc.patch, tc.versions)
+
+			if tc.updateCalled {
+				mockUsersRepo.AssertExpectations(t)
+			} else {
+				mockUsersRepo.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything, mock.Anything)
+			}
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedUser, user)
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2022, time.January, 10, 12, 0, 0, 0, time.FixedZone("BRT", -3*60*60))
+	}
+	defer func() { timeNow = time.Now }()
+
+	currentUser := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		Password:     "5YLItbmdkfC1",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+	deletedAt := time.Date(2022, time.January, 10, 15, 0, 0, 0, time.UTC)
+	deletedUser := currentUser
+	deletedUser.DeletedAt = &deletedAt
+
+	testCases := []struct {
+		name          string
+		userID        string
+		versions      []string
+		getResponse   User
+		getError      error
+		updateCalled  bool
+		updateError   error
+		expectedError error
+	}{
+		{
+			name:          "base case - marked as deleted",
+			userID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+			getResponse:   currentUser,
+			updateCalled:  true,
+			expectedError: nil,
+		},
+		{
+			name:          "expected version matched",
+			userID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+			versions:      []string{UserVersion(currentUser)},
+			getResponse:   currentUser,
+			updateCalled:  true,
+			expectedError: nil,
+		},
+		{
+			name:          "version mismatch",
+			userID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+			versions:      []string{"outdated_version"},
+			getResponse:   currentUser,
+			expectedError: fmt.Errorf("user '1311f914-1d4f-40b6-8886-80193265d5a4' was modified (version mismatch): %w", ErrPreconditionFailed),
+		},
+		{
+			name:          "not found",
+			userID:        "unknown_id",
+			getError:      ErrNotFound,
+			expectedError: ErrNotFound,
+		},
+		{
+			name:          "already deleted - not found",
+			userID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+			getResponse:   deletedUser,
+			expectedError: ErrNotFound,
+		},
+		{
+			name:          "repo error",
+			userID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+			getResponse:   currentUser,
+			updateCalled:  true,
+			updateError:   fmt.Errorf("repo error"),
+			expectedError: fmt.Errorf("repo error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+
+			ctx := context.Background()
+
+			mockUsersRepo.On("GetUser", ctx, tc.userID).Return(tc.getResponse, tc.getError)
+			mockUsersRepo.On("UpdateUser", ctx, deletedUser, UserVersion(tc.getResponse)).Return(deletedUser, tc.updateError)
+
+			svc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+
+			err := svc.DeleteUser(ctx, tc.userID, tc.versions)
+
+			if tc.updateCalled {
+				mockUsersRepo.AssertExpectations(t)
+			} else {
+				mockUsersRepo.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything, mock.Anything)
+			}
+			mockUsersRepo.AssertNotCalled(t, "DeleteUser", mock.Anything, mock.Anything)
+
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	deletedAt := time.Date(2022, time.January, 10, 15, 0, 0, 0, time.UTC)
+	restoredUser := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+	deletedUser := restoredUser
+	deletedUser.DeletedAt = &deletedAt
+
+	testCases := []struct {
+		name          string
+		userID        string
+		getResponse   User
+		getError      error
+		updateCalled  bool
+		expectedUser  User
+		expectedError error
+	}{
+		{
+			name:          "base case",
+			userID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+			getResponse:   deletedUser,
+			updateCalled:  true,
+			expectedUser:  restoredUser,
+			expectedError: nil,
+		},
+		{
+			name:          "not deleted",
+			userID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+			getResponse:   restoredUser,
+			expectedUser:  User{},
+			expectedError: fmt.Errorf("user '1311f914-1d4f-40b6-8886-80193265d5a4' is not deleted: %w", ErrPreconditionFailed),
+		},
+		{
+			name:          "not found",
+			userID:        "unknown_id",
+			getError:      ErrNotFound,
+			expectedUser:  User{},
+			expectedError: ErrNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+
+			ctx := context.Background()
+
+			mockUsersRepo.On("GetUser", ctx, tc.userID).Return(tc.getResponse, tc.getError)
+			mockUsersRepo.On("UpdateUser", ctx, restoredUser, UserVersion(tc.getResponse)).Return(restoredUser, nil)
+
+			svc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 0, 0)
+
+			user, err := svc.RestoreUser(ctx, tc.userID)
+
+			if tc.updateCalled {
+				mockUsersRepo.AssertExpectations(t)
+			} else {
+				mockUsersRepo.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything, mock.Anything)
+			}
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedUser, user)
+		})
+	}
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2022, time.February, 10, 0, 0, 0, 0, time.UTC)
+	}
+	defer func() { timeNow = time.Now }()
+
+	expiredDate := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	recentDate := time.Date(2022, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	users := []User{
+		{ID: "1"},
+		{ID: "2", FirstName: "Terrence", LastName: "Trillow", Email: "ttrillow1@feedburner.com", IPAddress: "63.119.6.98", DeletedAt: &expiredDate},
+		{ID: "3", DeletedAt: &recentDate},
+		{ID: "4", DeletedAt: &expiredDate},
+		{ID: "5", DeletedAt: &expiredDate},
+	}
+
+	mockUsersRepo := new(mockUsersRepo)
+
+	ctx := context.Background()
+
+	mockUsersRepo.On("GetUsers", ctx, UsersQuery{
+		Limit:  exportBatchSize,
+		Cursor: &UsersCursor{},
+		Filter: UsersFilter{OnlyDeleted: true},
+	}).Return(UsersPage{Users: users[1:]}, nil)
+	mockUsersRepo.On("GetUser", ctx, "2").Return(users[1], nil)
+	// restored in the meantime
+	mockUsersRepo.On("GetUser", ctx, "4").Return(User{ID: "4"}, nil)
+	// purged in the meantime
+	mockUsersRepo.On("GetUser", ctx, "5").Return(User{}, ErrNotFound)
+	mockUsersRepo.On("DeleteUser", ctx, "2").Return(nil)
+
+	// the personal values are anonymized in the history, and not recorded by the purge revision
+	mockRevisionsRepo := new(mockRevisionsRepo)
+	mockRevisionsRepo.On("GetRevisions", ctx, "2").Return([]UserRevision{{
+		UserID:  "2",
+		Number:  1,
+		Action:  RevisionUpdated,
+		Changes: []FieldChange{{Field: "email", From: "terrence@example.com", To: "ttrillow1@feedburner.com"}},
+	}}, nil)
+	mockRevisionsRepo.On("ReplaceRevisions", ctx, "2", []UserRevision{{
+		UserID:  "2",
+		Number:  1,
+		Action:  RevisionUpdated,
+		Changes: []FieldChange{{Field: "email", From: "2@erased.invalid", To: "2@erased.invalid"}},
+	}}).Return(nil)
+	mockRevisionsRepo.On("AddRevisions", ctx, []UserRevision{{
+		UserID: "2",
+		Action: RevisionPurged,
+		Actor:  "system",
+		Time:   timeNow(),
+		Changes: []FieldChange{
+			{Field: "id", From: "2"},
+			{Field: "first_name", From: "Erased"},
+			{Field: "last_name", From: "User"},
+			{Field: "email", From: "2@erased.invalid"},
+			{Field: "deleted_at", From: "2022-01-01T00:00:00Z"},
+		},
+	}}).Return(nil)
+
+	svc := NewUsersService(mockUsersRepo, mockRevisionsRepo, new(mockAuditRepo), 0, 0)
+
+	purged, err := svc.PurgeDeletedUsers(ctx, 30*24*time.Hour)
+
+	mockUsersRepo.AssertExpectations(t)
+	mockUsersRepo.AssertNumberOfCalls(t, "DeleteUser", 1)
+	mockRevisionsRepo.AssertExpectations(t)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+}
+
+func TestAuthenticate(t *testing.T) {
+	passwordHash, err := HashPassword("5YLItbmdkfC1")
+	assert.NoError(t, err)
+
+	user := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		Password:     passwordHash,
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+	deletedAt := time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC)
+	deletedUser := user
+	deletedUser.DeletedAt = &deletedAt
+
+	testCases := []struct {
+		name              string
+		email             string
+		password          string
+		previousFailures  int
+		repoResponse      User
+		repoError         error
+		expectedUser      User
+		expectedError     error
+		expectedRepoCalls bool
+	}{
+		{
+			name:              "base case",
+			email:             "ttrillow1@feedburner.com",
+			password:          "5YLItbmdkfC1",
+			repoResponse:      user,
+			expectedUser:      user,
+			expectedError:     nil,
+			expectedRepoCalls: true,
+		},
+		{
+			name:              "wrong password",
+			email:             "ttrillow1@feedburner.com",
+			password:          "wrong",
+			repoResponse:      user,
+			expectedUser:      User{},
+			expectedError:     ErrUnauthorized,
+			expectedRepoCalls: true,
+		},
+		{
+			name:              "unknown email",
+			email:             "unknown@feedburner.com",
+			password:          "5YLItbmdkfC1",
+			repoResponse:      User{},
+			repoError:         ErrNotFound,
+			expectedUser:      User{},
+			expectedError:     ErrUnauthorized,
+			expectedRepoCalls: true,
+		},
+		{
+			name:              "deleted user",
+			email:             "ttrillow1@feedburner.com",
+			password:          "5YLItbmdkfC1",
+			repoResponse:      deletedUser,
+			expectedUser:      User{},
+			expectedError:     ErrUnauthorized,
+			expectedRepoCalls: true,
+		},
+		{
+			name:              "repo error",
+			email:             "ttrillow1@feedburner.com",
+			password:          "5YLItbmdkfC1",
+			repoResponse:      User{},
+			repoError:         fmt.Errorf("repo error"),
+			expectedUser:      User{},
+			expectedError:     fmt.Errorf("repo error"),
+			expectedRepoCalls: true,
+		},
+		{
+			name:              "locked account - even with the right password",
+			email:             "ttrillow1@feedburner.com",
+			password:          "5YLItbmdkfC1",
+			previousFailures:  3,
+			repoResponse:      user,
+			expectedUser:      User{},
+			expectedError:     ErrTooManyAttempts,
+			expectedRepoCalls: false,
+		},
+		{
+			name:              "locked account - email with other case and spaces",
+			email:             " TTrillow1@Feedburner.com ",
+			password:          "5YLItbmdkfC1",
+			previousFailures:  3,
+			repoResponse:      user,
+			expectedUser:      User{},
+			expectedError:     ErrTooManyAttempts,
+			expectedRepoCalls: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersRepo := new(mockUsersRepo)
+
+			ctx := context.Background()
+
+			mockUsersRepo.On("GetUserByEmail", ctx, tc.email).Return(tc.repoResponse, tc.repoError)
+
+			svc := NewUsersService(mockUsersRepo, newMockRevisionsRepo(), new(mockAuditRepo), 3, time.Minute)
+			for i := 0; i < tc.previousFailures; i++ {
+				svc.loginAttempts.recordFailure("ttrillow1@feedburner.com")
+			}
+
+			user, err := svc.Authenticate(ctx, tc.email, tc.password)
+
+			if tc.expectedRepoCalls {
+				mockUsersRepo.AssertExpectations(t)
+			} else {
+				mockUsersRepo.AssertNotCalled(t, "GetUserByEmail", mock.Anything, mock.Anything)
+			}
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedUser, user)
+		})
+	}
+}
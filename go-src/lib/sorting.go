@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// SortField represents a users sorting field (user JSON field name) and its direction
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// userSortKeys maps the sortable user fields (JSON names) to their sort key getters,
+// the sort keys are strings that compare in the natural order of the field (invalid values sort first)
+var userSortKeys = map[string]func(user User) string{
+	"id":         func(user User) string { return user.ID },
+	"first_name": func(user User) string { return strings.ToLower(user.FirstName) },
+	"last_name":  func(user User) string { return strings.ToLower(user.LastName) },
+	"email":      func(user User) string { return strings.ToLower(user.Email) },
+	"ip_address": func(user User) string {
+		// comparing the IPv6 (16 bytes) form, so IPv4 and IPv6 addresses are numerically ordered
+		ip := net.ParseIP(user.IPAddress)
+		if ip == nil {
+			return ""
+		}
+		return string(ip.To16())
+	},
+	"creation_date": func(user User) string {
+		// fixed width UTC timestamp, so it compares lexically (the missing dates sort first)
+		return user.CreationDate.UTC().Format("20060102150405.000000000")
+	},
+}
+
+// IsSortableUserField checks if the users can be sorted by the field (user JSON field name)
+func IsSortableUserField(field string) bool {
+	_, ok := userSortKeys[field]
+	return ok
+}
+
+// UserSortKey gets the user sort key of the sortable field (user JSON field name), that compares in the natural order
+// of the field (e.g. to be stored by the repos that sort on their own), empty if the field is not sortable
+func UserSortKey(user User, field string) string {
+	sortKey, ok := userSortKeys[field]
+	if !ok {
+		return ""
+	}
+	return sortKey(user)
+}
+
+// SortUsers sorts (stable) the users in place by the sort fields, in order of priority
+func SortUsers(users []User, sortFields []SortField) {
+	if len(sortFields) == 0 {
+		return
+	}
+
+	// computing the sort keys only once per user (parsing dates and IPs is expensive)
+	sortable := make([]sortableUser, len(users))
+	for i, user := range users {
+		sortable[i] = sortableUser{
+			User: user,
+			keys: userSortKeysOf(user, sortFields),
+		}
+	}
+
+	sort.SliceStable(sortable, func(i, j int) bool {
+		return compareSortKeys(sortable[i].keys, sortable[j].keys, sortFields) < 0
+	})
+
+	for i := range sortable {
+		users[i] = sortable[i].User
+	}
+}
+
+// userSortKeysOf gets the user sort keys for the sort fields
+func userSortKeysOf(user User, sortFields []SortField) []string {
+	keys := make([]string, len(sortFields))
+	for i, sortField := range sortFields {
+		keys[i] = userSortKeys[sortField.Field](user)
+	}
+	return keys
+}
+
+// sortableUser is the user with its precomputed sort keys
+type sortableUser struct {
+	User
+	keys []string
+}
+
+// compareSortKeys compares the sort keys of two users, following the sort fields directions
+func compareSortKeys(a, b []string, sortFields []SortField) int {
+	for i, sortField := range sortFields {
+		cmp := strings.Compare(a[i], b[i])
+		if cmp == 0 {
+			continue
+		}
+		if sortField.Descending {
+			return -cmp
+		}
+		return cmp
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortUsers(t *testing.T) {
+	users := []User{
+		{ID: "1", FirstName: "nicky", LastName: "Blasio", IPAddress: "43.113.46.36", CreationDate: time.Date(2021, time.June, 6, 0, 0, 0, 0, time.UTC)},
+		{ID: "2", FirstName: "Terrence", LastName: "Trillow", IPAddress: "9.119.6.98", CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC)},
+		{ID: "3", FirstName: "Niels", LastName: "Blasio", IPAddress: "2001:db8::1", CreationDate: time.Date(2022, time.January, 19, 0, 0, 0, 0, time.UTC)},
+		{ID: "4", FirstName: "Amie", LastName: "Trillow", IPAddress: "invalid"},
+	}
+
+	testCases := []struct {
+		name        string
+		sortFields  []SortField
+		expectedIDs []string
+	}{
+		{
+			name:        "no sorting - original order",
+			sortFields:  nil,
+			expectedIDs: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:        "case-insensitive string",
+			sortFields:  []SortField{{Field: "first_name"}},
+			expectedIDs: []string{"4", "1", "3", "2"},
+		},
+		{
+			name:        "creation date is compared as date (not lexically), invalid first",
+			sortFields:  []SortField{{Field: "creation_date"}},
+			expectedIDs: []string{"4", "2", "1", "3"},
+		},
+		{
+			name:        "descending",
+			sortFields:  []SortField{{Field: "creation_date", Descending: true}},
+			expectedIDs: []string{"3", "1", "2", "4"},
+		},
+		{
+			name:        "IP addresses are compared numerically",
+			sortFields:  []SortField{{Field: "ip_address"}},
+			expectedIDs: []string{"4", "2", "1", "3"},
+		},
+		{
+			name:        "multiple fields",
+			sortFields:  []SortField{{Field: "last_name"}, {Field: "creation_date", Descending: true}},
+			expectedIDs: []string{"3", "1", "2", "4"},
+		},
+		{
+			name:        "stable - ties keep the original order",
+			sortFields:  []SortField{{Field: "last_name", Descending: true}},
+			expectedIDs: []string{"2", "4", "1", "3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sortedUsers := append([]User(nil), users...)
+			SortUsers(sortedUsers, tc.sortFields)
+
+			ids := make([]string, len(sortedUsers))
+			for i, user := range sortedUsers {
+				ids[i] = user.ID
+			}
+			assert.Equal(t, tc.expectedIDs, ids)
+		})
+	}
+}
+
+func TestIsSortableUserField(t *testing.T) {
+	assert.True(t, IsSortableUserField("creation_date"))
+	assert.True(t, IsSortableUserField("last_name"))
+	assert.False(t, IsSortableUserField("password"))
+	assert.False(t, IsSortableUserField("unknown"))
+}
+
+func TestUserSortKey(t *testing.T) {
+	user := User{
+		ID:           "1",
+		FirstName:    "Nicky",
+		IPAddress:    "43.113.46.36",
+		CreationDate: time.Date(2021, time.June, 6, 12, 0, 0, 0, time.FixedZone("BRT", -3*60*60)),
+	}
+
+	assert.Equal(t, "nicky", UserSortKey(user, "first_name"))
+	assert.Equal(t, "20210606150000.000000000", UserSortKey(user, "creation_date"))
+	assert.Equal(t, "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff\x2b\x71\x2e\x24", UserSortKey(user, "ip_address"))
+	assert.Equal(t, "", UserSortKey(User{IPAddress: "invalid"}, "ip_address"))
+	assert.Equal(t, "", UserSortKey(user, "password"))
+}
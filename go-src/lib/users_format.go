@@ -0,0 +1,350 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DataFormat represents a users data exchange format
+type DataFormat string
+
+const (
+	// FormatJSON is a JSON array of users
+	FormatJSON DataFormat = "json"
+	// FormatNDJSON is a newline delimited JSON, one user per line
+	FormatNDJSON DataFormat = "ndjson"
+	// FormatCSV is a CSV with a header row (the user JSON field names) and one user per row
+	FormatCSV DataFormat = "csv"
+)
+
+// UserCSVColumns are the CSV columns (the user JSON field names), in the default order
+var UserCSVColumns = []string{"id", "first_name", "last_name", "email", "password", "ip_address", "creation_date", "deleted_at"}
+
+// UserExportColumns are the exported user fields (all but the password), in the default order
+var UserExportColumns = []string{"id", "first_name", "last_name", "email", "ip_address", "creation_date"}
+
+// UsersRow represents a user read from the users data, or the reason why it could not be read
+type UsersRow struct {
+	// Row is the row position in the data (starting at 1, not counting the CSV header)
+	Row  int
+	User User
+	Err  error
+}
+
+// ParseDataFormat parses the data format name (json, ndjson or csv)
+func ParseDataFormat(name string) (DataFormat, error) {
+	switch format := DataFormat(name); format {
+	case FormatJSON, FormatNDJSON, FormatCSV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown data format '%s': %w", name, ErrPreconditionFailed)
+	}
+}
+
+// ReadUsersRows reads the users from the data in the format received as parameter,
+// the invalid rows are returned with their error (only an unreadable data as a whole fails)
+func ReadUsersRows(r io.Reader, format DataFormat) ([]UsersRow, error) {
+	switch format {
+	case FormatJSON:
+		return readJSONUsersRows(r)
+	case FormatNDJSON:
+		return readNDJSONUsersRows(r)
+	case FormatCSV:
+		return readCSVUsersRows(r)
+	default:
+		return nil, fmt.Errorf("unknown data format '%s': %w", format, ErrPreconditionFailed)
+	}
+}
+
+// readJSONUsersRows reads the users from a JSON array
+func readJSONUsersRows(r io.Reader) ([]UsersRow, error) {
+	var items []json.RawMessage
+	err := json.NewDecoder(r).Decode(&items)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON array: %s: %w", err.Error(), ErrPreconditionFailed)
+	}
+
+	rows := make([]UsersRow, len(items))
+	for i, item := range items {
+		rows[i] = decodeJSONUsersRow(i+1, item)
+	}
+
+	return rows, nil
+}
+
+// readNDJSONUsersRows reads the users from a newline delimited JSON, the blank lines are skipped
+func readNDJSONUsersRows(r io.Reader) ([]UsersRow, error) {
+	var rows []UsersRow
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			rows = append(rows, decodeJSONUsersRow(len(rows)+1, line))
+		}
+
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// decodeJSONUsersRow decodes a user JSON object, the unknown fields are rejected
+func decodeJSONUsersRow(row int, data []byte) UsersRow {
+	var user User
+	err := json.NewDecoder(bytes.NewReader(data)).Decode(&user)
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return UsersRow{Row: row, User: user, Err: validationErr}
+	}
+	if err != nil {
+		return UsersRow{Row: row, Err: fmt.Errorf("invalid JSON: %s", err.Error())}
+	}
+
+	return UsersRow{Row: row, User: user}
+}
+
+// readCSVUsersRows reads the users from a CSV, the header row must only contain user JSON field names (in any order)
+func readCSVUsersRows(r io.Reader) ([]UsersRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // the number of fields is checked for each row
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return []UsersRow{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %s: %w", err.Error(), ErrPreconditionFailed)
+	}
+
+	err = validateCSVHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []UsersRow{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %s: %w", err.Error(), ErrPreconditionFailed)
+		}
+
+		row := UsersRow{Row: len(rows) + 1}
+		if len(record) != len(header) {
+			row.Err = fmt.Errorf("expected %d columns, got %d", len(header), len(record))
+		} else {
+			row.User, row.Err = userFromCSVRecord(header, record)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// validateCSVHeader checks that the CSV columns are known and not duplicated
+func validateCSVHeader(header []string) error {
+	seen := make(map[string]bool, len(header))
+
+	for _, column := range header {
+		known := false
+		for _, userColumn := range UserCSVColumns {
+			if column == userColumn {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown CSV column '%s': %w", column, ErrPreconditionFailed)
+		}
+
+		if seen[column] {
+			return fmt.Errorf("duplicated CSV column '%s': %w", column, ErrPreconditionFailed)
+		}
+		seen[column] = true
+	}
+
+	return nil
+}
+
+// userFromCSVRecord creates the user from the CSV record, the fields are got by the header columns
+func userFromCSVRecord(header []string, record []string) (User, error) {
+	var userRecord userRecord
+
+	for i, column := range header {
+		switch column {
+		case "id":
+			userRecord.ID = record[i]
+		case "first_name":
+			userRecord.FirstName = record[i]
+		case "last_name":
+			userRecord.LastName = record[i]
+		case "email":
+			userRecord.Email = record[i]
+		case "password":
+			userRecord.Password = record[i]
+		case "ip_address":
+			userRecord.IPAddress = record[i]
+		case "creation_date":
+			userRecord.CreationDate = record[i]
+		case "deleted_at":
+			userRecord.DeletedAt = record[i]
+		}
+	}
+
+	return userRecord.user()
+}
+
+// userColumnValue gets the user field value of the column (a user JSON field name),
+// the creation date in RFC 3339 or in the legacy format
+func userColumnValue(user User, column string, legacyDates bool) string {
+	switch column {
+	case "id":
+		return user.ID
+	case "first_name":
+		return user.FirstName
+	case "last_name":
+		return user.LastName
+	case "email":
+		return user.Email
+	case "password":
+		return user.Password
+	case "ip_address":
+		return user.IPAddress
+	case "creation_date":
+		return FormatCreationDate(user.CreationDate, legacyDates)
+	case "deleted_at":
+		if !user.IsDeleted() {
+			return ""
+		}
+		return user.DeletedAt.UTC().Format(time.RFC3339)
+	default:
+		return ""
+	}
+}
+
+// UsersWriter writes users one by one in a data format (the reverse of ReadUsersRows), only the selected columns are written,
+// it is buffered, so Flush must be called to send the written users to the underlying writer
+type UsersWriter struct {
+	w       *bufio.Writer
+	format  DataFormat
+	columns []string
+	csv     *csv.Writer
+	// legacyDates writes the creation dates in the legacy format (dd/mm/yyyy) instead of RFC 3339
+	legacyDates bool
+	// count is the number of written users
+	count int
+}
+
+// NewUsersWriter creates a new users writer, receives the underlying writer, the data format and the columns
+// (user JSON field names, UserExportColumns if empty) as parameters
+func NewUsersWriter(w io.Writer, format DataFormat, columns []string) (*UsersWriter, error) {
+	if len(columns) == 0 {
+		columns = UserExportColumns
+	}
+	err := validateCSVHeader(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	uw := &UsersWriter{
+		w:       bufio.NewWriter(w),
+		format:  format,
+		columns: columns,
+	}
+
+	switch format {
+	case FormatJSON:
+		_, err = uw.w.WriteString("[")
+	case FormatNDJSON:
+	case FormatCSV:
+		uw.csv = csv.NewWriter(uw.w)
+		err = uw.csv.Write(columns)
+	default:
+		return nil, fmt.Errorf("unknown data format '%s': %w", format, ErrPreconditionFailed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return uw, nil
+}
+
+// UseLegacyDates writes the creation dates in the legacy format (dd/mm/yyyy) instead of RFC 3339
+func (uw *UsersWriter) UseLegacyDates() {
+	uw.legacyDates = true
+}
+
+// Write writes the user (its selected columns)
+func (uw *UsersWriter) Write(user User) error {
+	defer func() { uw.count++ }()
+
+	if uw.format == FormatCSV {
+		record := make([]string, len(uw.columns))
+		for i, column := range uw.columns {
+			record[i] = userColumnValue(user, column, uw.legacyDates)
+		}
+		return uw.csv.Write(record)
+	}
+
+	if uw.format == FormatJSON && uw.count > 0 {
+		uw.w.WriteString(",")
+	}
+
+	// writing the JSON object field by field, to keep the columns order
+	uw.w.WriteString("{")
+	for i, column := range uw.columns {
+		if i > 0 {
+			uw.w.WriteString(",")
+		}
+		key, _ := json.Marshal(column)
+		value, _ := json.Marshal(userColumnValue(user, column, uw.legacyDates))
+		uw.w.Write(key)
+		uw.w.WriteString(":")
+		uw.w.Write(value)
+	}
+	_, err := uw.w.WriteString("}")
+	if err != nil {
+		return err
+	}
+
+	if uw.format == FormatNDJSON {
+		_, err = uw.w.WriteString("\n")
+	}
+	return err
+}
+
+// Flush sends the written users to the underlying writer
+func (uw *UsersWriter) Flush() error {
+	if uw.csv != nil {
+		uw.csv.Flush()
+		err := uw.csv.Error()
+		if err != nil {
+			return err
+		}
+	}
+
+	return uw.w.Flush()
+}
+
+// Close ends the users data (e.g. the JSON array) and flushes it, the underlying writer is not closed
+func (uw *UsersWriter) Close() error {
+	if uw.format == FormatJSON {
+		_, err := uw.w.WriteString("]\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return uw.Flush()
+}
@@ -0,0 +1,196 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDataFormat(t *testing.T) {
+	format, err := ParseDataFormat("ndjson")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatNDJSON, format)
+
+	_, err = ParseDataFormat("xml")
+	assert.Equal(t, fmt.Errorf("unknown data format 'xml': %w", ErrPreconditionFailed), err)
+}
+
+func TestReadUsersRows(t *testing.T) {
+	testCases := []struct {
+		name          string
+		format        DataFormat
+		data          string
+		expectedRows  []UsersRow
+		expectedError error
+	}{
+		{
+			name:   "JSON",
+			format: FormatJSON,
+			data:   `[{"first_name":"Terrence","email":"ttrillow1@feedburner.com"},{"first_name":"Niels","unknown":1}]`,
+			expectedRows: []UsersRow{
+				{Row: 1, User: User{FirstName: "Terrence", Email: "ttrillow1@feedburner.com"}},
+				{Row: 2, Err: errors.New(`invalid JSON: json: unknown field "unknown"`)},
+			},
+			expectedError: nil,
+		},
+		{
+			name:          "JSON - truncated array",
+			format:        FormatJSON,
+			data:          `[{"first_name":"Terrence"}`,
+			expectedRows:  nil,
+			expectedError: fmt.Errorf("invalid JSON array: unexpected EOF: %w", ErrPreconditionFailed),
+		},
+		{
+			name:   "NDJSON - blank lines skipped, last line without newline",
+			format: FormatNDJSON,
+			data:   "{\"first_name\":\"Terrence\"}\n\n{\"first_name\":\n{\"first_name\":\"Niels\"}",
+			expectedRows: []UsersRow{
+				{Row: 1, User: User{FirstName: "Terrence"}},
+				{Row: 2, Err: errors.New("invalid JSON: unexpected EOF")},
+				{Row: 3, User: User{FirstName: "Niels"}},
+			},
+			expectedError: nil,
+		},
+		{
+			name:   "CSV - columns in any order",
+			format: FormatCSV,
+			data:   "email,first_name\nttrillow1@feedburner.com,Terrence\nnmacpaik2@phoca.cz\n",
+			expectedRows: []UsersRow{
+				{Row: 1, User: User{FirstName: "Terrence", Email: "ttrillow1@feedburner.com"}},
+				{Row: 2, Err: errors.New("expected 2 columns, got 1")},
+			},
+			expectedError: nil,
+		},
+		{
+			name:          "CSV - empty",
+			format:        FormatCSV,
+			data:          "",
+			expectedRows:  []UsersRow{},
+			expectedError: nil,
+		},
+		{
+			name:          "CSV - unknown column",
+			format:        FormatCSV,
+			data:          "email,age\n",
+			expectedRows:  nil,
+			expectedError: fmt.Errorf("unknown CSV column 'age': %w", ErrPreconditionFailed),
+		},
+		{
+			name:          "CSV - duplicated column",
+			format:        FormatCSV,
+			data:          "email,email\n",
+			expectedRows:  nil,
+			expectedError: fmt.Errorf("duplicated CSV column 'email': %w", ErrPreconditionFailed),
+		},
+		{
+			name:          "unknown format",
+			format:        DataFormat("xml"),
+			data:          "<users/>",
+			expectedRows:  nil,
+			expectedError: fmt.Errorf("unknown data format 'xml': %w", ErrPreconditionFailed),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, err := ReadUsersRows(strings.NewReader(tc.data), tc.format)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedRows, rows)
+		})
+	}
+}
+
+func TestUsersWriter(t *testing.T) {
+	users := []User{
+		{ID: "1", FirstName: "Terrence", LastName: "Trillow", Email: "ttrillow1@feedburner.com", Password: "5YLItbmdkfC1", IPAddress: "63.119.6.98", CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC)},
+		{ID: "2", FirstName: "Niels, \"Jr\"", LastName: "MacPaik", Email: "nmacpaik2@phoca.cz", Password: "Vae1mnI", IPAddress: "::1", CreationDate: time.Date(2021, time.January, 19, 0, 0, 0, 0, time.UTC)},
+	}
+
+	testCases := []struct {
+		name           string
+		format         DataFormat
+		columns        []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{
+			name:    "CSV - default columns, without password",
+			format:  FormatCSV,
+			columns: nil,
+			expectedOutput: "id,first_name,last_name,email,ip_address,creation_date\n" +
+				"1,Terrence,Trillow,ttrillow1@feedburner.com,63.119.6.98,2021-04-19T00:00:00Z\n" +
+				"2,\"Niels, \"\"Jr\"\"\",MacPaik,nmacpaik2@phoca.cz,::1,2021-01-19T00:00:00Z\n",
+			expectedError: nil,
+		},
+		{
+			name:    "NDJSON - selected columns in order",
+			format:  FormatNDJSON,
+			columns: []string{"email", "id"},
+			expectedOutput: `{"email":"ttrillow1@feedburner.com","id":"1"}` + "\n" +
+				`{"email":"nmacpaik2@phoca.cz","id":"2"}` + "\n",
+			expectedError: nil,
+		},
+		{
+			name:           "JSON - array",
+			format:         FormatJSON,
+			columns:        []string{"id", "first_name"},
+			expectedOutput: `[{"id":"1","first_name":"Terrence"},{"id":"2","first_name":"Niels, \"Jr\""}]` + "\n",
+			expectedError:  nil,
+		},
+		{
+			name:           "unknown column",
+			format:         FormatCSV,
+			columns:        []string{"age"},
+			expectedOutput: "",
+			expectedError:  fmt.Errorf("unknown CSV column 'age': %w", ErrPreconditionFailed),
+		},
+		{
+			name:           "unknown format",
+			format:         DataFormat("xml"),
+			columns:        nil,
+			expectedOutput: "",
+			expectedError:  fmt.Errorf("unknown data format 'xml': %w", ErrPreconditionFailed),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var output strings.Builder
+
+			writer, err := NewUsersWriter(&output, tc.format, tc.columns)
+			assert.Equal(t, tc.expectedError, err)
+			if err != nil {
+				return
+			}
+
+			for _, user := range users {
+				assert.NoError(t, writer.Write(user))
+			}
+			assert.NoError(t, writer.Close())
+
+			assert.Equal(t, tc.expectedOutput, output.String())
+		})
+	}
+}
+
+func TestUsersWriterReadBack(t *testing.T) {
+	user := User{ID: "1", FirstName: "Terrence", LastName: "Trillow", Email: "ttrillow1@feedburner.com", Password: "5YLItbmdkfC1", IPAddress: "63.119.6.98", CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC)}
+
+	for _, format := range []DataFormat{FormatJSON, FormatNDJSON, FormatCSV} {
+		var output strings.Builder
+
+		writer, err := NewUsersWriter(&output, format, UserCSVColumns)
+		assert.NoError(t, err)
+		assert.NoError(t, writer.Write(user))
+		assert.NoError(t, writer.Close())
+
+		rows, err := ReadUsersRows(strings.NewReader(output.String()), format)
+		assert.NoError(t, err)
+		assert.Equal(t, []UsersRow{{Row: 1, User: user}}, rows, format)
+	}
+}
@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// requiredMessage is the field error message of a missing required field
+	requiredMessage = "is required"
+	// emailUsedMessage is the field error message of an email that is not unique
+	emailUsedMessage = "is already used by another user"
+	// invalidCreationDateMessage is the field error message of a missing or unparsable creation date
+	invalidCreationDateMessage = "must be a valid date (RFC 3339 or dd/mm/yyyy)"
+)
+
+type (
+	// FieldError represents an invalid user field (user JSON field name) and the reason
+	FieldError struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	}
+
+	// ValidationError represents the invalid fields of a user, it wraps ErrValidation
+	ValidationError struct {
+		Fields []FieldError
+	}
+)
+
+// Error formats the invalid fields in a descriptive format (required for the custom error)
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = fmt.Sprintf("'%s' %s", field.Field, field.Message)
+	}
+	return fmt.Sprintf("invalid user: %s", strings.Join(messages, ", "))
+}
+
+// Unwrap gets the wrapped ErrValidation, so errors.Is can be used
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// add adds an invalid field
+func (e *ValidationError) add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// hasField checks if the field is already invalid
+func (e *ValidationError) hasField(field string) bool {
+	for _, fieldErr := range e.Fields {
+		if fieldErr.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// orNil gets the validation error only if there is any invalid field
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ValidateUser checks the user fields format, returning a *ValidationError with all the invalid fields:
+// - the ID is a UUID
+// - the email is an RFC 5322 address (without display name)
+// - the IP address is IPv4 or IPv6 (optional)
+// - the creation date is set (the unparsable dates are reported when reading the users data)
+// The required fields and the email uniqueness are checked apart
+func ValidateUser(user User) error {
+	return validateUserFields(user).orNil()
+}
+
+// ValidateUsersData checks each user format and that the IDs and emails (case-insensitive) are unique, along with
+// the date errors of the decoded users data (see DecodeUsersData, nil if there are none),
+// returning the *ValidationError of each invalid user by its position (empty if all are valid)
+func ValidateUsersData(usersData []User, dateErrs map[int]error) map[int]error {
+	invalidUsers := make(map[int]error)
+	seenIDs := make(map[string]bool, len(usersData))
+	seenEmails := make(map[string]bool, len(usersData))
+
+	for i, user := range usersData {
+		validationErr := &ValidationError{}
+
+		// the invalid dates are reported as decoded (with the invalid values), instead of as missing
+		var dateErr *ValidationError
+		if errors.As(dateErrs[i], &dateErr) {
+			validationErr.Fields = append(validationErr.Fields, dateErr.Fields...)
+		}
+		for _, fieldErr := range validateUserFields(user).Fields {
+			if !validationErr.hasField(fieldErr.Field) {
+				validationErr.add(fieldErr.Field, fieldErr.Message)
+			}
+		}
+
+		if seenIDs[user.ID] {
+			validationErr.add("id", "is duplicated")
+		}
+		seenIDs[user.ID] = true
+
+		email := strings.ToLower(user.Email)
+		if seenEmails[email] {
+			validationErr.add("email", emailUsedMessage)
+		}
+		seenEmails[email] = true
+
+		if err := validationErr.orNil(); err != nil {
+			invalidUsers[i] = err
+		}
+	}
+
+	return invalidUsers
+}
+
+// validateUserFields checks the user fields format, the validation error has no fields if all are valid
+func validateUserFields(user User) *ValidationError {
+	validationErr := &ValidationError{}
+
+	// only the canonical form (e.g. no braces or "urn:uuid:" prefix)
+	if _, err := uuid.Parse(user.ID); err != nil || len(user.ID) != 36 {
+		validationErr.add("id", "must be a UUID")
+	}
+
+	if !isValidEmail(user.Email) {
+		validationErr.add("email", "must be a valid email address")
+	}
+
+	if user.IPAddress != "" && net.ParseIP(user.IPAddress) == nil {
+		validationErr.add("ip_address", "must be an IPv4 or IPv6 address")
+	}
+
+	if user.CreationDate.IsZero() {
+		validationErr.add("creation_date", invalidCreationDateMessage)
+	}
+
+	return validationErr
+}
+
+// isValidEmail checks if the email is a bare RFC 5322 address (e.g. "ttrillow1@feedburner.com", not "Terrence <ttrillow1@feedburner.com>")
+func isValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Name == "" && address.Address == email
+}
@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUser(t *testing.T) {
+	validUser := User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+
+	testCases := []struct {
+		name          string
+		change        func(user *User)
+		expectedError error
+	}{
+		{
+			name:          "valid",
+			change:        func(user *User) {},
+			expectedError: nil,
+		},
+		{
+			name:          "valid - IPv6 address",
+			change:        func(user *User) { user.IPAddress = "2001:db8::68" },
+			expectedError: nil,
+		},
+		{
+			name:          "valid - missing IP address",
+			change:        func(user *User) { user.IPAddress = "" },
+			expectedError: nil,
+		},
+		{
+			name:   "invalid ID - not a UUID",
+			change: func(user *User) { user.ID = "1311f914" },
+			expectedError: &ValidationError{Fields: []FieldError{
+				{Field: "id", Message: "must be a UUID"},
+			}},
+		},
+		{
+			name:   "invalid ID - not the canonical UUID form",
+			change: func(user *User) { user.ID = "{1311f914-1d4f-40b6-8886-80193265d5a4}" },
+			expectedError: &ValidationError{Fields: []FieldError{
+				{Field: "id", Message: "must be a UUID"},
+			}},
+		},
+		{
+			name:   "invalid email - display name",
+			change: func(user *User) { user.Email = "Terrence <ttrillow1@feedburner.com>" },
+			expectedError: &ValidationError{Fields: []FieldError{
+				{Field: "email", Message: "must be a valid email address"},
+			}},
+		},
+		{
+			name:   "invalid email - missing domain",
+			change: func(user *User) { user.Email = "ttrillow1@" },
+			expectedError: &ValidationError{Fields: []FieldError{
+				{Field: "email", Message: "must be a valid email address"},
+			}},
+		},
+		{
+			name: "all invalid fields",
+			change: func(user *User) {
+				user.ID = ""
+				user.Email = "ttrillow1 feedburner.com"
+				user.IPAddress = "63.119.6.256"
+				user.CreationDate = time.Time{}
+			},
+			expectedError: &ValidationError{Fields: []FieldError{
+				{Field: "id", Message: "must be a UUID"},
+				{Field: "email", Message: "must be a valid email address"},
+				{Field: "ip_address", Message: "must be an IPv4 or IPv6 address"},
+				{Field: "creation_date", Message: "must be a valid date (RFC 3339 or dd/mm/yyyy)"},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := validUser
+			tc.change(&user)
+
+			err := ValidateUser(user)
+
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestValidateUsersData(t *testing.T) {
+	usersData := []User{
+		{ID: "1311f914-1d4f-40b6-8886-80193265d5a4", Email: "ttrillow1@feedburner.com", CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC)},
+		{ID: "1311f914-1d4f-40b6-8886-80193265d5a4", Email: "TTrillow1@Feedburner.com", CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC)},
+		{ID: "3e601207-0e80-4e7e-ae87-bb802b16a179", Email: "nmacpaik2@phoca.cz", CreationDate: time.Time{}},
+		{ID: "144bf891-f161-4c9a-8d83-38a275e088a5", Email: "nblasio0@jiathis.com", CreationDate: time.Date(2021, time.June, 6, 0, 0, 0, 0, time.UTC)},
+	}
+
+	invalidUsers := ValidateUsersData(usersData, nil)
+
+	assert.Equal(t, map[int]error{
+		1: &ValidationError{Fields: []FieldError{
+			{Field: "id", Message: "is duplicated"},
+			{Field: "email", Message: "is already used by another user"},
+		}},
+		2: &ValidationError{Fields: []FieldError{
+			{Field: "creation_date", Message: "must be a valid date (RFC 3339 or dd/mm/yyyy)"},
+		}},
+	}, invalidUsers)
+
+	// the decoded date errors are reported instead
+	invalidUsers = ValidateUsersData(usersData, map[int]error{
+		2: &ValidationError{Fields: []FieldError{{Field: "creation_date", Message: "must be a valid date (RFC 3339 or dd/mm/yyyy), got '31/02/2020'"}}},
+		3: &ValidationError{Fields: []FieldError{{Field: "deleted_at", Message: "must be an RFC 3339 date, got '10/01/2022'"}}},
+	})
+
+	assert.Equal(t, map[int]error{
+		1: &ValidationError{Fields: []FieldError{
+			{Field: "id", Message: "is duplicated"},
+			{Field: "email", Message: "is already used by another user"},
+		}},
+		2: &ValidationError{Fields: []FieldError{
+			{Field: "creation_date", Message: "must be a valid date (RFC 3339 or dd/mm/yyyy), got '31/02/2020'"},
+		}},
+		3: &ValidationError{Fields: []FieldError{
+			{Field: "deleted_at", Message: "must be an RFC 3339 date, got '10/01/2022'"},
+		}},
+	}, invalidUsers)
+}
+
+func TestValidationError(t *testing.T) {
+	err := error(&ValidationError{Fields: []FieldError{
+		{Field: "email", Message: "must be a valid email address"},
+		{Field: "ip_address", Message: "must be an IPv4 or IPv6 address"},
+	}})
+
+	assert.Equal(t, "invalid user: 'email' must be a valid email address, 'ip_address' must be an IPv4 or IPv6 address", err.Error())
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	var validationErr *ValidationError
+	assert.True(t, errors.As(fmt.Errorf("creating user: %w", err), &validationErr))
+	assert.Len(t, validationErr.Fields, 2)
+}
@@ -13,6 +13,8 @@ import (
 type httpError struct {
 	StatusCode int    `json:"-"`
 	Message    string `json:"error"`
+	// Fields are the invalid fields of a validation error
+	Fields []lib.FieldError `json:"fields,omitempty"`
 }
 
 // Error formats the error in a descriptive format (required for the custom error)
@@ -56,6 +58,40 @@ func handleError(err error) *httpError {
 		}
 	}
 
+	// conflict error converting to HTTP error
+	if errors.Is(err, lib.ErrConflict) {
+		return &httpError{
+			StatusCode: http.StatusConflict,
+			Message:    err.Error(),
+		}
+	}
+
+	// validation error converting to HTTP error, with the invalid fields
+	var validationErr *lib.ValidationError
+	if errors.As(err, &validationErr) {
+		return &httpError{
+			StatusCode: http.StatusUnprocessableEntity,
+			Message:    err.Error(),
+			Fields:     validationErr.Fields,
+		}
+	}
+
+	// unauthorized error converting to HTTP error
+	if errors.Is(err, lib.ErrUnauthorized) {
+		return &httpError{
+			StatusCode: http.StatusUnauthorized,
+			Message:    err.Error(),
+		}
+	}
+
+	// too many attempts error converting to HTTP error
+	if errors.Is(err, lib.ErrTooManyAttempts) {
+		return &httpError{
+			StatusCode: http.StatusTooManyRequests,
+			Message:    err.Error(),
+		}
+	}
+
 	// default error handling:
 	// - log as internal error
 	// - convert to HTTP internal server error
@@ -61,6 +61,43 @@ func TestHandleError(t *testing.T) {
 				Message:    "invalid: precondition failed",
 			},
 		},
+		{
+			name: "conflict error",
+			err:  fmt.Errorf("user 1 already exists: %w", lib.ErrConflict),
+			expectedHTTPError: &httpError{
+				StatusCode: http.StatusConflict,
+				Message:    "user 1 already exists: conflict",
+			},
+		},
+		{
+			name: "validation error",
+			err: &lib.ValidationError{Fields: []lib.FieldError{
+				{Field: "email", Message: "must be a valid email address"},
+			}},
+			expectedHTTPError: &httpError{
+				StatusCode: http.StatusUnprocessableEntity,
+				Message:    "invalid user: 'email' must be a valid email address",
+				Fields: []lib.FieldError{
+					{Field: "email", Message: "must be a valid email address"},
+				},
+			},
+		},
+		{
+			name: "unauthorized error",
+			err:  lib.ErrUnauthorized,
+			expectedHTTPError: &httpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "invalid credentials",
+			},
+		},
+		{
+			name: "too many attempts error",
+			err:  lib.ErrTooManyAttempts,
+			expectedHTTPError: &httpError{
+				StatusCode: http.StatusTooManyRequests,
+				Message:    "too many failed attempts",
+			},
+		},
 	}
 
 	for _, tc := range testCases {
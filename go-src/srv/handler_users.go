@@ -1,16 +1,48 @@
 package srv
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/hbernardo/users/go-src/lib"
+	log "github.com/sirupsen/logrus"
 )
 
 type (
 	usersService interface {
-		GetUsers(ctx context.Context, limit int, offset int) ([]lib.User, error)
-		GetUser(ctx context.Context, userID string) (lib.User, error)
+		GetUsers(ctx context.Context, query lib.UsersQuery) (lib.UsersPage, error)
+		GetUser(ctx context.Context, userID string, includeDeleted bool) (lib.User, error)
+		GetUserAsOf(ctx context.Context, userID string, asOf time.Time, includeDeleted bool) (lib.User, error)
+		GetUserHistory(ctx context.Context, userID string, includeDeleted bool) ([]lib.UserRevision, error)
+		GetUsersByIDs(ctx context.Context, userIDs []string) (lib.UsersBatch, error)
+		SearchUsers(ctx context.Context, query lib.UsersSearchQuery) (lib.UsersPage, error)
+		CreateUser(ctx context.Context, user lib.User) (lib.User, error)
+		UpdateUser(ctx context.Context, user lib.User, versions []string) (lib.User, error)
+		PatchUser(ctx context.Context, userID string, patch lib.UserPatch, versions []string) (lib.User, error)
+		DeleteUser(ctx context.Context, userID string, versions []string) error
+		RestoreUser(ctx context.Context, userID string) (lib.User, error)
+		ExportUserData(ctx context.Context, userID string) (lib.UserDataExport, error)
+		EraseUser(ctx context.Context, userID string) (lib.User, error)
+		Authenticate(ctx context.Context, email string, password string) (lib.User, error)
+		ImportUsers(ctx context.Context, r io.Reader, format lib.DataFormat, options lib.ImportOptions) (lib.ImportReport, error)
+		ExportUsers(ctx context.Context, filter lib.UsersFilter, sort []lib.SortField, fn func(user lib.User) error) error
+	}
+
+	// batchGetUsersRequest represents the user IDs received by the batch get route (POST)
+	batchGetUsersRequest struct {
+		IDs []string `json:"ids"`
+	}
+
+	// authenticateRequest represents the credentials received by the authentication route
+	authenticateRequest struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
 	}
 
 	usersHandler struct {
@@ -23,6 +55,33 @@ const (
 	// maxUsersLimit sets the maximum number of users
 	// that the client can request to the server
 	maxUsersLimit = 1000
+
+	// maxUsersBatchSize sets the maximum number of user IDs
+	// that the client can request at once to the batch get route
+	maxUsersBatchSize = 500
+
+	// exportFlushRows sets the number of users written to the export response
+	// before flushing it to the client
+	exportFlushRows = 100
+
+	// historyPath is the URL path suffix of the user change history route
+	historyPath = "/history"
+
+	// restoreAction is the URL path suffix of the deleted user restoration route
+	restoreAction = ":restore"
+
+	// exportAction is the URL path suffix of the user data export route (right of access)
+	exportAction = ":export"
+
+	// eraseAction is the URL path suffix of the user personal data erasure route (right to erasure)
+	eraseAction = ":erase"
+
+	// authenticatePath is the URL path of the users authentication route
+	authenticatePath = "/v1/users/authenticate"
+
+	// maxImportBodySize sets the maximum size (bytes) of the users data
+	// that the client can send to the bulk import route
+	maxImportBodySize = 32 << 20
 )
 
 // NewUsersHandler creates a new users handler, receives the users service as parameter
@@ -34,21 +93,432 @@ func NewUsersHandler(usersSvc usersService) *usersHandler {
 		usersSvc,
 	}
 
-	// route for multiple users fetching, receiving pagination parameters
-	handler.HandleFunc("/v1/users", h.handleGetUsers)
-	// route for single user fetching, receiving the user id as URL parameter
-	handler.HandleFunc("/v1/users/", h.handleGetUser)
+	// route for the users collection:
+	// - GET: multiple users fetching, receiving pagination parameters (or the whole data export, as CSV or NDJSON)
+	// - POST: user creation
+	handler.HandleFunc("/v1/users", h.routeMethods(map[string]http.HandlerFunc{
+		http.MethodGet:  h.handleGetUsers,
+		http.MethodPost: h.handleCreateUser,
+	}))
+	// route for a single user, receiving the user id as URL parameter:
+	// - GET: user fetching
+	// - PUT: user replacement
+	// - PATCH: user partial update
+	// - DELETE: user deletion (soft delete, see the restore action)
+	// and its sub-resources and actions, as URL path suffixes:
+	// - GET "/history": user change history
+	// - POST ":restore": deleted user restoration
+	// - GET ":export": all the data held about the user, as a downloadable JSON (admin only)
+	// - POST ":erase": user personal data erasure (admin only)
+	handler.HandleFunc("/v1/users/", h.routeActions(
+		h.routeMethods(map[string]http.HandlerFunc{
+			http.MethodGet:    h.handleGetUser,
+			http.MethodPut:    h.handleUpdateUser,
+			http.MethodPatch:  h.handlePatchUser,
+			http.MethodDelete: h.handleDeleteUser,
+		}),
+		map[string]http.HandlerFunc{
+			historyPath: h.routeMethods(map[string]http.HandlerFunc{
+				http.MethodGet: h.handleGetUserHistory,
+			}),
+			restoreAction: h.routeMethods(map[string]http.HandlerFunc{
+				http.MethodPost: h.handleRestoreUser,
+			}),
+			exportAction: h.routeMethods(map[string]http.HandlerFunc{
+				http.MethodGet: h.handleExportUserData,
+			}),
+			eraseAction: h.routeMethods(map[string]http.HandlerFunc{
+				http.MethodPost: h.handleEraseUser,
+			}),
+		},
+	))
+
+	// route for getting multiple users by their IDs:
+	// - GET: receiving the IDs as repeated "id" querystrings
+	// - POST: receiving the IDs in the JSON body (for many IDs, as the URL length is limited)
+	handler.HandleFunc("/v1/users:batchGet", h.routeMethods(map[string]http.HandlerFunc{
+		http.MethodGet:  h.handleBatchGetUsers,
+		http.MethodPost: h.handleBatchGetUsers,
+	}))
+
+	// route for users bulk import:
+	// - POST: users creation or replacement, receiving the users data (JSON, NDJSON or CSV) and the import modes
+	handler.HandleFunc("/v1/users:bulk", h.routeMethods(map[string]http.HandlerFunc{
+		http.MethodPost: h.handleImportUsers,
+	}))
+
+	// route for users full-text search:
+	// - GET: users search, receiving the search text and pagination parameters
+	handler.HandleFunc("/v1/users/search", h.routeMethods(map[string]http.HandlerFunc{
+		http.MethodGet: h.handleSearchUsers,
+	}))
+
+	// route for users credentials checking (e.g. login flows):
+	// - POST: user authentication
+	handler.HandleFunc(authenticatePath, h.routeMethods(map[string]http.HandlerFunc{
+		http.MethodPost: h.handleAuthenticate,
+	}))
 
 	return h
 }
 
-// handleGetUsers is the HTTP handler function for getting multiple users based on pagination querystrings (limit and offset)
+// routeMethods creates an HTTP handler function that dispatches the request to the handler registered for its method,
+// returning 405 status code (method not allowed) if there is none
+func (h *usersHandler) routeMethods(handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		handle, ok := handlers[req.Method]
+		if !ok {
+			writeError(w, &httpError{
+				StatusCode: http.StatusMethodNotAllowed,
+				Message:    "method not allowed",
+			})
+			return
+		}
+
+		handle(w, req)
+	}
+}
+
+// routeActions creates an HTTP handler function that dispatches the request to the handler registered for the action
+// or sub-resource (suffix of the URL path, e.g. ":restore"), or to the resource handler if there is none
+func (h *usersHandler) routeActions(resourceHandler http.HandlerFunc, actionHandlers map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		for action, handle := range actionHandlers {
+			if strings.HasSuffix(req.URL.Path, action) {
+				handle(w, req)
+				return
+			}
+		}
+
+		resourceHandler(w, req)
+	}
+}
+
+// handleGetUsers is the HTTP handler function for getting multiple users based on pagination (limit and offset, or cursor), filters and sorting querystrings,
+// the offset pagination responses contain the total count and navigation links headers (and optionally the envelope),
+// the CSV and NDJSON formats (got from the "format" querystring or the Accept header) export all the matching users instead
 func (h *usersHandler) handleGetUsers(w http.ResponseWriter, req *http.Request) {
-	// validating GET method
-	if req.Method != http.MethodGet {
+	// the response depends on the Accept and date format headers (caches must tell the formats apart)
+	w.Header().Set("Vary", "Accept, "+dateFormatHeader)
+
+	// getting and validating the response format
+	format, err := getAndValidateResponseFormat(req.URL.Query(), req.Header.Get("Accept"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if format != lib.FormatJSON {
+		h.exportUsers(w, req, format)
+		return
+	}
+
+	// getting and validating pagination parameters
+	limit, offset, err := getAndValidatePaginationParams(req.URL.Query(), maxUsersLimit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating filters
+	filter, err := getAndValidateUsersFilter(req.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	filter.IncludeDeleted, err = getAndValidateIncludeDeleted(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating sorting
+	sort, err := getAndValidateSortParam(req.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating cursor (enables cursor pagination)
+	cursor, err := getAndValidateCursorParam(req.URL.Query(), sort)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating the selected fields
+	fields, err := getAndValidateFieldsParam(req.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating the dates format (legacy compatibility)
+	legacyDates, err := getAndValidateDateFormat(req.URL.Query(), req.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating the envelope option (offset pagination only)
+	envelope, err := getAndValidateBoolParam(req.URL.Query(), "envelope")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if envelope && cursor != nil {
 		writeError(w, &httpError{
-			StatusCode: http.StatusMethodNotAllowed,
-			Message:    "method not allowed",
+			StatusCode: http.StatusBadRequest,
+			Message:    "'envelope' and 'cursor' cannot be used together",
+		})
+		return
+	}
+
+	page, err := h.usersService.GetUsers(req.Context(), lib.UsersQuery{
+		Limit:  limit,
+		Offset: offset,
+		Cursor: cursor,
+		Filter: filter,
+		Sort:   sort,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// cursor pagination responds with the page data and the next cursor
+	if cursor != nil {
+		response := newUsersCursorPageResponse(page)
+		selectUsersFields(response.Data, fields)
+		useUsersLegacyDates(response.Data, legacyDates)
+		writeJSONWithETag(w, req, "", response)
+		return
+	}
+
+	links := newPageLinks(req.URL, limit, offset, page.Total)
+	setPaginationHeaders(w, page.Total, links)
+
+	// the envelope (opt-in) responds with the page data, the total and the navigation URLs
+	if envelope {
+		response := newUsersPageResponse(page, limit, offset, links)
+		selectUsersFields(response.Data, fields)
+		useUsersLegacyDates(response.Data, legacyDates)
+		writeJSONWithETag(w, req, "", response)
+		return
+	}
+
+	response := newUsersResponse(page.Users)
+	selectUsersFields(response, fields)
+	useUsersLegacyDates(response, legacyDates)
+	writeJSONWithETag(w, req, "", response)
+}
+
+// exportUsers streams all the users matching the filters and sorting querystrings in the export format (CSV or NDJSON),
+// row by row and flushing periodically, so the response is never built in memory
+func (h *usersHandler) exportUsers(w http.ResponseWriter, req *http.Request, format lib.DataFormat) {
+	// the whole matching data is exported, there is no pagination
+	for _, key := range []string{"limit", "offset", "cursor", "envelope"} {
+		if _, ok := req.URL.Query()[key]; ok {
+			writeError(w, &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("'%s' cannot be used with the '%s' format", key, format),
+			})
+			return
+		}
+	}
+
+	// getting and validating filters
+	filter, err := getAndValidateUsersFilter(req.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	filter.IncludeDeleted, err = getAndValidateIncludeDeleted(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating sorting
+	sort, err := getAndValidateSortParam(req.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating the selected fields (the exported columns)
+	fields, err := getAndValidateFieldsParam(req.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating the dates format (legacy compatibility)
+	legacyDates, err := getAndValidateDateFormat(req.URL.Query(), req.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// the response is only started by the first flushed data,
+	// so the errors that happen before can still be written as usual
+	response := &exportResponseWriter{ResponseWriter: w}
+	usersWriter, err := lib.NewUsersWriter(response, format, fields)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if legacyDates {
+		usersWriter.UseLegacyDates()
+	}
+
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, format))
+
+	rows := 0
+	err = h.usersService.ExportUsers(req.Context(), filter, sort, func(user lib.User) error {
+		err := usersWriter.Write(user)
+		if err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushRows == 0 {
+			return response.flush(usersWriter)
+		}
+		return nil
+	})
+	if err == nil {
+		err = usersWriter.Close()
+	}
+
+	if err != nil {
+		if !response.started {
+			w.Header().Del("Content-Disposition")
+			writeError(w, err)
+			return
+		}
+
+		// the status code was already sent, the client gets a truncated response
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+			"rows":  rows,
+		}).Warn("users export interrupted")
+		return
+	}
+
+	response.flush(usersWriter)
+}
+
+// handleBatchGetUsers is the HTTP handler function for getting multiple users by their IDs
+// (got from repeated "id" querystrings or from the JSON body), reporting the IDs that were not found
+func (h *usersHandler) handleBatchGetUsers(w http.ResponseWriter, req *http.Request) {
+	// getting and validating the selected fields
+	fields, err := getAndValidateFieldsParam(req.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating the dates format (legacy compatibility)
+	legacyDates, err := getAndValidateDateFormat(req.URL.Query(), req.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	userIDs := req.URL.Query()["id"]
+	if req.Method == http.MethodPost {
+		var batchReq batchGetUsersRequest
+		err = readJSON(w, req, &batchReq)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		userIDs = batchReq.IDs
+	}
+
+	err = validateUserIDs(userIDs, maxUsersBatchSize)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	batch, err := h.usersService.GetUsersByIDs(req.Context(), userIDs)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := newUsersBatchResponse(batch)
+	selectUsersFields(response.Data, fields)
+	useUsersLegacyDates(response.Data, legacyDates)
+	writeJSONWithETag(w, req, "", response)
+}
+
+// handleImportUsers is the HTTP handler function for importing users from the body (format got from the content type),
+// responding with the result of each row
+func (h *usersHandler) handleImportUsers(w http.ResponseWriter, req *http.Request) {
+	format, err := getAndValidateImportFormat(req.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating the import modes
+	dryRun, err := getAndValidateBoolParam(req.URL.Query(), "dry_run")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	allOrNothing, err := getAndValidateBoolParam(req.URL.Query(), "all_or_nothing")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if req.Body == nil {
+		writeError(w, &httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "missing request body",
+		})
+		return
+	}
+
+	// reading one byte more than the maximum, to tell if the body is too large
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxImportBodySize+1))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(body) > maxImportBodySize {
+		writeError(w, &httpError{
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Message:    fmt.Sprintf("request body is larger than %d bytes", maxImportBodySize),
+		})
+		return
+	}
+
+	report, err := h.usersService.ImportUsers(req.Context(), bytes.NewReader(body), format, lib.ImportOptions{
+		DryRun:       dryRun,
+		AllOrNothing: allOrNothing,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleSearchUsers is the HTTP handler function for searching users by their names and email (got from "q" querystring),
+// ordered by relevance and paginated by limit and offset
+func (h *usersHandler) handleSearchUsers(w http.ResponseWriter, req *http.Request) {
+	// getting the required search text
+	text := getURLQueryParam(req.URL.Query(), "q")
+	if text == "" {
+		writeError(w, &httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "missing required query param 'q'",
 		})
 		return
 	}
@@ -60,26 +530,226 @@ func (h *usersHandler) handleGetUsers(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	users, err := h.usersService.GetUsers(req.Context(), limit, offset)
+	// getting and validating the selected fields
+	fields, err := getAndValidateFieldsParam(req.URL.Query())
 	if err != nil {
 		writeError(w, err)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, users)
+	// getting and validating the dates format (legacy compatibility)
+	legacyDates, err := getAndValidateDateFormat(req.URL.Query(), req.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating the envelope option
+	envelope, err := getAndValidateBoolParam(req.URL.Query(), "envelope")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	page, err := h.usersService.SearchUsers(req.Context(), lib.UsersSearchQuery{
+		Text:   text,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	links := newPageLinks(req.URL, limit, offset, page.Total)
+	setPaginationHeaders(w, page.Total, links)
+
+	if envelope {
+		response := newUsersPageResponse(page, limit, offset, links)
+		selectUsersFields(response.Data, fields)
+		useUsersLegacyDates(response.Data, legacyDates)
+		writeJSONWithETag(w, req, "", response)
+		return
+	}
+
+	response := newUsersResponse(page.Users)
+	selectUsersFields(response, fields)
+	useUsersLegacyDates(response, legacyDates)
+	writeJSONWithETag(w, req, "", response)
 }
 
-// handleGetUser is the HTTP handler function for getting a single user by its ID (got from URL parameter)
+// handleGetUser is the HTTP handler function for getting a single user by its ID (got from URL parameter),
+// as it currently is or as it was at the "as_of" time
 func (h *usersHandler) handleGetUser(w http.ResponseWriter, req *http.Request) {
-	// validating GET method
-	if req.Method != http.MethodGet {
-		writeError(w, &httpError{
-			StatusCode: http.StatusMethodNotAllowed,
-			Message:    "method not allowed",
-		})
+	// the response depends on the date format header (caches must tell the formats apart)
+	w.Header().Set("Vary", dateFormatHeader)
+
+	// getting user id from URL parameter
+	userID, err := getURLPathParam(req.URL.Path, "users")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating the selected fields
+	fields, err := getAndValidateFieldsParam(req.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating the dates format (legacy compatibility)
+	legacyDates, err := getAndValidateDateFormat(req.URL.Query(), req.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	includeDeleted, err := getAndValidateIncludeDeleted(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting and validating the point-in-time (optional, the current user if missing)
+	asOf, err := getAndValidateTimeParam(req.URL.Query(), "as_of")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var user lib.User
+	if asOf.IsZero() {
+		user, err = h.usersService.GetUser(req.Context(), userID, includeDeleted)
+	} else {
+		user, err = h.usersService.GetUserAsOf(req.Context(), userID, asOf, includeDeleted)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// the point-in-time user has its own version, so only the representation is added to the entity tag
+	response := newUserResponse(user)
+	response.fields = fields
+	response.useLegacyDate(legacyDates)
+	writeJSONWithETag(w, req, userRepresentationETag(user, fields, legacyDates), response)
+}
+
+// handleGetUserHistory is the HTTP handler function for getting the change history of a user by its ID (got from URL parameter)
+func (h *usersHandler) handleGetUserHistory(w http.ResponseWriter, req *http.Request) {
+	// getting user id from URL parameter
+	userID, err := getURLPathParam(req.URL.Path, "users")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	includeDeleted, err := getAndValidateIncludeDeleted(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	revisions, err := h.usersService.GetUserHistory(req.Context(), userID, includeDeleted)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSONWithETag(w, req, "", newUserRevisionsResponse(revisions))
+}
+
+// handleCreateUser is the HTTP handler function for creating a user based on the JSON body
+func (h *usersHandler) handleCreateUser(w http.ResponseWriter, req *http.Request) {
+	var user lib.User
+	err := readJSON(w, req, &user)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	user, err = h.usersService.CreateUser(req.Context(), user)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/v1/users/"+user.ID)
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, http.StatusCreated, newUserResponse(user))
+}
+
+// handleUpdateUser is the HTTP handler function for replacing a user (ID got from URL parameter) based on the JSON body
+func (h *usersHandler) handleUpdateUser(w http.ResponseWriter, req *http.Request) {
+	// getting user id from URL parameter
+	userID, err := getURLPathParam(req.URL.Path, "users")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting the expected user versions (required)
+	versions, err := getAndValidateIfMatch(req.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var user lib.User
+	err = readJSON(w, req, &user)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// the URL parameter is the source of truth for the user id
+	user.ID = userID
+
+	user, err = h.usersService.UpdateUser(req.Context(), user, versions)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// handlePatchUser is the HTTP handler function for partially updating a user (ID got from URL parameter) based on the JSON body
+func (h *usersHandler) handlePatchUser(w http.ResponseWriter, req *http.Request) {
+	// getting user id from URL parameter
+	userID, err := getURLPathParam(req.URL.Path, "users")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting the expected user versions (required)
+	versions, err := getAndValidateIfMatch(req.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var patch lib.UserPatch
+	err = readJSON(w, req, &patch)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	user, err := h.usersService.PatchUser(req.Context(), userID, patch, versions)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// handleDeleteUser is the HTTP handler function for deleting (soft delete) a user by its ID (got from URL parameter)
+func (h *usersHandler) handleDeleteUser(w http.ResponseWriter, req *http.Request) {
 	// getting user id from URL parameter
 	userID, err := getURLPathParam(req.URL.Path, "users")
 	if err != nil {
@@ -87,11 +757,116 @@ func (h *usersHandler) handleGetUser(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	user, err := h.usersService.GetUser(req.Context(), userID)
+	// getting the expected user versions (required)
+	versions, err := getAndValidateIfMatch(req.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = h.usersService.DeleteUser(req.Context(), userID, versions)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRestoreUser is the HTTP handler function for restoring a deleted user by its ID (got from URL parameter, before the action)
+func (h *usersHandler) handleRestoreUser(w http.ResponseWriter, req *http.Request) {
+	// getting user id from URL parameter
+	userID, err := getURLPathParam(strings.TrimSuffix(req.URL.Path, restoreAction), "users")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	user, err := h.usersService.RestoreUser(req.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// handleExportUserData is the HTTP handler function for exporting all the data held about a user by its ID
+// (got from URL parameter, before the action) as a downloadable JSON, only allowed to the admin requests
+func (h *usersHandler) handleExportUserData(w http.ResponseWriter, req *http.Request) {
+	err := validateAdmin(req, "user data export")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting user id from URL parameter
+	userID, err := getURLPathParam(strings.TrimSuffix(req.URL.Path, exportAction), "users")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	export, err := h.usersService.ExportUserData(req.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%s.json"`, userID))
+	writeJSON(w, http.StatusOK, newUserDataExportResponse(export))
+}
+
+// handleEraseUser is the HTTP handler function for erasing (anonymizing) the personal data of a user by its ID
+// (got from URL parameter, before the action), only allowed to the admin requests
+func (h *usersHandler) handleEraseUser(w http.ResponseWriter, req *http.Request) {
+	err := validateAdmin(req, "user erasure")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// getting user id from URL parameter
+	userID, err := getURLPathParam(strings.TrimSuffix(req.URL.Path, eraseAction), "users")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	user, err := h.usersService.EraseUser(req.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// handleAuthenticate is the HTTP handler function for checking the user credentials (email and password got from the JSON body),
+// returns the user if they are valid
+func (h *usersHandler) handleAuthenticate(w http.ResponseWriter, req *http.Request) {
+	var credentials authenticateRequest
+	err := readJSON(w, req, &credentials)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if credentials.Email == "" || credentials.Password == "" {
+		writeError(w, &httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "'email' and 'password' are required",
+		})
+		return
+	}
+
+	user, err := h.usersService.Authenticate(req.Context(), credentials.Email, credentials.Password)
 	if err != nil {
 		writeError(w, err)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, user)
+	writeJSON(w, http.StatusOK, newUserResponse(user))
 }
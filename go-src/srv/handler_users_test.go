@@ -3,11 +3,17 @@ package srv
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -15,16 +21,93 @@ type mockUsersService struct {
 	mock.Mock
 }
 
-func (m *mockUsersService) GetUsers(ctx context.Context, limit int, offset int) ([]lib.User, error) {
-	args := m.Called(ctx, limit, offset)
-	return args.Get(0).([]lib.User), args.Error(1)
+func (m *mockUsersService) GetUsers(ctx context.Context, query lib.UsersQuery) (lib.UsersPage, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).(lib.UsersPage), args.Error(1)
 }
 
-func (m *mockUsersService) GetUser(ctx context.Context, userID string) (lib.User, error) {
+func (m *mockUsersService) GetUser(ctx context.Context, userID string, includeDeleted bool) (lib.User, error) {
+	args := m.Called(ctx, userID, includeDeleted)
+	return args.Get(0).(lib.User), args.Error(1)
+}
+
+func (m *mockUsersService) GetUserAsOf(ctx context.Context, userID string, asOf time.Time, includeDeleted bool) (lib.User, error) {
+	args := m.Called(ctx, userID, asOf, includeDeleted)
+	return args.Get(0).(lib.User), args.Error(1)
+}
+
+func (m *mockUsersService) GetUserHistory(ctx context.Context, userID string, includeDeleted bool) ([]lib.UserRevision, error) {
+	args := m.Called(ctx, userID, includeDeleted)
+	return args.Get(0).([]lib.UserRevision), args.Error(1)
+}
+
+func (m *mockUsersService) GetUsersByIDs(ctx context.Context, userIDs []string) (lib.UsersBatch, error) {
+	args := m.Called(ctx, userIDs)
+	return args.Get(0).(lib.UsersBatch), args.Error(1)
+}
+
+func (m *mockUsersService) SearchUsers(ctx context.Context, query lib.UsersSearchQuery) (lib.UsersPage, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).(lib.UsersPage), args.Error(1)
+}
+
+func (m *mockUsersService) CreateUser(ctx context.Context, user lib.User) (lib.User, error) {
+	args := m.Called(ctx, user)
+	return args.Get(0).(lib.User), args.Error(1)
+}
+
+func (m *mockUsersService) UpdateUser(ctx context.Context, user lib.User, versions []string) (lib.User, error) {
+	args := m.Called(ctx, user, versions)
+	return args.Get(0).(lib.User), args.Error(1)
+}
+
+func (m *mockUsersService) PatchUser(ctx context.Context, userID string, patch lib.UserPatch, versions []string) (lib.User, error) {
+	args := m.Called(ctx, userID, patch, versions)
+	return args.Get(0).(lib.User), args.Error(1)
+}
+
+func (m *mockUsersService) DeleteUser(ctx context.Context, userID string, versions []string) error {
+	args := m.Called(ctx, userID, versions)
+	return args.Error(0)
+}
+
+func (m *mockUsersService) RestoreUser(ctx context.Context, userID string) (lib.User, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(lib.User), args.Error(1)
+}
+
+func (m *mockUsersService) ExportUserData(ctx context.Context, userID string) (lib.UserDataExport, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(lib.UserDataExport), args.Error(1)
+}
+
+func (m *mockUsersService) EraseUser(ctx context.Context, userID string) (lib.User, error) {
 	args := m.Called(ctx, userID)
 	return args.Get(0).(lib.User), args.Error(1)
 }
 
+func (m *mockUsersService) Authenticate(ctx context.Context, email string, password string) (lib.User, error) {
+	args := m.Called(ctx, email, password)
+	return args.Get(0).(lib.User), args.Error(1)
+}
+
+func (m *mockUsersService) ImportUsers(ctx context.Context, r io.Reader, format lib.DataFormat, options lib.ImportOptions) (lib.ImportReport, error) {
+	data, _ := ioutil.ReadAll(r)
+	args := m.Called(ctx, string(data), format, options)
+	return args.Get(0).(lib.ImportReport), args.Error(1)
+}
+
+func (m *mockUsersService) ExportUsers(ctx context.Context, filter lib.UsersFilter, sort []lib.SortField, fn func(user lib.User) error) error {
+	args := m.Called(ctx, filter, sort)
+	for _, user := range args.Get(0).([]lib.User) {
+		err := fn(user)
+		if err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
 type mockHTTPResponseWriter struct {
 	mock.Mock
 }
@@ -52,11 +135,11 @@ func TestHandleGetUsers(t *testing.T) {
 		name               string
 		httpRequest        *http.Request
 		svcNotCalled       bool
-		svcResponse        []lib.User
+		svcResponse        lib.UsersPage
 		svcError           error
-		expectedLimit      int
-		expectedOffset     int
+		expectedQuery      lib.UsersQuery
 		expectedHTTPStatus int
+		expectedHeaders    http.Header
 		expectedResponse   []byte
 	}{
 		{
@@ -65,10 +148,10 @@ func TestHandleGetUsers(t *testing.T) {
 				Method: "GET",
 				URL: &url.URL{
 					Path:     "/v1/users",
-					RawQuery: "limit=1&offset=5",
+					RawQuery: "limit=1&offset=5&last_name=Tri*&sort=first_name,-creation_date",
 				},
 			},
-			svcResponse: []lib.User{
+			svcResponse: lib.UsersPage{Users: []lib.User{
 				{
 					ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
 					FirstName:    "Terrence",
@@ -76,14 +159,200 @@ func TestHandleGetUsers(t *testing.T) {
 					Email:        "ttrillow1@feedburner.com",
 					Password:     "5YLItbmdkfC1",
 					IPAddress:    "63.119.6.98",
-					CreationDate: "19/04/2021",
+					CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
 				},
+			}, Total: 10},
+			svcError: nil,
+			expectedQuery: lib.UsersQuery{
+				Limit:  1,
+				Offset: 5,
+				Filter: lib.UsersFilter{LastName: &lib.StringFilter{Value: "Tri", Prefix: true}},
+				Sort:   []lib.SortField{{Field: "first_name"}, {Field: "creation_date", Descending: true}},
+			},
+			expectedHTTPStatus: http.StatusOK,
+			expectedHeaders: http.Header{
+				"Content-Type":  []string{"application/json"},
+				"Vary":          []string{"Accept, X-Date-Format"},
+				"Etag":          []string{`"3c15a974fea9c40787f0d4a5d965cb9e33064438"`},
+				"X-Total-Count": []string{"10"},
+				"Link": []string{
+					`</v1/users?last_name=Tri%2A&limit=1&offset=0&sort=first_name%2C-creation_date>; rel="first", ` +
+						`</v1/users?last_name=Tri%2A&limit=1&offset=4&sort=first_name%2C-creation_date>; rel="prev", ` +
+						`</v1/users?last_name=Tri%2A&limit=1&offset=6&sort=first_name%2C-creation_date>; rel="next", ` +
+						`</v1/users?last_name=Tri%2A&limit=1&offset=9&sort=first_name%2C-creation_date>; rel="last"`,
+				},
+			},
+			expectedResponse: []byte(`[{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"}]` + "\n"),
+		},
+		{
+			name: "envelope",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users",
+					RawQuery: "limit=1&envelope=true",
+				},
+			},
+			svcResponse: lib.UsersPage{Users: []lib.User{
+				{
+					ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+					FirstName:    "Terrence",
+					LastName:     "Trillow",
+					Email:        "ttrillow1@feedburner.com",
+					Password:     "5YLItbmdkfC1",
+					IPAddress:    "63.119.6.98",
+					CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+				},
+			}, Total: 2},
+			svcError: nil,
+			expectedQuery: lib.UsersQuery{
+				Limit: 1,
+			},
+			expectedHTTPStatus: http.StatusOK,
+			expectedHeaders: http.Header{
+				"Content-Type":  []string{"application/json"},
+				"Vary":          []string{"Accept, X-Date-Format"},
+				"Etag":          []string{`"6213419796f05c2bce17f3e579fbc2cdf4f994a9"`},
+				"X-Total-Count": []string{"2"},
+				"Link": []string{
+					`</v1/users?envelope=true&limit=1&offset=0>; rel="first", ` +
+						`</v1/users?envelope=true&limit=1&offset=1>; rel="next", ` +
+						`</v1/users?envelope=true&limit=1&offset=1>; rel="last"`,
+				},
+			},
+			expectedResponse: []byte(`{"data":[{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"}],` +
+				`"total":2,"limit":1,"offset":0,"next":"/v1/users?envelope=true\u0026limit=1\u0026offset=1","prev":null}` + "\n"),
+		},
+		{
+			name: "envelope - selected fields",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users",
+					RawQuery: "limit=1&offset=1&envelope=true&fields=id",
+				},
+			},
+			svcResponse: lib.UsersPage{Users: []lib.User{
+				{
+					ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+					FirstName:    "Terrence",
+					LastName:     "Trillow",
+					Email:        "ttrillow1@feedburner.com",
+					Password:     "5YLItbmdkfC1",
+					IPAddress:    "63.119.6.98",
+					CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+				},
+			}, Total: 2},
+			svcError: nil,
+			expectedQuery: lib.UsersQuery{
+				Limit:  1,
+				Offset: 1,
+			},
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse: []byte(`{"data":[{"id":"1311f914-1d4f-40b6-8886-80193265d5a4"}],` +
+				`"total":2,"limit":1,"offset":1,"next":null,"prev":"/v1/users?envelope=true\u0026fields=id\u0026limit=1\u0026offset=0"}` + "\n"),
+		},
+		{
+			name: "selected fields",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users",
+					RawQuery: "limit=1&fields=first_name,last_name",
+				},
+			},
+			svcResponse: lib.UsersPage{Users: []lib.User{
+				{
+					ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+					FirstName:    "Terrence",
+					LastName:     "Trillow",
+					Email:        "ttrillow1@feedburner.com",
+					Password:     "5YLItbmdkfC1",
+					IPAddress:    "63.119.6.98",
+					CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+				},
+			}, Total: 1},
+			svcError: nil,
+			expectedQuery: lib.UsersQuery{
+				Limit: 1,
+			},
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`[{"first_name":"Terrence","last_name":"Trillow"}]` + "\n"),
+		},
+		{
+			name: "error - unknown field",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users",
+					RawQuery: "limit=1&fields=id,unknown",
+				},
+			},
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid field 'unknown'"}` + "\n"),
+		},
+		{
+			name: "error - envelope with cursor",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users",
+					RawQuery: "limit=1&envelope=true&cursor=",
+				},
+			},
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"'envelope' and 'cursor' cannot be used together"}` + "\n"),
+		},
+		{
+			name: "cursor pagination",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users",
+					RawQuery: "limit=1&cursor=",
+				},
+			},
+			svcResponse: lib.UsersPage{
+				Users: []lib.User{
+					{
+						ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+						FirstName:    "Terrence",
+						LastName:     "Trillow",
+						Email:        "ttrillow1@feedburner.com",
+						Password:     "5YLItbmdkfC1",
+						IPAddress:    "63.119.6.98",
+						CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+					},
+				},
+				NextCursor: &lib.UsersCursor{ID: "1311f914-1d4f-40b6-8886-80193265d5a4"},
+			},
+			svcError: nil,
+			expectedQuery: lib.UsersQuery{
+				Limit:  1,
+				Cursor: &lib.UsersCursor{},
 			},
-			svcError:           nil,
-			expectedLimit:      1,
-			expectedOffset:     5,
 			expectedHTTPStatus: http.StatusOK,
-			expectedResponse:   []byte(`[{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","password":"5YLItbmdkfC1","ip_address":"63.119.6.98","creation_date":"19/04/2021"}]` + "\n"),
+			expectedResponse:   []byte(`{"data":[{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"}],"next_cursor":"` + (lib.UsersCursor{ID: "1311f914-1d4f-40b6-8886-80193265d5a4"}).Encode() + `"}` + "\n"),
+		},
+		{
+			name: "cursor pagination - last page",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users",
+					RawQuery: "limit=1&cursor=" + (lib.UsersCursor{ID: "1311f914-1d4f-40b6-8886-80193265d5a4"}).Encode(),
+				},
+			},
+			svcResponse: lib.UsersPage{Users: []lib.User{}},
+			svcError:    nil,
+			expectedQuery: lib.UsersQuery{
+				Limit:  1,
+				Cursor: &lib.UsersCursor{ID: "1311f914-1d4f-40b6-8886-80193265d5a4"},
+			},
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"data":[],"next_cursor":null}` + "\n"),
 		},
 		{
 			name: "service error",
@@ -94,35 +363,48 @@ func TestHandleGetUsers(t *testing.T) {
 					RawQuery: "limit=1&offset=5",
 				},
 			},
-			svcResponse:        nil,
+			svcResponse:        lib.UsersPage{},
 			svcError:           fmt.Errorf("svc error"),
-			expectedLimit:      1,
-			expectedOffset:     5,
+			expectedQuery:      lib.UsersQuery{Limit: 1, Offset: 5},
 			expectedHTTPStatus: http.StatusInternalServerError,
 			expectedResponse:   []byte(`{"error":"internal server error"}` + "\n"),
 		},
 		{
-			name: "not allowed method",
+			name: "invalid filter",
 			httpRequest: &http.Request{
-				Method: "POST",
+				Method: "GET",
 				URL: &url.URL{
 					Path:     "/v1/users",
-					RawQuery: "limit=1&offset=5",
+					RawQuery: "limit=1&offset=5&ip_cidr=invalid",
 				},
 			},
 			svcNotCalled:       true,
-			expectedHTTPStatus: http.StatusMethodNotAllowed,
-			expectedResponse:   []byte(`{"error":"method not allowed"}` + "\n"),
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid CIDR param 'ip_cidr'"}` + "\n"),
+		},
+		{
+			name: "error - deleted users included without admin",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users",
+					RawQuery: "limit=1&include_deleted=true",
+				},
+			},
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusForbidden,
+			expectedResponse:   []byte(`{"error":"param 'include_deleted' requires admin privileges"}` + "\n"),
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockUsersService := new(mockUsersService)
-			mockUsersService.On("GetUsers", mock.Anything, tc.expectedLimit, tc.expectedOffset).Return(tc.svcResponse, tc.svcError)
+			mockUsersService.On("GetUsers", mock.Anything, tc.expectedQuery).Return(tc.svcResponse, tc.svcError)
 
+			header := make(http.Header)
 			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
-			mockHTTPResponseWriter.On("Header").Return(make(http.Header))
+			mockHTTPResponseWriter.On("Header").Return(header)
 			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
 			mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
 
@@ -133,79 +415,1750 @@ func TestHandleGetUsers(t *testing.T) {
 				mockUsersService.AssertExpectations(t)
 			}
 			mockHTTPResponseWriter.AssertExpectations(t)
+			if tc.expectedHeaders != nil {
+				assert.Equal(t, tc.expectedHeaders, header)
+			}
 		})
 	}
 }
 
-func TestHandleGetUser(t *testing.T) {
-	// NOTE: function "getURLPathParam" is already being tested in "helper_test.go"
-	// and function "handleError" is already being tested in "errors_test.go"
-	// so all tests here will assume the success case scenario for them
+func TestHandleExportUsers(t *testing.T) {
+	users := []lib.User{
+		{
+			ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+			FirstName:    "Terrence",
+			LastName:     "Trillow",
+			Email:        "ttrillow1@feedburner.com",
+			Password:     "5YLItbmdkfC1",
+			IPAddress:    "63.119.6.98",
+			CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			ID:           "3e601207-0e80-4e7e-ae87-bb802b16a179",
+			FirstName:    "Niels",
+			LastName:     "MacPaik",
+			Email:        "nmacpaik2@phoca.cz",
+			Password:     "Vae1mnI",
+			IPAddress:    "158.186.130.96",
+			CreationDate: time.Date(2021, time.January, 19, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	// enough users to flush the response before the error
+	manyUsers := make([]lib.User, exportFlushRows)
+	for i := range manyUsers {
+		manyUsers[i] = users[0]
+	}
+
+	testCases := []struct {
+		name                string
+		rawQuery            string
+		accept              string
+		svcNotCalled        bool
+		svcResponse         []lib.User
+		svcError            error
+		expectedFilter      lib.UsersFilter
+		expectedSort        []lib.SortField
+		expectedHTTPStatus  int
+		expectedContentType string
+		expectedResponse    string
+	}{
+		{
+			name:                "CSV - format param, filters, sorting and legacy dates",
+			rawQuery:            "format=csv&email_domain=phoca.cz&sort=-first_name&date_format=legacy",
+			svcResponse:         users,
+			svcError:            nil,
+			expectedFilter:      lib.UsersFilter{EmailDomain: "phoca.cz"},
+			expectedSort:        []lib.SortField{{Field: "first_name", Descending: true}},
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: "text/csv; charset=utf-8",
+			expectedResponse: "id,first_name,last_name,email,ip_address,creation_date\n" +
+				"1311f914-1d4f-40b6-8886-80193265d5a4,Terrence,Trillow,ttrillow1@feedburner.com,63.119.6.98,19/04/2021\n" +
+				"3e601207-0e80-4e7e-ae87-bb802b16a179,Niels,MacPaik,nmacpaik2@phoca.cz,158.186.130.96,19/01/2021\n",
+		},
+		{
+			name:                "NDJSON - Accept header and selected fields",
+			rawQuery:            "fields=id,email",
+			accept:              "application/json;q=0.5, application/x-ndjson",
+			svcResponse:         users,
+			svcError:            nil,
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: "application/x-ndjson",
+			expectedResponse: `{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","email":"ttrillow1@feedburner.com"}` + "\n" +
+				`{"id":"3e601207-0e80-4e7e-ae87-bb802b16a179","email":"nmacpaik2@phoca.cz"}` + "\n",
+		},
+		{
+			name:                "CSV - no users, only the header",
+			rawQuery:            "format=csv",
+			svcResponse:         []lib.User{},
+			svcError:            nil,
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: "text/csv; charset=utf-8",
+			expectedResponse:    "id,first_name,last_name,email,ip_address,creation_date\n",
+		},
+		{
+			name:                "error - pagination cannot be used",
+			rawQuery:            "format=ndjson&limit=10",
+			svcNotCalled:        true,
+			expectedHTTPStatus:  http.StatusBadRequest,
+			expectedContentType: "application/json",
+			expectedResponse:    `{"error":"'limit' cannot be used with the 'ndjson' format"}` + "\n",
+		},
+		{
+			name:                "error - invalid format",
+			rawQuery:            "format=xml",
+			svcNotCalled:        true,
+			expectedHTTPStatus:  http.StatusBadRequest,
+			expectedContentType: "application/json",
+			expectedResponse:    `{"error":"invalid param 'format' (expected json, ndjson or csv)"}` + "\n",
+		},
+		{
+			name:                "error - service error before the response is started",
+			rawQuery:            "format=ndjson",
+			svcResponse:         users,
+			svcError:            fmt.Errorf("repo error"),
+			expectedHTTPStatus:  http.StatusInternalServerError,
+			expectedContentType: "application/json",
+			expectedResponse:    `{"error":"internal server error"}` + "\n",
+		},
+		{
+			name:                "error - service error after the response is started (truncated)",
+			rawQuery:            "format=csv&fields=first_name",
+			svcResponse:         manyUsers,
+			svcError:            fmt.Errorf("repo error"),
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: "text/csv; charset=utf-8",
+			expectedResponse:    "first_name\n" + strings.Repeat("Terrence\n", exportFlushRows),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("ExportUsers", mock.Anything, tc.expectedFilter, tc.expectedSort).Return(tc.svcResponse, tc.svcError)
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/v1/users?"+tc.rawQuery, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handleGetUsers(recorder, req)
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			}
+			assert.Equal(t, tc.expectedHTTPStatus, recorder.Code)
+			assert.Equal(t, tc.expectedContentType, recorder.Header().Get("Content-Type"))
+			assert.Equal(t, tc.expectedResponse, recorder.Body.String())
+			assert.Equal(t, "Accept, X-Date-Format", recorder.Header().Get("Vary"))
+		})
+	}
+}
+
+func TestHandleBatchGetUsers(t *testing.T) {
+	terrence := lib.User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		Password:     "5YLItbmdkfC1",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
 
 	testCases := []struct {
 		name               string
-		httpRequest        *http.Request
+		httpMethod         string
+		rawQuery           string
+		httpBody           string
 		svcNotCalled       bool
-		svcResponse        lib.User
+		svcResponse        lib.UsersBatch
 		svcError           error
-		expectedUserID     string
+		expectedIDs        []string
 		expectedHTTPStatus int
 		expectedResponse   []byte
 	}{
 		{
-			name: "base case",
-			httpRequest: &http.Request{
-				Method: "GET",
-				URL: &url.URL{
-					Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
-				},
-			},
-			svcResponse: lib.User{
-				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
-				FirstName:    "Terrence",
-				LastName:     "Trillow",
-				Email:        "ttrillow1@feedburner.com",
-				Password:     "5YLItbmdkfC1",
-				IPAddress:    "63.119.6.98",
-				CreationDate: "19/04/2021",
+			name:       "GET - repeated id querystring",
+			httpMethod: "GET",
+			rawQuery:   "id=1311f914-1d4f-40b6-8886-80193265d5a4&id=unknown",
+			svcResponse: lib.UsersBatch{
+				Users:      []lib.User{terrence},
+				MissingIDs: []string{"unknown"},
 			},
 			svcError:           nil,
-			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedIDs:        []string{"1311f914-1d4f-40b6-8886-80193265d5a4", "unknown"},
 			expectedHTTPStatus: http.StatusOK,
-			expectedResponse:   []byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","password":"5YLItbmdkfC1","ip_address":"63.119.6.98","creation_date":"19/04/2021"}` + "\n"),
+			expectedResponse:   []byte(`{"data":[{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"}],"missing_ids":["unknown"]}` + "\n"),
 		},
 		{
-			name: "service error",
-			httpRequest: &http.Request{
-				Method: "GET",
-				URL: &url.URL{
-					Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
-				},
+			name:       "POST - JSON body, selected fields",
+			httpMethod: "POST",
+			rawQuery:   "fields=id,email",
+			httpBody:   `{"ids":["1311f914-1d4f-40b6-8886-80193265d5a4"]}`,
+			svcResponse: lib.UsersBatch{
+				Users:      []lib.User{terrence},
+				MissingIDs: []string{},
 			},
-			svcResponse:        lib.User{},
-			svcError:           lib.ErrNotFound,
-			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
-			expectedHTTPStatus: http.StatusNotFound,
-			expectedResponse:   []byte(`{"error":"not found"}` + "\n"),
+			svcError:           nil,
+			expectedIDs:        []string{"1311f914-1d4f-40b6-8886-80193265d5a4"},
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"data":[{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","email":"ttrillow1@feedburner.com"}],"missing_ids":[]}` + "\n"),
 		},
 		{
-			name: "not allowed method",
-			httpRequest: &http.Request{
-				Method: "POST",
+			name:               "error - no IDs",
+			httpMethod:         "GET",
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"missing required user IDs"}` + "\n"),
+		},
+		{
+			name:               "error - invalid JSON body",
+			httpMethod:         "POST",
+			httpBody:           `{"ids":"1311f914-1d4f-40b6-8886-80193265d5a4"}`,
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid JSON body: json: cannot unmarshal string into Go struct field batchGetUsersRequest.ids of type []string"}` + "\n"),
+		},
+		{
+			name:               "service error",
+			httpMethod:         "GET",
+			rawQuery:           "id=1311f914-1d4f-40b6-8886-80193265d5a4",
+			svcResponse:        lib.UsersBatch{},
+			svcError:           fmt.Errorf("svc error"),
+			expectedIDs:        []string{"1311f914-1d4f-40b6-8886-80193265d5a4"},
+			expectedHTTPStatus: http.StatusInternalServerError,
+			expectedResponse:   []byte(`{"error":"internal server error"}` + "\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("GetUsersByIDs", mock.Anything, tc.expectedIDs).Return(tc.svcResponse, tc.svcError)
+
+			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
+			mockHTTPResponseWriter.On("Header").Return(make(http.Header))
+			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
+			mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
+
+			httpRequest := &http.Request{
+				Method: tc.httpMethod,
 				URL: &url.URL{
-					Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+					Path:     "/v1/users:batchGet",
+					RawQuery: tc.rawQuery,
 				},
-			},
-			svcNotCalled:       true,
-			expectedHTTPStatus: http.StatusMethodNotAllowed,
-			expectedResponse:   []byte(`{"error":"method not allowed"}` + "\n"),
+			}
+			if tc.httpBody != "" {
+				httpRequest.Body = ioutil.NopCloser(strings.NewReader(tc.httpBody))
+			}
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handleBatchGetUsers(mockHTTPResponseWriter, httpRequest)
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			}
+			mockHTTPResponseWriter.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleImportUsers(t *testing.T) {
+	testCases := []struct {
+		name               string
+		httpMethod         string
+		rawQuery           string
+		contentType        string
+		httpBody           string
+		svcNotCalled       bool
+		svcResponse        lib.ImportReport
+		svcError           error
+		expectedFormat     lib.DataFormat
+		expectedOptions    lib.ImportOptions
+		expectedHTTPStatus int
+		expectedResponse   []byte
+	}{
+		{
+			name:        "base case",
+			httpMethod:  "POST",
+			contentType: "text/csv; charset=utf-8",
+			httpBody:    "first_name,last_name,email,password\nNicky,Blasio,nblasio0@jiathis.com,rKJKin\n",
+			svcResponse: lib.ImportReport{
+				Applied: true,
+				Created: 1,
+				Rows:    []lib.ImportRowReport{{Row: 1, ID: "144bf891-f161-4c9a-8d83-38a275e088a5", Status: lib.ImportCreated}},
+			},
+			svcError:           nil,
+			expectedFormat:     lib.FormatCSV,
+			expectedOptions:    lib.ImportOptions{},
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"applied":true,"created":1,"updated":0,"rejected":0,"rows":[{"row":1,"id":"144bf891-f161-4c9a-8d83-38a275e088a5","status":"created"}]}` + "\n"),
+		},
+		{
+			name:        "dry run and all or nothing",
+			httpMethod:  "POST",
+			rawQuery:    "dry_run=true&all_or_nothing=1",
+			contentType: "application/x-ndjson",
+			httpBody:    `{"first_name":"Nicky"}`,
+			svcResponse: lib.ImportReport{
+				Rejected: 1,
+				Rows:     []lib.ImportRowReport{{Row: 1, Status: lib.ImportRejected, Reason: "invalid user: 'last_name' is required"}},
+			},
+			svcError:           nil,
+			expectedFormat:     lib.FormatNDJSON,
+			expectedOptions:    lib.ImportOptions{DryRun: true, AllOrNothing: true},
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"applied":false,"created":0,"updated":0,"rejected":1,"rows":[{"row":1,"status":"rejected","reason":"invalid user: 'last_name' is required"}]}` + "\n"),
+		},
+		{
+			name:               "error - unsupported content type",
+			httpMethod:         "POST",
+			contentType:        "application/xml",
+			httpBody:           "<users/>",
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusUnsupportedMediaType,
+			expectedResponse:   []byte(`{"error":"unsupported content type (expected application/json, application/x-ndjson or text/csv)"}` + "\n"),
+		},
+		{
+			name:               "error - invalid mode",
+			httpMethod:         "POST",
+			rawQuery:           "dry_run=maybe",
+			contentType:        "application/json",
+			httpBody:           "[]",
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid boolean param 'dry_run'"}` + "\n"),
+		},
+		{
+			name:               "error - missing body",
+			httpMethod:         "POST",
+			contentType:        "application/json",
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"missing request body"}` + "\n"),
+		},
+		{
+			name:               "service error",
+			httpMethod:         "POST",
+			contentType:        "application/json",
+			httpBody:           "{}",
+			svcResponse:        lib.ImportReport{},
+			svcError:           fmt.Errorf("invalid JSON array: %w", lib.ErrPreconditionFailed),
+			expectedFormat:     lib.FormatJSON,
+			expectedOptions:    lib.ImportOptions{},
+			expectedHTTPStatus: http.StatusPreconditionFailed,
+			expectedResponse:   []byte(`{"error":"invalid JSON array: precondition failed"}` + "\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("ImportUsers", mock.Anything, tc.httpBody, tc.expectedFormat, tc.expectedOptions).Return(tc.svcResponse, tc.svcError)
+
+			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
+			mockHTTPResponseWriter.On("Header").Return(make(http.Header))
+			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
+			mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
+
+			httpRequest := &http.Request{
+				Method: tc.httpMethod,
+				URL: &url.URL{
+					Path:     "/v1/users:bulk",
+					RawQuery: tc.rawQuery,
+				},
+				Header: http.Header{"Content-Type": []string{tc.contentType}},
+			}
+			if tc.httpBody != "" {
+				httpRequest.Body = ioutil.NopCloser(strings.NewReader(tc.httpBody))
+			}
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handleImportUsers(mockHTTPResponseWriter, httpRequest)
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			}
+			mockHTTPResponseWriter.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleSearchUsers(t *testing.T) {
+	testCases := []struct {
+		name               string
+		httpRequest        *http.Request
+		svcNotCalled       bool
+		svcResponse        lib.UsersPage
+		svcError           error
+		expectedQuery      lib.UsersSearchQuery
+		expectedHTTPStatus int
+		expectedResponse   []byte
+	}{
+		{
+			name: "base case",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/search",
+					RawQuery: "q=terence+trillow&limit=10",
+				},
+			},
+			svcResponse: lib.UsersPage{Users: []lib.User{
+				{
+					ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+					FirstName:    "Terrence",
+					LastName:     "Trillow",
+					Email:        "ttrillow1@feedburner.com",
+					Password:     "5YLItbmdkfC1",
+					IPAddress:    "63.119.6.98",
+					CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+				},
+			}, Total: 1},
+			svcError: nil,
+			expectedQuery: lib.UsersSearchQuery{
+				Text:  "terence trillow",
+				Limit: 10,
+			},
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`[{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"}]` + "\n"),
+		},
+		{
+			name: "envelope",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/search",
+					RawQuery: "q=unknown&limit=10&envelope=true",
+				},
+			},
+			svcResponse: lib.UsersPage{Users: []lib.User{}},
+			svcError:    nil,
+			expectedQuery: lib.UsersSearchQuery{
+				Text:  "unknown",
+				Limit: 10,
+			},
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"data":[],"total":0,"limit":10,"offset":0,"next":null,"prev":null}` + "\n"),
+		},
+		{
+			name: "error - missing search text",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/search",
+					RawQuery: "limit=10",
+				},
+			},
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"missing required query param 'q'"}` + "\n"),
+		},
+		{
+			name: "service error",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/search",
+					RawQuery: "q=trillow&limit=10",
+				},
+			},
+			svcResponse: lib.UsersPage{},
+			svcError:    fmt.Errorf("svc error"),
+			expectedQuery: lib.UsersSearchQuery{
+				Text:  "trillow",
+				Limit: 10,
+			},
+			expectedHTTPStatus: http.StatusInternalServerError,
+			expectedResponse:   []byte(`{"error":"internal server error"}` + "\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("SearchUsers", mock.Anything, tc.expectedQuery).Return(tc.svcResponse, tc.svcError)
+
+			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
+			mockHTTPResponseWriter.On("Header").Return(make(http.Header))
+			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
+			mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handleSearchUsers(mockHTTPResponseWriter, tc.httpRequest)
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			}
+			mockHTTPResponseWriter.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleGetUser(t *testing.T) {
+	// NOTE: function "getURLPathParam" is already being tested in "helper_test.go"
+	// and function "handleError" is already being tested in "errors_test.go"
+	// so all tests here will assume the success case scenario for them
+
+	deletedAt := time.Date(2022, time.January, 10, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name                   string
+		httpRequest            *http.Request
+		admin                  bool
+		svcNotCalled           bool
+		svcResponse            lib.User
+		svcError               error
+		expectedUserID         string
+		expectedAsOf           time.Time
+		expectedIncludeDeleted bool
+		expectedHTTPStatus     int
+		expectedResponse       []byte
+	}{
+		{
+			name: "base case",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+				},
+			},
+			svcResponse: lib.User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terrence",
+				LastName:     "Trillow",
+				Email:        "ttrillow1@feedburner.com",
+				Password:     "5YLItbmdkfC1",
+				IPAddress:    "63.119.6.98",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			svcError:           nil,
+			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"}` + "\n"),
+		},
+		{
+			name: "not modified - If-None-Match with the user entity tag",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+				},
+				Header: http.Header{"If-None-Match": []string{`"other", W/` + userETag(lib.User{ID: "1311f914-1d4f-40b6-8886-80193265d5a4"})}},
+			},
+			svcResponse: lib.User{
+				ID: "1311f914-1d4f-40b6-8886-80193265d5a4",
+			},
+			svcError:           nil,
+			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedHTTPStatus: http.StatusNotModified,
+			expectedResponse:   nil,
+		},
+		{
+			name: "not found - If-None-Match ignored",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+				},
+				Header: http.Header{"If-None-Match": []string{"*"}},
+			},
+			svcResponse:        lib.User{},
+			svcError:           lib.ErrNotFound,
+			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedHTTPStatus: http.StatusNotFound,
+			expectedResponse:   []byte(`{"error":"not found"}` + "\n"),
+		},
+		{
+			name: "selected fields",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+					RawQuery: "fields=email,id",
+				},
+			},
+			svcResponse: lib.User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terrence",
+				LastName:     "Trillow",
+				Email:        "ttrillow1@feedburner.com",
+				Password:     "5YLItbmdkfC1",
+				IPAddress:    "63.119.6.98",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			svcError:           nil,
+			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","email":"ttrillow1@feedburner.com"}` + "\n"),
+		},
+		{
+			name: "legacy dates - compatibility header",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+					RawQuery: "fields=id,creation_date",
+				},
+				Header: http.Header{"X-Date-Format": []string{"legacy"}},
+			},
+			svcResponse: lib.User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terrence",
+				LastName:     "Trillow",
+				Email:        "ttrillow1@feedburner.com",
+				Password:     "5YLItbmdkfC1",
+				IPAddress:    "63.119.6.98",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			svcError:           nil,
+			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","creation_date":"19/04/2021"}` + "\n"),
+		},
+		{
+			name: "error - invalid date format",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+					RawQuery: "date_format=us",
+				},
+			},
+			svcNotCalled:       true,
+			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid param 'date_format' (expected rfc3339 or legacy)"}` + "\n"),
+		},
+		{
+			name: "deleted user included - admin",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+					RawQuery: "include_deleted=true&fields=id,deleted_at",
+				},
+			},
+			admin: true,
+			svcResponse: lib.User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				Email:        "ttrillow1@feedburner.com",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+				DeletedAt:    &deletedAt,
+			},
+			svcError:               nil,
+			expectedUserID:         "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedIncludeDeleted: true,
+			expectedHTTPStatus:     http.StatusOK,
+			expectedResponse:       []byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","deleted_at":"2022-01-10T12:00:00Z"}` + "\n"),
+		},
+		{
+			name: "error - deleted user included without admin",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+					RawQuery: "include_deleted=true",
+				},
+			},
+			svcNotCalled:       true,
+			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedHTTPStatus: http.StatusForbidden,
+			expectedResponse:   []byte(`{"error":"param 'include_deleted' requires admin privileges"}` + "\n"),
+		},
+		{
+			name: "point-in-time read",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+					RawQuery: "as_of=2022-03-01T10:00:00-03:00&fields=id,email",
+				},
+			},
+			svcResponse: lib.User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				Email:        "ttrillow1@feedburner.com",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			svcError:           nil,
+			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedAsOf:       time.Date(2022, time.March, 1, 13, 0, 0, 0, time.UTC),
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","email":"ttrillow1@feedburner.com"}` + "\n"),
+		},
+		{
+			name: "point-in-time read - not found",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+					RawQuery: "as_of=2020-01-01",
+				},
+			},
+			svcResponse:        lib.User{},
+			svcError:           lib.ErrNotFound,
+			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedAsOf:       time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+			expectedHTTPStatus: http.StatusNotFound,
+			expectedResponse:   []byte(`{"error":"not found"}` + "\n"),
+		},
+		{
+			name: "error - invalid point-in-time",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+					RawQuery: "as_of=yesterday",
+				},
+			},
+			svcNotCalled:       true,
+			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid time param 'as_of' (expected RFC 3339 or yyyy-mm-dd)"}` + "\n"),
+		},
+		{
+			name: "error - unknown field",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path:     "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+					RawQuery: "fields=id,password",
+				},
+			},
+			svcNotCalled:       true,
+			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid field 'password'"}` + "\n"),
+		},
+		{
+			name: "service error",
+			httpRequest: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+				},
+			},
+			svcResponse:        lib.User{},
+			svcError:           lib.ErrNotFound,
+			expectedUserID:     "1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedHTTPStatus: http.StatusNotFound,
+			expectedResponse:   []byte(`{"error":"not found"}` + "\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			if tc.expectedAsOf.IsZero() {
+				mockUsersService.On("GetUser", mock.Anything, tc.expectedUserID, tc.expectedIncludeDeleted).Return(tc.svcResponse, tc.svcError)
+			} else {
+				mockUsersService.On("GetUserAsOf", mock.Anything, tc.expectedUserID, mock.MatchedBy(tc.expectedAsOf.Equal), tc.expectedIncludeDeleted).Return(tc.svcResponse, tc.svcError)
+			}
+
+			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
+			mockHTTPResponseWriter.On("Header").Return(make(http.Header))
+			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
+			if tc.expectedResponse != nil {
+				mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
+			}
+
+			httpRequest := tc.httpRequest
+			if tc.admin {
+				httpRequest = httpRequest.WithContext(context.WithValue(context.Background(), adminContextKey{}, true))
+			}
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handleGetUser(mockHTTPResponseWriter, httpRequest)
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			}
+			mockHTTPResponseWriter.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleGetUserRepresentationETags(t *testing.T) {
+	user := lib.User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		Email:        "ttrillow1@feedburner.com",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+	mockUsersService := new(mockUsersService)
+	mockUsersService.On("GetUser", mock.Anything, user.ID, false).Return(user, nil)
+	handler := NewUsersHandler(mockUsersService)
+
+	getUser := func(rawQuery string, header http.Header) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.handleGetUser(recorder, &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/v1/users/" + user.ID, RawQuery: rawQuery},
+			Header: header,
+		})
+		return recorder
+	}
+
+	fullETag := getUser("", nil).Header().Get("ETag")
+	assert.Equal(t, userETag(user), fullETag)
+
+	// each representation has its own entity tag, holding the user version
+	etags := map[string]bool{fullETag: true}
+	for _, representation := range []struct {
+		rawQuery string
+		header   http.Header
+	}{
+		{rawQuery: "fields=email,id"},
+		{rawQuery: "date_format=legacy"},
+		{header: http.Header{"X-Date-Format": []string{"legacy"}}},
+		{rawQuery: "fields=email,id&date_format=legacy"},
+	} {
+		header := http.Header{"If-None-Match": []string{fullETag}}
+		for key, values := range representation.header {
+			header[key] = values
+		}
+		response := getUser(representation.rawQuery, header)
+		assert.Equal(t, http.StatusOK, response.Code, representation)
+		assert.Equal(t, "X-Date-Format", response.Header().Get("Vary"), representation)
+
+		etag := response.Header().Get("ETag")
+		assert.True(t, strings.HasPrefix(etag, `"`+lib.UserVersion(user)+"-"), representation)
+		etags[etag] = true
+
+		header.Set("If-None-Match", etag)
+		response = getUser(representation.rawQuery, header)
+		assert.Equal(t, http.StatusNotModified, response.Code, representation)
+	}
+	// the compatibility header and the querystring are the same representation
+	assert.Len(t, etags, 4)
+}
+
+func TestHandleGetUserHistory(t *testing.T) {
+	revisions := []lib.UserRevision{
+		{
+			UserID:  "1311f914-1d4f-40b6-8886-80193265d5a4",
+			Number:  1,
+			Action:  lib.RevisionUpdated,
+			Actor:   "admin",
+			Time:    time.Date(2022, time.March, 1, 10, 0, 0, 500, time.FixedZone("BRT", -3*60*60)),
+			Changes: []lib.FieldChange{{Field: "email", From: "ttrillow1@feedburner.com", To: "terry@feedburner.com"}},
+		},
+	}
+
+	testCases := []struct {
+		name                   string
+		httpMethod             string
+		rawQuery               string
+		admin                  bool
+		svcNotCalled           bool
+		svcResponse            []lib.UserRevision
+		svcError               error
+		expectedIncludeDeleted bool
+		expectedHTTPStatus     int
+		expectedResponse       []byte
+	}{
+		{
+			name:               "base case",
+			httpMethod:         "GET",
+			svcResponse:        revisions,
+			svcError:           nil,
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`[{"number":1,"action":"updated","actor":"admin","time":"2022-03-01T13:00:00.0000005Z","changes":[{"field":"email","from":"ttrillow1@feedburner.com","to":"terry@feedburner.com"}]}]` + "\n"),
+		},
+		{
+			name:               "no history",
+			httpMethod:         "GET",
+			svcResponse:        []lib.UserRevision{},
+			svcError:           nil,
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`[]` + "\n"),
+		},
+		{
+			name:                   "deleted user included - admin",
+			httpMethod:             "GET",
+			rawQuery:               "include_deleted=true",
+			admin:                  true,
+			svcResponse:            revisions,
+			svcError:               nil,
+			expectedIncludeDeleted: true,
+			expectedHTTPStatus:     http.StatusOK,
+			expectedResponse:       []byte(`[{"number":1,"action":"updated","actor":"admin","time":"2022-03-01T13:00:00.0000005Z","changes":[{"field":"email","from":"ttrillow1@feedburner.com","to":"terry@feedburner.com"}]}]` + "\n"),
+		},
+		{
+			name:               "error - deleted user included without admin",
+			httpMethod:         "GET",
+			rawQuery:           "include_deleted=true",
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusForbidden,
+			expectedResponse:   []byte(`{"error":"param 'include_deleted' requires admin privileges"}` + "\n"),
+		},
+		{
+			name:               "service error",
+			httpMethod:         "GET",
+			svcResponse:        nil,
+			svcError:           lib.ErrNotFound,
+			expectedHTTPStatus: http.StatusNotFound,
+			expectedResponse:   []byte(`{"error":"not found"}` + "\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("GetUserHistory", mock.Anything, "1311f914-1d4f-40b6-8886-80193265d5a4", tc.expectedIncludeDeleted).Return(tc.svcResponse, tc.svcError)
+
+			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
+			mockHTTPResponseWriter.On("Header").Return(make(http.Header))
+			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
+			mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
+
+			httpRequest := &http.Request{
+				Method: tc.httpMethod,
+				URL:    &url.URL{Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4/history", RawQuery: tc.rawQuery},
+			}
+			if tc.admin {
+				httpRequest = httpRequest.WithContext(context.WithValue(context.Background(), adminContextKey{}, true))
+			}
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handleGetUserHistory(mockHTTPResponseWriter, httpRequest)
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			}
+			mockHTTPResponseWriter.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleCreateUser(t *testing.T) {
+	// NOTE: function "readJSON" is already being tested in "helper_test.go"
+	// and function "handleError" is already being tested in "errors_test.go"
+	// so all tests here will assume the success case scenario for them
+
+	testCases := []struct {
+		name               string
+		httpMethod         string
+		httpBody           string
+		svcNotCalled       bool
+		expectedUser       lib.User
+		svcResponse        lib.User
+		svcError           error
+		expectedHTTPStatus int
+		expectedResponse   []byte
+	}{
+		{
+			name:       "base case",
+			httpMethod: "POST",
+			httpBody:   `{"first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","password":"5YLItbmdkfC1","ip_address":"63.119.6.98"}`,
+			expectedUser: lib.User{
+				FirstName: "Terrence",
+				LastName:  "Trillow",
+				Email:     "ttrillow1@feedburner.com",
+				Password:  "5YLItbmdkfC1",
+				IPAddress: "63.119.6.98",
+			},
+			svcResponse: lib.User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terrence",
+				LastName:     "Trillow",
+				Email:        "ttrillow1@feedburner.com",
+				Password:     "5YLItbmdkfC1",
+				IPAddress:    "63.119.6.98",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			svcError:           nil,
+			expectedHTTPStatus: http.StatusCreated,
+			expectedResponse:   []byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"}` + "\n"),
+		},
+		{
+			name:       "missing required field",
+			httpMethod: "POST",
+			httpBody:   `{"first_name":"Terrence"}`,
+			expectedUser: lib.User{
+				FirstName: "Terrence",
+			},
+			svcResponse: lib.User{},
+			svcError: &lib.ValidationError{Fields: []lib.FieldError{
+				{Field: "last_name", Message: "is required"},
+			}},
+			expectedHTTPStatus: http.StatusUnprocessableEntity,
+			expectedResponse:   []byte(`{"error":"invalid user: 'last_name' is required","fields":[{"field":"last_name","message":"is required"}]}` + "\n"),
+		},
+		{
+			name:       "validation error",
+			httpMethod: "POST",
+			httpBody:   `{"first_name":"Terrence","email":"ttrillow1"}`,
+			expectedUser: lib.User{
+				FirstName: "Terrence",
+				Email:     "ttrillow1",
+			},
+			svcResponse: lib.User{},
+			svcError: &lib.ValidationError{Fields: []lib.FieldError{
+				{Field: "email", Message: "must be a valid email address"},
+			}},
+			expectedHTTPStatus: http.StatusUnprocessableEntity,
+			expectedResponse:   []byte(`{"error":"invalid user: 'email' must be a valid email address","fields":[{"field":"email","message":"must be a valid email address"}]}` + "\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("CreateUser", mock.Anything, tc.expectedUser).Return(tc.svcResponse, tc.svcError)
+
+			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
+			mockHTTPResponseWriter.On("Header").Return(make(http.Header))
+			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
+			mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handleCreateUser(mockHTTPResponseWriter, &http.Request{
+				Method: tc.httpMethod,
+				URL:    &url.URL{Path: "/v1/users"},
+				Body:   ioutil.NopCloser(strings.NewReader(tc.httpBody)),
+			})
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			}
+			mockHTTPResponseWriter.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleUpdateUser(t *testing.T) {
+	testCases := []struct {
+		name               string
+		httpMethod         string
+		ifMatch            string
+		httpBody           string
+		svcNotCalled       bool
+		expectedUser       lib.User
+		expectedVersions   []string
+		svcResponse        lib.User
+		svcError           error
+		expectedHTTPStatus int
+		expectedResponse   []byte
+	}{
+		{
+			name:       "base case - id from url parameter",
+			httpMethod: "PUT",
+			ifMatch:    `"v1", W/"v0"`,
+			httpBody:   `{"id":"other","first_name":"Terry","last_name":"Trillow","email":"terry@feedburner.com","password":"5YLItbmdkfC1","ip_address":"63.119.6.98"}`,
+			expectedUser: lib.User{
+				ID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName: "Terry",
+				LastName:  "Trillow",
+				Email:     "terry@feedburner.com",
+				Password:  "5YLItbmdkfC1",
+				IPAddress: "63.119.6.98",
+			},
+			expectedVersions: []string{"v1"},
+			svcResponse: lib.User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terry",
+				LastName:     "Trillow",
+				Email:        "terry@feedburner.com",
+				Password:     "5YLItbmdkfC1",
+				IPAddress:    "63.119.6.98",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			svcError:           nil,
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terry","last_name":"Trillow","email":"terry@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"}` + "\n"),
+		},
+		{
+			name:       "service error",
+			httpMethod: "PUT",
+			ifMatch:    "*",
+			httpBody:   `{"first_name":"Terry","last_name":"Trillow","email":"terry@feedburner.com","password":"5YLItbmdkfC1"}`,
+			expectedUser: lib.User{
+				ID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName: "Terry",
+				LastName:  "Trillow",
+				Email:     "terry@feedburner.com",
+				Password:  "5YLItbmdkfC1",
+			},
+			svcResponse:        lib.User{},
+			svcError:           lib.ErrNotFound,
+			expectedHTTPStatus: http.StatusNotFound,
+			expectedResponse:   []byte(`{"error":"not found"}` + "\n"),
+		},
+		{
+			name:       "version mismatch",
+			httpMethod: "PUT",
+			ifMatch:    `"v0"`,
+			httpBody:   `{"first_name":"Terry","last_name":"Trillow","email":"terry@feedburner.com","password":"5YLItbmdkfC1"}`,
+			expectedUser: lib.User{
+				ID:        "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName: "Terry",
+				LastName:  "Trillow",
+				Email:     "terry@feedburner.com",
+				Password:  "5YLItbmdkfC1",
+			},
+			expectedVersions:   []string{"v0"},
+			svcResponse:        lib.User{},
+			svcError:           fmt.Errorf("user '1311f914-1d4f-40b6-8886-80193265d5a4' was modified (version mismatch): %w", lib.ErrPreconditionFailed),
+			expectedHTTPStatus: http.StatusPreconditionFailed,
+			expectedResponse:   []byte(`{"error":"user '1311f914-1d4f-40b6-8886-80193265d5a4' was modified (version mismatch): precondition failed"}` + "\n"),
+		},
+		{
+			name:               "missing If-Match",
+			httpMethod:         "PUT",
+			httpBody:           `{"first_name":"Terry","last_name":"Trillow","email":"terry@feedburner.com","password":"5YLItbmdkfC1"}`,
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusPreconditionRequired,
+			expectedResponse:   []byte(`{"error":"missing required header 'If-Match'"}` + "\n"),
+		},
+		{
+			name:               "invalid body",
+			httpMethod:         "PUT",
+			ifMatch:            "*",
+			httpBody:           `{"unknown":"field"}`,
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid JSON body: json: unknown field \"unknown\""}` + "\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("UpdateUser", mock.Anything, tc.expectedUser, tc.expectedVersions).Return(tc.svcResponse, tc.svcError)
+
+			header := make(http.Header)
+			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
+			mockHTTPResponseWriter.On("Header").Return(header)
+			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
+			mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handleUpdateUser(mockHTTPResponseWriter, &http.Request{
+				Method: tc.httpMethod,
+				URL:    &url.URL{Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4"},
+				Header: http.Header{"If-Match": []string{tc.ifMatch}},
+				Body:   ioutil.NopCloser(strings.NewReader(tc.httpBody)),
+			})
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			}
+			mockHTTPResponseWriter.AssertExpectations(t)
+			if tc.expectedHTTPStatus == http.StatusOK {
+				assert.Equal(t, userETag(tc.svcResponse), header.Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestHandlePatchUser(t *testing.T) {
+	newFirstName := "Terry"
+
+	testCases := []struct {
+		name               string
+		httpMethod         string
+		ifMatch            string
+		httpBody           string
+		svcNotCalled       bool
+		expectedPatch      lib.UserPatch
+		expectedVersions   []string
+		svcResponse        lib.User
+		svcError           error
+		expectedHTTPStatus int
+		expectedResponse   []byte
+	}{
+		{
+			name:             "base case",
+			httpMethod:       "PATCH",
+			ifMatch:          `"v1","v2"`,
+			httpBody:         `{"first_name":"Terry"}`,
+			expectedPatch:    lib.UserPatch{FirstName: &newFirstName},
+			expectedVersions: []string{"v1", "v2"},
+			svcResponse: lib.User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terry",
+				LastName:     "Trillow",
+				Email:        "ttrillow1@feedburner.com",
+				Password:     "5YLItbmdkfC1",
+				IPAddress:    "63.119.6.98",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			svcError:           nil,
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terry","last_name":"Trillow","email":"ttrillow1@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"}` + "\n"),
+		},
+		{
+			name:               "service error",
+			httpMethod:         "PATCH",
+			ifMatch:            "*",
+			httpBody:           `{"first_name":"Terry"}`,
+			expectedPatch:      lib.UserPatch{FirstName: &newFirstName},
+			svcResponse:        lib.User{},
+			svcError:           lib.ErrNotFound,
+			expectedHTTPStatus: http.StatusNotFound,
+			expectedResponse:   []byte(`{"error":"not found"}` + "\n"),
+		},
+		{
+			name:               "error - only weak entity tags",
+			httpMethod:         "PATCH",
+			ifMatch:            `W/"v1"`,
+			httpBody:           `{"first_name":"Terry"}`,
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusPreconditionFailed,
+			expectedResponse:   []byte(`{"error":"only weak entity tags in 'If-Match': precondition failed"}` + "\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("PatchUser", mock.Anything, "1311f914-1d4f-40b6-8886-80193265d5a4", tc.expectedPatch, tc.expectedVersions).Return(tc.svcResponse, tc.svcError)
+
+			header := make(http.Header)
+			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
+			mockHTTPResponseWriter.On("Header").Return(header)
+			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
+			mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handlePatchUser(mockHTTPResponseWriter, &http.Request{
+				Method: tc.httpMethod,
+				URL:    &url.URL{Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4"},
+				Header: http.Header{"If-Match": []string{tc.ifMatch}},
+				Body:   ioutil.NopCloser(strings.NewReader(tc.httpBody)),
+			})
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			}
+			mockHTTPResponseWriter.AssertExpectations(t)
+			if tc.expectedHTTPStatus == http.StatusOK {
+				assert.Equal(t, userETag(tc.svcResponse), header.Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestHandleDeleteUser(t *testing.T) {
+	testCases := []struct {
+		name               string
+		httpMethod         string
+		ifMatch            string
+		svcNotCalled       bool
+		expectedVersions   []string
+		svcError           error
+		expectedHTTPStatus int
+		expectedResponse   []byte
+	}{
+		{
+			name:               "base case",
+			httpMethod:         "DELETE",
+			ifMatch:            `"v1"`,
+			expectedVersions:   []string{"v1"},
+			svcError:           nil,
+			expectedHTTPStatus: http.StatusNoContent,
+		},
+		{
+			name:               "service error",
+			httpMethod:         "DELETE",
+			ifMatch:            "*",
+			svcError:           lib.ErrNotFound,
+			expectedHTTPStatus: http.StatusNotFound,
+			expectedResponse:   []byte(`{"error":"not found"}` + "\n"),
+		},
+		{
+			name:               "missing If-Match",
+			httpMethod:         "DELETE",
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusPreconditionRequired,
+			expectedResponse:   []byte(`{"error":"missing required header 'If-Match'"}` + "\n"),
+		},
+		{
+			name:               "invalid If-Match",
+			httpMethod:         "DELETE",
+			ifMatch:            "v1",
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid header 'If-Match' (expected quoted entity tags or \"*\")"}` + "\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("DeleteUser", mock.Anything, "1311f914-1d4f-40b6-8886-80193265d5a4", tc.expectedVersions).Return(tc.svcError)
+
+			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
+			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
+			if tc.expectedResponse != nil {
+				mockHTTPResponseWriter.On("Header").Return(make(http.Header))
+				mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
+			}
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handleDeleteUser(mockHTTPResponseWriter, &http.Request{
+				Method: tc.httpMethod,
+				URL:    &url.URL{Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4"},
+				Header: http.Header{"If-Match": []string{tc.ifMatch}},
+			})
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			}
+			mockHTTPResponseWriter.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleRestoreUser(t *testing.T) {
+	restoredUser := lib.User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+
+	testCases := []struct {
+		name               string
+		httpMethod         string
+		svcNotCalled       bool
+		svcResponse        lib.User
+		svcError           error
+		expectedHTTPStatus int
+		expectedResponse   []byte
+	}{
+		{
+			name:               "base case",
+			httpMethod:         "POST",
+			svcResponse:        restoredUser,
+			svcError:           nil,
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"}` + "\n"),
+		},
+		{
+			name:               "not deleted",
+			httpMethod:         "POST",
+			svcResponse:        lib.User{},
+			svcError:           fmt.Errorf("user '1311f914-1d4f-40b6-8886-80193265d5a4' is not deleted: %w", lib.ErrPreconditionFailed),
+			expectedHTTPStatus: http.StatusPreconditionFailed,
+			expectedResponse:   []byte(`{"error":"user '1311f914-1d4f-40b6-8886-80193265d5a4' is not deleted: precondition failed"}` + "\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("RestoreUser", mock.Anything, "1311f914-1d4f-40b6-8886-80193265d5a4").Return(tc.svcResponse, tc.svcError)
+
+			header := make(http.Header)
+			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
+			mockHTTPResponseWriter.On("Header").Return(header)
+			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
+			mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handleRestoreUser(mockHTTPResponseWriter, &http.Request{
+				Method: tc.httpMethod,
+				URL:    &url.URL{Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4:restore"},
+			})
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			}
+			mockHTTPResponseWriter.AssertExpectations(t)
+			if tc.expectedHTTPStatus == http.StatusOK {
+				assert.Equal(t, userETag(tc.svcResponse), header.Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestHandleExportUserData(t *testing.T) {
+	export := lib.UserDataExport{
+		ExportedAt: time.Date(2022, time.April, 1, 12, 0, 0, 0, time.UTC),
+		User: lib.User{
+			ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+			FirstName:    "Terrence",
+			LastName:     "Trillow",
+			Email:        "terry@feedburner.com",
+			Password:     "$2a$10$hash",
+			IPAddress:    "63.119.6.98",
+			CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+		},
+		History: []lib.UserRevision{
+			{
+				UserID:  "1311f914-1d4f-40b6-8886-80193265d5a4",
+				Number:  1,
+				Action:  lib.RevisionUpdated,
+				Actor:   "admin",
+				Time:    time.Date(2022, time.March, 1, 10, 0, 0, 0, time.UTC),
+				Changes: []lib.FieldChange{{Field: "email", From: "ttrillow1@feedburner.com", To: "terry@feedburner.com"}},
+			},
+		},
+		AuditEntries: []lib.AuditEntry{
+			{
+				UserID: "1311f914-1d4f-40b6-8886-80193265d5a4",
+				Action: lib.AuditDataExported,
+				Actor:  "admin",
+				Time:   time.Date(2022, time.April, 1, 12, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	testCases := []struct {
+		name                       string
+		httpMethod                 string
+		admin                      bool
+		svcNotCalled               bool
+		svcResponse                lib.UserDataExport
+		svcError                   error
+		expectedHTTPStatus         int
+		expectedResponse           []byte
+		expectedContentDisposition string
+	}{
+		{
+			name:                       "base case",
+			httpMethod:                 "GET",
+			admin:                      true,
+			svcResponse:                export,
+			svcError:                   nil,
+			expectedHTTPStatus:         http.StatusOK,
+			expectedResponse:           []byte(`{"exported_at":"2022-04-01T12:00:00Z","user":{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"terry@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"},"history":[{"number":1,"action":"updated","actor":"admin","time":"2022-03-01T10:00:00Z","changes":[{"field":"email","from":"ttrillow1@feedburner.com","to":"terry@feedburner.com"}]}],"audit_entries":[{"action":"data_exported","actor":"admin","time":"2022-04-01T12:00:00Z"}]}` + "\n"),
+			expectedContentDisposition: `attachment; filename="user-1311f914-1d4f-40b6-8886-80193265d5a4.json"`,
+		},
+		{
+			name:               "not admin",
+			httpMethod:         "GET",
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusForbidden,
+			expectedResponse:   []byte(`{"error":"user data export requires admin privileges"}` + "\n"),
+		},
+		{
+			name:               "service error",
+			httpMethod:         "GET",
+			admin:              true,
+			svcResponse:        lib.UserDataExport{},
+			svcError:           lib.ErrNotFound,
+			expectedHTTPStatus: http.StatusNotFound,
+			expectedResponse:   []byte(`{"error":"not found"}` + "\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("ExportUserData", mock.Anything, "1311f914-1d4f-40b6-8886-80193265d5a4").Return(tc.svcResponse, tc.svcError)
+
+			header := make(http.Header)
+			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
+			mockHTTPResponseWriter.On("Header").Return(header)
+			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
+			mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
+
+			httpRequest := &http.Request{
+				Method: tc.httpMethod,
+				URL:    &url.URL{Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4:export"},
+			}
+			if tc.admin {
+				httpRequest = httpRequest.WithContext(context.WithValue(context.Background(), adminContextKey{}, true))
+			}
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handleExportUserData(mockHTTPResponseWriter, httpRequest)
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			} else {
+				mockUsersService.AssertNotCalled(t, "ExportUserData", mock.Anything, mock.Anything)
+			}
+			mockHTTPResponseWriter.AssertExpectations(t)
+			assert.Equal(t, tc.expectedContentDisposition, header.Get("Content-Disposition"))
+		})
+	}
+}
+
+func TestHandleEraseUser(t *testing.T) {
+	erasedUser := lib.User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Erased",
+		LastName:     "User",
+		Email:        "1311f914-1d4f-40b6-8886-80193265d5a4@erased.invalid",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+
+	testCases := []struct {
+		name               string
+		httpMethod         string
+		admin              bool
+		svcNotCalled       bool
+		svcResponse        lib.User
+		svcError           error
+		expectedHTTPStatus int
+		expectedResponse   []byte
+	}{
+		{
+			name:               "base case",
+			httpMethod:         "POST",
+			admin:              true,
+			svcResponse:        erasedUser,
+			svcError:           nil,
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Erased","last_name":"User","email":"1311f914-1d4f-40b6-8886-80193265d5a4@erased.invalid","ip_address":"","creation_date":"2021-04-19T00:00:00Z"}` + "\n"),
+		},
+		{
+			name:               "not admin",
+			httpMethod:         "POST",
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusForbidden,
+			expectedResponse:   []byte(`{"error":"user erasure requires admin privileges"}` + "\n"),
+		},
+		{
+			name:               "service error",
+			httpMethod:         "POST",
+			admin:              true,
+			svcResponse:        lib.User{},
+			svcError:           lib.ErrNotFound,
+			expectedHTTPStatus: http.StatusNotFound,
+			expectedResponse:   []byte(`{"error":"not found"}` + "\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("EraseUser", mock.Anything, "1311f914-1d4f-40b6-8886-80193265d5a4").Return(tc.svcResponse, tc.svcError)
+
+			header := make(http.Header)
+			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
+			mockHTTPResponseWriter.On("Header").Return(header)
+			mockHTTPResponseWriter.On("WriteHeader", tc.expectedHTTPStatus)
+			mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
+
+			httpRequest := &http.Request{
+				Method: tc.httpMethod,
+				URL:    &url.URL{Path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4:erase"},
+			}
+			if tc.admin {
+				httpRequest = httpRequest.WithContext(context.WithValue(context.Background(), adminContextKey{}, true))
+			}
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.handleEraseUser(mockHTTPResponseWriter, httpRequest)
+
+			if tc.svcNotCalled == false {
+				mockUsersService.AssertExpectations(t)
+			} else {
+				mockUsersService.AssertNotCalled(t, "EraseUser", mock.Anything, mock.Anything)
+			}
+			mockHTTPResponseWriter.AssertExpectations(t)
+			if tc.expectedHTTPStatus == http.StatusOK {
+				assert.Equal(t, userETag(tc.svcResponse), header.Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestUsersHandlerRouting(t *testing.T) {
+	testCases := []struct {
+		name               string
+		httpMethod         string
+		urlPath            string
+		expectedHTTPStatus int
+	}{
+		{
+			name:               "create user",
+			httpMethod:         "POST",
+			urlPath:            "/v1/users",
+			expectedHTTPStatus: http.StatusCreated,
+		},
+		{
+			name:               "delete user",
+			httpMethod:         "DELETE",
+			urlPath:            "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedHTTPStatus: http.StatusNoContent,
+		},
+		{
+			name:               "authenticate route is not a user id",
+			httpMethod:         "GET",
+			urlPath:            "/v1/users/authenticate",
+			expectedHTTPStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:               "search route is not a user id",
+			httpMethod:         "GET",
+			urlPath:            "/v1/users/search?q=trillow&limit=10",
+			expectedHTTPStatus: http.StatusOK,
+		},
+		{
+			name:               "batch get route is not the users collection",
+			httpMethod:         "POST",
+			urlPath:            "/v1/users:batchGet",
+			expectedHTTPStatus: http.StatusBadRequest, // empty IDs
+		},
+		{
+			name:               "bulk route is not the users collection",
+			httpMethod:         "POST",
+			urlPath:            "/v1/users:bulk",
+			expectedHTTPStatus: http.StatusUnsupportedMediaType, // no content type
+		},
+		{
+			name:               "not allowed method for users collection",
+			httpMethod:         "DELETE",
+			urlPath:            "/v1/users",
+			expectedHTTPStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:               "not allowed method for single user",
+			httpMethod:         "POST",
+			urlPath:            "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4",
+			expectedHTTPStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:               "user history",
+			httpMethod:         "GET",
+			urlPath:            "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4/history",
+			expectedHTTPStatus: http.StatusOK,
+		},
+		{
+			name:               "not allowed method for user history",
+			httpMethod:         "DELETE",
+			urlPath:            "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4/history",
+			expectedHTTPStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:               "restore user action",
+			httpMethod:         "POST",
+			urlPath:            "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4:restore",
+			expectedHTTPStatus: http.StatusOK,
+		},
+		{
+			name:               "not allowed method for restore user action",
+			httpMethod:         "DELETE",
+			urlPath:            "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4:restore",
+			expectedHTTPStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:               "export user data action - not admin",
+			httpMethod:         "GET",
+			urlPath:            "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4:export",
+			expectedHTTPStatus: http.StatusForbidden,
+		},
+		{
+			name:               "erase user action - not admin",
+			httpMethod:         "POST",
+			urlPath:            "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4:erase",
+			expectedHTTPStatus: http.StatusForbidden,
+		},
+		{
+			name:               "not allowed method for batch get route",
+			httpMethod:         "DELETE",
+			urlPath:            "/v1/users:batchGet",
+			expectedHTTPStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:               "not allowed method for bulk route",
+			httpMethod:         "GET",
+			urlPath:            "/v1/users:bulk",
+			expectedHTTPStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:               "not allowed method for search route",
+			httpMethod:         "POST",
+			urlPath:            "/v1/users/search",
+			expectedHTTPStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:               "not allowed method for export user data action",
+			httpMethod:         "POST",
+			urlPath:            "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4:export",
+			expectedHTTPStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:               "not allowed method for erase user action",
+			httpMethod:         "DELETE",
+			urlPath:            "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4:erase",
+			expectedHTTPStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUsersService := new(mockUsersService)
+			mockUsersService.On("CreateUser", mock.Anything, mock.Anything).Return(lib.User{ID: "1311f914-1d4f-40b6-8886-80193265d5a4"}, nil)
+			mockUsersService.On("DeleteUser", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			mockUsersService.On("SearchUsers", mock.Anything, mock.Anything).Return(lib.UsersPage{}, nil)
+			mockUsersService.On("RestoreUser", mock.Anything, "1311f914-1d4f-40b6-8886-80193265d5a4").Return(lib.User{ID: "1311f914-1d4f-40b6-8886-80193265d5a4"}, nil)
+			mockUsersService.On("GetUserHistory", mock.Anything, "1311f914-1d4f-40b6-8886-80193265d5a4", false).Return([]lib.UserRevision{}, nil)
+
+			recorder := httptest.NewRecorder()
+
+			req := httptest.NewRequest(tc.httpMethod, tc.urlPath, strings.NewReader(`{}`))
+			req.Header.Set("If-Match", "*")
+
+			handler := NewUsersHandler(mockUsersService)
+			handler.ServeHTTP(recorder, req)
+
+			assert.Equal(t, tc.expectedHTTPStatus, recorder.Code)
+		})
+	}
+}
+
+func TestHandleAuthenticate(t *testing.T) {
+	testCases := []struct {
+		name               string
+		httpMethod         string
+		httpBody           string
+		svcNotCalled       bool
+		svcResponse        lib.User
+		svcError           error
+		expectedHTTPStatus int
+		expectedResponse   []byte
+	}{
+		{
+			name:       "base case",
+			httpMethod: "POST",
+			httpBody:   `{"email":"ttrillow1@feedburner.com","password":"5YLItbmdkfC1"}`,
+			svcResponse: lib.User{
+				ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+				FirstName:    "Terrence",
+				LastName:     "Trillow",
+				Email:        "ttrillow1@feedburner.com",
+				Password:     "$2a$04$/Wv9d.olqIFBGaMj3SR4O.Oq4GgM5r1urmuxGQNFdE6gcd90wqE4a",
+				IPAddress:    "63.119.6.98",
+				CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+			},
+			svcError:           nil,
+			expectedHTTPStatus: http.StatusOK,
+			expectedResponse:   []byte(`{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"}` + "\n"),
+		},
+		{
+			name:               "invalid credentials",
+			httpMethod:         "POST",
+			httpBody:           `{"email":"ttrillow1@feedburner.com","password":"5YLItbmdkfC1"}`,
+			svcResponse:        lib.User{},
+			svcError:           lib.ErrUnauthorized,
+			expectedHTTPStatus: http.StatusUnauthorized,
+			expectedResponse:   []byte(`{"error":"invalid credentials"}` + "\n"),
+		},
+		{
+			name:               "missing password",
+			httpMethod:         "POST",
+			httpBody:           `{"email":"ttrillow1@feedburner.com"}`,
+			svcNotCalled:       true,
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"'email' and 'password' are required"}` + "\n"),
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockUsersService := new(mockUsersService)
-			mockUsersService.On("GetUser", mock.Anything, tc.expectedUserID).Return(tc.svcResponse, tc.svcError)
+			mockUsersService.On("Authenticate", mock.Anything, "ttrillow1@feedburner.com", "5YLItbmdkfC1").Return(tc.svcResponse, tc.svcError)
 
 			mockHTTPResponseWriter := new(mockHTTPResponseWriter)
 			mockHTTPResponseWriter.On("Header").Return(make(http.Header))
@@ -213,7 +2166,11 @@ func TestHandleGetUser(t *testing.T) {
 			mockHTTPResponseWriter.On("Write", tc.expectedResponse).Return(len(tc.expectedResponse), nil)
 
 			handler := NewUsersHandler(mockUsersService)
-			handler.handleGetUser(mockHTTPResponseWriter, tc.httpRequest)
+			handler.handleAuthenticate(mockHTTPResponseWriter, &http.Request{
+				Method: tc.httpMethod,
+				URL:    &url.URL{Path: "/v1/users/authenticate"},
+				Body:   ioutil.NopCloser(strings.NewReader(tc.httpBody)),
+			})
 
 			if tc.svcNotCalled == false {
 				mockUsersService.AssertExpectations(t)
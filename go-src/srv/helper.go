@@ -1,12 +1,20 @@
 package srv
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
 )
 
 // getURLPathParam gets URL path parameter value based on the group (e.g. "users")
@@ -82,9 +90,620 @@ func getAndValidatePaginationParams(urlQuery url.Values, maxLimit int) (limit, o
 	return limit, offset, nil
 }
 
+// validateUserIDs validates the user IDs requested at once, there must be at least one and no more than the maximum
+func validateUserIDs(userIDs []string, maxIDs int) error {
+	if len(userIDs) == 0 {
+		return &httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "missing required user IDs",
+		}
+	}
+
+	// the number of IDs cannot be greater than the maximum
+	if len(userIDs) > maxIDs {
+		return &httpError{
+			StatusCode: http.StatusPreconditionFailed,
+			Message:    fmt.Sprintf("number of user IDs is greater than %d", maxIDs),
+		}
+	}
+
+	for _, userID := range userIDs {
+		if userID == "" {
+			return &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid empty user ID",
+			}
+		}
+	}
+
+	return nil
+}
+
+// getAndValidateFieldsParam gets and validates the "fields" parameter (sparse fieldset) from the URL querystrings,
+// a comma separated list of user response fields (nil if missing, meaning all the fields)
+func getAndValidateFieldsParam(urlQuery url.Values) ([]string, error) {
+	fieldsStr := getURLQueryParam(urlQuery, "fields")
+	if fieldsStr == "" { // optional param
+		return nil, nil
+	}
+
+	fields := strings.Split(fieldsStr, ",")
+	for i, field := range fields {
+		field = strings.TrimSpace(field)
+
+		valid := false
+		for _, responseField := range userResponseFields {
+			if field == responseField {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("invalid field '%s'", field),
+			}
+		}
+
+		fields[i] = field
+	}
+
+	return fields, nil
+}
+
+// dateFormatHeader is the compatibility header that selects the format of the response dates (same as the "date_format" querystring)
+const dateFormatHeader = "X-Date-Format"
+
+// getAndValidateDateFormat gets whether the response dates must use the legacy format (dd/mm/yyyy) instead of RFC 3339,
+// from the "date_format" querystring (optional) or, if missing, from the compatibility header
+func getAndValidateDateFormat(urlQuery url.Values, header http.Header) (bool, error) {
+	dateFormat := getURLQueryParam(urlQuery, "date_format")
+	if dateFormat == "" {
+		dateFormat = strings.TrimSpace(header.Get(dateFormatHeader))
+	}
+
+	switch strings.ToLower(dateFormat) {
+	case "", "rfc3339":
+		return false, nil
+	case "legacy":
+		return true, nil
+	default:
+		return false, &httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "invalid param 'date_format' (expected rfc3339 or legacy)",
+		}
+	}
+}
+
+// importContentTypes maps the accepted bulk import content types to the users data formats
+var importContentTypes = map[string]lib.DataFormat{
+	"application/json":     lib.FormatJSON,
+	"application/x-ndjson": lib.FormatNDJSON,
+	"text/csv":             lib.FormatCSV,
+}
+
+// getAndValidateImportFormat gets the users data format from the request content type
+func getAndValidateImportFormat(contentType string) (lib.DataFormat, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		if format, ok := importContentTypes[mediaType]; ok {
+			return format, nil
+		}
+	}
+
+	return "", &httpError{
+		StatusCode: http.StatusUnsupportedMediaType,
+		Message:    "unsupported content type (expected application/json, application/x-ndjson or text/csv)",
+	}
+}
+
+// responseFormats maps the users response format names ("format" querystring) to the users data formats
+var responseFormats = map[string]lib.DataFormat{
+	"json":   lib.FormatJSON,
+	"ndjson": lib.FormatNDJSON,
+	"csv":    lib.FormatCSV,
+}
+
+// acceptedMediaTypes maps the accepted media types (Accept header) to the users data formats
+var acceptedMediaTypes = map[string]lib.DataFormat{
+	"*/*":                  lib.FormatJSON,
+	"application/*":        lib.FormatJSON,
+	"application/json":     lib.FormatJSON,
+	"application/x-ndjson": lib.FormatNDJSON,
+	"text/csv":             lib.FormatCSV,
+}
+
+// getAndValidateResponseFormat gets the users response format from the "format" querystring (optional) or,
+// if missing, from the Accept header (the supported media type with the highest quality), defaulting to JSON
+func getAndValidateResponseFormat(urlQuery url.Values, accept string) (lib.DataFormat, error) {
+	formatName := getURLQueryParam(urlQuery, "format")
+	if formatName != "" {
+		format, ok := responseFormats[formatName]
+		if !ok {
+			return "", &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid param 'format' (expected json, ndjson or csv)",
+			}
+		}
+		return format, nil
+	}
+
+	// content negotiation, the first media type wins among the ones with the same quality
+	format, bestQuality := lib.FormatJSON, 0.0
+	for _, acceptedType := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(acceptedType)
+		if err != nil {
+			continue
+		}
+		acceptedFormat, ok := acceptedMediaTypes[mediaType]
+		if !ok {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		if quality > bestQuality {
+			format, bestQuality = acceptedFormat, quality
+		}
+	}
+
+	return format, nil
+}
+
+// exportContentTypes maps the users export formats to their response content types
+var exportContentTypes = map[lib.DataFormat]string{
+	lib.FormatNDJSON: "application/x-ndjson",
+	lib.FormatCSV:    "text/csv; charset=utf-8",
+}
+
+// exportResponseWriter tracks if the export response was started (i.e. some data was written),
+// after that the status code and headers cannot be changed anymore
+type exportResponseWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+// Write writes the data to the response, starting it
+func (w *exportResponseWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+// flush sends the users written so far to the client (if the response can be flushed)
+func (w *exportResponseWriter) flush(usersWriter *lib.UsersWriter) error {
+	err := usersWriter.Flush()
+	if err != nil {
+		return err
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// getAndValidateBoolParam gets and validates an optional boolean parameter from the URL querystrings (default false)
+func getAndValidateBoolParam(urlQuery url.Values, key string) (bool, error) {
+	valueStr := getURLQueryParam(urlQuery, key)
+	if valueStr == "" { // optional param
+		return false, nil
+	}
+
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		return false, &httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("invalid boolean param '%s'", key),
+		}
+	}
+
+	return value, nil
+}
+
+// getAndValidateTimeParam gets and validates a time parameter (RFC 3339, or yyyy-mm-dd for the start of the day in UTC)
+// from the URL querystrings, the zero time if missing
+func getAndValidateTimeParam(urlQuery url.Values, key string) (time.Time, error) {
+	timeStr := getURLQueryParam(urlQuery, key)
+	if timeStr == "" { // optional param
+		return time.Time{}, nil
+	}
+
+	value, err := time.Parse(time.RFC3339, timeStr)
+	if err != nil {
+		value, err = time.Parse(filterDateLayout, timeStr)
+	}
+	if err != nil {
+		return time.Time{}, &httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("invalid time param '%s' (expected RFC 3339 or yyyy-mm-dd)", key),
+		}
+	}
+
+	return value, nil
+}
+
+// getAndValidateIncludeDeleted gets whether the deleted users must be included from the "include_deleted" querystring (optional),
+// only the admin requests can include them
+func getAndValidateIncludeDeleted(req *http.Request) (bool, error) {
+	includeDeleted, err := getAndValidateBoolParam(req.URL.Query(), "include_deleted")
+	if err != nil {
+		return false, err
+	}
+	if includeDeleted {
+		err = validateAdmin(req, "param 'include_deleted'")
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return includeDeleted, nil
+}
+
+// validateAdmin checks that the request is an admin request (see AdminMiddleware), the operation is described in the error
+func validateAdmin(req *http.Request, operation string) error {
+	if !isAdmin(req) {
+		return &httpError{
+			StatusCode: http.StatusForbidden,
+			Message:    fmt.Sprintf("%s requires admin privileges", operation),
+		}
+	}
+	return nil
+}
+
+// pageLinks represents the navigation URLs of an offset pagination page, empty if there is no such page
+type pageLinks struct {
+	First string
+	Prev  string
+	Next  string
+	Last  string
+}
+
+// newPageLinks creates the navigation URLs of the page, keeping the request URL querystrings but the offset
+func newPageLinks(reqURL *url.URL, limit, offset, total int) pageLinks {
+	// a page without users cannot move forward nor backward
+	if limit == 0 {
+		return pageLinks{}
+	}
+
+	pageURL := func(offset int) string {
+		urlQuery := reqURL.Query()
+		urlQuery.Set("offset", strconv.Itoa(offset))
+		return reqURL.Path + "?" + urlQuery.Encode()
+	}
+
+	lastOffset := 0
+	if total > 0 {
+		lastOffset = (total - 1) / limit * limit
+	}
+
+	links := pageLinks{
+		First: pageURL(0),
+		Last:  pageURL(lastOffset),
+	}
+	if offset > 0 {
+		prevOffset := offset - limit
+		if prevOffset < 0 {
+			prevOffset = 0
+		}
+		links.Prev = pageURL(prevOffset)
+	}
+	if offset+limit < total {
+		links.Next = pageURL(offset + limit)
+	}
+
+	return links
+}
+
+// setPaginationHeaders sets the total count and the navigation links (RFC 8288) headers of the response
+func setPaginationHeaders(w http.ResponseWriter, total int, links pageLinks) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	var linkValues []string
+	for _, link := range []struct{ rel, url string }{
+		{"first", links.First},
+		{"prev", links.Prev},
+		{"next", links.Next},
+		{"last", links.Last},
+	} {
+		if link.url != "" {
+			linkValues = append(linkValues, fmt.Sprintf(`<%s>; rel="%s"`, link.url, link.rel))
+		}
+	}
+	if len(linkValues) > 0 {
+		w.Header().Set("Link", strings.Join(linkValues, ", "))
+	}
+}
+
+const (
+	// filterDateLayout is the layout of the date filters (yyyy-mm-dd)
+	filterDateLayout = "2006-01-02"
+	// prefixWildcard is the suffix that turns a string filter into a prefix match (e.g. "Ter*")
+	prefixWildcard = "*"
+)
+
+// getAndValidateUsersFilter gets and validates the users filters from the URL querystrings (all optional):
+// - first_name and last_name: exact match, or prefix match if ending with "*" (case-insensitive)
+// - email_domain: exact match of the email domain (case-insensitive)
+// - ip_cidr: IP address range in CIDR notation (e.g. "10.0.0.0/8")
+// - created_after and created_before: exclusive creation date bounds (yyyy-mm-dd or RFC 3339)
+func getAndValidateUsersFilter(urlQuery url.Values) (lib.UsersFilter, error) {
+	var filter lib.UsersFilter
+
+	filter.FirstName = getStringFilter(urlQuery, "first_name")
+	filter.LastName = getStringFilter(urlQuery, "last_name")
+	filter.EmailDomain = strings.TrimPrefix(getURLQueryParam(urlQuery, "email_domain"), "@")
+
+	ipCIDR := getURLQueryParam(urlQuery, "ip_cidr")
+	if ipCIDR != "" {
+		_, ipNet, err := net.ParseCIDR(ipCIDR)
+		if err != nil {
+			return lib.UsersFilter{}, &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid CIDR param 'ip_cidr'",
+			}
+		}
+		filter.IPNet = ipNet
+	}
+
+	for _, dateParam := range []struct {
+		key   string
+		value *time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+	} {
+		dateStr := getURLQueryParam(urlQuery, dateParam.key)
+		if dateStr == "" {
+			continue
+		}
+		date, err := time.Parse(filterDateLayout, dateStr)
+		if err != nil {
+			date, err = time.Parse(time.RFC3339, dateStr)
+		}
+		if err != nil {
+			return lib.UsersFilter{}, &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("invalid date param '%s' (expected yyyy-mm-dd or RFC 3339)", dateParam.key),
+			}
+		}
+		*dateParam.value = date
+	}
+
+	return filter, nil
+}
+
+// getAndValidateSortParam gets and validates the sorting parameter from the URL querystring (optional),
+// a comma separated list of user fields in order of priority, prefixed with "-" for descending order (e.g. "last_name,-creation_date")
+func getAndValidateSortParam(urlQuery url.Values) ([]lib.SortField, error) {
+	sortStr := getURLQueryParam(urlQuery, "sort")
+	if sortStr == "" { // optional param
+		return nil, nil
+	}
+
+	var sortFields []lib.SortField
+	seenFields := make(map[string]bool)
+
+	for _, field := range strings.Split(sortStr, ",") {
+		sortField := lib.SortField{
+			Field:      strings.TrimPrefix(strings.TrimSpace(field), "-"),
+			Descending: strings.HasPrefix(strings.TrimSpace(field), "-"),
+		}
+
+		if !lib.IsSortableUserField(sortField.Field) {
+			return nil, &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("invalid sort field '%s'", sortField.Field),
+			}
+		}
+		if seenFields[sortField.Field] {
+			return nil, &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("duplicated sort field '%s'", sortField.Field),
+			}
+		}
+		seenFields[sortField.Field] = true
+
+		sortFields = append(sortFields, sortField)
+	}
+
+	return sortFields, nil
+}
+
+// getAndValidateCursorParam gets and validates the cursor parameter from the URL querystring (optional),
+// its presence enables the cursor pagination, starting from the beginning if it's empty
+// (it cannot be combined with the "offset" parameter and must have been created for the same sorting)
+func getAndValidateCursorParam(urlQuery url.Values, sort []lib.SortField) (*lib.UsersCursor, error) {
+	if _, ok := urlQuery["cursor"]; !ok { // optional param
+		return nil, nil
+	}
+
+	if getURLQueryParam(urlQuery, "offset") != "" {
+		return nil, &httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "'cursor' and 'offset' cannot be used together",
+		}
+	}
+
+	token := getURLQueryParam(urlQuery, "cursor")
+	if token == "" {
+		return &lib.UsersCursor{Sort: sort}, nil
+	}
+
+	cursor, err := lib.DecodeUsersCursor(token, sort)
+	if err != nil {
+		return nil, &httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("invalid param 'cursor': %s", err.Error()),
+		}
+	}
+
+	return &cursor, nil
+}
+
+// getStringFilter gets the string filter from the URL querystring, a trailing "*" means prefix match
+func getStringFilter(urlQuery url.Values, key string) *lib.StringFilter {
+	value := getURLQueryParam(urlQuery, key)
+	if value == "" {
+		return nil
+	}
+
+	if strings.HasSuffix(value, prefixWildcard) {
+		return &lib.StringFilter{
+			Value:  strings.TrimSuffix(value, prefixWildcard),
+			Prefix: true,
+		}
+	}
+
+	return &lib.StringFilter{
+		Value: value,
+	}
+}
+
+const (
+	// maxJSONBodySize sets the maximum size (bytes) of the JSON request bodies
+	maxJSONBodySize = 1 << 20
+	// bodyTooLargeMessage is the error message of the http.MaxBytesReader reads over the limit
+	bodyTooLargeMessage = "http: request body too large"
+)
+
+// readJSON decodes the JSON request body (at most maxJSONBodySize bytes) into the value received as parameter
+func readJSON(w http.ResponseWriter, req *http.Request, v interface{}) error {
+	if req.Body == nil {
+		return &httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "missing request body",
+		}
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxJSONBodySize))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil && err.Error() == bodyTooLargeMessage {
+		return &httpError{
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Message:    fmt.Sprintf("request body is larger than %d bytes", maxJSONBodySize),
+		}
+	}
+	if err != nil {
+		return &httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("invalid JSON body: %s", err.Error()),
+		}
+	}
+
+	return nil
+}
+
 // writeJSON writes the correct header, status code and JSON format to the response
 func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(v)
 }
+
+// writeJSONWithETag writes the JSON response (200 status code) with its entity tag (the content hash if empty),
+// or only the 304 status code (not modified) if the client of a GET request already has it (If-None-Match)
+func writeJSONWithETag(w http.ResponseWriter, req *http.Request, etag string, v interface{}) {
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(v)
+
+	if etag == "" {
+		sum := sha1.Sum(body.Bytes())
+		etag = `"` + hex.EncodeToString(sum[:]) + `"`
+	}
+	w.Header().Set("ETag", etag)
+
+	if req.Method == http.MethodGet && etagsMatch(req.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
+
+// userETagSeparator separates the user version from the representation suffix in the user entity tags
+const userETagSeparator = "-"
+
+// userETag gets the entity tag of the user (its version), of its full representation (all the fields, RFC 3339 dates)
+func userETag(user lib.User) string {
+	return `"` + lib.UserVersion(user) + `"`
+}
+
+// userRepresentationETag gets the entity tag of the user representation: the user entity tag for the full representation,
+// or the user version with a suffix of the selected fields and dates format, so each representation has its own entity tag
+// (still accepted by the user writes, see getAndValidateIfMatch)
+func userRepresentationETag(user lib.User, fields []string, legacyDates bool) string {
+	if len(fields) == 0 && !legacyDates {
+		return userETag(user)
+	}
+
+	representation := "fields=" + strings.Join(fields, ",")
+	if legacyDates {
+		representation += ";date_format=legacy"
+	}
+	sum := sha1.Sum([]byte(representation))
+
+	return `"` + lib.UserVersion(user) + userETagSeparator + hex.EncodeToString(sum[:4]) + `"`
+}
+
+// etagsMatch checks if the conditional header value (list of entity tags or "*") matches the entity tag,
+// the weak entity tags (W/ prefix) match as well (weak comparison)
+func etagsMatch(header string, etag string) bool {
+	for _, headerETag := range strings.Split(header, ",") {
+		headerETag = strings.TrimSpace(headerETag)
+		if headerETag == "*" || strings.TrimPrefix(headerETag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// getAndValidateIfMatch gets the expected user versions from the If-Match header (list of entity tags or "*"),
+// required by the writes of a user (optimistic concurrency), nil if any version is expected ("*")
+func getAndValidateIfMatch(header http.Header) ([]string, error) {
+	ifMatch := strings.TrimSpace(header.Get("If-Match"))
+	if ifMatch == "" {
+		return nil, &httpError{
+			StatusCode: http.StatusPreconditionRequired,
+			Message:    "missing required header 'If-Match'",
+		}
+	}
+
+	var versions []string
+	for _, etag := range strings.Split(ifMatch, ",") {
+		etag = strings.TrimSpace(etag)
+		if etag == "*" {
+			return nil, nil
+		}
+
+		// the weak entity tags never match (strong comparison)
+		if strings.HasPrefix(etag, "W/") {
+			continue
+		}
+
+		if len(etag) < 2 || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+			return nil, &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid header 'If-Match' (expected quoted entity tags or \"*\")",
+			}
+		}
+		// the entity tags of the user representations hold the user version too
+		version := strings.SplitN(strings.Trim(etag, `"`), userETagSeparator, 2)[0]
+		versions = append(versions, version)
+	}
+
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("only weak entity tags in 'If-Match': %w", lib.ErrPreconditionFailed)
+	}
+
+	return versions, nil
+}
@@ -1,11 +1,18 @@
 package srv
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hbernardo/users/go-src/lib"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -228,3 +235,911 @@ func TestGetAndValidatePaginationParams(t *testing.T) {
 		})
 	}
 }
+
+func TestReadJSON(t *testing.T) {
+	type body struct {
+		Name string `json:"name"`
+	}
+
+	testCases := []struct {
+		name          string
+		httpRequest   *http.Request
+		expectedValue body
+		expectedError error
+	}{
+		{
+			name: "base case",
+			httpRequest: &http.Request{
+				Body: ioutil.NopCloser(strings.NewReader(`{"name":"test"}`)),
+			},
+			expectedValue: body{Name: "test"},
+			expectedError: nil,
+		},
+		{
+			name:          "error - missing body",
+			httpRequest:   &http.Request{},
+			expectedValue: body{},
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "missing request body",
+			},
+		},
+		{
+			name: "error - invalid JSON",
+			httpRequest: &http.Request{
+				Body: ioutil.NopCloser(strings.NewReader(`{"name":`)),
+			},
+			expectedValue: body{},
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid JSON body: unexpected EOF",
+			},
+		},
+		{
+			name: "error - unknown field",
+			httpRequest: &http.Request{
+				Body: ioutil.NopCloser(strings.NewReader(`{"age":10}`)),
+			},
+			expectedValue: body{},
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    `invalid JSON body: json: unknown field "age"`,
+			},
+		},
+		{
+			name: "error - body too large",
+			httpRequest: &http.Request{
+				Body: ioutil.NopCloser(strings.NewReader(`{"name":"` + strings.Repeat("a", maxJSONBodySize) + `"}`)),
+			},
+			expectedValue: body{},
+			expectedError: &httpError{
+				StatusCode: http.StatusRequestEntityTooLarge,
+				Message:    "request body is larger than 1048576 bytes",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var value body
+			err := readJSON(httptest.NewRecorder(), tc.httpRequest, &value)
+
+			assert.Equal(t, tc.expectedValue, value)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestGetAndValidateUsersFilter(t *testing.T) {
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/8")
+
+	testCases := []struct {
+		name           string
+		urlValues      url.Values
+		expectedFilter lib.UsersFilter
+		expectedError  error
+	}{
+		{
+			name:           "no filters",
+			urlValues:      url.Values{"limit": []string{"10"}},
+			expectedFilter: lib.UsersFilter{},
+			expectedError:  nil,
+		},
+		{
+			name: "all filters",
+			urlValues: url.Values{
+				"first_name":     []string{"Terrence"},
+				"last_name":      []string{"Tri*"},
+				"email_domain":   []string{"@feedburner.com"},
+				"ip_cidr":        []string{"10.0.0.0/8"},
+				"created_after":  []string{"2021-01-01"},
+				"created_before": []string{"2021-12-31"},
+			},
+			expectedFilter: lib.UsersFilter{
+				FirstName:     &lib.StringFilter{Value: "Terrence"},
+				LastName:      &lib.StringFilter{Value: "Tri", Prefix: true},
+				EmailDomain:   "feedburner.com",
+				IPNet:         ipNet,
+				CreatedAfter:  time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+				CreatedBefore: time.Date(2021, time.December, 31, 0, 0, 0, 0, time.UTC),
+			},
+			expectedError: nil,
+		},
+		{
+			name: "RFC 3339 dates",
+			urlValues: url.Values{
+				"created_after": []string{"2021-01-01T12:30:00Z"},
+			},
+			expectedFilter: lib.UsersFilter{
+				CreatedAfter: time.Date(2021, time.January, 1, 12, 30, 0, 0, time.UTC),
+			},
+			expectedError: nil,
+		},
+		{
+			name:           "error - invalid CIDR",
+			urlValues:      url.Values{"ip_cidr": []string{"10.0.0.1"}},
+			expectedFilter: lib.UsersFilter{},
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid CIDR param 'ip_cidr'",
+			},
+		},
+		{
+			name:           "error - invalid date",
+			urlValues:      url.Values{"created_before": []string{"31/12/2021"}},
+			expectedFilter: lib.UsersFilter{},
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid date param 'created_before' (expected yyyy-mm-dd or RFC 3339)",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := getAndValidateUsersFilter(tc.urlValues)
+
+			assert.Equal(t, tc.expectedFilter, filter)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestGetAndValidateSortParam(t *testing.T) {
+	testCases := []struct {
+		name          string
+		urlValues     url.Values
+		expectedSort  []lib.SortField
+		expectedError error
+	}{
+		{
+			name:          "no sorting",
+			urlValues:     url.Values{},
+			expectedSort:  nil,
+			expectedError: nil,
+		},
+		{
+			name:      "multiple fields",
+			urlValues: url.Values{"sort": []string{"last_name, -creation_date"}},
+			expectedSort: []lib.SortField{
+				{Field: "last_name"},
+				{Field: "creation_date", Descending: true},
+			},
+			expectedError: nil,
+		},
+		{
+			name:         "error - unknown field",
+			urlValues:    url.Values{"sort": []string{"last_name,-password"}},
+			expectedSort: nil,
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid sort field 'password'",
+			},
+		},
+		{
+			name:         "error - empty field",
+			urlValues:    url.Values{"sort": []string{"last_name,"}},
+			expectedSort: nil,
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid sort field ''",
+			},
+		},
+		{
+			name:         "error - duplicated field",
+			urlValues:    url.Values{"sort": []string{"email,-email"}},
+			expectedSort: nil,
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "duplicated sort field 'email'",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sort, err := getAndValidateSortParam(tc.urlValues)
+
+			assert.Equal(t, tc.expectedSort, sort)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestGetAndValidateCursorParam(t *testing.T) {
+	sort := []lib.SortField{{Field: "last_name"}}
+	cursor := lib.NewUsersCursor(lib.User{ID: "1", LastName: "Blasio"}, sort)
+
+	testCases := []struct {
+		name           string
+		urlValues      url.Values
+		expectedCursor *lib.UsersCursor
+		expectedError  error
+	}{
+		{
+			name:           "no cursor - offset pagination",
+			urlValues:      url.Values{},
+			expectedCursor: nil,
+			expectedError:  nil,
+		},
+		{
+			name:           "empty cursor - first page",
+			urlValues:      url.Values{"cursor": []string{""}},
+			expectedCursor: &lib.UsersCursor{Sort: sort},
+			expectedError:  nil,
+		},
+		{
+			name:           "cursor",
+			urlValues:      url.Values{"cursor": []string{cursor.Encode()}},
+			expectedCursor: &cursor,
+			expectedError:  nil,
+		},
+		{
+			name:           "error - with offset",
+			urlValues:      url.Values{"cursor": []string{""}, "offset": []string{"10"}},
+			expectedCursor: nil,
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "'cursor' and 'offset' cannot be used together",
+			},
+		},
+		{
+			name:           "error - invalid cursor",
+			urlValues:      url.Values{"cursor": []string{"invalid"}},
+			expectedCursor: nil,
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid param 'cursor': invalid cursor",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cursor, err := getAndValidateCursorParam(tc.urlValues, sort)
+
+			assert.Equal(t, tc.expectedCursor, cursor)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestGetAndValidateBoolParam(t *testing.T) {
+	testCases := []struct {
+		name          string
+		urlValues     url.Values
+		expectedValue bool
+		expectedError error
+	}{
+		{
+			name:          "missing - default false",
+			urlValues:     url.Values{},
+			expectedValue: false,
+			expectedError: nil,
+		},
+		{
+			name:          "true",
+			urlValues:     url.Values{"envelope": []string{"true"}},
+			expectedValue: true,
+			expectedError: nil,
+		},
+		{
+			name:          "false",
+			urlValues:     url.Values{"envelope": []string{"0"}},
+			expectedValue: false,
+			expectedError: nil,
+		},
+		{
+			name:          "error - invalid boolean",
+			urlValues:     url.Values{"envelope": []string{"yes"}},
+			expectedValue: false,
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid boolean param 'envelope'",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, err := getAndValidateBoolParam(tc.urlValues, "envelope")
+
+			assert.Equal(t, tc.expectedValue, value)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestGetAndValidateTimeParam(t *testing.T) {
+	testCases := []struct {
+		name          string
+		urlValues     url.Values
+		expectedValue time.Time
+		expectedError error
+	}{
+		{
+			name:          "missing - zero time",
+			urlValues:     url.Values{},
+			expectedValue: time.Time{},
+			expectedError: nil,
+		},
+		{
+			name:          "RFC 3339",
+			urlValues:     url.Values{"as_of": []string{"2022-03-01T10:00:00Z"}},
+			expectedValue: time.Date(2022, time.March, 1, 10, 0, 0, 0, time.UTC),
+			expectedError: nil,
+		},
+		{
+			name:          "date - start of the day",
+			urlValues:     url.Values{"as_of": []string{"2022-03-01"}},
+			expectedValue: time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC),
+			expectedError: nil,
+		},
+		{
+			name:          "error - invalid time",
+			urlValues:     url.Values{"as_of": []string{"01/03/2022"}},
+			expectedValue: time.Time{},
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid time param 'as_of' (expected RFC 3339 or yyyy-mm-dd)",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, err := getAndValidateTimeParam(tc.urlValues, "as_of")
+
+			assert.Equal(t, tc.expectedValue, value)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestGetAndValidateIncludeDeleted(t *testing.T) {
+	testCases := []struct {
+		name          string
+		rawQuery      string
+		admin         bool
+		expectedValue bool
+		expectedError error
+	}{
+		{
+			name:          "missing - default false",
+			rawQuery:      "",
+			expectedValue: false,
+			expectedError: nil,
+		},
+		{
+			name:          "included - admin",
+			rawQuery:      "include_deleted=true",
+			admin:         true,
+			expectedValue: true,
+			expectedError: nil,
+		},
+		{
+			name:          "not included - no admin needed",
+			rawQuery:      "include_deleted=false",
+			expectedValue: false,
+			expectedError: nil,
+		},
+		{
+			name:          "error - included without admin",
+			rawQuery:      "include_deleted=true",
+			expectedValue: false,
+			expectedError: &httpError{
+				StatusCode: http.StatusForbidden,
+				Message:    "param 'include_deleted' requires admin privileges",
+			},
+		},
+		{
+			name:          "error - invalid boolean",
+			rawQuery:      "include_deleted=all",
+			admin:         true,
+			expectedValue: false,
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid boolean param 'include_deleted'",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/users?"+tc.rawQuery, nil)
+			if tc.admin {
+				req = req.WithContext(context.WithValue(req.Context(), adminContextKey{}, true))
+			}
+
+			value, err := getAndValidateIncludeDeleted(req)
+
+			assert.Equal(t, tc.expectedValue, value)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestValidateAdmin(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4:erase", nil)
+	assert.Equal(t, &httpError{
+		StatusCode: http.StatusForbidden,
+		Message:    "user erasure requires admin privileges",
+	}, validateAdmin(req, "user erasure"))
+
+	req = req.WithContext(context.WithValue(req.Context(), adminContextKey{}, true))
+	assert.NoError(t, validateAdmin(req, "user erasure"))
+}
+
+func TestNewPageLinks(t *testing.T) {
+	reqURL := &url.URL{Path: "/v1/users", RawQuery: "limit=10&offset=15"}
+
+	testCases := []struct {
+		name          string
+		limit         int
+		offset        int
+		total         int
+		expectedLinks pageLinks
+	}{
+		{
+			name:   "middle page",
+			limit:  10,
+			offset: 15,
+			total:  42,
+			expectedLinks: pageLinks{
+				First: "/v1/users?limit=10&offset=0",
+				Prev:  "/v1/users?limit=10&offset=5",
+				Next:  "/v1/users?limit=10&offset=25",
+				Last:  "/v1/users?limit=10&offset=40",
+			},
+		},
+		{
+			name:   "first page",
+			limit:  10,
+			offset: 0,
+			total:  42,
+			expectedLinks: pageLinks{
+				First: "/v1/users?limit=10&offset=0",
+				Next:  "/v1/users?limit=10&offset=10",
+				Last:  "/v1/users?limit=10&offset=40",
+			},
+		},
+		{
+			name:   "last page - prev offset not negative",
+			limit:  10,
+			offset: 5,
+			total:  15,
+			expectedLinks: pageLinks{
+				First: "/v1/users?limit=10&offset=0",
+				Prev:  "/v1/users?limit=10&offset=0",
+				Last:  "/v1/users?limit=10&offset=10",
+			},
+		},
+		{
+			name:   "no users",
+			limit:  10,
+			offset: 0,
+			total:  0,
+			expectedLinks: pageLinks{
+				First: "/v1/users?limit=10&offset=0",
+				Last:  "/v1/users?limit=10&offset=0",
+			},
+		},
+		{
+			name:          "zero limit - no links",
+			limit:         0,
+			offset:        0,
+			total:         42,
+			expectedLinks: pageLinks{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedLinks, newPageLinks(reqURL, tc.limit, tc.offset, tc.total))
+		})
+	}
+}
+
+func TestGetAndValidateFieldsParam(t *testing.T) {
+	testCases := []struct {
+		name           string
+		urlValues      url.Values
+		expectedFields []string
+		expectedError  error
+	}{
+		{
+			name:           "no fields - all fields",
+			urlValues:      url.Values{},
+			expectedFields: nil,
+			expectedError:  nil,
+		},
+		{
+			name:           "multiple fields",
+			urlValues:      url.Values{"fields": []string{"id, email,first_name"}},
+			expectedFields: []string{"id", "email", "first_name"},
+			expectedError:  nil,
+		},
+		{
+			name:           "error - password is not a response field",
+			urlValues:      url.Values{"fields": []string{"id,password"}},
+			expectedFields: nil,
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid field 'password'",
+			},
+		},
+		{
+			name:           "error - empty field",
+			urlValues:      url.Values{"fields": []string{"id,"}},
+			expectedFields: nil,
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid field ''",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fields, err := getAndValidateFieldsParam(tc.urlValues)
+
+			assert.Equal(t, tc.expectedFields, fields)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestGetAndValidateDateFormat(t *testing.T) {
+	testCases := []struct {
+		name           string
+		urlValues      url.Values
+		header         http.Header
+		expectedLegacy bool
+		expectedError  error
+	}{
+		{
+			name:           "no date format - RFC 3339",
+			urlValues:      url.Values{},
+			header:         http.Header{},
+			expectedLegacy: false,
+			expectedError:  nil,
+		},
+		{
+			name:           "legacy - param",
+			urlValues:      url.Values{"date_format": []string{"legacy"}},
+			header:         http.Header{},
+			expectedLegacy: true,
+			expectedError:  nil,
+		},
+		{
+			name:           "legacy - compatibility header",
+			urlValues:      url.Values{},
+			header:         http.Header{"X-Date-Format": []string{"Legacy"}},
+			expectedLegacy: true,
+			expectedError:  nil,
+		},
+		{
+			name:           "param has priority over the header",
+			urlValues:      url.Values{"date_format": []string{"rfc3339"}},
+			header:         http.Header{"X-Date-Format": []string{"legacy"}},
+			expectedLegacy: false,
+			expectedError:  nil,
+		},
+		{
+			name:           "error - unknown date format",
+			urlValues:      url.Values{},
+			header:         http.Header{"X-Date-Format": []string{"mm/dd/yyyy"}},
+			expectedLegacy: false,
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid param 'date_format' (expected rfc3339 or legacy)",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			legacy, err := getAndValidateDateFormat(tc.urlValues, tc.header)
+
+			assert.Equal(t, tc.expectedLegacy, legacy)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestValidateUserIDs(t *testing.T) {
+	testCases := []struct {
+		name          string
+		userIDs       []string
+		expectedError error
+	}{
+		{
+			name:          "valid",
+			userIDs:       []string{"1", "2", "3"},
+			expectedError: nil,
+		},
+		{
+			name:    "error - no IDs",
+			userIDs: nil,
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "missing required user IDs",
+			},
+		},
+		{
+			name:    "error - greater than the maximum",
+			userIDs: []string{"1", "2", "3", "4"},
+			expectedError: &httpError{
+				StatusCode: http.StatusPreconditionFailed,
+				Message:    "number of user IDs is greater than 3",
+			},
+		},
+		{
+			name:    "error - empty ID",
+			userIDs: []string{"1", ""},
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid empty user ID",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedError, validateUserIDs(tc.userIDs, 3))
+		})
+	}
+}
+
+func TestGetAndValidateImportFormat(t *testing.T) {
+	testCases := []struct {
+		name           string
+		contentType    string
+		expectedFormat lib.DataFormat
+		expectedError  error
+	}{
+		{
+			name:           "JSON",
+			contentType:    "application/json",
+			expectedFormat: lib.FormatJSON,
+			expectedError:  nil,
+		},
+		{
+			name:           "NDJSON",
+			contentType:    "application/x-ndjson",
+			expectedFormat: lib.FormatNDJSON,
+			expectedError:  nil,
+		},
+		{
+			name:           "CSV with parameters",
+			contentType:    "text/csv; charset=utf-8; header=present",
+			expectedFormat: lib.FormatCSV,
+			expectedError:  nil,
+		},
+		{
+			name:           "error - missing",
+			contentType:    "",
+			expectedFormat: "",
+			expectedError: &httpError{
+				StatusCode: http.StatusUnsupportedMediaType,
+				Message:    "unsupported content type (expected application/json, application/x-ndjson or text/csv)",
+			},
+		},
+		{
+			name:           "error - unsupported",
+			contentType:    "text/plain",
+			expectedFormat: "",
+			expectedError: &httpError{
+				StatusCode: http.StatusUnsupportedMediaType,
+				Message:    "unsupported content type (expected application/json, application/x-ndjson or text/csv)",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			format, err := getAndValidateImportFormat(tc.contentType)
+
+			assert.Equal(t, tc.expectedFormat, format)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestGetAndValidateResponseFormat(t *testing.T) {
+	testCases := []struct {
+		name           string
+		rawQuery       string
+		accept         string
+		expectedFormat lib.DataFormat
+		expectedError  error
+	}{
+		{
+			name:           "default",
+			rawQuery:       "",
+			accept:         "",
+			expectedFormat: lib.FormatJSON,
+			expectedError:  nil,
+		},
+		{
+			name:           "format param wins over the Accept header",
+			rawQuery:       "format=csv",
+			accept:         "application/x-ndjson",
+			expectedFormat: lib.FormatCSV,
+			expectedError:  nil,
+		},
+		{
+			name:           "Accept header",
+			rawQuery:       "",
+			accept:         "text/csv",
+			expectedFormat: lib.FormatCSV,
+			expectedError:  nil,
+		},
+		{
+			name:           "Accept header - highest quality",
+			rawQuery:       "",
+			accept:         "text/csv;q=0.5, application/x-ndjson;q=0.8, application/json;q=0.2",
+			expectedFormat: lib.FormatNDJSON,
+			expectedError:  nil,
+		},
+		{
+			name:           "Accept header - first one among the same quality",
+			rawQuery:       "",
+			accept:         "text/html, */*, text/csv",
+			expectedFormat: lib.FormatJSON,
+			expectedError:  nil,
+		},
+		{
+			name:           "Accept header - unsupported and not acceptable types are ignored",
+			rawQuery:       "",
+			accept:         "text/html, text/csv;q=0, application/x-ndjson;q=invalid",
+			expectedFormat: lib.FormatJSON,
+			expectedError:  nil,
+		},
+		{
+			name:           "error - invalid format param",
+			rawQuery:       "format=xml",
+			accept:         "",
+			expectedFormat: "",
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid param 'format' (expected json, ndjson or csv)",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			urlQuery, _ := url.ParseQuery(tc.rawQuery)
+			format, err := getAndValidateResponseFormat(urlQuery, tc.accept)
+
+			assert.Equal(t, tc.expectedFormat, format)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestWriteJSONWithETag(t *testing.T) {
+	testCases := []struct {
+		name               string
+		httpMethod         string
+		etag               string
+		ifNoneMatch        string
+		expectedHTTPStatus int
+		expectedETag       string
+		expectedBody       string
+	}{
+		{
+			name:               "content hash entity tag",
+			httpMethod:         "GET",
+			etag:               "",
+			expectedHTTPStatus: http.StatusOK,
+			expectedETag:       `"1ddad51b371a84fe30af8b7c23e775865b23a2f0"`,
+			expectedBody:       `{"id":"1"}` + "\n",
+		},
+		{
+			name:               "given entity tag",
+			httpMethod:         "GET",
+			etag:               `"v1"`,
+			ifNoneMatch:        `"v0"`,
+			expectedHTTPStatus: http.StatusOK,
+			expectedETag:       `"v1"`,
+			expectedBody:       `{"id":"1"}` + "\n",
+		},
+		{
+			name:               "not modified",
+			httpMethod:         "GET",
+			etag:               `"v1"`,
+			ifNoneMatch:        `"v0", W/"v1"`,
+			expectedHTTPStatus: http.StatusNotModified,
+			expectedETag:       `"v1"`,
+			expectedBody:       "",
+		},
+		{
+			name:               "If-None-Match ignored - not a GET request",
+			httpMethod:         "POST",
+			etag:               `"v1"`,
+			ifNoneMatch:        "*",
+			expectedHTTPStatus: http.StatusOK,
+			expectedETag:       `"v1"`,
+			expectedBody:       `{"id":"1"}` + "\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.httpMethod, "/v1/users", nil)
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+			recorder := httptest.NewRecorder()
+
+			writeJSONWithETag(recorder, req, tc.etag, map[string]string{"id": "1"})
+
+			assert.Equal(t, tc.expectedHTTPStatus, recorder.Code)
+			assert.Equal(t, tc.expectedETag, recorder.Header().Get("ETag"))
+			assert.Equal(t, tc.expectedBody, recorder.Body.String())
+		})
+	}
+}
+
+func TestGetAndValidateIfMatch(t *testing.T) {
+	testCases := []struct {
+		name             string
+		ifMatch          string
+		expectedVersions []string
+		expectedError    error
+	}{
+		{
+			name:             "entity tags list",
+			ifMatch:          `"v1", W/"v2" ,"v3"`,
+			expectedVersions: []string{"v1", "v3"},
+			expectedError:    nil,
+		},
+		{
+			name:             "user representation entity tag",
+			ifMatch:          `"v1-0a1b2c3d"`,
+			expectedVersions: []string{"v1"},
+			expectedError:    nil,
+		},
+		{
+			name:             "any version",
+			ifMatch:          `"v1", *`,
+			expectedVersions: nil,
+			expectedError:    nil,
+		},
+		{
+			name:             "error - missing",
+			ifMatch:          "",
+			expectedVersions: nil,
+			expectedError: &httpError{
+				StatusCode: http.StatusPreconditionRequired,
+				Message:    "missing required header 'If-Match'",
+			},
+		},
+		{
+			name:             "error - unquoted entity tag",
+			ifMatch:          `"v1", v2`,
+			expectedVersions: nil,
+			expectedError: &httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    `invalid header 'If-Match' (expected quoted entity tags or "*")`,
+			},
+		},
+		{
+			name:             "error - only weak entity tags",
+			ifMatch:          `W/"v1"`,
+			expectedVersions: nil,
+			expectedError:    fmt.Errorf("only weak entity tags in 'If-Match': %w", lib.ErrPreconditionFailed),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			versions, err := getAndValidateIfMatch(http.Header{"If-Match": []string{tc.ifMatch}})
+
+			assert.Equal(t, tc.expectedVersions, versions)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
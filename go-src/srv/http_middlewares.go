@@ -1,13 +1,21 @@
 package srv
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/hbernardo/users/go-src/lib"
 	"golang.org/x/time/rate"
 )
 
@@ -41,23 +49,6 @@ func PanicRecoveryMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// ETagMiddleware adds ETag header for proper client caching based on a version received as parameter (e.g. the users data version)
-// and returns 304 status code (not modified) if client requests the same version
-func ETagMiddleware(version string) func(next http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("ETag", version)
-
-			if r.Header.Get("If-None-Match") == version {
-				w.WriteHeader(http.StatusNotModified)
-				return
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
 // CORSMiddleware sets proper CORS headers and handles the preflight OPTIONS request
 func CORSMiddleware(allowOrigin string, allowMethods []string, allowHeaders []string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -65,6 +56,8 @@ func CORSMiddleware(allowOrigin string, allowMethods []string, allowHeaders []st
 			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
 			w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowMethods, ","))
 			w.Header().Set("Access-Control-Allow-Headers", strings.Join(allowHeaders, ","))
+			// response headers that browsers hide from cross-origin clients unless exposed
+			w.Header().Set("Access-Control-Expose-Headers", "ETag,Idempotent-Replayed,Link,X-Total-Count")
 
 			// just returns if it's a prefligh request
 			if r.Method == http.MethodOptions {
@@ -76,23 +69,197 @@ func CORSMiddleware(allowOrigin string, allowMethods []string, allowHeaders []st
 	}
 }
 
+// rateLimiters are the rate limiters of the clients (by key, e.g. IP address)
+type rateLimiters struct {
+	maxFrequency int
+	burstSize    int
+
+	mutex    sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// newRateLimiters creates the rate limiters of the clients (maxFrequency requests per second, with bursts of burstSize),
+// forgetting them every memoryDuration to release the memory
+func newRateLimiters(maxFrequency int, burstSize int, memoryDuration time.Duration) *rateLimiters {
+	// Using rate limiter from package "golang.org/x/time/rate"
+	l := &rateLimiters{
+		maxFrequency: maxFrequency,
+		burstSize:    burstSize,
+		limiters:     make(map[string]*rate.Limiter),
+	}
+
+	// Cleaning the map from time to time to release the memory
+	go func() {
+		for {
+			time.Sleep(memoryDuration)
+			l.mutex.Lock()
+			for key := range l.limiters {
+				delete(l.limiters, key)
+			}
+			l.mutex.Unlock()
+		}
+	}()
+
+	return l
+}
+
+// allow checks if the client (by key) can make a request now
+func (l *rateLimiters) allow(key string) bool {
+	// Creating rate limiter for the client (if not created yet)
+	l.mutex.Lock()
+	limiter, alreadyCreated := l.limiters[key]
+	if !alreadyCreated {
+		limiter = rate.NewLimiter(rate.Limit(l.maxFrequency), l.burstSize)
+		l.limiters[key] = limiter
+	}
+	l.mutex.Unlock()
+
+	return limiter.Allow()
+}
+
 // RateLimiterMiddleware blocks the user from making a big amount of requests in a small amount of time,
 // receives some configuration:
 // - maxFrequency: maximum allowed frequency (requests per second)
 // - burstSize: maximum bursts permitted
 // - memoryDuration: duration of users rate limiter memory before it's cleaned
 func RateLimiterMiddleware(maxFrequency int, burstSize int, memoryDuration time.Duration) func(next http.Handler) http.Handler {
-	// Using rate limiter from package "golang.org/x/time/rate"
-	rateLimiterPerUser := make(map[string]*rate.Limiter)
+	limiters := newRateLimiters(maxFrequency, burstSize, memoryDuration)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userIPAddress, err := clientIPAddress(r)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+
+			// Checking user rate limiter
+			if !limiters.allow(userIPAddress) {
+				// returns status code 429 ("too many requests") if rate limit is reached
+				writeError(w, &httpError{
+					StatusCode: http.StatusTooManyRequests,
+					Message:    "Too many requests",
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AuthRateLimiterMiddleware blocks the user from making a big amount of authentication requests in a small amount of time,
+// with its own (stricter) rate limiter per IP address, on top of RateLimiterMiddleware, so the passwords cannot be guessed
+// nor the accounts locked out (see lib.ErrTooManyAttempts) at the general requests rate. It receives the same configuration
+// as RateLimiterMiddleware, the other requests are not limited
+func AuthRateLimiterMiddleware(maxFrequency int, burstSize int, memoryDuration time.Duration) func(next http.Handler) http.Handler {
+	limiters := newRateLimiters(maxFrequency, burstSize, memoryDuration)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != authenticatePath {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userIPAddress, err := clientIPAddress(r)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+
+			if !limiters.allow(userIPAddress) {
+				writeError(w, &httpError{
+					StatusCode: http.StatusTooManyRequests,
+					Message:    "Too many authentication attempts",
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIPAddress gets the client IP address of the request (first checking if the server is under a reverse proxy by
+// trying to get it from the headers "X-Real-Ip" and "X-Forwarded-For")
+func clientIPAddress(r *http.Request) (string, error) {
+	ipAddress := r.Header.Get("X-Real-Ip")
+	if ipAddress == "" {
+		ipAddress = r.Header.Get("X-Forwarded-For")
+	}
+	if ipAddress == "" {
+		var err error
+		ipAddress, _, err = net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return "", err
+		}
+	}
+	return ipAddress, nil
+}
+
+const (
+	// idempotencyKeyHeader is the header with the client generated key of a write request (e.g. a UUID)
+	idempotencyKeyHeader = "Idempotency-Key"
+	// maxIdempotencyKeyLength is the maximum length of the idempotency keys
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotentResponse is the response stored for an idempotency key, with the fingerprint of its request
+type idempotentResponse struct {
+	fingerprint string
+	statusCode  int
+	header      http.Header
+	body        []byte
+	expiresAt   time.Time
+}
+
+// idempotencyResponseWriter writes the response while recording it (to be stored for the idempotency key)
+type idempotencyResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+// WriteHeader records the status code and writes it
+func (w *idempotencyResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write records the data and writes it
+func (w *idempotencyResponseWriter) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// IdempotencyMiddleware makes the write requests (POST, PUT, PATCH and DELETE) with the Idempotency-Key header safely retryable:
+// the response of the first request is stored with the request fingerprint (method, URL and body) for the TTL received as parameter
+// (positive), scoped by the caller (Authorization header, or else IP address), method and path,
+// and replayed to the retries (with the Idempotent-Replayed header), a key reused by a different request returns 422 status code.
+// The requests with the same key are serialized (the retry waits for the first request), the server errors are not stored
+func IdempotencyMiddleware(ttl time.Duration) func(next http.Handler) http.Handler {
+	responses := make(map[string]*idempotentResponse)
+	// inProgress has the keys of the requests being handled, the channel is closed when the request finishes
+	inProgress := make(map[string]chan struct{})
 	var mutex sync.Mutex
 
-	// Cleaning the map from time to time to release the memory
+	// Cleaning the expired responses from time to time to release the memory
 	go func(m *sync.Mutex) {
-		for {
-			time.Sleep(memoryDuration)
+		ticker := time.NewTicker(ttl)
+		defer ticker.Stop()
+
+		for range ticker.C {
 			m.Lock()
-			for user := range rateLimiterPerUser {
-				delete(rateLimiterPerUser, user)
+			for key, response := range responses {
+				if time.Now().After(response.expiresAt) {
+					delete(responses, key)
+				}
 			}
 			m.Unlock()
 		}
@@ -100,41 +267,192 @@ func RateLimiterMiddleware(maxFrequency int, burstSize int, memoryDuration time.
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Getting user IP address (first checking if the server is under a reverse proxy by
-			// trying to get it from the headers "X-Real-Ip" and "X-Forwarded-For")
-			userIPAddress := r.Header.Get("X-Real-Ip")
-			if userIPAddress == "" {
-				userIPAddress = r.Header.Get("X-Forwarded-For")
-			}
-			if userIPAddress == "" {
-				var err error
-				userIPAddress, _, err = net.SplitHostPort(r.RemoteAddr)
-				if err != nil {
-					writeError(w, err)
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" || !isWriteMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				writeError(w, &httpError{
+					StatusCode: http.StatusBadRequest,
+					Message:    fmt.Sprintf("invalid header '%s' (longer than %d characters)", idempotencyKeyHeader, maxIdempotencyKeyLength),
+				})
+				return
+			}
+
+			fingerprint, err := requestFingerprint(r)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+
+			// the keys of other callers or routes are never replayed
+			scope, err := idempotencyScope(r)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			key = scope + ":" + key
+
+			// waiting for the request with the same key (if any) to finish
+			for {
+				mutex.Lock()
+				response, stored := responses[key]
+				if stored && time.Now().Before(response.expiresAt) {
+					mutex.Unlock()
+					replayIdempotentResponse(w, response, fingerprint)
+					return
+				}
+
+				done, handling := inProgress[key]
+				if !handling {
+					inProgress[key] = make(chan struct{})
+					mutex.Unlock()
+					break
+				}
+				mutex.Unlock()
+
+				select {
+				case <-done:
+				case <-r.Context().Done(): // the client gave up
 					return
 				}
 			}
 
-			// Creating rate limiter for the user (if not created yet)
-			mutex.Lock()
-			userRateLimiter, alreadyCreated := rateLimiterPerUser[userIPAddress]
-			if !alreadyCreated {
-				userRateLimiter = rate.NewLimiter(rate.Limit(maxFrequency), burstSize)
-				rateLimiterPerUser[userIPAddress] = userRateLimiter
+			recorder := &idempotencyResponseWriter{ResponseWriter: w}
+			// releasing the key even if the handler panics
+			defer func() {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if recorder.statusCode != 0 && recorder.statusCode < http.StatusInternalServerError {
+					responses[key] = &idempotentResponse{
+						fingerprint: fingerprint,
+						statusCode:  recorder.statusCode,
+						header:      w.Header().Clone(),
+						body:        recorder.body.Bytes(),
+						expiresAt:   time.Now().Add(ttl),
+					}
+				}
+				close(inProgress[key])
+				delete(inProgress, key)
+			}()
+
+			next.ServeHTTP(recorder, r)
+		})
+	}
+}
+
+// idempotencyScope gets the scope of the request idempotency key (SHA256): the caller (its Authorization header,
+// or else its IP address), the method and the path
+func idempotencyScope(r *http.Request) (string, error) {
+	caller := "authorization:" + r.Header.Get("Authorization")
+	if r.Header.Get("Authorization") == "" {
+		ipAddress, err := clientIPAddress(r)
+		if err != nil {
+			return "", err
+		}
+		caller = "client:" + ipAddress
+	}
+
+	sum := sha256.Sum256([]byte(caller + "\n" + r.Method + " " + r.URL.Path))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// isWriteMethod checks if the HTTP method is a write (POST, PUT, PATCH or DELETE)
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// requestFingerprint gets the fingerprint (SHA256) of the request method, URL and body,
+// the body (at most maxImportBodySize bytes) is read and replaced, so it can still be read by the handler
+func requestFingerprint(r *http.Request) (string, error) {
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n")
+
+	if r.Body != nil {
+		// reading one byte more than the maximum, to tell if the body is too large
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxImportBodySize+1))
+		if err != nil {
+			return "", err
+		}
+		if len(body) > maxImportBodySize {
+			return "", &httpError{
+				StatusCode: http.StatusRequestEntityTooLarge,
+				Message:    fmt.Sprintf("request body is larger than %d bytes", maxImportBodySize),
 			}
-			mutex.Unlock()
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		hash.Write(body)
+	}
 
-			// Checking user rate limiter
-			if !userRateLimiter.Allow() {
-				// returns status code 429 ("too many requests") if rate limit is reached
-				writeError(w, &httpError{
-					StatusCode: http.StatusTooManyRequests,
-					Message:    "Too many requests",
-				})
-				return
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// replayIdempotentResponse writes the stored response again, if it's from the same request (fingerprint)
+func replayIdempotentResponse(w http.ResponseWriter, response *idempotentResponse, fingerprint string) {
+	if response.fingerprint != fingerprint {
+		writeError(w, &httpError{
+			StatusCode: http.StatusUnprocessableEntity,
+			Message:    fmt.Sprintf("'%s' already used by a different request", idempotencyKeyHeader),
+		})
+		return
+	}
+
+	for name, values := range response.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(response.statusCode)
+	w.Write(response.body)
+}
+
+// adminContextKey is the request context key marking the admin requests
+type adminContextKey struct{}
+
+// AdminMiddleware marks the requests authorized with the admin token received as parameter (Authorization: Bearer <token>)
+// as admin requests (see isAdmin), there are no admin requests if the token is empty
+func AdminMiddleware(adminToken string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization := r.Header.Get("Authorization")
+			if adminToken != "" && subtle.ConstantTimeCompare([]byte(authorization), []byte("Bearer "+adminToken)) == 1 {
+				r = r.WithContext(context.WithValue(r.Context(), adminContextKey{}, true))
 			}
 
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// isAdmin checks if the request was marked as an admin request (see AdminMiddleware)
+func isAdmin(r *http.Request) bool {
+	admin, _ := r.Context().Value(adminContextKey{}).(bool)
+	return admin
+}
+
+// adminActor is the actor of the changes made by the admin requests
+const adminActor = "admin"
+
+// ActorMiddleware sets who makes the request changes (recorded in the users history) in the request context:
+// the admin for the admin requests (see AdminMiddleware, that must run before it), or else the client IP address
+func ActorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := adminActor
+		if !isAdmin(r) {
+			ipAddress, err := clientIPAddress(r)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			actor = "client:" + ipAddress
+		}
+
+		next.ServeHTTP(w, r.WithContext(lib.WithActor(r.Context(), actor)))
+	})
+}
@@ -0,0 +1,308 @@
+package srv
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	type request struct {
+		method         string
+		path           string
+		authorization  string
+		key            string
+		body           string
+		expectedStatus int
+		expectedBody   string
+		replayed       bool
+	}
+
+	testCases := []struct {
+		name          string
+		handlerStatus int
+		requests      []request
+		expectedCalls int32
+	}{
+		{
+			name:          "retry replayed",
+			handlerStatus: http.StatusCreated,
+			requests: []request{
+				{method: "POST", key: "key-1", body: `{"a":1}`, expectedStatus: http.StatusCreated, expectedBody: "call 1"},
+				{method: "POST", key: "key-1", body: `{"a":1}`, expectedStatus: http.StatusCreated, expectedBody: "call 1", replayed: true},
+			},
+			expectedCalls: 1,
+		},
+		{
+			name:          "key reused by a different body",
+			handlerStatus: http.StatusOK,
+			requests: []request{
+				{method: "PATCH", key: "key-1", body: `{"a":1}`, expectedStatus: http.StatusOK, expectedBody: "call 1"},
+				{method: "PATCH", key: "key-1", body: `{"a":2}`, expectedStatus: http.StatusUnprocessableEntity, expectedBody: `{"error":"'Idempotency-Key' already used by a different request"}` + "\n"},
+			},
+			expectedCalls: 1,
+		},
+		{
+			name:          "key reused by other callers - not replayed",
+			handlerStatus: http.StatusOK,
+			requests: []request{
+				{method: "POST", path: "/v1/users:batchGet", authorization: "Bearer s3cr3t", key: "key-1", body: `{"a":1}`, expectedStatus: http.StatusOK, expectedBody: "call 1"},
+				{method: "POST", path: "/v1/users:batchGet", key: "key-1", body: `{"a":1}`, expectedStatus: http.StatusOK, expectedBody: "call 2"},
+				{method: "POST", path: "/v1/users:batchGet", authorization: "Bearer other", key: "key-1", body: `{"a":1}`, expectedStatus: http.StatusOK, expectedBody: "call 3"},
+				{method: "POST", path: "/v1/users:batchGet", authorization: "Bearer s3cr3t", key: "key-1", body: `{"a":1}`, expectedStatus: http.StatusOK, expectedBody: "call 1", replayed: true},
+			},
+			expectedCalls: 3,
+		},
+		{
+			name:          "key reused by other routes - not replayed",
+			handlerStatus: http.StatusOK,
+			requests: []request{
+				{method: "POST", path: "/v1/users", key: "key-1", expectedStatus: http.StatusOK, expectedBody: "call 1"},
+				{method: "POST", path: "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4:erase", key: "key-1", expectedStatus: http.StatusOK, expectedBody: "call 2"},
+				{method: "DELETE", path: "/v1/users", key: "key-1", expectedStatus: http.StatusOK, expectedBody: "call 3"},
+			},
+			expectedCalls: 3,
+		},
+		{
+			name:          "different keys",
+			handlerStatus: http.StatusCreated,
+			requests: []request{
+				{method: "POST", key: "key-1", body: `{"a":1}`, expectedStatus: http.StatusCreated, expectedBody: "call 1"},
+				{method: "POST", key: "key-2", body: `{"a":1}`, expectedStatus: http.StatusCreated, expectedBody: "call 2"},
+			},
+			expectedCalls: 2,
+		},
+		{
+			name:          "no key or not a write - not stored",
+			handlerStatus: http.StatusOK,
+			requests: []request{
+				{method: "POST", body: `{"a":1}`, expectedStatus: http.StatusOK, expectedBody: "call 1"},
+				{method: "POST", body: `{"a":1}`, expectedStatus: http.StatusOK, expectedBody: "call 2"},
+				{method: "GET", key: "key-1", expectedStatus: http.StatusOK, expectedBody: "call 3"},
+				{method: "GET", key: "key-1", expectedStatus: http.StatusOK, expectedBody: "call 4"},
+			},
+			expectedCalls: 4,
+		},
+		{
+			name:          "server error - not stored",
+			handlerStatus: http.StatusInternalServerError,
+			requests: []request{
+				{method: "DELETE", key: "key-1", expectedStatus: http.StatusInternalServerError, expectedBody: "call 1"},
+				{method: "DELETE", key: "key-1", expectedStatus: http.StatusInternalServerError, expectedBody: "call 2"},
+			},
+			expectedCalls: 2,
+		},
+		{
+			name:          "error - key too long",
+			handlerStatus: http.StatusOK,
+			requests: []request{
+				{method: "POST", key: strings.Repeat("k", 256), expectedStatus: http.StatusBadRequest, expectedBody: `{"error":"invalid header 'Idempotency-Key' (longer than 255 characters)"}` + "\n"},
+			},
+			expectedCalls: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int32
+			handler := IdempotencyMiddleware(time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tc.handlerStatus)
+				fmt.Fprintf(w, "call %d", call)
+			}))
+
+			for _, req := range tc.requests {
+				path := req.path
+				if path == "" {
+					path = "/v1/users"
+				}
+				httpReq := httptest.NewRequest(req.method, path, strings.NewReader(req.body))
+				if req.authorization != "" {
+					httpReq.Header.Set("Authorization", req.authorization)
+				}
+				if req.key != "" {
+					httpReq.Header.Set("Idempotency-Key", req.key)
+				}
+				recorder := httptest.NewRecorder()
+
+				handler.ServeHTTP(recorder, httpReq)
+
+				assert.Equal(t, req.expectedStatus, recorder.Code)
+				assert.Equal(t, req.expectedBody, recorder.Body.String())
+				if req.replayed {
+					assert.Equal(t, "true", recorder.Header().Get("Idempotent-Replayed"))
+				} else {
+					assert.Empty(t, recorder.Header().Get("Idempotent-Replayed"))
+				}
+			}
+
+			assert.Equal(t, tc.expectedCalls, atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestIdempotencyMiddlewareConcurrency(t *testing.T) {
+	var calls int32
+	handler := IdempotencyMiddleware(time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond) // the other requests arrive while this one is running
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest("POST", "/v1/users", strings.NewReader(`{"a":1}`))
+			req.Header.Set("Idempotency-Key", "key-1")
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, req)
+
+			assert.Equal(t, http.StatusCreated, recorder.Code)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	testCases := []struct {
+		name          string
+		adminToken    string
+		authorization string
+		expectedAdmin bool
+	}{
+		{
+			name:          "base case - admin token",
+			adminToken:    "s3cr3t",
+			authorization: "Bearer s3cr3t",
+			expectedAdmin: true,
+		},
+		{
+			name:          "wrong token",
+			adminToken:    "s3cr3t",
+			authorization: "Bearer secret",
+			expectedAdmin: false,
+		},
+		{
+			name:          "token without the bearer scheme",
+			adminToken:    "s3cr3t",
+			authorization: "s3cr3t",
+			expectedAdmin: false,
+		},
+		{
+			name:          "missing authorization",
+			adminToken:    "s3cr3t",
+			authorization: "",
+			expectedAdmin: false,
+		},
+		{
+			name:          "no admin token configured",
+			adminToken:    "",
+			authorization: "Bearer ",
+			expectedAdmin: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var admin bool
+			handler := AdminMiddleware(tc.adminToken)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				admin = isAdmin(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/users", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tc.expectedAdmin, admin)
+		})
+	}
+}
+
+func TestActorMiddleware(t *testing.T) {
+	testCases := []struct {
+		name          string
+		authorization string
+		forwardedFor  string
+		expectedActor string
+	}{
+		{
+			name:          "base case - client IP address",
+			expectedActor: "client:192.0.2.1",
+		},
+		{
+			name:          "client IP address behind a reverse proxy",
+			forwardedFor:  "63.119.6.98",
+			expectedActor: "client:63.119.6.98",
+		},
+		{
+			name:          "admin request",
+			authorization: "Bearer s3cr3t",
+			expectedActor: "admin",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var actor string
+			handler := withMiddlewares(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					actor = lib.ContextActor(r.Context())
+				}),
+				ActorMiddleware,
+				AdminMiddleware("s3cr3t"),
+			)
+
+			req := httptest.NewRequest(http.MethodPatch, "/v1/users/1311f914-1d4f-40b6-8886-80193265d5a4", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tc.expectedActor, actor)
+		})
+	}
+}
+
+func TestAuthRateLimiterMiddleware(t *testing.T) {
+	handler := AuthRateLimiterMiddleware(1, 2, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string, ipAddress string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("X-Real-Ip", ipAddress)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	// the authentication attempts have their own budget per IP address (the burst size)
+	assert.Equal(t, http.StatusOK, serve(authenticatePath, "10.0.0.1"))
+	assert.Equal(t, http.StatusOK, serve(authenticatePath, "10.0.0.1"))
+	assert.Equal(t, http.StatusTooManyRequests, serve(authenticatePath, "10.0.0.1"))
+	assert.Equal(t, http.StatusOK, serve(authenticatePath, "10.0.0.2"))
+
+	// the other requests are not limited
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, serve("/v1/users", "10.0.0.1"))
+	}
+}
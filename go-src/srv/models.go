@@ -0,0 +1,258 @@
+package srv
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
+)
+
+// userResponse represents the user data returned by the HTTP responses,
+// the password (hash) is never included
+type userResponse struct {
+	ID           string `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Email        string `json:"email"`
+	IPAddress    string `json:"ip_address"`
+	CreationDate string `json:"creation_date"`
+	// DeletedAt is only included for the deleted users (RFC 3339)
+	DeletedAt *string `json:"deleted_at,omitempty"`
+
+	// fields are the selected fields (sparse fieldset) to be encoded, all the fields if empty
+	fields []string
+	// creationDate is the typed creation date, used to format it again if requested
+	creationDate time.Time
+}
+
+// userResponseFields are the user response fields (JSON names) in encoding order
+var userResponseFields = jsonFieldNames(reflect.TypeOf(userResponse{}))
+
+// usersCursorPageResponse represents a page of users returned by the cursor pagination,
+// the next cursor is null if there are no more users
+type usersCursorPageResponse struct {
+	Data       []userResponse `json:"data"`
+	NextCursor *string        `json:"next_cursor"`
+}
+
+// usersPageResponse represents a page of users returned by the offset pagination envelope (opt-in),
+// the next and prev URLs are null if there is no such page
+type usersPageResponse struct {
+	Data   []userResponse `json:"data"`
+	Total  int            `json:"total"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+	Next   *string        `json:"next"`
+	Prev   *string        `json:"prev"`
+}
+
+// usersBatchResponse represents the users got by their IDs, with the IDs that were not found
+type usersBatchResponse struct {
+	Data       []userResponse `json:"data"`
+	MissingIDs []string       `json:"missing_ids"`
+}
+
+// userRevisionResponse represents a user revision (change history entry) returned by the HTTP responses,
+// the password values are never recorded
+type userRevisionResponse struct {
+	Number  int               `json:"number"`
+	Action  string            `json:"action"`
+	Actor   string            `json:"actor"`
+	Time    string            `json:"time"`
+	Changes []lib.FieldChange `json:"changes"`
+}
+
+// auditEntryResponse represents an audit entry (sensitive operation on the user data) returned by the HTTP responses
+type auditEntryResponse struct {
+	Action string `json:"action"`
+	Actor  string `json:"actor"`
+	Time   string `json:"time"`
+}
+
+// userDataExportResponse represents all the data held about a user (right of access) returned by the export route
+type userDataExportResponse struct {
+	ExportedAt   string                 `json:"exported_at"`
+	User         userResponse           `json:"user"`
+	History      []userRevisionResponse `json:"history"`
+	AuditEntries []auditEntryResponse   `json:"audit_entries"`
+}
+
+// newUserResponse creates the user response from the user model
+func newUserResponse(user lib.User) userResponse {
+	response := userResponse{
+		ID:           user.ID,
+		FirstName:    user.FirstName,
+		LastName:     user.LastName,
+		Email:        user.Email,
+		IPAddress:    user.IPAddress,
+		CreationDate: lib.FormatCreationDate(user.CreationDate, false),
+		creationDate: user.CreationDate,
+	}
+	if user.IsDeleted() {
+		deletedAt := user.DeletedAt.UTC().Format(time.RFC3339)
+		response.DeletedAt = &deletedAt
+	}
+	return response
+}
+
+// newUsersResponse creates the users response from the user models
+func newUsersResponse(users []lib.User) []userResponse {
+	usersResponse := make([]userResponse, len(users))
+	for i, user := range users {
+		usersResponse[i] = newUserResponse(user)
+	}
+	return usersResponse
+}
+
+// newUsersCursorPageResponse creates the cursor pagination response from the users page
+func newUsersCursorPageResponse(page lib.UsersPage) usersCursorPageResponse {
+	response := usersCursorPageResponse{
+		Data: newUsersResponse(page.Users),
+	}
+	if page.NextCursor != nil {
+		nextCursor := page.NextCursor.Encode()
+		response.NextCursor = &nextCursor
+	}
+	return response
+}
+
+// newUsersPageResponse creates the offset pagination envelope from the users page and its links
+func newUsersPageResponse(page lib.UsersPage, limit, offset int, links pageLinks) usersPageResponse {
+	response := usersPageResponse{
+		Data:   newUsersResponse(page.Users),
+		Total:  page.Total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	if links.Next != "" {
+		response.Next = &links.Next
+	}
+	if links.Prev != "" {
+		response.Prev = &links.Prev
+	}
+	return response
+}
+
+// newUsersBatchResponse creates the batch get response from the users batch
+func newUsersBatchResponse(batch lib.UsersBatch) usersBatchResponse {
+	return usersBatchResponse{
+		Data:       newUsersResponse(batch.Users),
+		MissingIDs: batch.MissingIDs,
+	}
+}
+
+// newUserRevisionsResponse creates the user history response from the user revisions
+func newUserRevisionsResponse(revisions []lib.UserRevision) []userRevisionResponse {
+	revisionsResponse := make([]userRevisionResponse, len(revisions))
+	for i, revision := range revisions {
+		revisionsResponse[i] = userRevisionResponse{
+			Number:  revision.Number,
+			Action:  string(revision.Action),
+			Actor:   revision.Actor,
+			Time:    revision.Time.UTC().Format(time.RFC3339Nano),
+			Changes: revision.Changes,
+		}
+	}
+	return revisionsResponse
+}
+
+// newUserDataExportResponse creates the user data export response from the export bundle
+func newUserDataExportResponse(export lib.UserDataExport) userDataExportResponse {
+	auditEntriesResponse := make([]auditEntryResponse, len(export.AuditEntries))
+	for i, entry := range export.AuditEntries {
+		auditEntriesResponse[i] = auditEntryResponse{
+			Action: string(entry.Action),
+			Actor:  entry.Actor,
+			Time:   entry.Time.UTC().Format(time.RFC3339Nano),
+		}
+	}
+
+	return userDataExportResponse{
+		ExportedAt:   export.ExportedAt.UTC().Format(time.RFC3339Nano),
+		User:         newUserResponse(export.User),
+		History:      newUserRevisionsResponse(export.History),
+		AuditEntries: auditEntriesResponse,
+	}
+}
+
+// MarshalJSON encodes the user response, only with the selected fields (in the struct order) if any
+func (u userResponse) MarshalJSON() ([]byte, error) {
+	// same fields without the MarshalJSON method, avoiding the infinite recursion
+	type plainUserResponse userResponse
+
+	if len(u.fields) == 0 {
+		return json.Marshal(plainUserResponse(u))
+	}
+
+	selected := make(map[string]bool, len(u.fields))
+	for _, field := range u.fields {
+		selected[field] = true
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	value := reflect.ValueOf(u)
+	for i := 0; i < value.NumField(); i++ {
+		field := jsonFieldName(value.Type().Field(i))
+		if !selected[field] {
+			continue
+		}
+
+		fieldBytes, err := json.Marshal(value.Field(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`"` + field + `":`)
+		buf.Write(fieldBytes)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// selectUsersFields selects the fields (sparse fieldset) to be encoded in the users responses, all the fields if empty
+func selectUsersFields(users []userResponse, fields []string) {
+	for i := range users {
+		users[i].fields = fields
+	}
+}
+
+// useLegacyDate formats the creation date of the user response in the legacy format (dd/mm/yyyy) if requested
+func (u *userResponse) useLegacyDate(legacy bool) {
+	if legacy {
+		u.CreationDate = lib.FormatCreationDate(u.creationDate, true)
+	}
+}
+
+// useUsersLegacyDates formats the creation dates of the users responses in the legacy format (dd/mm/yyyy) if requested
+func useUsersLegacyDates(users []userResponse, legacy bool) {
+	for i := range users {
+		users[i].useLegacyDate(legacy)
+	}
+}
+
+// jsonFieldNames gets the JSON names of the exported struct fields (in order)
+func jsonFieldNames(structType reflect.Type) []string {
+	names := make([]string, 0, structType.NumField())
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		names = append(names, jsonFieldName(field))
+	}
+	return names
+}
+
+// jsonFieldName gets the JSON name of the struct field (from its tag), empty if it has no name
+func jsonFieldName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
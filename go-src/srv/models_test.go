@@ -0,0 +1,67 @@
+package srv
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hbernardo/users/go-src/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserResponseMarshalJSON(t *testing.T) {
+	user := lib.User{
+		ID:           "1311f914-1d4f-40b6-8886-80193265d5a4",
+		FirstName:    "Terrence",
+		LastName:     "Trillow",
+		Email:        "ttrillow1@feedburner.com",
+		IPAddress:    "63.119.6.98",
+		CreationDate: time.Date(2021, time.April, 19, 0, 0, 0, 0, time.UTC),
+	}
+
+	testCases := []struct {
+		name         string
+		fields       []string
+		legacyDates  bool
+		expectedJSON string
+	}{
+		{
+			name:         "all fields",
+			fields:       nil,
+			expectedJSON: `{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","first_name":"Terrence","last_name":"Trillow","email":"ttrillow1@feedburner.com","ip_address":"63.119.6.98","creation_date":"2021-04-19T00:00:00Z"}`,
+		},
+		{
+			name:         "selected fields - struct order",
+			fields:       []string{"creation_date", "id"},
+			expectedJSON: `{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","creation_date":"2021-04-19T00:00:00Z"}`,
+		},
+		{
+			name:         "legacy dates",
+			fields:       []string{"id", "creation_date"},
+			legacyDates:  true,
+			expectedJSON: `{"id":"1311f914-1d4f-40b6-8886-80193265d5a4","creation_date":"19/04/2021"}`,
+		},
+		{
+			name:         "duplicated fields",
+			fields:       []string{"email", "email"},
+			expectedJSON: `{"email":"ttrillow1@feedburner.com"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response := newUserResponse(user)
+			response.fields = tc.fields
+			response.useLegacyDate(tc.legacyDates)
+
+			jsonBytes, err := json.Marshal(response)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedJSON, string(jsonBytes))
+		})
+	}
+}
+
+func TestUserResponseFields(t *testing.T) {
+	assert.Equal(t, []string{"id", "first_name", "last_name", "email", "ip_address", "creation_date", "deleted_at"}, userResponseFields)
+}